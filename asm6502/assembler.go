@@ -0,0 +1,552 @@
+package asm6502
+
+import (
+	"fmt"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"github.com/simulatedsimian/emu6502/obj6502"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Position in a source file
+type Pos struct {
+	File string
+	Line int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%s:%d", p.File, p.Line)
+}
+
+// An assembly error, with the include and macro expansion chain that led to it
+type Error struct {
+	Pos   Pos
+	Err   error
+	Chain []string // innermost first, e.g. "in macro print at main.s:10"
+}
+
+func (e *Error) Error() string {
+	s := fmt.Sprintf("%v: %v", e.Pos, e.Err)
+	for _, c := range e.Chain {
+		s += "\n\t" + c
+	}
+	return s
+}
+
+// A symbol defined by the source, a label or a constant
+type Symbol struct {
+	Name  string
+	Value int
+}
+
+/*
+	Two pass assembler. The first pass determines the size of every
+	statement and the value of every label, the second evaluates all
+	operands and writes the output. An instruction whose operand is a
+	forward reference in the first pass always uses the absolute form.
+
+	Symbols defined inside .scope and .proc blocks and macro expansions
+	are named scope::name, a name is looked up in the current scope then
+	each enclosing scope in turn.
+
+	With Relocatable set the output is an object for the linker, see
+	reloc.go.
+*/
+type Assembler struct {
+	// reads source and binary files, ioutil.ReadFile if nil
+	ReadFile func(filename string) ([]byte, error)
+	// directories searched by .include after the including file's directory
+	IncludePaths []string
+	// assemble into segments for the linker, the output is available from Object
+	Relocatable bool
+
+	pass       int
+	pc         int
+	pos        Pos
+	symbols    map[string]int
+	defined    map[string]bool // defined during the current pass
+	unresolved bool            // an expression referred to an undefined symbol in the first pass
+
+	scope     string            // current scope, "" for global
+	macros    map[string]*macro // defined so far in the current pass
+	chain     []string          // include and macro expansions being assembled, outermost first
+	blocks    int               // count of macro expansions and anonymous scopes in the current pass
+	expanding int               // depth of macro and .repeat expansion
+
+	// relocatable output
+	segment  *segment             // current segment, nil if not relocatable
+	segments []*segment           // in order of first use
+	terms    map[string]relocTerm // what each relocatable symbol is relative to
+	zeroPage map[string]bool      // imported symbols that are in zero page
+	bases    map[relocTerm]int    // addresses given to relocatable terms while evaluating
+	used     map[relocTerm]bool   // relocatable terms used by the last evaluation
+	imports  []string
+	exports  []obj6502.Export
+
+	// second pass results for the listing
+	listing []listingLine
+	defs    map[string]Pos
+	refs    map[string][]Pos
+
+	// encoding chosen in the first pass for each instruction, in source order
+	modes []core6502.OpcodeInfo
+	instr int
+
+	mem     [0x10000]uint8
+	written [0x10000]bool
+	lo, hi  int
+}
+
+func New() *Assembler {
+	return &Assembler{symbols: map[string]int{}}
+}
+
+func (a *Assembler) readFile(filename string) ([]byte, error) {
+	if a.ReadFile != nil {
+		return a.ReadFile(filename)
+	}
+	return ioutil.ReadFile(filename)
+}
+
+// assembles filename, the output is available from Binary and Symbols
+func (a *Assembler) AssembleFile(filename string) error {
+	src, err := a.readFile(filename)
+	if err != nil {
+		return err
+	}
+	return a.AssembleSource(filename, src)
+}
+
+// assembles src, name is used for error messages and to find files it refers to
+func (a *Assembler) AssembleSource(name string, src []byte) error {
+	a.symbols = map[string]int{}
+	a.terms = map[string]relocTerm{}
+	a.zeroPage = map[string]bool{}
+	a.modes = nil
+
+	for a.pass = 1; a.pass <= 2; a.pass++ {
+		a.pc = 0
+		a.instr = 0
+		a.defined = map[string]bool{}
+		a.mem = [0x10000]uint8{}
+		a.written = [0x10000]bool{}
+		a.lo, a.hi = 0x10000, 0
+		a.scope = ""
+		a.macros = map[string]*macro{}
+		a.chain = nil
+		a.blocks = 0
+		a.expanding = 0
+		a.listing = nil
+		a.defs = map[string]Pos{}
+		a.refs = map[string][]Pos{}
+		a.segment, a.segments = nil, nil
+		a.imports, a.exports = nil, nil
+		if a.Relocatable {
+			a.selectSegment("CODE")
+		}
+
+		if err := a.assembleLines(splitLines(name, src)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// a line of source and where it came from
+type sourceLine struct {
+	text string
+	pos  Pos
+}
+
+func splitLines(name string, src []byte) []sourceLine {
+	var lines []sourceLine
+	for n, text := range strings.Split(strings.Replace(string(src), "\r\n", "\n", -1), "\n") {
+		lines = append(lines, sourceLine{text, Pos{name, n + 1}})
+	}
+	return lines
+}
+
+func (a *Assembler) wrapError(pos Pos, err error) error {
+	if _, ok := err.(*Error); ok {
+		return err
+	}
+
+	e := &Error{Pos: pos, Err: err}
+	for n := len(a.chain) - 1; n >= 0; n-- {
+		e.Chain = append(e.Chain, a.chain[n])
+	}
+	return e
+}
+
+func (a *Assembler) assembleLines(lines []sourceLine) error {
+	for n := 0; n < len(lines); n++ {
+		pos := lines[n].pos
+		a.pos = pos
+		st := parseStatement(lines[n].text)
+		if a.pass == 2 {
+			a.listing = append(a.listing, listingLine{pos: pos, text: lines[n].text, addr: a.pc,
+				label: st.label != "", expanded: a.expanding > 0})
+		}
+
+		var err error
+		if block, ok := blockDirectives[strings.ToLower(st.op)]; ok && !st.assign {
+			var end int
+			if end, err = findBlockEnd(lines, n, st.op, block.end); err == nil {
+				err = a.assembleBlock(block, st, lines[n+1:end])
+				a.listSource(lines[end])
+				n = end
+			}
+		} else {
+			err = a.assembleStatement(st)
+		}
+
+		if err != nil {
+			return a.wrapError(pos, err)
+		}
+	}
+	return nil
+}
+
+func (a *Assembler) assembleStatement(st statement) error {
+	if st.label != "" {
+		if err := a.defineLabel(st.label); err != nil {
+			return err
+		}
+	}
+
+	if m, ok := a.macros[st.op]; ok {
+		return a.expandMacro(m, st.args)
+	}
+
+	switch {
+	case st.op == "":
+		return nil
+	case st.assign:
+		return a.assign(st.op, st.args)
+	case strings.HasPrefix(st.op, "."):
+		d, ok := directives[strings.ToLower(st.op)]
+		if !ok {
+			return fmt.Errorf("Unknown directive: %s", st.op)
+		}
+		return d(a, st.args)
+	}
+	return a.instruction(st.op, st.args)
+}
+
+/*
+	Reads a file named in the source. A relative name is looked up in
+	the directory of the file being assembled, then in each of
+	IncludePaths. Returns the contents and the path found.
+*/
+func (a *Assembler) readRelative(name string) ([]byte, string, error) {
+	if filepath.IsAbs(name) {
+		data, err := a.readFile(name)
+		return data, name, err
+	}
+
+	dirs := append([]string{filepath.Dir(a.pos.File)}, a.IncludePaths...)
+	for _, dir := range dirs {
+		path := filepath.Join(dir, name)
+		data, err := a.readFile(path)
+		if err == nil || !os.IsNotExist(err) {
+			return data, path, err
+		}
+	}
+	return nil, "", fmt.Errorf("File not found: %s", name)
+}
+
+// symbol name of name defined in scope
+func qualify(scope, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "::" + name
+}
+
+func parentScope(scope string) string {
+	if i := strings.LastIndex(scope, "::"); i >= 0 {
+		return scope[:i]
+	}
+	return ""
+}
+
+// looks name up in the current scope and then each enclosing scope,
+// returns the value and the qualified name found
+func (a *Assembler) lookup(name string) (int, string, bool) {
+	for scope := a.scope; ; scope = parentScope(scope) {
+		key := qualify(scope, name)
+		if val, ok := a.symbols[key]; ok {
+			return val, key, true
+		}
+		if scope == "" {
+			return 0, "", false
+		}
+	}
+}
+
+// resolves symbols during a pass, * is the current address
+type passSymbols struct {
+	a *Assembler
+}
+
+func (s passSymbols) ResolveSymbol(name string) (int, bool) {
+	a := s.a
+	if name == "*" {
+		if a.segment != nil {
+			return a.pc + a.relocBase(relocTerm{segment: a.segment.name}), true
+		}
+		return a.pc, true
+	}
+	if val, key, ok := a.lookup(name); ok {
+		if refs := a.refs[key]; a.pass == 2 && (len(refs) == 0 || refs[len(refs)-1] != a.pos) {
+			a.refs[key] = append(refs, a.pos)
+		}
+		if term, ok := a.terms[key]; ok {
+			val += a.relocBase(term)
+		}
+		return val, true
+	}
+	if a.pass == 1 {
+		a.unresolved = true
+		return 0, true
+	}
+	return 0, false
+}
+
+// evaluates e. known is false if e refers to a symbol that is not yet
+// defined, which is only allowed in the first pass
+func (a *Assembler) evalExpr(e *core6502.Expr) (val int, known bool, err error) {
+	a.unresolved = false
+	a.used = map[relocTerm]bool{}
+	val, err = e.Eval(&core6502.ExprEnv{Symbols: passSymbols{a}})
+	if a.unresolved {
+		return 0, false, nil
+	}
+	return val, true, err
+}
+
+// evaluates e, which must not be relocatable
+func (a *Assembler) eval(e *core6502.Expr) (int, bool, error) {
+	val, rel, known, err := a.evalReloc(e)
+	if err == nil && rel != nil {
+		err = fmt.Errorf("Value must be a constant: %s", e)
+	}
+	return val, known, err
+}
+
+func (a *Assembler) evalString(s string) (int, bool, error) {
+	e, err := core6502.ParseExpr(s)
+	if err != nil {
+		return 0, false, err
+	}
+	return a.eval(e)
+}
+
+// evaluates an expression whose value must be known in the first pass
+func (a *Assembler) evalConst(s string) (int, error) {
+	val, known, err := a.evalString(s)
+	if err == nil && !known {
+		err = fmt.Errorf("Value must not depend on a forward reference: %s", s)
+	}
+	return val, err
+}
+
+// defines name in the current scope
+func (a *Assembler) define(name string, val int) error {
+	name = qualify(a.scope, name)
+	if a.defined[name] {
+		return fmt.Errorf("Duplicate symbol: %s", name)
+	}
+	a.defined[name] = true
+	a.symbols[name] = val
+	a.defs[name] = a.pos
+	return nil
+}
+
+func (a *Assembler) defineLabel(name string) error {
+	if old, ok := a.symbols[qualify(a.scope, name)]; a.pass == 2 && ok && old != a.pc {
+		return fmt.Errorf("Label %s moved from $%04x to $%04x between passes", name, old, a.pc)
+	}
+	if err := a.define(name, a.pc); err != nil {
+		return err
+	}
+	if a.segment != nil {
+		a.terms[qualify(a.scope, name)] = relocTerm{segment: a.segment.name}
+	}
+	return nil
+}
+
+// name = expr, * = expr sets the address
+func (a *Assembler) assign(name, expr string) error {
+	if name == "*" {
+		return directiveOrg(a, expr)
+	}
+	if scanName(name) != len(name) {
+		return fmt.Errorf("Invalid symbol name: %s", name)
+	}
+
+	e, err := core6502.ParseExpr(expr)
+	if err != nil {
+		return err
+	}
+	val, rel, known, err := a.evalReloc(e)
+	if err != nil || !known {
+		return err
+	}
+	if rel != nil && rel.kind != obj6502.Reloc_Word {
+		return fmt.Errorf("Value must be a constant: %s", expr)
+	}
+
+	if a.pass == 2 {
+		a.currentLine().value = &val
+	}
+	if err := a.define(name, val); err != nil {
+		return err
+	}
+	if rel != nil {
+		a.terms[qualify(a.scope, name)] = rel.term
+	}
+	return nil
+}
+
+func (a *Assembler) setPC(addr int) error {
+	if addr < 0 || addr > 0xffff {
+		return fmt.Errorf("Address out of range: $%x", addr)
+	}
+	a.pc = addr
+	return nil
+}
+
+// writes bytes at the current address in the second pass, the first
+// pass only advances the address
+func (a *Assembler) emit(bytes ...uint8) error {
+	if a.pc+len(bytes) > 0x10000 {
+		return fmt.Errorf("Output beyond $ffff")
+	}
+
+	if a.pass == 2 && a.segment != nil {
+		a.currentLine().bytes = append(a.currentLine().bytes, bytes...)
+		a.segment.data = append(a.segment.data, bytes...)
+	} else if a.pass == 2 {
+		line := a.currentLine()
+		line.bytes = append(line.bytes, bytes...)
+		for _, b := range bytes {
+			if a.written[a.pc] {
+				return fmt.Errorf("Output overlaps at $%04x", a.pc)
+			}
+			a.mem[a.pc] = b
+			a.written[a.pc] = true
+			if a.pc < a.lo {
+				a.lo = a.pc
+			}
+			if a.pc >= a.hi {
+				a.hi = a.pc + 1
+			}
+			a.pc++
+		}
+		return nil
+	}
+
+	a.pc += len(bytes)
+	return nil
+}
+
+func (a *Assembler) instruction(mnemonic, operand string) error {
+	ai, err := core6502.ParseAsmInstruction(mnemonic + " " + operand)
+	if err != nil {
+		return err
+	}
+
+	value, known := 0, true
+	var rel *relocValue
+	if ai.Operand != nil {
+		if value, rel, known, err = a.evalReloc(ai.Operand); err != nil {
+			return err
+		}
+		// the linker may place it anywhere, so it needs the absolute form
+		if rel != nil && rel.kind == obj6502.Reloc_Word && !a.isZeroPage(rel.term) {
+			known = false
+		}
+	}
+
+	var info core6502.OpcodeInfo
+	if a.pass == 1 {
+		if info, err = ai.SelectMode(value, known); err != nil {
+			return err
+		}
+		a.modes = append(a.modes, info)
+	} else {
+		info = a.modes[a.instr]
+	}
+	a.instr++
+
+	if a.pass == 1 {
+		return a.emit(make([]uint8, info.Length)...)
+	}
+
+	// a branch offset is only known if the target moves with the branch
+	if info.Mode == core6502.AddrMode_Relative && a.segment != nil {
+		if rel == nil || rel.term != (relocTerm{segment: a.segment.name}) {
+			return fmt.Errorf("Branch target not in the current segment: %s", ai.Operand)
+		}
+	}
+
+	bytes, err := ai.Encode(info, uint16(a.pc), value)
+	if err != nil {
+		return err
+	}
+
+	if rel != nil && info.Mode != core6502.AddrMode_Relative {
+		kind := rel.kind
+		if kind == obj6502.Reloc_Word && info.Length == 2 {
+			kind = obj6502.Reloc_Byte
+		}
+		a.addReloc(a.pc+1, kind, rel)
+	}
+
+	line := a.currentLine()
+	line.info = &info
+	line.branchCross = info.Mode == core6502.AddrMode_Relative && a.segment == nil && (a.pc+2)&0xff00 != value&0xff00
+	return a.emit(bytes...)
+}
+
+// returns the lowest address written and the output up to the highest
+// address written, gaps are filled with zeros
+func (a *Assembler) Binary() (uint16, []uint8) {
+	if a.lo >= a.hi {
+		return 0, nil
+	}
+	return uint16(a.lo), append([]uint8(nil), a.mem[a.lo:a.hi]...)
+}
+
+// returns all symbols ordered by value then name
+func (a *Assembler) Symbols() []Symbol {
+	var syms []Symbol
+	for name, val := range a.symbols {
+		syms = append(syms, Symbol{name, val})
+	}
+	sort.Slice(syms, func(i, j int) bool {
+		if syms[i].Value != syms[j].Value {
+			return syms[i].Value < syms[j].Value
+		}
+		return syms[i].Name < syms[j].Name
+	})
+	return syms
+}
+
+// writes symbols in the 16 bit range as a VICE label file, which emu6502 -sym loads
+func (a *Assembler) WriteLabels(w io.Writer) error {
+	for _, sym := range a.Symbols() {
+		if sym.Value < 0 || sym.Value > 0xffff {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "al C:%04x .%s\n", sym.Value, sym.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
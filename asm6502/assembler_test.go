@@ -0,0 +1,309 @@
+package asm6502
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/simulatedsimian/assert"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"os"
+	"strings"
+	"testing"
+)
+
+// assembler reading files from a map
+func testAssembler(files map[string]string) *Assembler {
+	a := New()
+	a.ReadFile = func(filename string) ([]byte, error) {
+		if src, ok := files[filename]; ok {
+			return []byte(src), nil
+		}
+		return nil, os.ErrNotExist
+	}
+	return a
+}
+
+func TestAssembleProgram(t *testing.T) {
+	pack := assert.Pack
+
+	src := `
+; test program
+chrout = $ffd2
+count  = 3
+
+        .org $0400
+start:  ldx #0
+loop:   lda message,x   ; forward reference, absolute form
+        beq done
+        jsr chrout
+        inx
+        bne loop
+done:   jmp *
+message:
+        .asciiz "Hi"
+table:  .byte 1, -1, 'A', <start, >start
+        .word start, count*2
+        .fill count, $ea
+        .align 4
+aligned:
+        .text "ab", 0
+`
+	a := testAssembler(nil)
+	assert.NoError(t, pack(a.AssembleSource("test.s", []byte(src))))
+
+	start, bin := a.Binary()
+	assert.Equal(t, start, uint16(0x400))
+	assert.Equal(t, bin, []uint8{
+		0xa2, 0x00, // ldx #0
+		0xbd, 0x10, 0x04, // lda message,x
+		0xf0, 0x06, // beq done
+		0x20, 0xd2, 0xff, // jsr chrout
+		0xe8,       // inx
+		0xd0, 0xf5, // bne loop
+		0x4c, 0x0d, 0x04, // jmp *
+		'H', 'i', 0x00,
+		0x01, 0xff, 'A', 0x00, 0x04,
+		0x00, 0x04, 0x06, 0x00,
+		0xea, 0xea, 0xea,
+		0x00, // align to $0420
+		'a', 'b', 0x00,
+	})
+
+	var lbl bytes.Buffer
+	assert.NoError(t, pack(a.WriteLabels(&lbl)))
+	assert.Equal(t, lbl.String(), `al C:0003 .count
+al C:0400 .start
+al C:0402 .loop
+al C:040d .done
+al C:0410 .message
+al C:0413 .table
+al C:0420 .aligned
+al C:ffd2 .chrout
+`)
+}
+
+func TestAssembleZeroPage(t *testing.T) {
+	pack := assert.Pack
+
+	src := `
+ptr = $fb
+        * = $c000
+        lda ptr
+        sta (ptr),y
+        lda later
+        lda ptr+$100,x
+later = $10
+`
+	a := testAssembler(nil)
+	assert.NoError(t, pack(a.AssembleSource("zp.s", []byte(src))))
+
+	_, bin := a.Binary()
+	assert.Equal(t, bin, []uint8{0xa5, 0xfb, 0x91, 0xfb, 0xad, 0x10, 0x00, 0xbd, 0xfb, 0x01})
+}
+
+// every documented instruction in each of its addressing modes
+func TestAssembleAllInstructions(t *testing.T) {
+	pack := assert.Pack
+
+	operands := map[core6502.AddressMode]string{
+		core6502.AddrMode_Implicit:          "",
+		core6502.AddrMode_Immediate:         "#$12",
+		core6502.AddrMode_AbsoluteZeroPage:  "$12",
+		core6502.AddrMode_Absolute:          "$1234",
+		core6502.AddrMode_ZeroPageIdxX:      "$12,x",
+		core6502.AddrMode_ZeroPageIdxY:      "$12,y",
+		core6502.AddrMode_PreIndexIndirect:  "($12,x)",
+		core6502.AddrMode_PostIndexIndirect: "($12),y",
+		core6502.AddrMode_AbsoluteIndexedX:  "$1234,x",
+		core6502.AddrMode_AbsoluteIndexedY:  "$1234,y",
+		core6502.AddrMode_Indirect:          "($1234)",
+		core6502.AddrMode_Relative:          "*+$14",
+	}
+	operandBytes := map[uint16][]uint8{1: nil, 2: {0x12}, 3: {0x34, 0x12}}
+
+	for _, info := range core6502.Opcodes() {
+		a := testAssembler(nil)
+		src := fmt.Sprintf(" * = $0400\n %s %s\n", strings.ToLower(info.Mnemonic), operands[info.Mode])
+		assert.NoError(t, pack(a.AssembleSource("all.s", []byte(src))))
+
+		_, bin := a.Binary()
+		assert.Equal(t, bin, append([]uint8{info.Opcode}, operandBytes[info.Length]...))
+	}
+
+	// the accumulator shifts may name A
+	a := testAssembler(nil)
+	assert.NoError(t, pack(a.AssembleSource("acc.s", []byte(" asl a\n lsr A\n rol\n ror a\n"))))
+	_, bin := a.Binary()
+	assert.Equal(t, bin, []uint8{0x0a, 0x4a, 0x2a, 0x6a})
+}
+
+func TestIncbin(t *testing.T) {
+	pack := assert.Pack
+
+	a := testAssembler(map[string]string{
+		"src/data.bin": "0123456789",
+	})
+	src := `
+        .org $1000
+        .incbin "data.bin", 2, 3
+        .incbin "data.bin", 8
+`
+	assert.NoError(t, pack(a.AssembleSource("src/main.s", []byte(src))))
+	_, bin := a.Binary()
+	assert.Equal(t, string(bin), "23489")
+}
+
+func TestAssembleErrors(t *testing.T) {
+	errors := []struct {
+		src string
+		msg string
+	}{
+		{"  foo", "err.s:1: 'foo' not valid instruction"},
+		{"\n  .bogus 1", "err.s:2: Unknown directive: .bogus"},
+		{"a: nop\na: nop", "err.s:2: Duplicate symbol: a"},
+		{"  lda missing", "err.s:1: Unknown Symbol: missing"},
+		{"  .org later\nlater:", "err.s:1: Value must not depend on a forward reference: later"},
+		{"  .byte 256", "err.s:1: Value out of range: 256 = 256"},
+		{"  .org $ffff\n  nop\n  nop", "err.s:3: Output beyond $ffff"},
+		{"  .org $10\n  nop\n  .org $10\n  nop", "err.s:4: Output overlaps at $0010"},
+		{"  bne far\n  .fill 200\nfar:", "err.s:1: Branch target $00ca out of range from $0000"},
+		{"  .incbin \"none.bin\"", "err.s:1: File not found: none.bin"},
+	}
+
+	for _, test := range errors {
+		a := testAssembler(nil)
+		assert.Equal(t, fmt.Sprint(a.AssembleSource("err.s", []byte(test.src))), test.msg)
+	}
+}
+
+func TestMacros(t *testing.T) {
+	pack := assert.Pack
+
+	src := `
+        .macro ldax value
+        lda #<value
+        ldx #>value
+        .endmacro
+
+        .macro wait count
+        ldy #count
+loop:   dey             ; local to each expansion
+        bne loop
+        .endmacro
+
+        .org $400
+        ldax $1234
+        wait 2
+        wait 3
+`
+	a := testAssembler(nil)
+	assert.NoError(t, pack(a.AssembleSource("macro.s", []byte(src))))
+
+	_, bin := a.Binary()
+	assert.Equal(t, bin, []uint8{
+		0xa9, 0x34, 0xa2, 0x12,
+		0xa0, 0x02, 0x88, 0xd0, 0xfd,
+		0xa0, 0x03, 0x88, 0xd0, 0xfd,
+	})
+}
+
+func TestConditionalAndRepeat(t *testing.T) {
+	pack := assert.Pack
+
+	src := `
+debug = 0
+        .org $400
+        .if debug
+        brk
+        .else
+        .if 1
+        nop
+        .endif
+        .endif
+        .repeat 3, n
+        .byte n * 2
+        .endrepeat
+        .if debug = 0
+        rts
+        .endif
+`
+	a := testAssembler(nil)
+	assert.NoError(t, pack(a.AssembleSource("cond.s", []byte(src))))
+
+	_, bin := a.Binary()
+	assert.Equal(t, bin, []uint8{0xea, 0x00, 0x02, 0x04, 0x60})
+}
+
+func TestScopes(t *testing.T) {
+	pack := assert.Pack
+
+	src := `
+        .org $400
+        .proc clear
+        ldx #0
+loop:   sta $d800,x
+        inx
+        bne loop
+        rts
+        .endproc
+
+        .scope data
+value:  .byte 1
+        .endscope
+
+loop:   jsr clear
+        lda data::value
+        jmp clear::loop
+`
+	a := testAssembler(nil)
+	assert.NoError(t, pack(a.AssembleSource("scope.s", []byte(src))))
+
+	_, bin := a.Binary()
+	assert.Equal(t, bin[9:], []uint8{0x01, 0x20, 0x00, 0x04, 0xad, 0x09, 0x04, 0x4c, 0x02, 0x04})
+	assert.Equal(t, a.Symbols()[:3], []Symbol{{"clear", 0x400}, {"clear::loop", 0x402}, {"data::value", 0x409}})
+}
+
+func TestInclude(t *testing.T) {
+	pack := assert.Pack
+
+	a := testAssembler(map[string]string{
+		"src/defs.s":    "        .include \"macros.s\"\nscreen = $400\n",
+		"lib/macros.s":  "        .macro poke addr, value\n        lda #value\n        sta addr\n        .endmacro\n",
+		"src/broken.s":  "        .macro bad\n        lda missing\n        .endmacro\n",
+		"src/nested.s":  "        .include \"broken.s\"\n        bad\n",
+		"src/recurse.s": "        .include \"recurse.s\"\n",
+	})
+	a.IncludePaths = []string{"lib"}
+
+	src := "        .include \"defs.s\"\n        .org $c000\n        poke screen, 1\n"
+	assert.NoError(t, pack(a.AssembleSource("src/main.s", []byte(src))))
+	_, bin := a.Binary()
+	assert.Equal(t, bin, []uint8{0xa9, 0x01, 0x8d, 0x00, 0x04})
+
+	err := a.AssembleSource("src/main.s", []byte("\n        .include \"nested.s\"\n"))
+	assert.Equal(t, fmt.Sprint(err), "src/broken.s:2: Unknown Symbol: missing\n"+
+		"\tin macro bad at src/nested.s:2\n"+
+		"\tincluded from src/main.s:2")
+
+	err = a.AssembleSource("src/main.s", []byte("        .include \"recurse.s\"\n"))
+	assert.HasError(t, pack(err))
+}
+
+func TestBlockErrors(t *testing.T) {
+	errors := []struct {
+		src string
+		msg string
+	}{
+		{"  .if 1\n  nop", "err.s:1: Missing .endif for .if"},
+		{"  .endif", "err.s:1: .endif without .if"},
+		{"  .else", "err.s:1: .else without .if"},
+		{"  .macro lda\n  .endmacro", "err.s:1: Macro name is an instruction: lda"},
+		{"  .macro m a\n  .endmacro\n  m 1, 2", "err.s:3: Too many arguments for macro m"},
+		{"  .if later\n  .endif\nlater:", "err.s:1: Value must not depend on a forward reference: later"},
+		{"  .repeat 2\nx: nop\n  .endrepeat", "err.s:2: Duplicate symbol: x\n\tin .repeat iteration 1 at err.s:1"},
+	}
+
+	for _, test := range errors {
+		a := testAssembler(nil)
+		assert.Equal(t, fmt.Sprint(a.AssembleSource("err.s", []byte(test.src))), test.msg)
+	}
+}
@@ -0,0 +1,273 @@
+package asm6502
+
+import (
+	"fmt"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"strconv"
+	"strings"
+)
+
+// limit on nested includes, macro expansions and blocks, stops runaway recursion
+const maxNesting = 64
+
+// a directive that applies to the lines up to its end directive
+type blockDirective struct {
+	end string
+	run func(a *Assembler, st statement, body []sourceLine) error
+}
+
+type macro struct {
+	name   string
+	params []string
+	body   []sourceLine
+}
+
+// returns the index of the line ending the block opened at lines[start]
+func findBlockEnd(lines []sourceLine, start int, open, end string) (int, error) {
+	depth := 0
+	for n := start + 1; n < len(lines); n++ {
+		st := parseStatement(lines[n].text)
+		switch strings.ToLower(st.op) {
+		case strings.ToLower(open):
+			depth++
+		case end:
+			if depth == 0 {
+				return n, nil
+			}
+			depth--
+		}
+	}
+	return 0, fmt.Errorf("Missing %s for %s", end, open)
+}
+
+func (a *Assembler) assembleBlock(block blockDirective, st statement, body []sourceLine) error {
+	if st.label != "" {
+		if err := a.defineLabel(st.label); err != nil {
+			return err
+		}
+	}
+	return block.run(a, st, body)
+}
+
+/*
+	Assembles lines nested inside the current ones. desc is added to
+	the expansion chain shown in errors if not empty, and the lines are
+	assembled in scope, relative to the current scope, if not empty.
+*/
+func (a *Assembler) assembleNested(desc, scope string, lines []sourceLine) error {
+	if len(a.chain) >= maxNesting {
+		return fmt.Errorf("Nesting deeper than %d", maxNesting)
+	}
+
+	pos, oldScope, oldChain := a.pos, a.scope, a.chain
+	if desc != "" {
+		a.chain = append(a.chain[:len(a.chain):len(a.chain)], desc)
+	}
+	if scope != "" {
+		a.scope = qualify(a.scope, scope)
+	}
+
+	err := a.assembleLines(lines)
+
+	a.pos, a.scope, a.chain = pos, oldScope, oldChain
+	return err
+}
+
+// name for an anonymous scope
+func (a *Assembler) anonymous(prefix string) string {
+	a.blocks++
+	return fmt.Sprintf("%s.%d", prefix, a.blocks)
+}
+
+func checkName(name string) error {
+	if name == "" || scanName(name) != len(name) {
+		return fmt.Errorf("Invalid name: %s", name)
+	}
+	return nil
+}
+
+// .macro name [param, ...]  the body is assembled by using name as an instruction
+func directiveMacro(a *Assembler, st statement, body []sourceLine) error {
+	name := st.args
+	if i := strings.IndexAny(name, " \t"); i >= 0 {
+		name = name[:i]
+	}
+	if err := checkName(name); err != nil {
+		return err
+	}
+	if core6502.InstructionModes(name) != nil {
+		return fmt.Errorf("Macro name is an instruction: %s", name)
+	}
+	if _, ok := a.macros[name]; ok {
+		return fmt.Errorf("Duplicate macro: %s", name)
+	}
+
+	m := &macro{name: name, body: body}
+	for _, param := range splitArgs(st.args[len(name):]) {
+		if err := checkName(param); err != nil {
+			return err
+		}
+		m.params = append(m.params, param)
+	}
+
+	a.macros[name] = m
+	a.listSource(body...)
+	return nil
+}
+
+// substitutes the macro arguments, missing arguments are empty. labels
+// defined by the expansion are local to it
+func (a *Assembler) expandMacro(m *macro, args string) error {
+	argv := splitArgs(args)
+	if len(argv) > len(m.params) {
+		return fmt.Errorf("Too many arguments for macro %s", m.name)
+	}
+
+	subst := map[string]string{}
+	for n, param := range m.params {
+		subst[param] = ""
+		if n < len(argv) {
+			subst[param] = argv[n]
+		}
+	}
+
+	lines := make([]sourceLine, len(m.body))
+	for n, line := range m.body {
+		lines[n] = sourceLine{substitute(line.text, subst), line.pos}
+	}
+
+	a.expanding++
+	defer func() { a.expanding-- }()
+	return a.assembleNested(fmt.Sprintf("in macro %s at %v", m.name, a.pos), a.anonymous(m.name), lines)
+}
+
+// .if expression ... [.else ...] .endif
+func directiveIf(a *Assembler, st statement, body []sourceLine) error {
+	cond, err := a.evalConst(st.args)
+	if err != nil {
+		return err
+	}
+
+	// split at an .else that is not inside a nested .if
+	then, elseLine, otherwise := body, []sourceLine(nil), []sourceLine(nil)
+	depth := 0
+split:
+	for n, line := range body {
+		switch strings.ToLower(parseStatement(line.text).op) {
+		case ".if":
+			depth++
+		case ".endif":
+			depth--
+		case ".else":
+			if depth == 0 {
+				then, elseLine, otherwise = body[:n], body[n:n+1], body[n+1:]
+				break split
+			}
+		}
+	}
+
+	if cond == 0 {
+		a.listSource(then...)
+		a.listSource(elseLine...)
+		return a.assembleNested("", "", otherwise)
+	}
+	if err := a.assembleNested("", "", then); err != nil {
+		return err
+	}
+	a.listSource(elseLine...)
+	a.listSource(otherwise...)
+	return nil
+}
+
+// .repeat count [, name]  name is replaced by the iteration number, from 0
+func directiveRepeat(a *Assembler, st statement, body []sourceLine) error {
+	argv := splitArgs(st.args)
+	if err := argCount(argv, 1, 2); err != nil {
+		return err
+	}
+
+	count, err := a.evalConst(argv[0])
+	if err != nil {
+		return err
+	}
+	if count < 0 || count > 0x10000 {
+		return fmt.Errorf("Invalid count: %d", count)
+	}
+	if count == 0 {
+		a.listSource(body...)
+	}
+
+	subst := map[string]string{}
+	if len(argv) > 1 {
+		if err := checkName(argv[1]); err != nil {
+			return err
+		}
+	}
+
+	a.expanding++
+	defer func() { a.expanding-- }()
+
+	for i := 0; i < count; i++ {
+		lines := body
+		if len(argv) > 1 {
+			subst[argv[1]] = strconv.Itoa(i)
+			lines = make([]sourceLine, len(body))
+			for n, line := range body {
+				lines[n] = sourceLine{substitute(line.text, subst), line.pos}
+			}
+		}
+
+		desc := fmt.Sprintf("in .repeat iteration %d at %v", i, a.pos)
+		if err := a.assembleNested(desc, "", lines); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// .scope [name]  symbols defined in the block are name::symbol
+func directiveScope(a *Assembler, st statement, body []sourceLine) error {
+	name := st.args
+	if name == "" {
+		name = a.anonymous("scope")
+	} else if err := checkName(name); err != nil {
+		return err
+	}
+	return a.assembleNested("", name, body)
+}
+
+// .proc name  defines the label name and a scope of the same name
+func directiveProc(a *Assembler, st statement, body []sourceLine) error {
+	if err := checkName(st.args); err != nil {
+		return err
+	}
+	if err := a.defineLabel(st.args); err != nil {
+		return err
+	}
+	return a.assembleNested("", st.args, body)
+}
+
+// .include "file"
+func directiveInclude(a *Assembler, args string) error {
+	argv := splitArgs(args)
+	if err := argCount(argv, 1, 1); err != nil {
+		return err
+	}
+
+	name, err := parseString(argv[0])
+	if err != nil {
+		return err
+	}
+	src, path, err := a.readRelative(name)
+	if err != nil {
+		return err
+	}
+	return a.assembleNested(fmt.Sprintf("included from %v", a.pos), "", splitLines(path, src))
+}
+
+// an end directive without its opening directive
+func unmatched(open, end string) directiveFunc {
+	return func(a *Assembler, args string) error {
+		return fmt.Errorf("%s without %s", end, open)
+	}
+}
@@ -0,0 +1,217 @@
+package asm6502
+
+import "fmt"
+
+type directiveFunc func(a *Assembler, args string) error
+
+var directives map[string]directiveFunc
+
+var blockDirectives map[string]blockDirective
+
+func init() {
+	directives = map[string]directiveFunc{
+		".org":      directiveOrg,
+		".byte":     directiveByte,
+		".text":     directiveByte,
+		".asciiz":   directiveAsciiz,
+		".word":     directiveWord,
+		".fill":     directiveFill,
+		".res":      directiveFill,
+		".align":    directiveAlign,
+		".incbin":   directiveIncbin,
+		".include":  directiveInclude,
+		".segment":  directiveSegment,
+		".import":   directiveImport,
+		".importzp": directiveImportZp,
+		".export":   directiveExport,
+		".else":     unmatched(".if", ".else"),
+	}
+
+	blockDirectives = map[string]blockDirective{
+		".macro":  {".endmacro", directiveMacro},
+		".if":     {".endif", directiveIf},
+		".repeat": {".endrepeat", directiveRepeat},
+		".scope":  {".endscope", directiveScope},
+		".proc":   {".endproc", directiveProc},
+	}
+
+	for open, block := range blockDirectives {
+		directives[block.end] = unmatched(open, block.end)
+	}
+}
+
+func argCount(args []string, min, max int) error {
+	if len(args) < min || len(args) > max {
+		return fmt.Errorf("Wrong number of arguments")
+	}
+	return nil
+}
+
+// evaluates a value that must fit in bitSize bits, negative values are two's complement
+func (a *Assembler) evalData(arg string, bitSize uint) (int, error) {
+	val, known, err := a.evalString(arg)
+	if err != nil || !known {
+		return 0, err
+	}
+	return checkRange(arg, val, bitSize)
+}
+
+func checkRange(arg string, val int, bitSize uint) (int, error) {
+	if val < -(1<<(bitSize-1)) || val >= 1<<bitSize {
+		return 0, fmt.Errorf("Value out of range: %s = %d", arg, val)
+	}
+	return val & (1<<bitSize - 1), nil
+}
+
+// .org address
+func directiveOrg(a *Assembler, args string) error {
+	if a.Relocatable {
+		return fmt.Errorf("The address is set by the linker in relocatable mode")
+	}
+	addr, err := a.evalConst(args)
+	if err != nil {
+		return err
+	}
+	return a.setPC(addr)
+}
+
+// string arguments give their characters, other arguments are byte values
+func (a *Assembler) dataBytes(args string) ([]uint8, error) {
+	var bytes []uint8
+	for _, arg := range splitArgs(args) {
+		if isString(arg) {
+			s, err := parseString(arg)
+			if err != nil {
+				return nil, err
+			}
+			bytes = append(bytes, s...)
+			continue
+		}
+
+		val, err := a.evalField(arg, 8, a.pc+len(bytes))
+		if err != nil {
+			return nil, err
+		}
+		bytes = append(bytes, uint8(val))
+	}
+	return bytes, nil
+}
+
+// .byte value|"string", ...  .text is the same
+func directiveByte(a *Assembler, args string) error {
+	bytes, err := a.dataBytes(args)
+	if err != nil {
+		return err
+	}
+	return a.emit(bytes...)
+}
+
+// .asciiz "string", ...  adds a zero terminator
+func directiveAsciiz(a *Assembler, args string) error {
+	bytes, err := a.dataBytes(args)
+	if err != nil {
+		return err
+	}
+	return a.emit(append(bytes, 0)...)
+}
+
+// .word value, ...  little endian
+func directiveWord(a *Assembler, args string) error {
+	for _, arg := range splitArgs(args) {
+		val, err := a.evalField(arg, 16, a.pc)
+		if err != nil {
+			return err
+		}
+		if err := a.emit(uint8(val), uint8(val>>8)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Assembler) fill(count int, args []string) error {
+	value := 0
+	if len(args) > 1 {
+		var err error
+		if value, err = a.evalData(args[1], 8); err != nil {
+			return err
+		}
+	}
+
+	bytes := make([]uint8, count)
+	for n := range bytes {
+		bytes[n] = uint8(value)
+	}
+	return a.emit(bytes...)
+}
+
+// .fill count [, value]  .res is the same
+func directiveFill(a *Assembler, args string) error {
+	argv := splitArgs(args)
+	if err := argCount(argv, 1, 2); err != nil {
+		return err
+	}
+
+	count, err := a.evalConst(argv[0])
+	if err != nil {
+		return err
+	}
+	if count < 0 || count > 0x10000 {
+		return fmt.Errorf("Invalid count: %d", count)
+	}
+	return a.fill(count, argv)
+}
+
+// .align boundary [, value]  pads to a multiple of boundary
+func directiveAlign(a *Assembler, args string) error {
+	argv := splitArgs(args)
+	if err := argCount(argv, 1, 2); err != nil {
+		return err
+	}
+
+	align, err := a.evalConst(argv[0])
+	if err != nil {
+		return err
+	}
+	if align < 1 || align > 0x10000 {
+		return fmt.Errorf("Invalid alignment: %d", align)
+	}
+	return a.fill((align-a.pc%align)%align, argv)
+}
+
+// .incbin "file" [, offset [, length]]
+func directiveIncbin(a *Assembler, args string) error {
+	argv := splitArgs(args)
+	if err := argCount(argv, 1, 3); err != nil {
+		return err
+	}
+
+	name, err := parseString(argv[0])
+	if err != nil {
+		return err
+	}
+	data, _, err := a.readRelative(name)
+	if err != nil {
+		return err
+	}
+
+	offset, length := 0, len(data)
+	if len(argv) > 1 {
+		if offset, err = a.evalConst(argv[1]); err != nil {
+			return err
+		}
+		if offset < 0 || offset > len(data) {
+			return fmt.Errorf("Offset %d beyond end of %s", offset, name)
+		}
+		length = len(data) - offset
+	}
+	if len(argv) > 2 {
+		if length, err = a.evalConst(argv[2]); err != nil {
+			return err
+		}
+		if length < 0 || offset+length > len(data) {
+			return fmt.Errorf("Length %d beyond end of %s", length, name)
+		}
+	}
+	return a.emit(data[offset : offset+length]...)
+}
@@ -0,0 +1,161 @@
+package asm6502
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"io"
+	"sort"
+	"strings"
+)
+
+// bytes shown on each listing line, longer output continues on the following lines
+const listingBytes = 4
+
+// a source line in the second pass
+type listingLine struct {
+	pos      Pos
+	text     string
+	addr     int
+	bytes    []uint8
+	label    bool
+	expanded bool // from a macro or .repeat expansion
+	value    *int // value of an assignment
+
+	info        *core6502.OpcodeInfo // nil if not an instruction
+	branchCross bool                 // branch to another page
+}
+
+func (a *Assembler) currentLine() *listingLine {
+	return &a.listing[len(a.listing)-1]
+}
+
+// lists lines that are not assembled, such as a macro definition, an
+// .if branch not taken or the end of a block
+func (a *Assembler) listSource(lines ...sourceLine) {
+	if a.pass != 2 {
+		return
+	}
+	for _, line := range lines {
+		a.listing = append(a.listing, listingLine{pos: line.pos, text: line.text, addr: a.pc, expanded: a.expanding > 0})
+	}
+}
+
+/*
+	Base cycle count of an instruction, with the possible extra cycles:
+	+1 for indexed reads that may cross a page, and for branches +1 if
+	taken, or +2 if taken to another page.
+*/
+func (l *listingLine) cycles() string {
+	if l.info == nil {
+		return ""
+	}
+
+	cycles := fmt.Sprint(l.info.Cycles)
+	switch l.info.PageCross {
+	case core6502.PageCross_Indexed:
+		cycles += "+1"
+	case core6502.PageCross_Branch:
+		if l.branchCross {
+			cycles += "+2"
+		} else {
+			cycles += "+1"
+		}
+	}
+	return cycles
+}
+
+func hexBytes(bytes []uint8) string {
+	var s []string
+	for _, b := range bytes {
+		s = append(s, fmt.Sprintf("%02x", b))
+	}
+	return strings.Join(s, " ")
+}
+
+func formatValue(val int) string {
+	if val >= 0 && val <= 0xffff {
+		return fmt.Sprintf("$%04x", val)
+	}
+	return fmt.Sprint(val)
+}
+
+/*
+	Writes a listing of the second pass: every source line with its
+	address and the bytes emitted if it produced any, and for instructions the base cycle count
+	with any possible extra cycles. Lines from macro and .repeat
+	expansions are marked with + after the line number. A cross
+	reference of all symbols follows.
+*/
+func (a *Assembler) WriteListing(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "Addr   %-*s  Cycles  Line  Source\n", listingBytes*3-1, "Bytes")
+
+	file := ""
+	for _, l := range a.listing {
+		if l.pos.File != file {
+			file = l.pos.File
+			fmt.Fprintf(bw, "\n; %s\n", file)
+		}
+
+		bytes := l.bytes
+		if len(bytes) > listingBytes {
+			bytes = bytes[:listingBytes]
+		}
+
+		addr := ""
+		switch {
+		case l.value != nil:
+			addr = "=" + formatValue(*l.value)
+		case len(l.bytes) > 0 || l.label:
+			addr = fmt.Sprintf("%04x", l.addr)
+		}
+
+		mark := " "
+		if l.expanded {
+			mark = "+"
+		}
+
+		line := fmt.Sprintf("%-6s %-*s  %-6s %5d%s %s", addr, listingBytes*3-1, hexBytes(bytes), l.cycles(), l.pos.Line, mark, l.text)
+		fmt.Fprintln(bw, strings.TrimRight(line, " \t"))
+
+		for n := listingBytes; n < len(l.bytes); n += listingBytes {
+			end := n + listingBytes
+			if end > len(l.bytes) {
+				end = len(l.bytes)
+			}
+			fmt.Fprintf(bw, "%04x   %s\n", l.addr+n, hexBytes(l.bytes[n:end]))
+		}
+	}
+
+	a.writeCrossReference(bw)
+	return bw.Flush()
+}
+
+// symbols by name with their value, definition and references
+func (a *Assembler) writeCrossReference(w io.Writer) {
+	var names []string
+	width, defWidth := len("Symbol"), len("Defined")
+	for name := range a.symbols {
+		names = append(names, name)
+		if len(name) > width {
+			width = len(name)
+		}
+		if len(a.defs[name].String()) > defWidth {
+			defWidth = len(a.defs[name].String())
+		}
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(w, "\n%-*s  Value  %-*s  References\n", width, "Symbol", defWidth, "Defined")
+	for _, name := range names {
+		var refs []string
+		for _, pos := range a.refs[name] {
+			refs = append(refs, pos.String())
+		}
+		line := fmt.Sprintf("%-*s  %-5s  %-*s  %s", width, name, formatValue(a.symbols[name]),
+			defWidth, a.defs[name], strings.Join(refs, " "))
+		fmt.Fprintln(w, strings.TrimRight(line, " "))
+	}
+}
@@ -0,0 +1,108 @@
+package asm6502
+
+import (
+	"bytes"
+	"github.com/simulatedsimian/assert"
+	"strings"
+	"testing"
+)
+
+func TestListing(t *testing.T) {
+	pack := assert.Pack
+
+	src := `count = 2
+        .org $04fc
+start:  ldy #count
+loop:   lda table,y
+        dey
+        bne loop
+        rts
+table:  .byte 1, 2, 3, 4, 5`
+
+	a := testAssembler(nil)
+	assert.NoError(t, pack(a.AssembleSource("list.s", []byte(src))))
+
+	var lst bytes.Buffer
+	assert.NoError(t, pack(a.WriteListing(&lst)))
+	assert.Equal(t, strings.Split(lst.String(), "\n"), []string{
+		"Addr   Bytes        Cycles  Line  Source",
+		"",
+		"; list.s",
+		"=$0002                         1  count = 2",
+		"                               2          .org $04fc",
+		"04fc   a0 02        2          3  start:  ldy #count",
+		"04fe   b9 05 05     4+1        4  loop:   lda table,y",
+		"0501   88           2          5          dey",
+		"0502   d0 fa        2+2        6          bne loop",
+		"0504   60           6          7          rts",
+		"0505   01 02 03 04             8  table:  .byte 1, 2, 3, 4, 5",
+		"0509   05",
+		"",
+		"Symbol  Value  Defined   References",
+		"count   $0002  list.s:1  list.s:3",
+		"loop    $04fe  list.s:4  list.s:6",
+		"start   $04fc  list.s:3",
+		"table   $0505  list.s:8  list.s:4",
+		"",
+	})
+}
+
+// every source line is listed, lines that are not assembled without an address
+func TestListingBlocks(t *testing.T) {
+	pack := assert.Pack
+
+	src := `        .org $0400
+        .macro inc2 addr
+        inc addr
+        inc addr
+        .endmacro
+        inc2 $10
+        .if 1
+        nop
+        .else
+skip:   brk
+        .endif
+        .if 0
+        brk
+        .else
+        rts
+        .endif
+        .repeat 0
+        brk
+        .endrepeat`
+
+	a := testAssembler(nil)
+	assert.NoError(t, pack(a.AssembleSource("blocks.s", []byte(src))))
+
+	var lst bytes.Buffer
+	assert.NoError(t, pack(a.WriteListing(&lst)))
+	assert.Equal(t, strings.Split(lst.String(), "\n"), []string{
+		"Addr   Bytes        Cycles  Line  Source",
+		"",
+		"; blocks.s",
+		"                               1          .org $0400",
+		"                               2          .macro inc2 addr",
+		"                               3          inc addr",
+		"                               4          inc addr",
+		"                               5          .endmacro",
+		"                               6          inc2 $10",
+		"0400   e6 10        5          3+         inc $10",
+		"0402   e6 10        5          4+         inc $10",
+		"                               7          .if 1",
+		"0404   ea           2          8          nop",
+		"                               9          .else",
+		"                              10  skip:   brk",
+		"                              11          .endif",
+		"                              12          .if 0",
+		"                              13          brk",
+		"                              14          .else",
+		"0405   60           6         15          rts",
+		"                              16          .endif",
+		"                              17          .repeat 0",
+		"                              18          brk",
+		"                              19          .endrepeat",
+		"",
+		"Symbol  Value  Defined  References",
+		"",
+	})
+}
@@ -0,0 +1,167 @@
+package asm6502
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// one source line split into its parts
+type statement struct {
+	label  string // label defined at the start of the line, without the colon
+	op     string // mnemonic, directive, or the symbol name of an assignment
+	args   string
+	assign bool // op = args
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || c == '.' || c == '@' || c < 0x80 && unicode.IsLetter(rune(c))
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
+
+// returns the length of the name at the start of s
+func scanName(s string) int {
+	if len(s) == 0 || !isNameStart(s[0]) {
+		return 0
+	}
+	n := 1
+	for n < len(s) && isNameChar(s[n]) {
+		n++
+	}
+	return n
+}
+
+// returns the index of the last byte of the string or character literal at s[i]
+func literalEnd(s string, i int) int {
+	if s[i] == '\'' {
+		return i + 2
+	}
+	for i++; i < len(s) && s[i] != '"'; i++ {
+		if s[i] == '\\' {
+			i++
+		}
+	}
+	return i
+}
+
+// calls f for each byte of s that is not inside a string or character literal,
+// stops early if f returns false
+func scanUnquoted(s string, f func(i int) bool) {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\'' {
+			i = literalEnd(s, i)
+		} else if !f(i) {
+			return
+		}
+	}
+}
+
+// replaces the names in s that are keys of subst, literals are left alone
+func substitute(s string, subst map[string]string) string {
+	out := ""
+	for i := 0; i < len(s); {
+		c := s[i]
+		n := 1
+
+		switch {
+		case c == '"' || c == '\'':
+			n = literalEnd(s, i) + 1 - i
+		case c == '$' || c >= '0' && c <= '9':
+			for i+n < len(s) && isNameChar(s[i+n]) {
+				n++
+			}
+		case isNameStart(c):
+			n = scanName(s[i:])
+			if r, ok := subst[s[i:i+n]]; ok {
+				out += r
+				i += n
+				continue
+			}
+		}
+
+		if i+n > len(s) {
+			n = len(s) - i
+		}
+		out += s[i : i+n]
+		i += n
+	}
+	return out
+}
+
+func stripComment(line string) string {
+	end := len(line)
+	scanUnquoted(line, func(i int) bool {
+		if line[i] == ';' {
+			end = i
+			return false
+		}
+		return true
+	})
+	return line[:end]
+}
+
+func parseStatement(line string) statement {
+	var st statement
+	s := strings.TrimSpace(stripComment(line))
+
+	if n := scanName(s); n > 0 && n < len(s) && s[n] == ':' && (n+1 == len(s) || s[n+1] != ':') {
+		st.label = s[:n]
+		s = strings.TrimSpace(s[n+1:])
+	}
+
+	op := s
+	if i := strings.IndexAny(s, " \t="); i >= 0 {
+		op = s[:i]
+	}
+	st.op = op
+	st.args = strings.TrimSpace(s[len(op):])
+
+	if strings.HasPrefix(st.args, "=") && !strings.HasPrefix(st.args, "==") {
+		st.assign = true
+		st.args = strings.TrimSpace(st.args[1:])
+	}
+	return st
+}
+
+// splits directive arguments at commas that are not inside brackets or quotes
+func splitArgs(s string) []string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+
+	var args []string
+	depth, start := 0, 0
+	scanUnquoted(s, func(i int) bool {
+		switch s[i] {
+		case '(', '[':
+			depth++
+		case ')', ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				args = append(args, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+		return true
+	})
+	return append(args, strings.TrimSpace(s[start:]))
+}
+
+func isString(arg string) bool {
+	return strings.HasPrefix(arg, "\"")
+}
+
+// parses a double quoted string, with Go escape sequences
+func parseString(arg string) (string, error) {
+	s, err := strconv.Unquote(arg)
+	if err != nil || !isString(arg) {
+		return "", fmt.Errorf("Invalid string: %s", arg)
+	}
+	return s, nil
+}
@@ -0,0 +1,260 @@
+package asm6502
+
+import (
+	"fmt"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"github.com/simulatedsimian/emu6502/obj6502"
+)
+
+/*
+	Relocatable output. With Assembler.Relocatable set the source is
+	assembled into named segments selected by .segment, CODE by default,
+	and each label is an offset in its segment. The linker places the
+	segments, so an expression that refers to a label or an imported
+	symbol is emitted with a relocation for the linker to fix up.
+
+	A relocatable expression must be a symbol plus or minus a constant,
+	or the low (<) or high (>) byte of one. .align aligns the offset in
+	the segment, so the segment must be at least as aligned when linked.
+*/
+
+// the segment labels in the ZEROPAGE segment are in, so operands may use zero page modes
+const zeroPageSegment = "ZEROPAGE"
+
+type segment struct {
+	name   string
+	pc     int
+	data   []uint8 // written in the second pass
+	relocs []obj6502.Reloc
+}
+
+// what a relocatable value is relative to, a segment of this object or
+// an imported symbol. The zero value is an absolute value.
+type relocTerm struct {
+	segment string
+	symbol  string
+}
+
+// a value to be fixed up by the linker
+type relocValue struct {
+	term   relocTerm
+	kind   obj6502.RelocKind // Reloc_Word for the whole address, Reloc_Lo or Reloc_Hi
+	addend int
+}
+
+// switches to the segment called name, creating it if it is new
+func (a *Assembler) selectSegment(name string) {
+	if a.segment != nil {
+		a.segment.pc = a.pc
+	}
+	for _, seg := range a.segments {
+		if seg.name == name {
+			a.segment, a.pc = seg, seg.pc
+			return
+		}
+	}
+	a.segment = &segment{name: name}
+	a.segments = append(a.segments, a.segment)
+	a.pc = 0
+}
+
+// the address given to term while evaluating, records that term was used
+func (a *Assembler) relocBase(term relocTerm) int {
+	a.used[term] = true
+	return a.bases[term]
+}
+
+func (a *Assembler) isZeroPage(term relocTerm) bool {
+	return term.segment == zeroPageSegment || a.zeroPage[term.symbol]
+}
+
+/*
+	Evaluates e, returning the relocation needed if e depends on the
+	address of a segment or imported symbol. Each symbol address used is
+	varied in turn: the value must move by the same amount for exactly one
+	of them, and must not move for the others.
+*/
+func (a *Assembler) evalReloc(e *core6502.Expr) (int, *relocValue, bool, error) {
+	if op, operand, ok := e.Unary(); ok && (op == "<" || op == ">") {
+		val, rel, known, err := a.evalReloc(operand)
+		if err != nil || !known || rel != nil {
+			if rel != nil {
+				if rel.kind != obj6502.Reloc_Word {
+					return 0, nil, false, fmt.Errorf("Expression is not relocatable: %s", e)
+				}
+				if rel.kind = obj6502.Reloc_Lo; op == ">" {
+					rel.kind = obj6502.Reloc_Hi
+					val >>= 8
+				}
+				val &= 0xff
+			}
+			return val, rel, known, err
+		}
+	}
+
+	val, known, err := a.evalExpr(e)
+	if err != nil || !known || len(a.used) == 0 {
+		return val, nil, known, err
+	}
+
+	var terms []relocTerm
+	for term := range a.used {
+		terms = append(terms, term)
+	}
+
+	var rel *relocValue
+	for _, term := range terms {
+		var moved [2]int
+		for n, base := range []int{1, 0x1000} {
+			a.bases = map[relocTerm]int{term: base}
+			v, _, err := a.evalExpr(e)
+			a.bases = nil
+			if err != nil {
+				return 0, nil, false, err
+			}
+			moved[n] = v - val
+		}
+
+		switch {
+		case moved[0] == 0 && moved[1] == 0:
+		case moved[0] == 1 && moved[1] == 0x1000 && rel == nil:
+			rel = &relocValue{term, obj6502.Reloc_Word, val}
+		default:
+			return 0, nil, false, fmt.Errorf("Expression is not relocatable: %s", e)
+		}
+	}
+	return val, rel, true, nil
+}
+
+// records a relocation at offset in the current segment, in the second pass
+func (a *Assembler) addReloc(offset int, kind obj6502.RelocKind, rel *relocValue) {
+	if a.pass == 2 {
+		a.segment.relocs = append(a.segment.relocs, obj6502.Reloc{Offset: offset, Kind: kind,
+			Segment: rel.term.segment, Symbol: rel.term.symbol, Addend: rel.addend})
+	}
+}
+
+// evaluates a .byte or .word value at offset in the current segment, which
+// may be relocatable
+func (a *Assembler) evalField(arg string, bitSize uint, offset int) (int, error) {
+	e, err := core6502.ParseExpr(arg)
+	if err != nil {
+		return 0, err
+	}
+	val, rel, known, err := a.evalReloc(e)
+	if err != nil || !known {
+		return 0, err
+	}
+	if rel == nil {
+		return checkRange(arg, val, bitSize)
+	}
+
+	kind := rel.kind
+	if kind == obj6502.Reloc_Word && bitSize == 8 {
+		kind = obj6502.Reloc_Byte
+	}
+	a.addReloc(offset, kind, rel)
+	return val & (1<<bitSize - 1), nil
+}
+
+func (a *Assembler) requireRelocatable(directive string) error {
+	if !a.Relocatable {
+		return fmt.Errorf("%s is only allowed in relocatable mode", directive)
+	}
+	return nil
+}
+
+// .segment "name"
+func directiveSegment(a *Assembler, args string) error {
+	if err := a.requireRelocatable(".segment"); err != nil {
+		return err
+	}
+	name, err := parseString(args)
+	if err != nil {
+		return err
+	}
+	if err := checkName(name); err != nil {
+		return err
+	}
+	a.selectSegment(name)
+	return nil
+}
+
+func (a *Assembler) importSymbols(directive, args string, zeroPage bool) error {
+	if err := a.requireRelocatable(directive); err != nil {
+		return err
+	}
+	for _, name := range splitArgs(args) {
+		if err := checkName(name); err != nil {
+			return err
+		}
+		if err := a.define(name, 0); err != nil {
+			return err
+		}
+		a.terms[qualify(a.scope, name)] = relocTerm{symbol: name}
+		a.zeroPage[name] = zeroPage
+		a.imports = append(a.imports, name)
+	}
+	return nil
+}
+
+// .import name, ...  symbols defined by another object
+func directiveImport(a *Assembler, args string) error {
+	return a.importSymbols(".import", args, false)
+}
+
+// .importzp name, ...  imported symbols in zero page
+func directiveImportZp(a *Assembler, args string) error {
+	return a.importSymbols(".importzp", args, true)
+}
+
+// .export name, ...  makes symbols visible to other objects
+func directiveExport(a *Assembler, args string) error {
+	if err := a.requireRelocatable(".export"); err != nil {
+		return err
+	}
+	for _, name := range splitArgs(args) {
+		if err := checkName(name); err != nil {
+			return err
+		}
+		if a.pass == 1 {
+			continue
+		}
+
+		val, key, ok := a.lookup(name)
+		if !ok {
+			return fmt.Errorf("Exported symbol not defined: %s", name)
+		}
+		term := a.terms[key]
+		if term.symbol != "" {
+			return fmt.Errorf("Cannot export imported symbol: %s", name)
+		}
+		for _, exp := range a.exports {
+			if exp.Name == name {
+				return fmt.Errorf("Duplicate export: %s", name)
+			}
+		}
+		a.exports = append(a.exports, obj6502.Export{Name: name, Segment: term.segment, Value: val})
+	}
+	return nil
+}
+
+// returns the output of a relocatable assembly as an object, source names the object
+func (a *Assembler) Object(source string) (*obj6502.Object, error) {
+	if !a.Relocatable {
+		return nil, fmt.Errorf("Not assembled as relocatable")
+	}
+
+	obj := obj6502.New(source)
+	for _, seg := range a.segments {
+		if len(seg.data) == 0 {
+			continue
+		}
+		s := obj.Segment(seg.name)
+		s.Data = seg.data
+		s.Relocs = seg.relocs
+	}
+	obj.Exports = a.exports
+	obj.Imports = a.imports
+	return obj, nil
+}
@@ -0,0 +1,128 @@
+package asm6502
+
+import (
+	"fmt"
+	"github.com/simulatedsimian/assert"
+	"github.com/simulatedsimian/emu6502/obj6502"
+	"testing"
+)
+
+func assembleObject(t *testing.T, name, src string) *obj6502.Object {
+	pack := assert.Pack
+
+	a := testAssembler(nil)
+	a.Relocatable = true
+	assert.NoError(t, pack(a.AssembleSource(name, []byte(src))))
+	obj, err := a.Object(name)
+	assert.NoError(t, pack(err))
+	return obj
+}
+
+func TestRelocatable(t *testing.T) {
+	obj := assembleObject(t, "main.s", `
+        .import print
+        .importzp ptr
+        .export main, table
+main:   lda #<table
+        sta ptr
+        lda #>(table+1)
+        jsr print
+loop:   bne loop
+        jmp loop
+        .segment "DATA"
+table:  .byte 1, <main
+        .word main+2, print
+        .segment "ZEROPAGE"
+tmp:    .res 2
+        .segment "CODE"
+        lda tmp+1
+`)
+
+	code := obj.Segment("CODE")
+	assert.Equal(t, code.Data, []uint8{
+		0xa9, 0x00, // lda #<table
+		0x85, 0x00, // sta ptr
+		0xa9, 0x00, // lda #>(table+1)
+		0x20, 0x00, 0x00, // jsr print
+		0xd0, 0xfe, // bne loop
+		0x4c, 0x09, 0x00, // jmp loop
+		0xa5, 0x01, // lda tmp+1
+	})
+	assert.Equal(t, code.Relocs, []obj6502.Reloc{
+		{Offset: 1, Kind: obj6502.Reloc_Lo, Segment: "DATA"},
+		{Offset: 3, Kind: obj6502.Reloc_Byte, Symbol: "ptr"},
+		{Offset: 5, Kind: obj6502.Reloc_Hi, Segment: "DATA", Addend: 1},
+		{Offset: 7, Kind: obj6502.Reloc_Word, Symbol: "print"},
+		{Offset: 12, Kind: obj6502.Reloc_Word, Segment: "CODE", Addend: 9},
+		{Offset: 15, Kind: obj6502.Reloc_Byte, Segment: "ZEROPAGE", Addend: 1},
+	})
+
+	data := obj.Segment("DATA")
+	assert.Equal(t, data.Data, []uint8{1, 0, 2, 0, 0, 0})
+	assert.Equal(t, data.Relocs, []obj6502.Reloc{
+		{Offset: 1, Kind: obj6502.Reloc_Lo, Segment: "CODE"},
+		{Offset: 2, Kind: obj6502.Reloc_Word, Segment: "CODE", Addend: 2},
+		{Offset: 4, Kind: obj6502.Reloc_Word, Symbol: "print"},
+	})
+
+	assert.Equal(t, obj.Imports, []string{"print", "ptr"})
+	assert.Equal(t, obj.Exports, []obj6502.Export{{Name: "main", Segment: "CODE"}, {Name: "table", Segment: "DATA"}})
+}
+
+func TestAssembleAndLink(t *testing.T) {
+	pack := assert.Pack
+
+	main := assembleObject(t, "main.s", `
+        .import print
+        .export main
+main:   jsr print
+        jmp main
+`)
+	lib := assembleObject(t, "lib.s", `
+        .export print
+print:  lda message
+        rts
+        .segment "DATA"
+message:
+        .byte "A"
+`)
+
+	prog, err := obj6502.Link(obj6502.DefaultConfig(), []*obj6502.Object{main, lib})
+	assert.NoError(t, pack(err))
+	assert.Equal(t, prog.Start, 0x400)
+	assert.Equal(t, prog.Data, []uint8{
+		0x20, 0x06, 0x04, // jsr print
+		0x4c, 0x00, 0x04, // jmp main
+		0xad, 0x0a, 0x04, // lda message
+		0x60, // rts
+		'A',
+	})
+	assert.Equal(t, prog.Symbols, map[string]int{"main": 0x400, "print": 0x406})
+}
+
+func TestRelocatableErrors(t *testing.T) {
+	errors := []struct {
+		src string
+		msg string
+	}{
+		{"  .org $400", "err.s:1: The address is set by the linker in relocatable mode"},
+		{"a: .byte a*2", "err.s:1: Expression is not relocatable: a*2"},
+		{"a: .word a+b\nb:", "err.s:1: Expression is not relocatable: a+b"},
+		{"  .import x\n  bne x", "err.s:2: Branch target not in the current segment: x"},
+		{"  .segment \"DATA\"\ndst:\n  .segment \"CODE\"\n  beq dst", "err.s:4: Branch target not in the current segment: dst"},
+		{"  bne $0010", "err.s:1: Branch target not in the current segment: $0010"},
+		{"a: .fill a", "err.s:1: Value must be a constant: a"},
+		{"  .export b", "err.s:1: Exported symbol not defined: b"},
+		{"  .import x\n  .export x", "err.s:2: Cannot export imported symbol: x"},
+	}
+
+	for _, test := range errors {
+		a := testAssembler(nil)
+		a.Relocatable = true
+		assert.Equal(t, fmt.Sprint(a.AssembleSource("err.s", []byte(test.src))), test.msg)
+	}
+
+	a := testAssembler(nil)
+	assert.Equal(t, fmt.Sprint(a.AssembleSource("err.s", []byte("  .segment \"DATA\""))),
+		"err.s:1: .segment is only allowed in relocatable mode")
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/simulatedsimian/emu6502/asm6502"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+// repeated -I flags
+type pathList []string
+
+func (l *pathList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *pathList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// source file name with its extension replaced by ext
+func outputName(source, ext string) string {
+	return strings.TrimSuffix(source, filepath.Ext(source)) + ext
+}
+
+func writeListing(asm *asm6502.Assembler, filename string) {
+	if filename == "" {
+		return
+	}
+	var lst bytes.Buffer
+	asm.WriteListing(&lst)
+	if err := ioutil.WriteFile(filename, lst.Bytes(), 0644); err != nil {
+		fail(err)
+	}
+}
+
+func main() {
+	output := flag.String("o", "", "write the binary to `file`, default <source>.bin, or <source>.o with -c")
+	object := flag.Bool("c", false, "write a relocatable object file for ld6502 instead of a binary")
+	labels := flag.String("l", "", "write VICE labels to `file`, default <source>.lbl")
+	listing := flag.String("L", "", "write a listing with cycle counts and symbol cross reference to `file`")
+	var includePaths pathList
+	flag.Var(&includePaths, "I", "search `dir` for .include and .incbin files, may be repeated")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] source.s\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	source := flag.Arg(0)
+
+	if *output == "" {
+		if *object {
+			*output = outputName(source, ".o")
+		} else {
+			*output = outputName(source, ".bin")
+		}
+	}
+	if *labels == "" {
+		*labels = outputName(source, ".lbl")
+	}
+
+	asm := asm6502.New()
+	asm.IncludePaths = includePaths
+	asm.Relocatable = *object
+	if err := asm.AssembleFile(source); err != nil {
+		fail(err)
+	}
+
+	writeListing(asm, *listing)
+
+	if *object {
+		obj, err := asm.Object(source)
+		if err != nil {
+			fail(err)
+		}
+		if err := obj.WriteFile(*output); err != nil {
+			fail(err)
+		}
+		for _, seg := range obj.Segments {
+			fmt.Printf("%s: %s %d bytes\n", *output, seg.Name, len(seg.Data))
+		}
+		return
+	}
+
+	start, bin := asm.Binary()
+	if err := ioutil.WriteFile(*output, bin, 0644); err != nil {
+		fail(err)
+	}
+
+	var lbl bytes.Buffer
+	asm.WriteLabels(&lbl)
+	if err := ioutil.WriteFile(*labels, lbl.Bytes(), 0644); err != nil {
+		fail(err)
+	}
+
+	if len(bin) > 0 {
+		fmt.Printf("%s: %d bytes at $%04x-$%04x\n", *output, len(bin), start, int(start)+len(bin)-1)
+	} else {
+		fmt.Printf("%s: no output\n", *output)
+	}
+}
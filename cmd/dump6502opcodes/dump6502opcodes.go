@@ -1,11 +1,50 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"github.com/simulatedsimian/emu6502/core6502"
+	"os"
 )
 
 func main() {
+	absBranches := flag.Bool("abs", false, "show branch operands as absolute target addresses")
+	symFile := flag.String("sym", "", "replace operand addresses with symbols from `file`")
+	style := flag.String("style", "default", "disassembly syntax: default or ca65")
+	letterCase := flag.String("case", "default", "letter case: default, upper or lower")
+	flag.Parse()
+
+	opts := &core6502.DisasmOptions{AbsoluteBranches: *absBranches}
+
+	switch *style {
+	case "default":
+	case "ca65":
+		opts.Style = core6502.DisasmStyle_CA65
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid style: %s\n", *style)
+		os.Exit(1)
+	}
+
+	switch *letterCase {
+	case "default":
+	case "upper":
+		opts.Case = core6502.DisasmCase_Upper
+	case "lower":
+		opts.Case = core6502.DisasmCase_Lower
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid case: %s\n", *letterCase)
+		os.Exit(1)
+	}
+
+	if *symFile != "" {
+		symbols := core6502.NewSymbolTable()
+		if _, err := symbols.LoadFile(*symFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		opts.Symbols = symbols
+	}
+
 	var ctx core6502.BasicCPUContext
 	core6502.HardResetCPU(&ctx, 0x400)
 	ctx.PokeWord(0x401, 0xeeff)
@@ -13,15 +52,17 @@ func main() {
 	for opcode := 0; opcode < 256; opcode++ {
 		ctx.Poke(0x400, uint8(opcode))
 
-		dis, len, ok := core6502.Disassemble(&ctx, 0x400)
-		if ok {
-			if len == 1 {
-				fmt.Printf("$%02x:              %s\n", opcode, dis)
-			} else if len == 2 {
-				fmt.Printf("$%02x $%02x:          %s\n", opcode, ctx.Peek(0x401), dis)
-			} else if len == 3 {
-				fmt.Printf("$%02x $%02x $%02x:      %s\n", opcode, ctx.Peek(0x401), ctx.Peek(0x402), dis)
+		inst := core6502.Decode(&ctx, 0x400)
+		if inst.Legal {
+			if label, ok := opts.Label(inst.Addr); ok {
+				fmt.Println(label)
+			}
+
+			bytes := fmt.Sprintf("$%02x", inst.Opcode)
+			for _, b := range inst.Operand {
+				bytes += fmt.Sprintf(" $%02x", b)
 			}
+			fmt.Printf("%-18s%s\n", bytes+":", opts.FormatInstruction(&inst))
 		}
 	}
 }
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"io"
+	"strings"
+)
+
+// interactive assembly, entered with "a <address>". while active each line
+// entered is assembled at addr, an empty line returns to command mode
+type asmMode struct {
+	active bool
+	addr   uint16
+}
+
+var assembly asmMode
+
+// assembles instr at addr and echoes the result, returns the next address
+func assembleAt(ctx core6502.CPUContext, out io.Writer, addr uint16, instr string) (uint16, error) {
+	next, err := core6502.AssembleSymbols(ctx, addr, instr, symbols)
+	if err != nil {
+		return addr, err
+	}
+	clearJournal()
+
+	inst := core6502.Decode(ctx, addr)
+	fmt.Fprintln(out, listingLine(&inst, disasmOptions()))
+	return next, nil
+}
+
+func disasmOptions() *core6502.DisasmOptions {
+	return &core6502.DisasmOptions{AbsoluteBranches: true, Symbols: symbols}
+}
+
+// the address, bytes and disassembly of inst
+func listingLine(inst *core6502.Instruction, opts *core6502.DisasmOptions) string {
+	bytes := fmt.Sprintf("%02x", inst.Opcode)
+	for _, b := range inst.Operand {
+		bytes += fmt.Sprintf(" %02x", b)
+	}
+	return fmt.Sprintf("$%04x  %-8s  %s", inst.Addr, bytes, opts.FormatInstruction(inst))
+}
+
+func asm(ctx core6502.CPUContext, out io.Writer, addr uint16, instr string) error {
+	_, err := assembleAt(ctx, out, addr, instr)
+	return err
+}
+
+// decodes count instructions from addr
+func decodeFrom(ctx core6502.CPUContext, addr uint16, count int) []core6502.Instruction {
+	var insts []core6502.Instruction
+	for ; count > 0; count-- {
+		inst := core6502.Decode(ctx, addr)
+		insts = append(insts, inst)
+		addr += inst.Length
+	}
+	return insts
+}
+
+// lists count instructions from addr, 10 if count is omitted, with their labels
+func disassemble(ctx core6502.CPUContext, out io.Writer, addr uint16, args []string) error {
+	count := 10
+	if len(args) > 0 {
+		val, _, err := evalArg(ctx, strings.Join(args, " "), 16)
+		if err != nil {
+			return err
+		}
+		count = int(val)
+	}
+
+	opts := disasmOptions()
+	for _, inst := range decodeFrom(ctx, addr, count) {
+		if label, ok := opts.Label(inst.Addr); ok {
+			fmt.Fprintln(out, label)
+		}
+		fmt.Fprintln(out, listingLine(&inst, opts))
+	}
+	return nil
+}
+
+func enterAsmMode(ctx core6502.CPUContext, out io.Writer, addr uint16) error {
+	assembly = asmMode{true, addr}
+	fmt.Fprintf(out, "Assembling at $%04x, empty line to exit\n", addr)
+	return nil
+}
+
+// handles a line entered in assembly mode, errors leave the address unchanged
+func assembleLine(ctx core6502.CPUContext, out io.Writer, line string) error {
+	if strings.TrimSpace(line) == "" {
+		assembly.active = false
+		return nil
+	}
+
+	next, err := assembleAt(ctx, out, assembly.addr, line)
+	assembly.addr = next
+	return err
+}
+
+// label shown before the command input
+func commandPrompt() string {
+	if assembly.active {
+		return fmt.Sprintf("$%04x:", assembly.addr)
+	}
+	return "Command:"
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/simulatedsimian/assert"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAsmMode(t *testing.T) {
+	pack := assert.Pack
+	defer func() { assembly = asmMode{} }()
+
+	ctx := &core6502.BasicCPUContext{}
+	assert.Equal(t, commandPrompt(), "Command:")
+
+	tests := []struct {
+		line, out, err, prompt string
+	}{
+		{"a $0400", "Assembling at $0400, empty line to exit\n", "<nil>", "$0400:"},
+		{"lda #$01", "$0400  a9 01     LDA #$01\n", "<nil>", "$0402:"},
+		{"sta $0300,x", "$0402  9d 00 03  STA $0300, X\n", "<nil>", "$0405:"},
+		{"lda ($10", "", "Missing ')': ($10", "$0405:"},
+		{"", "", "<nil>", "Command:"},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		quit, err := DispatchCommand(ctx, test.line, &out)
+		assert.Equal(t, quit, false)
+		assert.Equal(t, out.String(), test.out)
+		assert.Equal(t, fmt.Sprint(err), test.err)
+		assert.Equal(t, commandPrompt(), test.prompt)
+	}
+
+	var mem [6]uint8
+	for n := range mem {
+		mem[n] = ctx.Peek(0x0400 + uint16(n))
+	}
+	assert.Equal(t, mem, [6]uint8{0xa9, 0x01, 0x9d, 0x00, 0x03, 0x00})
+
+	// back in command mode, lines are commands again
+	var out bytes.Buffer
+	_, err := DispatchCommand(ctx, "sm $0405 $ea", &out)
+	assert.NoError(t, pack(err))
+	assert.Equal(t, ctx.Peek(0x0405), uint8(0xea))
+}
+
+// the RPC and scripts run commands while the console is assembling
+func TestAsmModeCommands(t *testing.T) {
+	pack := assert.Pack
+	defer func() { assembly = asmMode{} }()
+
+	dir, err := ioutil.TempDir("", "asmmode")
+	assert.NoError(t, pack(err))
+	defer os.RemoveAll(dir)
+
+	ctx := &core6502.BasicCPUContext{}
+	var out bytes.Buffer
+	_, err = DispatchCommand(ctx, "a $0400", &out)
+	assert.NoError(t, pack(err))
+
+	s := &rpcServer{ctx: ctx}
+	_, quit, err := s.invoke("command", json.RawMessage(`["sm $10 5"]`))
+	assert.NoError(t, pack(err))
+	assert.Equal(t, quit, false)
+	assert.Equal(t, ctx.Peek(0x0010), uint8(5))
+
+	file := filepath.Join(dir, "test.star")
+	assert.NoError(t, pack(ioutil.WriteFile(file, []byte(`command("sm $11 6")`), 0644)))
+	assert.NoError(t, pack(runCommand(ctx, "script", "run "+file, &out)))
+	assert.Equal(t, ctx.Peek(0x0011), uint8(6))
+
+	// nothing was assembled and the console is still assembling
+	assert.Equal(t, ctx.Peek(0x0400), uint8(0))
+	assert.Equal(t, commandPrompt(), "$0400:")
+}
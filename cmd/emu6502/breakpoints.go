@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"io"
+	"sort"
+)
+
+// upper limit on instructions executed by a single "g" command
+const maxRunInstructions = 1000000
+
+// stops execution when the PC reaches addr and cond (if any) is non zero,
+// and hook (if any) decides to stop
+type breakpoint struct {
+	addr uint16
+	cond *core6502.Expr
+	hook breakHook
+}
+
+// stops execution after a write to addr when cond (if any) is non zero,
+// and hook (if any) decides to stop
+type watchpoint struct {
+	addr uint16
+	cond *core6502.Expr
+	hook breakHook
+}
+
+// decides whether a breakpoint or watchpoint at addr stops execution,
+// writing any output to out
+type breakHook interface {
+	Hit(ctx core6502.CPUContext, out io.Writer, addr uint16) (bool, error)
+	String() string
+}
+
+var breakpoints = map[uint16]*breakpoint{}
+var watchpoints = map[uint16]*watchpoint{}
+
+func condString(cond *core6502.Expr) string {
+	if cond == nil {
+		return ""
+	}
+	return " if " + cond.String()
+}
+
+func condTrue(ctx core6502.CPUContext, cond *core6502.Expr) (bool, error) {
+	if cond == nil {
+		return true, nil
+	}
+	val, err := cond.Eval(exprEnv(ctx))
+	return val != 0, err
+}
+
+func hookString(hook breakHook) string {
+	if hook == nil {
+		return ""
+	}
+	return " hook " + hook.String()
+}
+
+// true if cond holds and then hook decides to stop
+func shouldStop(ctx core6502.CPUContext, out io.Writer, addr uint16, cond *core6502.Expr, hook breakHook) (bool, error) {
+	hit, err := condTrue(ctx, cond)
+	if !hit || err != nil || hook == nil {
+		return hit, err
+	}
+	return hook.Hit(ctx, out, addr)
+}
+
+func setBreakpoint(ctx core6502.CPUContext, addr uint16, cond *core6502.Expr) error {
+	breakpoints[addr] = &breakpoint{addr: addr, cond: cond}
+	return nil
+}
+
+func clearBreakpoint(ctx core6502.CPUContext, addr uint16) error {
+	if _, ok := breakpoints[addr]; !ok {
+		return fmt.Errorf("No Breakpoint at: $%04x", addr)
+	}
+	delete(breakpoints, addr)
+	return nil
+}
+
+func setWatchpoint(ctx core6502.CPUContext, addr uint16, cond *core6502.Expr) error {
+	watchpoints[addr] = &watchpoint{addr: addr, cond: cond}
+	return nil
+}
+
+func clearWatchpoint(ctx core6502.CPUContext, addr uint16) error {
+	if _, ok := watchpoints[addr]; !ok {
+		return fmt.Errorf("No Watchpoint at: $%04x", addr)
+	}
+	delete(watchpoints, addr)
+	return nil
+}
+
+func listBreakpoints(ctx core6502.CPUContext, out io.Writer) error {
+	var addrs []uint16
+	for addr := range breakpoints {
+		addrs = append(addrs, addr)
+	}
+	for _, addr := range sortAddrs(addrs) {
+		bp := breakpoints[addr]
+		fmt.Fprintf(out, "break $%04x%s%s\n", addr, condString(bp.cond), hookString(bp.hook))
+	}
+
+	addrs = addrs[:0]
+	for addr := range watchpoints {
+		addrs = append(addrs, addr)
+	}
+	for _, addr := range sortAddrs(addrs) {
+		wp := watchpoints[addr]
+		fmt.Fprintf(out, "watch $%04x%s%s\n", addr, condString(wp.cond), hookString(wp.hook))
+	}
+	return nil
+}
+
+func sortAddrs(addrs []uint16) []uint16 {
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs
+}
+
+// wraps a CPUContext, recording writes to watched addresses
+type watchContext struct {
+	core6502.AccessContext
+	written []uint16
+}
+
+func newWatchContext(ctx core6502.CPUContext) *watchContext {
+	w := &watchContext{}
+	w.CPUContext = ctx
+	w.Store = func(addr uint16) {
+		if _, ok := watchpoints[addr]; ok {
+			w.written = append(w.written, addr)
+		}
+	}
+	return w
+}
+
+// returns the first watchpoint written by the last instruction whose
+// condition holds and whose hook stops, hooks write to out
+func checkWatchpoints(w *watchContext, out io.Writer) (*watchpoint, error) {
+	written := w.written
+	w.written = w.written[:0]
+
+	for _, addr := range written {
+		wp := watchpoints[addr]
+		if hit, err := shouldStop(w.CPUContext, out, addr, wp.cond, wp.hook); hit || err != nil {
+			return wp, err
+		}
+	}
+	return nil, nil
+}
+
+// returns the breakpoint at the PC if it stops, its hook writes to out
+func checkBreakpoint(ctx core6502.CPUContext, out io.Writer) (*breakpoint, error) {
+	bp, ok := breakpoints[ctx.RegPC()]
+	if !ok {
+		return nil, nil
+	}
+	if hit, err := shouldStop(ctx, out, bp.addr, bp.cond, bp.hook); hit || err != nil {
+		return bp, err
+	}
+	return nil, nil
+}
+
+// the cycles executed by x and g since the emulator started
+var totalCycles uint64
+
+// executes an instruction, writing its trace line first if tracing is on,
+// tracking calls, and recording it in the journal and if profiling or
+// recording coverage
+func execute(ctx core6502.CPUContext) (int, error) {
+	return executeWith(ctx, ctx)
+}
+
+// executes an instruction as execute does, executing it with mem, a wrapper
+// of ctx that only sees the memory accesses the instruction makes
+func executeWith(ctx, mem core6502.CPUContext) (int, error) {
+	pc := ctx.RegPC()
+	opcode := ctx.Peek(pc)
+
+	if trace.tracer != nil {
+		trace.tracer.Trace(ctx)
+	}
+
+	if journal != nil {
+		mem = journal.Begin(mem)
+	}
+
+	var cycles int
+	var err error
+	if coverer != nil {
+		cycles, err = coverer.Execute(mem)
+	} else {
+		cycles, err = core6502.Execute(mem)
+	}
+
+	totalCycles += uint64(cycles)
+	if err == nil && journal != nil {
+		journal.Commit(cycles)
+	}
+	if trace.tracer != nil {
+		trace.tracer.Cycles += uint64(cycles)
+	}
+
+	if err == nil {
+		calls.Record(ctx, pc, opcode)
+	}
+	if err == nil && profiler != nil {
+		profiler.Record(ctx, pc, opcode, cycles)
+	}
+	return cycles, err
+}
+
+// delivers an interrupt before the next instruction, deliver pushes the PC
+// and flags and returns the cycles taken. It is recorded as an instruction is
+func deliverInterrupt(ctx core6502.CPUContext, deliver func(ctx core6502.CPUContext) int) {
+	mem := ctx
+	if journal != nil {
+		mem = journal.Begin(ctx)
+	}
+	cycles := deliver(mem)
+
+	totalCycles += uint64(cycles)
+	if trace.tracer != nil {
+		trace.tracer.Cycles += uint64(cycles)
+	}
+	if journal != nil {
+		journal.Commit(cycles)
+	}
+	calls.Interrupt(ctx)
+}
+
+// executes instructions until a breakpoint or watchpoint triggers, an
+// invalid instruction is reached or maxRunInstructions have executed.
+// a breakpoint at the starting PC is ignored so execution can resume from it
+func run(ctx core6502.CPUContext, out io.Writer) error {
+	wctx := newWatchContext(ctx)
+
+	for n := 0; n < maxRunInstructions; n++ {
+		if n > 0 {
+			bp, err := checkBreakpoint(ctx, out)
+			if err != nil {
+				return err
+			}
+			if bp != nil {
+				fmt.Fprintf(out, "Breakpoint at $%04x%s\n", bp.addr, condString(bp.cond))
+				return nil
+			}
+		}
+
+		pc := ctx.RegPC()
+		if _, err := execute(wctx); err != nil {
+			return err
+		}
+
+		wp, err := checkWatchpoints(wctx, out)
+		if err != nil {
+			return err
+		}
+		if wp != nil {
+			fmt.Fprintf(out, "Watchpoint $%04x written at $%04x%s\n", wp.addr, pc, condString(wp.cond))
+			return nil
+		}
+	}
+
+	fmt.Fprintf(out, "Stopped after %d instructions\n", maxRunInstructions)
+	return nil
+}
+
+// runs as g does until the PC reaches addr
+func runTo(ctx core6502.CPUContext, out io.Writer, addr uint16) error {
+	if _, ok := breakpoints[addr]; !ok {
+		breakpoints[addr] = &breakpoint{addr: addr}
+		defer delete(breakpoints, addr)
+	}
+	return run(ctx, out)
+}
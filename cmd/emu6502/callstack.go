@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"io"
+	"strings"
+)
+
+// the shadow call stack of the instructions executed by x and g
+var calls core6502.CallStack
+
+// addr with its symbol, if it has one
+func addrName(addr uint16) string {
+	return strings.TrimSpace(fmt.Sprintf("$%04x %s", addr, symbols.Format(addr)))
+}
+
+// irq delivers an IRQ before the next instruction, unless the I flag is set
+func irq(ctx core6502.CPUContext) error {
+	if ctx.Flag(core6502.Flag_I) {
+		return fmt.Errorf("IRQ is masked by the I flag")
+	}
+	deliverInterrupt(ctx, func(ctx core6502.CPUContext) int {
+		cycles, _ := core6502.IRQ(ctx)
+		return cycles
+	})
+	return nil
+}
+
+// nmi delivers an NMI before the next instruction
+func nmi(ctx core6502.CPUContext) {
+	deliverInterrupt(ctx, core6502.NMI)
+}
+
+/*
+	bt lists the calls in progress, innermost first, with where each was
+	called from and the bytes pushed in it after its return address. A
+	return address changed on the stack since the call is shown.
+*/
+func backtrace(ctx core6502.CPUContext, out io.Writer) {
+	calls.Sync(ctx)
+	fmt.Fprintf(out, "PC %s\n", addrName(ctx.RegPC()))
+
+	for n := len(calls.Frames) - 1; n >= 0; n-- {
+		f := &calls.Frames[n]
+		fmt.Fprintf(out, "#%-2d %-9s %-20s from %s\n", len(calls.Frames)-1-n, f.Kind, addrName(f.Target), addrName(f.Site))
+
+		if pushed := calls.Pushed(ctx, n); len(pushed) > 0 {
+			bytes := make([]string, len(pushed))
+			for i, b := range pushed {
+				bytes[i] = fmt.Sprintf("$%02x", b)
+			}
+			fmt.Fprintf(out, "    pushed %s\n", strings.Join(bytes, " "))
+		}
+		if !f.Intact(ctx) {
+			fmt.Fprintf(out, "    return address changed from $%04x to $%04x\n", f.Return, f.Returns(ctx))
+		}
+	}
+}
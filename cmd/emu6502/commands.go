@@ -3,7 +3,10 @@ package main
 import (
 	"fmt"
 	"github.com/simulatedsimian/emu6502/core6502"
+	"github.com/simulatedsimian/emu6502/nes6502"
+	"io"
 	"reflect"
+	"strings"
 )
 
 type commandInfo struct {
@@ -12,83 +15,212 @@ type commandInfo struct {
 }
 
 var commands = map[string]commandInfo{
-	"sm":        {"Set Memory:   sm <address> <value>", reflect.ValueOf(setMemory)},
-	"sb":        {"Set Block:    sb <address> <count> <value>", reflect.ValueOf(setMemoryBlock)},
-	"sr":        {"Set Register: sr <reg> <value>", reflect.ValueOf(setReg)},
-	"ps":        {"Push Stack:   ps <value>", reflect.ValueOf(push)},
-	"x":         {"Exec Instruction: x", reflect.ValueOf(execInstr)},
-	"softreset": {"", reflect.ValueOf(core6502.SoftResetCPU)},
-	"hardreset": {"", reflect.ValueOf(core6502.HardResetCPU)},
-	"asm":       {"Assemble:     asm: <address> <instruction>", reflect.ValueOf(asm)},
+	"sm":              {"Set Memory:   sm <address> <value> [value...]", reflect.ValueOf(setMemory)},
+	"sb":              {"Set Block:    sb <address> <count> <value>", reflect.ValueOf(setMemoryBlock)},
+	"sr":              {"Set Register: sr <reg> <value>", reflect.ValueOf(setReg)},
+	"ps":              {"Push Stack:   ps <value>", reflect.ValueOf(push)},
+	"x":               {"Exec Instruction: x [count]", reflect.ValueOf(execInstr)},
+	"g":               {"Go:           g", reflect.ValueOf(run)},
+	"until":           {"Run Until:    until <address>", reflect.ValueOf(runTo)},
+	"bp":              {"Breakpoint:   bp <address> [condition]", reflect.ValueOf(setBreakpoint)},
+	"bc":              {"Clear Break:  bc <address>", reflect.ValueOf(clearBreakpoint)},
+	"wp":              {"Watchpoint:   wp <address> [condition]", reflect.ValueOf(setWatchpoint)},
+	"wc":              {"Clear Watch:  wc <address>", reflect.ValueOf(clearWatchpoint)},
+	"bl":              {"List Breaks:  bl", reflect.ValueOf(listBreakpoints)},
+	"?":               {"Evaluate:     ? <expression>", reflect.ValueOf(evaluate)},
+	"sym":             {"Symbols:      sym load <file> | sym list [filter] | sym clear", reflect.ValueOf(symCommand)},
+	"softreset":       {"", reflect.ValueOf(softReset)},
+	"hardreset":       {"", reflect.ValueOf(hardResetCommand)},
+	"asm":             {"Assemble:     asm <address> <instruction>", reflect.ValueOf(asm)},
+	"a":               {"Asm Mode:     a <address>, empty line to exit", reflect.ValueOf(enterAsmMode)},
+	"d":               {"Disassemble:  d <address> [count]", reflect.ValueOf(disassemble)},
+	"load":            {"Load:         load <file> [address] [pc]", reflect.ValueOf(loadImage)},
+	"save":            {"Save:         save <file> <start> <end>", reflect.ValueOf(saveImage)},
+	"trace":           {"Trace:        trace on <file> | trace off", reflect.ValueOf(traceCommand)},
+	"prof":            {"Profile:      prof start | prof stop | prof report [count] | prof write <file>", reflect.ValueOf(profCommand)},
+	"cov":             {"Coverage:     cov start | cov stop | cov report [start end] | cov annotate <start> <end> [file] | cov lcov <dbgfile> <file>", reflect.ValueOf(covCommand)},
+	"bt":              {"Backtrace:    bt", reflect.ValueOf(backtrace)},
+	"irq":             {"Interrupt:    irq", reflect.ValueOf(irq)},
+	"nmi":             {"NMI:          nmi", reflect.ValueOf(nmi)},
+	"back":            {"Step Back:    back [count]", reflect.ValueOf(back)},
+	"rewind-to-write": {"Rewind:       rewind-to-write <address>", reflect.ValueOf(rewindToWrite)},
+	"journal":         {"Journal:      journal [size]", reflect.ValueOf(journalCommand)},
+	"snap":            {"Snapshot:     snap save <file> | snap load <file>", reflect.ValueOf(snapCommand)},
 }
 
-func processArgs(cmd commandInfo, ctx core6502.CPUContext, parts []string) ([]reflect.Value, error) {
+var (
+	writerType  = reflect.TypeOf((*io.Writer)(nil)).Elem()
+	exprType    = reflect.TypeOf((*core6502.Expr)(nil))
+	stringsType = reflect.TypeOf([]string(nil))
+)
+
+func exprEnv(ctx core6502.CPUContext) *core6502.ExprEnv {
+	return &core6502.ExprEnv{Ctx: ctx, Symbols: symbols}
+}
+
+// evaluates the leading expression of args, checks it fits in bitSize bits
+// (negative values are allowed and are two's complement) and returns the
+// value with the remaining args
+func evalArg(ctx core6502.CPUContext, args string, bitSize uint) (uint64, string, error) {
+	e, rest, err := core6502.ParseExprPrefix(args)
+	if err != nil {
+		return 0, "", err
+	}
 
-	args := []reflect.Value{reflect.ValueOf(ctx)}
+	val, err := e.Eval(exprEnv(ctx))
+	if err != nil {
+		return 0, "", err
+	}
+
+	if val < -(1<<(bitSize-1)) || val >= 1<<bitSize {
+		return 0, "", fmt.Errorf("Value out of range: %s = %d", e, val)
+	}
+	return uint64(val) & (1<<bitSize - 1), rest, nil
+}
 
-	for n := 1; n < cmd.handler.Type().NumIn(); n++ {
-		if len(parts) == 0 {
+/*
+	Converts the argument string to the handler's parameters. The first
+	parameter is always the CPUContext, io.Writer parameters receive the
+	command output. Numeric parameters take an expression, string parameters
+	take one word, or the rest of the line if last. A trailing *core6502.Expr
+	parameter takes the rest of the line and is nil if omitted. A trailing
+	[]string parameter takes the remaining words, if any.
+*/
+func processArgs(cmd commandInfo, ctx core6502.CPUContext, out io.Writer, args string) ([]reflect.Value, error) {
+
+	vals := []reflect.Value{reflect.ValueOf(ctx)}
+	handlerType := cmd.handler.Type()
+
+	for n := 1; n < handlerType.NumIn(); n++ {
+		args = strings.TrimLeft(args, " \t")
+		argType := handlerType.In(n)
+		last := n == handlerType.NumIn()-1
+
+		if argType == writerType {
+			vals = append(vals, reflect.ValueOf(&out).Elem())
+			continue
+		}
+
+		if argType == exprType {
+			var e *core6502.Expr
+			if len(args) > 0 {
+				var err error
+				if e, err = core6502.ParseExpr(args); err != nil {
+					return nil, err
+				}
+				args = ""
+			}
+			vals = append(vals, reflect.ValueOf(e))
+			continue
+		}
+
+		if argType == stringsType {
+			vals = append(vals, reflect.ValueOf(core6502.Split(args, " \t")))
+			args = ""
+			continue
+		}
+
+		if len(args) == 0 {
 			return nil, fmt.Errorf("Not enough Args: %s", cmd.help)
 		}
 
-		switch cmd.handler.Type().In(n).Kind() {
+		switch argType.Kind() {
 
 		case reflect.Uint8:
-			i, err := core6502.ParseUint(parts[0], 8)
+			i, rest, err := evalArg(ctx, args, 8)
 			if err != nil {
 				return nil, err
 			}
-			args = append(args, reflect.ValueOf(uint8(i)))
-			parts = parts[1:]
+			vals = append(vals, reflect.ValueOf(uint8(i)))
+			args = rest
 
 		case reflect.Uint16:
-			i, err := core6502.ParseUint(parts[0], 16)
+			i, rest, err := evalArg(ctx, args, 16)
 			if err != nil {
 				return nil, err
 			}
-			args = append(args, reflect.ValueOf(uint16(i)))
-			parts = parts[1:]
+			vals = append(vals, reflect.ValueOf(uint16(i)))
+			args = rest
 
 		case reflect.String:
-			args = append(args, reflect.ValueOf(parts[0]))
-			parts = parts[1:]
+			if last {
+				vals = append(vals, reflect.ValueOf(strings.TrimSpace(args)))
+				args = ""
+			} else {
+				word := core6502.Split(args, " \t")[0]
+				vals = append(vals, reflect.ValueOf(word))
+				args = args[len(word):]
+			}
 		}
 	}
 
-	if len(parts) > 0 {
+	if len(strings.TrimSpace(args)) > 0 {
 		return nil, fmt.Errorf("Too Many Args: %s", cmd.help)
 	}
 
-	return args, nil
+	return vals, nil
+}
+
+// runs a line typed at the console, which is assembled in assembly mode
+func DispatchCommand(ctx core6502.CPUContext, cmd string, out io.Writer) (bool, error) {
+	if assembly.active {
+		return false, assembleLine(ctx, out, cmd)
+	}
+	return execCommand(ctx, cmd, out)
 }
 
-func DispatchCommand(ctx core6502.CPUContext, cmd string) (bool, error) {
+/*
+	Runs a command line, returning true for q. Scripts, the RPC and the
+	DAP REPL run commands through this, so they are not assembled when
+	the console is in assembly mode.
+*/
+func execCommand(ctx core6502.CPUContext, cmd string, out io.Writer) (bool, error) {
 	if cmd == "q" {
 		return true, nil
 	}
 
 	parts := core6502.Split(cmd, " \t")
 	if len(parts) > 0 && parts[0] != "" {
-		if cmd, ok := commands[parts[0]]; ok {
-			if args, err := processArgs(cmd, ctx, parts[1:]); err == nil {
-				ret := cmd.handler.Call(args)
-				if len(ret) == 0 || ret[0].Interface() == nil {
-					return false, nil
-				} else {
-					return false, ret[0].Interface().(error)
-				}
-			} else {
-				return false, err
-			}
-		} else {
-			return false, fmt.Errorf("Unknown Command: %s", parts[0])
-		}
+		args := strings.TrimLeft(cmd, " \t")[len(parts[0]):]
+		return false, runCommand(ctx, parts[0], args, out)
 	}
 	return false, nil
 }
 
-func setMemory(ctx core6502.CPUContext, addr uint16, val uint8) error {
-	ctx.Poke(addr, val)
+// runs the command name with the argument string args
+func runCommand(ctx core6502.CPUContext, name, args string, out io.Writer) error {
+	cmd, ok := commands[name]
+	if !ok {
+		return fmt.Errorf("Unknown Command: %s", name)
+	}
+
+	vals, err := processArgs(cmd, ctx, out, args)
+	if err != nil {
+		return err
+	}
+	ret := cmd.handler.Call(vals)
+	if len(ret) == 0 || ret[0].Interface() == nil {
+		return nil
+	}
+	return ret[0].Interface().(error)
+}
+
+// sets the byte at addr, and those following it to any more values
+func setMemory(ctx core6502.CPUContext, addr uint16, val uint8, more []string) error {
+	vals := []uint8{val}
+	for rest := strings.Join(more, " "); rest != ""; {
+		val, r, err := evalArg(ctx, rest, 8)
+		if err != nil {
+			return err
+		}
+		vals = append(vals, uint8(val))
+		rest = strings.TrimSpace(r)
+	}
+
+	for n, val := range vals {
+		ctx.Poke(addr+uint16(n), val)
+	}
+	clearJournal()
 	return nil
 }
 
@@ -96,6 +228,7 @@ func push(ctx core6502.CPUContext, val uint8) error {
 	sp := ctx.RegSP()
 	ctx.Poke(0x100+uint16(sp), val)
 	ctx.SetRegSP(sp - 1)
+	clearJournal()
 	return nil
 }
 
@@ -105,30 +238,106 @@ func setMemoryBlock(ctx core6502.CPUContext, addr uint16, count uint16, val uint
 		addr++
 		count--
 	}
+	clearJournal()
 	return nil
 }
 
-func execInstr(ctx core6502.CPUContext) error {
-	_, err := core6502.Execute(ctx)
-	return err
+// executes count instructions, one if count is omitted
+func execInstr(ctx core6502.CPUContext, args []string) error {
+	count := 1
+	if len(args) > 0 {
+		val, _, err := evalArg(ctx, strings.Join(args, " "), 16)
+		if err != nil {
+			return err
+		}
+		count = int(val)
+	}
+
+	for ; count > 0; count-- {
+		if _, err := execute(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func setReg(ctx core6502.CPUContext, reg string, val uint8) error {
+func setReg(ctx core6502.CPUContext, reg string, value string) error {
+	bits := uint(8)
+	if reg == "pc" {
+		bits = 16
+	}
+	val, rest, err := evalArg(ctx, value, bits)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(rest) != "" {
+		return fmt.Errorf("Too Many Args: Set Register: sr <reg> <value>")
+	}
+
 	switch reg {
 	case "a":
-		ctx.SetRegA(val)
+		ctx.SetRegA(uint8(val))
 	case "x":
-		ctx.SetRegX(val)
+		ctx.SetRegX(uint8(val))
 	case "y":
-		ctx.SetRegY(val)
+		ctx.SetRegY(uint8(val))
+	case "sp":
+		ctx.SetRegSP(uint8(val))
+	case "p":
+		ctx.SetFlags(uint8(val))
+	case "pc":
+		ctx.SetRegPC(uint16(val))
 	default:
 		return fmt.Errorf("Invalid Register: %s", reg)
 	}
+	clearJournal()
 	return nil
 }
 
-func asm(ctx core6502.CPUContext, addr uint16, instr string) error {
-	newAddr, err := core6502.Assemble(ctx, addr, instr)
-	newAddr = newAddr
-	return err
+// resets ctx as on power up, with resetVector unless it is a NES, which
+// has its reset vector in ROM
+func hardReset(ctx core6502.CPUContext, resetVector uint16) {
+	defer clearJournal()
+	if nes, ok := ctx.(*nes6502.NES); ok {
+		nes.HardReset()
+		return
+	}
+	core6502.HardResetCPU(ctx, resetVector)
+}
+
+// resets the CPU from the reset vector, as the reset line does
+func softReset(ctx core6502.CPUContext) {
+	core6502.SoftResetCPU(ctx)
+	clearJournal()
+}
+
+// hardreset [vector], the vector defaults to the current reset vector
+func hardResetCommand(ctx core6502.CPUContext, args []string) error {
+	resetVector := ctx.PeekWord(core6502.Vector_RST)
+	if len(args) > 0 {
+		if _, ok := ctx.(*nes6502.NES); ok {
+			return fmt.Errorf("The NES reset vector is in ROM")
+		}
+		val, _, err := evalArg(ctx, strings.Join(args, " "), 16)
+		if err != nil {
+			return err
+		}
+		resetVector = uint16(val)
+	}
+	hardReset(ctx, resetVector)
+	return nil
+}
+
+func evaluate(ctx core6502.CPUContext, out io.Writer, e *core6502.Expr) error {
+	if e == nil {
+		return fmt.Errorf("Not enough Args: ? <expression>")
+	}
+
+	val, err := e.Eval(exprEnv(ctx))
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "%s = $%04x (%d)\n", e, uint16(val), val)
+	return nil
 }
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"github.com/simulatedsimian/emu6502/cov6502"
+	"io"
+	"os"
+)
+
+// the coverage being recorded, nil when not recording
+var coverer *cov6502.Coverage
+
+// the last coverage, kept after cov stop for the reports
+var coverage *cov6502.Coverage
+
+// evaluates args as a start and end address
+func addrRange(ctx core6502.CPUContext, args []string) (uint16, uint16, error) {
+	start, _, err := evalArg(ctx, args[0], 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, _, err := evalArg(ctx, args[1], 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	if end < start {
+		return 0, 0, fmt.Errorf("End address is before the start")
+	}
+	return uint16(start), uint16(end), nil
+}
+
+// writes to out, or to the file if one is named
+func writeOutput(out io.Writer, args []string, write func(w io.Writer) error) error {
+	if len(args) == 0 {
+		return write(out)
+	}
+	f, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Wrote %s\n", args[0])
+	return nil
+}
+
+/*
+	cov start records the memory executed, read and written by x and g
+	from then on, cov stop ends recording. cov report prints coverage by
+	symbol over start-end, or of each page touched without a range.
+	cov annotate disassembles start-end with coverage markers, and cov
+	lcov maps the coverage to source lines with an ld65 debug file.
+*/
+func covCommand(ctx core6502.CPUContext, out io.Writer, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("Usage: cov start | cov stop | cov report [start end] | cov annotate <start> <end> [file] | cov lcov <dbgfile> <file>")
+	}
+	if args[0] != "start" && args[0] != "stop" && coverage == nil {
+		return fmt.Errorf("No coverage, use cov start")
+	}
+
+	switch args[0] {
+	case "start":
+		coverer = cov6502.New()
+		coverage = coverer
+
+	case "stop":
+		if coverer == nil {
+			return fmt.Errorf("Not recording coverage")
+		}
+		coverer = nil
+
+	case "report":
+		regions := coverage.PageRegions()
+		if len(args) == 3 {
+			start, end, err := addrRange(ctx, args[1:])
+			if err != nil {
+				return err
+			}
+			regions = cov6502.SymbolRegions(symbols, start, end)
+		} else if len(args) != 1 {
+			return fmt.Errorf("Usage: cov report [start end]")
+		}
+		coverage.Report(out, ctx, regions, symbols)
+
+	case "annotate":
+		if len(args) != 3 && len(args) != 4 {
+			return fmt.Errorf("Usage: cov annotate <start> <end> [file]")
+		}
+		start, end, err := addrRange(ctx, args[1:])
+		if err != nil {
+			return err
+		}
+		return writeOutput(out, args[3:], func(w io.Writer) error {
+			return coverage.Annotate(w, ctx, start, end, symbols)
+		})
+
+	case "lcov":
+		if len(args) != 3 {
+			return fmt.Errorf("Usage: cov lcov <dbgfile> <file>")
+		}
+		li, err := core6502.LoadLineInfo(args[1])
+		if err != nil {
+			return err
+		}
+		return writeOutput(out, args[2:], func(w io.Writer) error {
+			return coverage.WriteLCOV(w, ctx, li)
+		})
+
+	default:
+		return fmt.Errorf("Unknown cov command: %s", args[0])
+	}
+	return nil
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+	A Debug Adapter Protocol server, so editors such as VS Code can debug
+	the emulated 6502. Messages are JSON with a Content-Length header, as
+	in the language server protocol:
+
+	Content-Length: 62\r\n
+	\r\n
+	{"seq":1,"type":"request","command":"initialize","arguments":{}}
+
+	There is one thread, the CPU. It runs in the background so a pause
+	request can stop it. Breakpoints set by the client belong to the
+	session, apart from those set from the console, which are shared.
+*/
+type dapSession struct {
+	in   *bufio.Reader
+	out  io.Writer
+	wmu  sync.Mutex // serialises writes to out
+	seq  int
+	done bool
+
+	cpuRunner
+	ctx core6502.CPUContext
+
+	launched, configured, stopOnEntry bool
+
+	lines       *core6502.LineInfo // nil without ca65 debug info
+	srcDir      string             // relative source paths in the debug info are from here
+	srcBreaks   map[string][]*breakpoint
+	funcBreaks  []*breakpoint
+	instBreaks  []*breakpoint
+	breaks      map[uint16][]*breakpoint // all of the above by address
+	nextBreakID int
+}
+
+const dapThreadID = 1
+
+type dapRequest struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type dapResponse struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+type dapEvent struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+func newDAPSession(ctx core6502.CPUContext, r io.Reader, w io.Writer) *dapSession {
+	return &dapSession{in: bufio.NewReader(r), out: w, ctx: ctx, srcBreaks: map[string][]*breakpoint{}}
+}
+
+// reads the next message, nil at the end of the input
+func (s *dapSession) read() ([]byte, error) {
+	length := -1
+	for {
+		line, err := s.in.ReadString('\n')
+		if err == io.EOF && line == "" && length == -1 {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, "Content-Length:") {
+			if length, err = strconv.Atoi(strings.TrimSpace(line[len("Content-Length:"):])); err != nil {
+				return nil, fmt.Errorf("Invalid DAP header: %s", line)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("DAP message without a Content-Length")
+	}
+
+	data := make([]byte, length)
+	_, err := io.ReadFull(s.in, data)
+	return data, err
+}
+
+func (s *dapSession) write(msg interface{}) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+
+	s.seq++
+	switch m := msg.(type) {
+	case *dapResponse:
+		m.Seq = s.seq
+	case *dapEvent:
+		m.Seq = s.seq
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+func (s *dapSession) respond(req *dapRequest, body interface{}, err error) {
+	resp := &dapResponse{Type: "response", RequestSeq: req.Seq, Command: req.Command, Success: err == nil, Body: body}
+	if err != nil {
+		resp.Message = err.Error()
+	}
+	s.write(resp)
+}
+
+func (s *dapSession) event(event string, body interface{}) {
+	s.write(&dapEvent{Type: "event", Event: event, Body: body})
+}
+
+// sends what is written to it to the debug console
+type dapOutput struct {
+	s *dapSession
+}
+
+func (o dapOutput) Write(p []byte) (int, error) {
+	o.s.event("output", map[string]string{"category": "console", "output": string(p)})
+	return len(p), nil
+}
+
+// handles requests until disconnect or the end of the input
+func (s *dapSession) serve() error {
+	defer s.stop()
+
+	for !s.done {
+		data, err := s.read()
+		if err != nil || data == nil {
+			return err
+		}
+
+		var req dapRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			return fmt.Errorf("Invalid DAP message: %v", err)
+		}
+		if req.Type == "request" {
+			s.handle(&req)
+		}
+	}
+	return nil
+}
+
+// the source line of addr, if there is debug info for it
+func (s *dapSession) line(addr uint16) (core6502.SourceLine, bool) {
+	if s.lines == nil {
+		return core6502.SourceLine{}, false
+	}
+	return s.lines.Find(addr)
+}
+
+func (s *dapSession) sourcePath(file string) string {
+	if filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(s.srcDir, file)
+}
+
+func (s *dapSession) stopped(reason, text string) {
+	body := map[string]interface{}{"reason": reason, "threadId": dapThreadID, "allThreadsStopped": true}
+	if text != "" {
+		body["text"] = text
+		body["description"] = text
+	}
+	s.event("stopped", body)
+}
+
+// the session's breakpoint at the PC whose condition holds, if any
+func (s *dapSession) checkBreakpoint() (*breakpoint, error) {
+	for _, bp := range s.breaks[s.ctx.RegPC()] {
+		if hit, err := condTrue(s.ctx, bp.cond); hit || err != nil {
+			return bp, err
+		}
+	}
+	return nil, nil
+}
+
+/*
+	Executes until a breakpoint, watchpoint, invalid instruction or pause,
+	or until done returns true after an instruction, returning the reason
+	for the stopped event. A breakpoint at the starting PC is ignored so
+	execution can resume from it.
+*/
+func (s *dapSession) runUntil(done func() bool) (reason, text string) {
+	wctx := newWatchContext(s.ctx)
+	out := dapOutput{s}
+
+	stop := func(r, t string) bool {
+		reason, text = r, t
+		return true
+	}
+	stopped := s.loop(func(first bool) bool {
+		if !first {
+			bp, err := checkBreakpoint(s.ctx, out)
+			if err == nil && bp == nil {
+				bp, err = s.checkBreakpoint()
+			}
+			if err != nil {
+				return stop("exception", err.Error())
+			}
+			if bp != nil {
+				return stop("breakpoint", "")
+			}
+		}
+
+		pc := s.ctx.RegPC()
+		if _, err := execute(wctx); err != nil {
+			return stop("exception", err.Error())
+		}
+
+		wp, err := checkWatchpoints(wctx, out)
+		if err != nil {
+			return stop("exception", err.Error())
+		}
+		if wp != nil {
+			return stop("data breakpoint", fmt.Sprintf("Watchpoint $%04x written at $%04x", wp.addr, pc))
+		}
+		if done != nil && done() {
+			return stop("step", "")
+		}
+		return false
+	})
+	if !stopped {
+		return "pause", ""
+	}
+	return reason, text
+}
+
+// starts executing in the background, sending a stopped event when it stops
+func (s *dapSession) resume(done func() bool) {
+	s.runInBackground(func() {
+		s.stopped(s.runUntil(done))
+	})
+}
+
+// starts execution once the program is launched and configuration is done
+func (s *dapSession) start() {
+	if !s.launched || !s.configured {
+		return
+	}
+	if s.stopOnEntry {
+		s.stopped("entry", "")
+	} else {
+		s.resume(nil)
+	}
+}
+
+/*
+	Serves DAP on stdin and stdout if listen is empty, otherwise on each
+	connection to the TCP address listen in turn, e.g. localhost:4711 for
+	a launch configuration with "debugServer": 4711.
+*/
+func serveDAP(ctx core6502.CPUContext, listen string) error {
+	if listen == "" {
+		return newDAPSession(ctx, os.Stdin, os.Stdout).serve()
+	}
+
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "DAP server listening on %s\n", l.Addr())
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		if err := newDAPSession(ctx, conn, conn).serve(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		conn.Close()
+	}
+}
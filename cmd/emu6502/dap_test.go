@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/simulatedsimian/assert"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testDAPLineInfo = "version\tmajor=2,minor=0\n" +
+	"file\tid=0,name=\"main.s\",size=100,mtime=0x5e5ba4e8,mod=0\n" +
+	"seg\tid=0,name=\"CODE\",start=0x000400,size=0x0008,addrsize=absolute,type=ro,oname=\"main.bin\",ooffs=0\n" +
+	"span\tid=0,seg=0,start=0,size=2\n" +
+	"span\tid=1,seg=0,start=2,size=2\n" +
+	"span\tid=2,seg=0,start=4,size=1\n" +
+	"span\tid=3,seg=0,start=5,size=3\n" +
+	"line\tid=0,file=0,line=5,span=0\n" +
+	"line\tid=1,file=0,line=6,span=1\n" +
+	"line\tid=2,file=0,line=7,span=2\n" +
+	"line\tid=3,file=0,line=8,span=3\n"
+
+var testDAPProgram = []uint8{
+	0xa9, 0x01, // lda #$01
+	0x85, 0x10, // sta $10
+	0xe8,             // inx
+	0x4c, 0x05, 0x04, // jmp $0405
+}
+
+// a DAP client for the tests, reading messages with the session's reader
+type dapClient struct {
+	t    *testing.T
+	conn net.Conn
+	in   *dapSession
+	seq  int
+}
+
+func (c *dapClient) request(command string, args interface{}) {
+	c.seq++
+	data, err := json.Marshal(map[string]interface{}{"seq": c.seq, "type": "request", "command": command, "arguments": args})
+	assert.NoError(c.t, assert.Pack(err))
+	_, err = fmt.Fprintf(c.conn, "Content-Length: %d\r\n\r\n%s", len(data), data)
+	assert.NoError(c.t, assert.Pack(err))
+}
+
+// reads messages until the response to command or the event, skipping output
+func (c *dapClient) expect(kind, name string) map[string]interface{} {
+	for {
+		data, err := c.in.read()
+		assert.NoError(c.t, assert.Pack(err))
+		var msg map[string]interface{}
+		assert.NoError(c.t, assert.Pack(json.Unmarshal(data, &msg)))
+
+		if msg["type"] == kind && (msg["command"] == name || msg["event"] == name) {
+			return msg
+		}
+		if msg["event"] != "output" {
+			c.t.Fatalf("Expected %s %s, got %s", kind, name, data)
+		}
+	}
+}
+
+// sends a request and returns the body of its successful response
+func (c *dapClient) call(command string, args interface{}) interface{} {
+	c.request(command, args)
+	resp := c.expect("response", command)
+	assert.Equal(c.t, resp["success"], true)
+	return resp["body"]
+}
+
+func (c *dapClient) evaluate(expr string) interface{} {
+	body := c.call("evaluate", map[string]string{"expression": expr})
+	return body.(map[string]interface{})["result"]
+}
+
+func TestDAPSession(t *testing.T) {
+	pack := assert.Pack
+
+	dir, err := ioutil.TempDir("", "dap")
+	assert.NoError(t, pack(err))
+	defer os.RemoveAll(dir)
+	dbg, bin, src := filepath.Join(dir, "main.dbg"), filepath.Join(dir, "main.bin"), filepath.Join(dir, "main.s")
+	assert.NoError(t, pack(ioutil.WriteFile(dbg, []byte(testDAPLineInfo), 0644)))
+	assert.NoError(t, pack(ioutil.WriteFile(bin, testDAPProgram, 0644)))
+
+	// a console breakpoint, which the session must leave alone
+	setBreakpoint(nil, 0x0405, nil)
+	defer delete(breakpoints, 0x0405)
+
+	server, conn := net.Pipe()
+	defer conn.Close()
+	s := newDAPSession(&core6502.BasicCPUContext{}, server, server)
+	done := make(chan error)
+	go func() {
+		done <- s.serve()
+		server.Close()
+	}()
+	c := &dapClient{t: t, conn: conn, in: newDAPSession(nil, conn, nil)}
+
+	body := c.call("initialize", map[string]string{"adapterID": "emu6502"})
+	assert.Equal(t, body.(map[string]interface{})["supportsConfigurationDoneRequest"], true)
+	c.expect("event", "initialized")
+
+	c.call("launch", map[string]string{"program": bin + "@$0400", "debugInfo": dbg})
+
+	body = c.call("setBreakpoints", map[string]interface{}{
+		"source":      map[string]string{"path": src},
+		"breakpoints": []map[string]int{{"line": 7}, {"line": 8}, {"line": 20}},
+	})
+	bps := body.(map[string]interface{})["breakpoints"].([]interface{})
+	assert.Equal(t, len(bps), 3)
+	assert.Equal(t, bps[0].(map[string]interface{})["instructionReference"], "0x0404")
+	assert.Equal(t, bps[1].(map[string]interface{})["instructionReference"], "0x0405")
+	assert.Equal(t, bps[2].(map[string]interface{})["verified"], false)
+	assert.Equal(t, bps[2].(map[string]interface{})["message"], "No code at or after this line")
+
+	// runs to the breakpoint on line 7
+	c.call("configurationDone", nil)
+	stopped := c.expect("event", "stopped")["body"].(map[string]interface{})
+	assert.Equal(t, stopped["reason"], "breakpoint")
+	assert.Equal(t, c.evaluate("pc"), "$0404 (1028)")
+	assert.Equal(t, c.evaluate("[$10]"), "$0001 (1)")
+
+	body = c.call("threads", nil)
+	assert.Equal(t, body, map[string]interface{}{"threads": []interface{}{
+		map[string]interface{}{"id": float64(dapThreadID), "name": "6502"},
+	}})
+
+	// clearing the session's breakpoints leaves the console one at $0405
+	body = c.call("setBreakpoints", map[string]interface{}{"source": map[string]string{"path": src}, "breakpoints": []int{}})
+	assert.Equal(t, body, map[string]interface{}{"breakpoints": []interface{}{}})
+
+	body = c.call("continue", map[string]int{"threadId": dapThreadID})
+	assert.Equal(t, body, map[string]interface{}{"allThreadsContinued": true})
+	stopped = c.expect("event", "stopped")["body"].(map[string]interface{})
+	assert.Equal(t, stopped["reason"], "breakpoint")
+	assert.Equal(t, c.evaluate("pc"), "$0405 (1029)")
+	assert.Equal(t, c.evaluate("x"), "$0001 (1)")
+
+	c.call("disconnect", nil)
+	assert.NoError(t, pack(<-done))
+	_, ok := breakpoints[0x0405]
+	assert.Equal(t, ok, true)
+	symbols.Clear()
+}
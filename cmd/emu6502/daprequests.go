@@ -0,0 +1,715 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"github.com/simulatedsimian/emu6502/image6502"
+	"github.com/simulatedsimian/emu6502/nes6502"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// variablesReference of each scope
+const (
+	Scope_Registers = iota + 1
+	Scope_Flags
+	Scope_ZeroPage
+)
+
+// the arguments of launch
+type dapLaunchArgs struct {
+	Program     string   `json:"program"`   // an image to load, file[@address]
+	NES         string   `json:"nes"`       // an iNES ROM to run instead
+	Symbols     []string `json:"symbols"`   // symbol files
+	DebugInfo   string   `json:"debugInfo"` // ld65 debug info, for symbols and source lines
+	Cwd         string   `json:"cwd"`       // relative source paths are from here, the debug info's directory by default
+	PC          string   `json:"pc"`        // start address, an expression
+	StopOnEntry bool     `json:"stopOnEntry"`
+}
+
+type dapSource struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+type dapBreakpoint struct {
+	ID                   int        `json:"id"`
+	Verified             bool       `json:"verified"`
+	Message              string     `json:"message,omitempty"`
+	Source               *dapSource `json:"source,omitempty"`
+	Line                 int        `json:"line,omitempty"`
+	InstructionReference string     `json:"instructionReference,omitempty"`
+}
+
+type dapVariable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	EvaluateName       string `json:"evaluateName,omitempty"`
+	MemoryReference    string `json:"memoryReference,omitempty"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+func memoryReference(addr uint16) string {
+	return fmt.Sprintf("0x%04x", addr)
+}
+
+// evaluates an address expression, e.g. a memoryReference or symbol
+func (s *dapSession) evalAddr(expr string) (uint16, error) {
+	val, err := core6502.EvalExpr(expr, exprEnv(s.ctx))
+	if err != nil {
+		return 0, err
+	}
+	if val < 0 || val > 0xffff {
+		return 0, fmt.Errorf("Address out of range: %s", expr)
+	}
+	return uint16(val), nil
+}
+
+func (s *dapSession) handle(req *dapRequest) {
+	var body interface{}
+	var err error
+
+	switch req.Command {
+	case "initialize":
+		body = map[string]bool{
+			"supportsConfigurationDoneRequest": true,
+			"supportsFunctionBreakpoints":      true,
+			"supportsConditionalBreakpoints":   true,
+			"supportsInstructionBreakpoints":   true,
+			"supportsSteppingGranularity":      true,
+			"supportsSetVariable":              true,
+			"supportsReadMemoryRequest":        true,
+			"supportsWriteMemoryRequest":       true,
+			"supportsTerminateRequest":         true,
+			"supportsStepBack":                 journal != nil,
+		}
+		s.respond(req, body, nil)
+		s.event("initialized", nil)
+		return
+
+	case "launch":
+		err = s.launch(req.Arguments)
+		s.respond(req, nil, err)
+		if err == nil {
+			s.launched = true
+			s.start()
+		}
+		return
+
+	case "configurationDone":
+		s.respond(req, nil, nil)
+		s.configured = true
+		s.start()
+		return
+
+	case "disconnect", "terminate":
+		s.stop()
+		s.respond(req, nil, nil)
+		if req.Command == "terminate" {
+			s.event("terminated", nil)
+		}
+		s.done = true
+		return
+
+	case "threads":
+		body = map[string]interface{}{"threads": []map[string]interface{}{{"id": dapThreadID, "name": "6502"}}}
+
+	case "setExceptionBreakpoints":
+		body = map[string]interface{}{"breakpoints": []dapBreakpoint{}}
+
+	case "continue":
+		s.respond(req, map[string]bool{"allThreadsContinued": true}, nil)
+		s.resume(nil)
+		return
+
+	case "next", "stepIn", "stepOut":
+		var args struct {
+			Granularity string `json:"granularity"`
+		}
+		err = unmarshalArgs(req.Arguments, &args)
+		s.respond(req, nil, err)
+		if err == nil {
+			s.resume(s.step(req.Command, args.Granularity == "instruction"))
+		}
+		return
+
+	case "stepBack":
+		s.cpu.Lock()
+		err = stepBack(s.ctx, 1)
+		s.cpu.Unlock()
+		s.respond(req, nil, err)
+		if err == nil {
+			s.stopped("step", "")
+		}
+		return
+
+	case "pause":
+		// a run sends its own stopped event
+		running := s.isRunning()
+		s.respond(req, nil, nil)
+		s.stop()
+		if !running {
+			s.stopped("pause", "")
+		}
+		return
+
+	default:
+		s.cpu.Lock()
+		body, err = s.handleStopped(req)
+		s.cpu.Unlock()
+	}
+	s.respond(req, body, err)
+}
+
+// requests that use the CPU state
+func (s *dapSession) handleStopped(req *dapRequest) (interface{}, error) {
+	switch req.Command {
+	case "setBreakpoints":
+		return s.setBreakpoints(req.Arguments)
+	case "setFunctionBreakpoints":
+		return s.setFunctionBreakpoints(req.Arguments)
+	case "setInstructionBreakpoints":
+		return s.setInstructionBreakpoints(req.Arguments)
+	case "stackTrace":
+		return s.stackTrace(), nil
+	case "scopes":
+		return map[string]interface{}{"scopes": []map[string]interface{}{
+			{"name": "Registers", "variablesReference": Scope_Registers, "presentationHint": "registers", "expensive": false},
+			{"name": "Flags", "variablesReference": Scope_Flags, "expensive": false},
+			{"name": "Zero Page", "variablesReference": Scope_ZeroPage, "expensive": false},
+		}}, nil
+	case "variables":
+		return s.variables(req.Arguments)
+	case "setVariable":
+		return s.setVariable(req.Arguments)
+	case "evaluate":
+		return s.evaluate(req.Arguments)
+	case "readMemory":
+		return s.readMemory(req.Arguments)
+	case "writeMemory":
+		return s.writeMemory(req.Arguments)
+	}
+	return nil, fmt.Errorf("Unsupported request: %s", req.Command)
+}
+
+func unmarshalArgs(data json.RawMessage, args interface{}) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, args)
+}
+
+func (s *dapSession) launch(data json.RawMessage) error {
+	var args dapLaunchArgs
+	if err := unmarshalArgs(data, &args); err != nil {
+		return err
+	}
+	s.stopOnEntry = args.StopOnEntry
+	out := dapOutput{s}
+
+	s.cpu.Lock()
+	defer s.cpu.Unlock()
+
+	if args.NES != "" {
+		rom, err := nes6502.LoadROM(args.NES)
+		if err != nil {
+			return err
+		}
+		console, err := nes6502.New(rom)
+		if err != nil {
+			return err
+		}
+		console.SetLog(out)
+		s.ctx = console
+		core6502.SoftResetCPU(s.ctx)
+		startJournal(s.ctx, journalSize)
+	}
+
+	for _, f := range args.Symbols {
+		if err := loadSymbols(f, out); err != nil {
+			return err
+		}
+	}
+	if args.DebugInfo != "" {
+		if err := loadSymbols(args.DebugInfo, out); err != nil {
+			return err
+		}
+		lines, err := core6502.LoadLineInfo(args.DebugInfo)
+		if err != nil {
+			return err
+		}
+		s.lines, s.srcDir = lines, filepath.Dir(args.DebugInfo)
+	}
+	if args.Cwd != "" {
+		s.srcDir = args.Cwd
+	}
+
+	if args.Program != "" {
+		img, filename, err := image6502.LoadFileArg(args.Program)
+		if err != nil {
+			return err
+		}
+		installImage(s.ctx, out, filename, img, true)
+	}
+	if args.PC != "" {
+		pc, err := s.evalAddr(args.PC)
+		if err != nil {
+			return err
+		}
+		s.ctx.SetRegPC(pc)
+	}
+	calls.Clear()
+	clearJournal()
+	return nil
+}
+
+/*
+	The condition ending a step. With source lines, next and stepIn run
+	until the PC is on another line, next running over calls; without
+	them, or with instruction granularity, they execute one instruction,
+	next running over a JSR. stepOut runs until the current call returns.
+*/
+func (s *dapSession) step(kind string, instruction bool) func() bool {
+	s.cpu.Lock()
+	calls.Sync(s.ctx)
+	depth := len(calls.Frames)
+	start, hasLine := s.line(s.ctx.RegPC())
+	s.cpu.Unlock()
+
+	newLine := func() bool {
+		if instruction || !hasLine {
+			return true
+		}
+		l, ok := s.line(s.ctx.RegPC())
+		return ok && (l.File != start.File || l.Line != start.Line)
+	}
+
+	switch kind {
+	case "next":
+		return func() bool { return len(calls.Frames) <= depth && newLine() }
+	case "stepOut":
+		return func() bool { return len(calls.Frames) < depth }
+	}
+	return newLine
+}
+
+// the source of a file in the debug info
+func (s *dapSession) source(file string) *dapSource {
+	path := s.sourcePath(file)
+	return &dapSource{Name: filepath.Base(path), Path: path}
+}
+
+// the lines in the debug info from the source file at path
+func (s *dapSession) sourceLines(path string) []core6502.SourceLine {
+	if s.lines == nil {
+		return nil
+	}
+
+	var lines, byName []core6502.SourceLine
+	for _, l := range s.lines.Lines {
+		switch {
+		case filepath.Clean(s.sourcePath(l.File)) == filepath.Clean(path):
+			lines = append(lines, l)
+		case filepath.Base(l.File) == filepath.Base(path):
+			byName = append(byName, l)
+		}
+	}
+	if len(lines) == 0 {
+		return byName
+	}
+	return lines
+}
+
+// the session's breakpoints at addrs
+func newBreakpoints(addrs []uint16, conds []*core6502.Expr) []*breakpoint {
+	var bps []*breakpoint
+	for n, addr := range addrs {
+		bps = append(bps, &breakpoint{addr: addr, cond: conds[n]})
+	}
+	return bps
+}
+
+// indexes the session's breakpoints by address, after one of the lists changed
+func (s *dapSession) indexBreakpoints() {
+	s.breaks = map[uint16][]*breakpoint{}
+	lists := [][]*breakpoint{s.funcBreaks, s.instBreaks}
+	for _, bps := range s.srcBreaks {
+		lists = append(lists, bps)
+	}
+	for _, bps := range lists {
+		for _, bp := range bps {
+			s.breaks[bp.addr] = append(s.breaks[bp.addr], bp)
+		}
+	}
+}
+
+func parseCondition(cond string) (*core6502.Expr, error) {
+	if strings.TrimSpace(cond) == "" {
+		return nil, nil
+	}
+	return core6502.ParseExpr(cond)
+}
+
+func (s *dapSession) breakpointID() int {
+	s.nextBreakID++
+	return s.nextBreakID
+}
+
+/*
+	Sets breakpoints on source lines, at the first address of the line or
+	of the next line with code. A line in a file without debug info is
+	not verified.
+*/
+func (s *dapSession) setBreakpoints(data json.RawMessage) (interface{}, error) {
+	var args struct {
+		Source      dapSource `json:"source"`
+		Breakpoints []struct {
+			Line      int    `json:"line"`
+			Condition string `json:"condition"`
+		} `json:"breakpoints"`
+	}
+	if err := unmarshalArgs(data, &args); err != nil {
+		return nil, err
+	}
+
+	lines := s.sourceLines(args.Source.Path)
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].Line < lines[j].Line })
+
+	var addrs []uint16
+	var conds []*core6502.Expr
+	result := []dapBreakpoint{}
+	for _, req := range args.Breakpoints {
+		bp := dapBreakpoint{ID: s.breakpointID(), Line: req.Line, Source: &args.Source}
+
+		cond, err := parseCondition(req.Condition)
+		n := sort.Search(len(lines), func(i int) bool { return lines[i].Line >= req.Line })
+		switch {
+		case err != nil:
+			bp.Message = err.Error()
+		case s.lines == nil:
+			bp.Message = "No debug info, set debugInfo in the launch configuration"
+		case n == len(lines):
+			bp.Message = "No code at or after this line"
+		default:
+			addr := lines[n].Start
+			for _, l := range lines[n:] {
+				if l.Line == lines[n].Line && l.Start < addr {
+					addr = l.Start
+				}
+			}
+			bp.Verified, bp.Line, bp.InstructionReference = true, lines[n].Line, memoryReference(addr)
+			addrs, conds = append(addrs, addr), append(conds, cond)
+		}
+		result = append(result, bp)
+	}
+
+	s.srcBreaks[args.Source.Path] = newBreakpoints(addrs, conds)
+	s.indexBreakpoints()
+	return map[string]interface{}{"breakpoints": result}, nil
+}
+
+// breakpoints at addresses given as expressions, e.g. symbols
+func (s *dapSession) setFunctionBreakpoints(data json.RawMessage) (interface{}, error) {
+	var args struct {
+		Breakpoints []struct {
+			Name      string `json:"name"`
+			Condition string `json:"condition"`
+		} `json:"breakpoints"`
+	}
+	if err := unmarshalArgs(data, &args); err != nil {
+		return nil, err
+	}
+
+	var addrs []uint16
+	var conds []*core6502.Expr
+	result := []dapBreakpoint{}
+	for _, req := range args.Breakpoints {
+		bp := dapBreakpoint{ID: s.breakpointID()}
+		addr, err := s.evalAddr(req.Name)
+		var cond *core6502.Expr
+		if err == nil {
+			cond, err = parseCondition(req.Condition)
+		}
+		if err != nil {
+			bp.Message = err.Error()
+		} else {
+			bp.Verified, bp.InstructionReference = true, memoryReference(addr)
+			addrs, conds = append(addrs, addr), append(conds, cond)
+		}
+		result = append(result, bp)
+	}
+
+	s.funcBreaks = newBreakpoints(addrs, conds)
+	s.indexBreakpoints()
+	return map[string]interface{}{"breakpoints": result}, nil
+}
+
+// breakpoints set from the disassembly view
+func (s *dapSession) setInstructionBreakpoints(data json.RawMessage) (interface{}, error) {
+	var args struct {
+		Breakpoints []struct {
+			InstructionReference string `json:"instructionReference"`
+			Offset               int    `json:"offset"`
+			Condition            string `json:"condition"`
+		} `json:"breakpoints"`
+	}
+	if err := unmarshalArgs(data, &args); err != nil {
+		return nil, err
+	}
+
+	var addrs []uint16
+	var conds []*core6502.Expr
+	result := []dapBreakpoint{}
+	for _, req := range args.Breakpoints {
+		bp := dapBreakpoint{ID: s.breakpointID()}
+		addr, err := s.evalAddr(req.InstructionReference)
+		addr += uint16(req.Offset)
+		var cond *core6502.Expr
+		if err == nil {
+			cond, err = parseCondition(req.Condition)
+		}
+		if err != nil {
+			bp.Message = err.Error()
+		} else {
+			bp.Verified, bp.InstructionReference = true, memoryReference(addr)
+			addrs, conds = append(addrs, addr), append(conds, cond)
+		}
+		result = append(result, bp)
+	}
+
+	s.instBreaks = newBreakpoints(addrs, conds)
+	s.indexBreakpoints()
+	return map[string]interface{}{"breakpoints": result}, nil
+}
+
+/*
+	The PC, then the call site of each call in progress from the shadow
+	call stack, innermost first. Each frame is named by the symbol of its
+	address.
+*/
+func (s *dapSession) stackTrace() interface{} {
+	calls.Sync(s.ctx)
+
+	addrs := []uint16{s.ctx.RegPC()}
+	for n := len(calls.Frames) - 1; n >= 0; n-- {
+		addrs = append(addrs, calls.Frames[n].Site)
+	}
+
+	frames := []map[string]interface{}{}
+	for n, addr := range addrs {
+		frame := map[string]interface{}{
+			"id":                          n + 1,
+			"name":                        addrName(addr),
+			"line":                        0,
+			"column":                      0,
+			"instructionPointerReference": memoryReference(addr),
+		}
+		if l, ok := s.line(addr); ok {
+			frame["source"], frame["line"], frame["column"] = s.source(l.File), l.Line, 1
+		}
+		frames = append(frames, frame)
+	}
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}
+}
+
+var dapFlags = []struct {
+	name string
+	mask uint8
+}{
+	{"N", core6502.Flag_N}, {"V", core6502.Flag_V}, {"B", core6502.Flag_B}, {"D", core6502.Flag_D},
+	{"I", core6502.Flag_I}, {"Z", core6502.Flag_Z}, {"C", core6502.Flag_C},
+}
+
+func (s *dapSession) variables(data json.RawMessage) (interface{}, error) {
+	var args struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+	if err := unmarshalArgs(data, &args); err != nil {
+		return nil, err
+	}
+
+	ctx := s.ctx
+	vars := []dapVariable{}
+	switch args.VariablesReference {
+	case Scope_Registers:
+		for _, r := range []struct {
+			name string
+			val  uint8
+		}{{"A", ctx.RegA()}, {"X", ctx.RegX()}, {"Y", ctx.RegY()}, {"SP", ctx.RegSP()}, {"P", ctx.Flags()}} {
+			vars = append(vars, dapVariable{Name: r.name, Value: fmt.Sprintf("$%02x", r.val), EvaluateName: strings.ToLower(r.name)})
+		}
+		pc := ctx.RegPC()
+		vars = append(vars, dapVariable{Name: "PC", Value: strings.TrimSpace(fmt.Sprintf("$%04x %s", pc, symbols.Format(pc))),
+			EvaluateName: "pc", MemoryReference: memoryReference(pc)})
+
+	case Scope_Flags:
+		for _, f := range dapFlags {
+			vars = append(vars, dapVariable{Name: f.name, Value: fmt.Sprint(btoi(ctx.Flag(f.mask))), EvaluateName: strings.ToLower(f.name)})
+		}
+
+	case Scope_ZeroPage:
+		for addr := uint16(0); addr < 0x100; addr++ {
+			name := fmt.Sprintf("$%02x", addr)
+			if sym, ok := symbols.Name(addr); ok {
+				name += " " + sym
+			}
+			vars = append(vars, dapVariable{Name: name, Value: fmt.Sprintf("$%02x", ctx.Peek(addr)),
+				EvaluateName: fmt.Sprintf("[$%02x]", addr), MemoryReference: memoryReference(addr)})
+		}
+
+	default:
+		return nil, fmt.Errorf("Unknown variablesReference: %d", args.VariablesReference)
+	}
+	return map[string]interface{}{"variables": vars}, nil
+}
+
+// sets a register, flag or zero page byte to the value of an expression
+func (s *dapSession) setVariable(data json.RawMessage) (interface{}, error) {
+	var args struct {
+		VariablesReference int    `json:"variablesReference"`
+		Name               string `json:"name"`
+		Value              string `json:"value"`
+	}
+	if err := unmarshalArgs(data, &args); err != nil {
+		return nil, err
+	}
+
+	val, err := core6502.EvalExpr(args.Value, exprEnv(s.ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := s.ctx
+	switch args.VariablesReference {
+	case Scope_Registers:
+		switch args.Name {
+		case "A":
+			ctx.SetRegA(uint8(val))
+		case "X":
+			ctx.SetRegX(uint8(val))
+		case "Y":
+			ctx.SetRegY(uint8(val))
+		case "SP":
+			ctx.SetRegSP(uint8(val))
+		case "P":
+			ctx.SetFlags(uint8(val))
+		case "PC":
+			ctx.SetRegPC(uint16(val))
+			clearJournal()
+			return map[string]string{"value": fmt.Sprintf("$%04x", uint16(val))}, nil
+		default:
+			return nil, fmt.Errorf("Unknown register: %s", args.Name)
+		}
+
+	case Scope_Flags:
+		for _, f := range dapFlags {
+			if f.name == args.Name {
+				ctx.SetFlag(f.mask, val != 0)
+				clearJournal()
+				return map[string]string{"value": fmt.Sprint(btoi(val != 0))}, nil
+			}
+		}
+		return nil, fmt.Errorf("Unknown flag: %s", args.Name)
+
+	case Scope_ZeroPage:
+		addr, err := s.evalAddr(strings.Fields(args.Name)[0])
+		if err != nil {
+			return nil, err
+		}
+		ctx.Poke(addr, uint8(val))
+
+	default:
+		return nil, fmt.Errorf("Unknown variablesReference: %d", args.VariablesReference)
+	}
+	clearJournal()
+	return map[string]string{"value": fmt.Sprintf("$%02x", uint8(val))}, nil
+}
+
+// the debug console runs emulator commands, other contexts evaluate expressions
+func (s *dapSession) evaluate(data json.RawMessage) (interface{}, error) {
+	var args struct {
+		Expression string `json:"expression"`
+		Context    string `json:"context"`
+	}
+	if err := unmarshalArgs(data, &args); err != nil {
+		return nil, err
+	}
+
+	if args.Context == "repl" {
+		var out bytes.Buffer
+		if _, err := execCommand(s.ctx, args.Expression, &out); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"result": strings.TrimRight(out.String(), "\n"), "variablesReference": 0}, nil
+	}
+
+	val, err := core6502.EvalExpr(args.Expression, exprEnv(s.ctx))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"result": fmt.Sprintf("$%04x (%d)", uint16(val), val), "variablesReference": 0}, nil
+}
+
+func (s *dapSession) readMemory(data json.RawMessage) (interface{}, error) {
+	var args struct {
+		MemoryReference string `json:"memoryReference"`
+		Offset          int    `json:"offset"`
+		Count           int    `json:"count"`
+	}
+	if err := unmarshalArgs(data, &args); err != nil {
+		return nil, err
+	}
+	addr, err := s.evalAddr(args.MemoryReference)
+	if err != nil {
+		return nil, err
+	}
+
+	start := int(addr) + args.Offset
+	body := map[string]interface{}{"address": memoryReference(uint16(start))}
+	var mem []uint8
+	for n := 0; n < args.Count; n++ {
+		if start+n < 0 || start+n > 0xffff {
+			body["unreadableBytes"] = args.Count - n
+			break
+		}
+		mem = append(mem, s.ctx.Peek(uint16(start+n)))
+	}
+	body["data"] = base64.StdEncoding.EncodeToString(mem)
+	return body, nil
+}
+
+func (s *dapSession) writeMemory(data json.RawMessage) (interface{}, error) {
+	var args struct {
+		MemoryReference string `json:"memoryReference"`
+		Offset          int    `json:"offset"`
+		Data            string `json:"data"`
+	}
+	if err := unmarshalArgs(data, &args); err != nil {
+		return nil, err
+	}
+	addr, err := s.evalAddr(args.MemoryReference)
+	if err != nil {
+		return nil, err
+	}
+	mem, err := base64.StdEncoding.DecodeString(args.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	start := int(addr) + args.Offset
+	written := 0
+	for n, b := range mem {
+		if start+n < 0 || start+n > 0xffff {
+			break
+		}
+		s.ctx.Poke(uint16(start+n), b)
+		written++
+	}
+	clearJournal()
+	s.event("memory", map[string]interface{}{"memoryReference": memoryReference(addr), "offset": args.Offset, "count": written})
+	return map[string]int{"bytesWritten": written}, nil
+}
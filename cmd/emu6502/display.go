@@ -19,7 +19,8 @@ type RegisterDisplay struct {
 }
 
 func (rd *RegisterDisplay) Draw() {
-	printAtDef(rd.x, rd.y, fmt.Sprintf("PC: $%04x    SP: $%02x", rd.ctx.RegPC(), rd.ctx.RegSP()))
+	pcLine := fmt.Sprintf("PC: $%04x    SP: $%02x %s", rd.ctx.RegPC(), rd.ctx.RegSP(), symbols.Format(rd.ctx.RegPC()))
+	printAtDef(rd.x, rd.y, truncate(pcLine, 28))
 	printAtDef(rd.x, rd.y+1, fmt.Sprintf("A: $%02x X: $%02x Y: $%02x", rd.ctx.RegA(), rd.ctx.RegX(), rd.ctx.RegY()))
 	printAtDef(rd.x, rd.y+2, fmt.Sprintf("FLAGS: N V B D I Z C"))
 	printAtDef(rd.x, rd.y+3, fmt.Sprintf("       %x %x %x %x %x %x %x",
@@ -30,6 +31,7 @@ func (rd *RegisterDisplay) Draw() {
 		btoi(rd.ctx.Flag(core6502.Flag_I)),
 		btoi(rd.ctx.Flag(core6502.Flag_Z)),
 		btoi(rd.ctx.Flag(core6502.Flag_C))))
+	printAtDef(rd.x, rd.y+4, fmt.Sprintf("CYCLES: %d", totalCycles))
 }
 
 func (rd *RegisterDisplay) GiveFocus() bool {
@@ -60,6 +62,7 @@ func (md *MemoryDisplay) Draw() {
 			termbox.SetCell(md.x+55+n, md.y+l, c, termbox.ColorDefault, termbox.ColorDefault)
 			addr++
 		}
+		printAtDef(md.x+72, md.y+l, symbols.Format(addr-16))
 	}
 }
 
@@ -79,15 +82,36 @@ func (md *MemoryDisplay) HandleInput(k termbox.Key, r rune) {
 }
 
 type StackDisplay struct {
-	x, y int
-	ctx  core6502.CPUContext
+	x, y  int
+	width int
+	ctx   core6502.CPUContext
 }
 
+// bytes pushed by a call are marked, the return address with > and its
+// symbol on its low byte, or ! if it has changed since the call, and the
+// flags pushed by BRK or an interrupt with P
 func (sd *StackDisplay) Draw() {
+	calls.Sync(sd.ctx)
 	sp := sd.ctx.RegSP() + 1
 
 	for l := 0; l < 16; l++ {
-		printAtDef(sd.x, sd.y+l, fmt.Sprintf("$%02x", sd.ctx.Peek(uint16(sp)+0x100)))
+		addr := uint16(sp) + 0x100
+		line := fmt.Sprintf("$%02x", sd.ctx.Peek(addr))
+		if f, ok := calls.FrameAt(addr); ok {
+			mark := ">"
+			if !f.Intact(sd.ctx) {
+				mark = "!"
+			}
+			switch addr {
+			case f.ReturnAddr():
+				line += fmt.Sprintf(" %s%s", mark, addrName(f.Return))
+			case f.ReturnAddr() + 1:
+				line += " " + mark
+			default:
+				line += " P"
+			}
+		}
+		printAtDef(sd.x, sd.y+l, truncate(line, sd.width))
 		sp++
 	}
 }
@@ -103,15 +127,24 @@ type DisasmDisplay struct {
 	x, y  int
 	lines int
 	ctx   core6502.CPUContext
+	opts  *core6502.DisasmOptions
 }
 
 func (dd *DisasmDisplay) Draw() {
 	pc := dd.ctx.RegPC()
 
 	for l := 0; l < dd.lines; l++ {
-		instr, len, _ := core6502.Disassemble(dd.ctx, pc)
-		printAtDef(dd.x, dd.y+l, fmt.Sprintf("$%04x %s", pc, instr))
-		pc += len
+		if label, ok := dd.opts.Label(pc); ok {
+			printAtDef(dd.x, dd.y+l, label)
+			l++
+			if l == dd.lines {
+				break
+			}
+		}
+
+		inst := core6502.Decode(dd.ctx, pc)
+		printAtDef(dd.x, dd.y+l, fmt.Sprintf("$%04x %s", pc, dd.opts.FormatInstruction(&inst)))
+		pc += inst.Length
 	}
 }
 
@@ -7,6 +7,7 @@ import (
 	"github.com/simulatedsimian/go_sandbox/geom"
 	"github.com/simulatedsimian/runes"
 	"reflect"
+	"strings"
 	"unicode"
 )
 
@@ -21,6 +22,13 @@ func printAtDef(x, y int, s string) {
 	printAt(x, y, s, termbox.ColorDefault, termbox.ColorDefault)
 }
 
+func truncate(s string, width int) string {
+	if len(s) > width {
+		return s[:width]
+	}
+	return s
+}
+
 func clearRect(rect geom.Rectangle, c rune, fg, bg termbox.Attribute) {
 	w, h := termbox.Size()
 	sz := geom.RectangleFromSize(geom.Coord{w, h})
@@ -129,12 +137,13 @@ func (t *TextInputField) HandleInput(k termbox.Key, r rune) {
 			} else {
 				t.history.PushBack(t.inp)
 			}
-			t.histPos = nil
-
-			t.inpHandler(string(t.inp))
-			t.inp = nil
-			t.cursorLoc = 0
 		}
+		t.histPos = nil
+
+		// empty lines are passed on too, they end assembly mode
+		t.inpHandler(string(t.inp))
+		t.inp = nil
+		t.cursorLoc = 0
 	}
 
 	if k == termbox.KeyEsc {
@@ -249,6 +258,9 @@ func (t *ScrollingTextOutput) HandleInput(k termbox.Key, r rune) {
 }
 
 func (t *ScrollingTextOutput) Write(p []byte) (n int, err error) {
+	for _, l := range strings.Split(strings.TrimSuffix(string(p), "\n"), "\n") {
+		t.WriteLine(l)
+	}
 	return len(p), nil
 }
 
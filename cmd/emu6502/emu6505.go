@@ -1,12 +1,122 @@
 package main
 
 import (
-	//	"fmt"
+	"flag"
+	"fmt"
 	"github.com/nsf/termbox-go"
 	"github.com/simulatedsimian/emu6502/core6502"
+	"github.com/simulatedsimian/emu6502/image6502"
+	"github.com/simulatedsimian/emu6502/nes6502"
+	"io/ioutil"
+	"net"
+	"os"
 )
 
 func main() {
+	var symFiles symFileList
+	flag.Var(&symFiles, "sym", "load symbols from `file` (VICE labels, ld65 map or debug info), may be repeated")
+	loadFile := flag.String("load", "", "load a raw, Intel HEX, S-record or PRG image from `file[@address]` and set PC to its start")
+	nesFile := flag.String("nes", "", "run the iNES ROM in `file` with the NES memory map, from its reset vector")
+	dap := flag.Bool("dap", false, "serve the Debug Adapter Protocol on stdin and stdout instead of the terminal UI")
+	dapListen := flag.String("dap-listen", "", "serve the Debug Adapter Protocol on the TCP `address`, e.g. localhost:4711")
+	viceListen := flag.String("binarymonitor", "", "serve the VICE binary monitor protocol on the TCP `address`, e.g. localhost:6502")
+	rpcListen := flag.String("rpc", "", "serve JSON-RPC on the Unix socket `path`, or a loopback TCP address such as localhost:6510")
+	journalSize := flag.Int("journal", 1<<20, "record up to `bytes` of history for back and rewind-to-write, 0 to turn it off")
+	headless := flag.Bool("headless", false, "with -rpc, run without the terminal UI")
+	flag.Parse()
+
+	if *headless && *rpcListen == "" {
+		fmt.Fprintln(os.Stderr, "-headless needs -rpc")
+		os.Exit(2)
+	}
+
+	for _, f := range symFiles {
+		if err := loadSymbols(f, ioutil.Discard); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	var img *image6502.Image
+	var imgFile string
+	if *loadFile != "" {
+		var err error
+		if img, imgFile, err = image6502.LoadFileArg(*loadFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	var console *nes6502.NES
+	if *nesFile != "" {
+		rom, err := nes6502.LoadROM(*nesFile)
+		if err == nil {
+			console, err = nes6502.New(rom)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	var ctx core6502.CPUContext = &core6502.BasicCPUContext{}
+	core6502.HardResetCPU(ctx, 0x400)
+	if console != nil {
+		ctx = console
+		core6502.SoftResetCPU(ctx)
+	}
+	if *journalSize > maxJournalSize {
+		fmt.Fprintf(os.Stderr, "The journal is limited to %d bytes\n", maxJournalSize)
+		os.Exit(2)
+	}
+	startJournal(ctx, *journalSize)
+
+	if *dap || *dapListen != "" {
+		if img != nil {
+			installImage(ctx, os.Stderr, imgFile, img, true)
+		}
+		if err := serveDAP(ctx, *dapListen); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *viceListen != "" {
+		if img != nil {
+			installImage(ctx, os.Stderr, imgFile, img, true)
+		}
+		if err := serveViceMonitor(ctx, *viceListen); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	var rpcListener net.Listener
+	var rpcCalls chan func() bool
+	if *rpcListen != "" {
+		l, err := listenRPC(*rpcListen)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer l.Close()
+
+		rpcCalls = make(chan func() bool)
+		if *headless {
+			if img != nil {
+				installImage(ctx, os.Stderr, imgFile, img, true)
+			}
+			go serveRPC(l, ctx, rpcCalls, nil)
+			for call := range rpcCalls {
+				if call() {
+					return
+				}
+			}
+		}
+		rpcListener = l
+	}
 
 	err := termbox.Init()
 	if err != nil {
@@ -14,23 +124,31 @@ func main() {
 	}
 	defer termbox.Close()
 
-	var ctx core6502.BasicCPUContext
-	core6502.HardResetCPU(&ctx, 0x400)
-
 	doQuit := false
 
-	regDisp := RegisterDisplay{1, 1, &ctx}
-	memDisp := MemoryDisplay{30, 1, 0, &ctx}
-	stkDisp := StackDisplay{24, 1, &ctx}
+	regDisp := RegisterDisplay{1, 1, ctx}
+	memDisp := MemoryDisplay{52, 1, 0, ctx}
+	stkDisp := StackDisplay{30, 1, 20, ctx}
 	logDisp := ScrollingTextOutput{1, 20, 80, 10, nil}
-	disDisp := DisasmDisplay{1, 7, 10, &ctx}
+	disDisp := DisasmDisplay{1, 7, 10, ctx, disasmOptions()}
+
+	cmdPrompt := StaticText{1, 18, commandPrompt()}
+
+	if console != nil {
+		console.SetLog(&logDisp)
+	}
+
+	if img != nil {
+		installImage(ctx, &logDisp, imgFile, img, true)
+	}
 
 	cmdInput := MakeTextInputField(10, 18, func(cmd string) {
 		var err error
-		doQuit, err = DispatchCommand(&ctx, cmd)
+		doQuit, err = DispatchCommand(ctx, cmd, &logDisp)
 		if err != nil {
 			logDisp.WriteLine(err.Error())
 		}
+		cmdPrompt.text = commandPrompt()
 	})
 
 	dl := DisplayList{}
@@ -40,10 +158,10 @@ func main() {
 	dl.AddElement(&stkDisp)
 	dl.AddElement(&logDisp)
 	dl.AddElement(&disDisp)
-	dl.AddElement(&StaticText{1, 18, "Command:"})
+	dl.AddElement(&cmdPrompt)
 	dl.AddElement(&StaticText{1, 0, "Registers:"})
-	dl.AddElement(&StaticText{30, 0, "Memory:"})
-	dl.AddElement(&StaticText{24, 0, "TOS:"})
+	dl.AddElement(&StaticText{52, 0, "Memory:"})
+	dl.AddElement(&StaticText{30, 0, "TOS:"})
 	dl.AddElement(&StaticText{1, 6, "Disassembly:"})
 
 	cmdInput.GiveFocus()
@@ -51,6 +169,10 @@ func main() {
 	dl.Draw()
 	termbox.Flush()
 
+	if rpcListener != nil {
+		go serveRPC(rpcListener, ctx, rpcCalls, termbox.Interrupt)
+	}
+
 	for !doQuit {
 		ev := termbox.PollEvent()
 
@@ -63,5 +185,13 @@ func main() {
 		if ev.Type == termbox.EventResize {
 			termbox.Flush()
 		}
+
+		if ev.Type == termbox.EventInterrupt {
+			call := <-rpcCalls
+			doQuit = call()
+			cmdPrompt.text = commandPrompt()
+			dl.Draw()
+			termbox.Flush()
+		}
 	}
 }
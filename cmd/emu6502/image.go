@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"github.com/simulatedsimian/emu6502/image6502"
+	"io"
+	"strings"
+)
+
+// copies img to memory, with setPC PC is set to its start address, or the first address loaded
+func installImage(ctx core6502.CPUContext, out io.Writer, filename string, img *image6502.Image, setPC bool) {
+	img.CopyTo(ctx)
+	clearJournal()
+	for _, s := range img.Segments {
+		fmt.Fprintf(out, "Loaded $%04x-$%04x from %s (%v)\n", s.Addr, s.End()-1, filename, img.Format)
+	}
+
+	if setPC && (img.HasStart || len(img.Segments) > 0) {
+		pc := img.Start
+		if !img.HasStart {
+			pc = img.Segments[0].Addr
+		}
+		ctx.SetRegPC(pc)
+		fmt.Fprintf(out, "PC = $%04x\n", pc)
+	}
+}
+
+// load <file> [address] [pc]
+func loadImage(ctx core6502.CPUContext, out io.Writer, filename string, args []string) error {
+	setPC := false
+	if n := len(args); n > 0 && strings.ToLower(args[n-1]) == "pc" {
+		setPC = true
+		args = args[:n-1]
+	}
+
+	addr, hasAddr := uint16(0), len(args) > 0
+	if hasAddr {
+		val, rest, err := evalArg(ctx, strings.Join(args, " "), 16)
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(rest) != "" {
+			return fmt.Errorf("Too Many Args: load <file> [address] [pc]")
+		}
+		addr = uint16(val)
+	}
+
+	img, err := image6502.LoadFileAt(filename, addr, hasAddr)
+	if err != nil {
+		return err
+	}
+	installImage(ctx, out, filename, img, setPC)
+	return nil
+}
+
+// save <file> <start> <end>, the format is given by the file extension
+func saveImage(ctx core6502.CPUContext, out io.Writer, filename string, start, end uint16) error {
+	if end < start {
+		return fmt.Errorf("End $%04x is before start $%04x", end, start)
+	}
+	if err := image6502.FromMemory(ctx, start, end).SaveFile(filename); err != nil {
+		return err
+	}
+
+	format, _ := image6502.FormatFromName(filename)
+	fmt.Fprintf(out, "Saved $%04x-$%04x to %s (%v)\n", start, end, filename, format)
+	return nil
+}
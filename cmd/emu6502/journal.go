@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"io"
+	"strings"
+)
+
+// the undo journal of the instructions executed, nil when off
+var journal *core6502.Journal
+
+// the size of the journal in bytes, 0 when off
+var journalSize int
+
+const maxJournalSize = 1 << 30
+
+// starts a journal of size bytes for ctx, turning it off if size is 0, and
+// again with the same size whenever the machine changes
+func startJournal(ctx core6502.CPUContext, size int) {
+	journal, journalSize = nil, size
+	if size <= 0 {
+		return
+	}
+	journal = core6502.NewJournal(size)
+	if d, ok := ctx.(interface {
+		IsDevice(addr uint16) bool
+	}); ok {
+		journal.IsDevice = d.IsDevice
+	}
+}
+
+// forgets the instructions executed, after the machine state was replaced
+// or changed other than by executing
+func clearJournal() {
+	if journal != nil {
+		journal.Clear()
+	}
+}
+
+// undoes count instructions, stopping early if the journal runs out
+func stepBack(ctx core6502.CPUContext, count int) error {
+	if journal == nil {
+		return fmt.Errorf("The journal is off, use journal <size>")
+	}
+	defer calls.Sync(ctx)
+
+	for ; count > 0; count-- {
+		cycles, ok := journal.Back(ctx)
+		if !ok {
+			return fmt.Errorf("No more history in the journal")
+		}
+		totalCycles -= uint64(cycles)
+	}
+	return nil
+}
+
+/*
+	back undoes the last instruction executed, or the last count, restoring
+	the registers and the memory they wrote. Calls returned from since then
+	are not restored to the shadow call stack.
+*/
+func back(ctx core6502.CPUContext, args []string) error {
+	count := 1
+	if len(args) > 0 {
+		val, _, err := evalArg(ctx, strings.Join(args, " "), 16)
+		if err != nil {
+			return err
+		}
+		count = int(val)
+	}
+	return stepBack(ctx, count)
+}
+
+// runs backwards until the PC is at the last instruction that wrote addr,
+// before it executed
+func rewindToWrite(ctx core6502.CPUContext, out io.Writer, addr uint16) error {
+	if journal == nil {
+		return fmt.Errorf("The journal is off, use journal <size>")
+	}
+	count := journal.LastWrite(addr)
+	if count == 0 {
+		return fmt.Errorf("No write to $%04x in the journal", addr)
+	}
+	if err := stepBack(ctx, count); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "$%04x written at %s\n", addr, addrName(ctx.RegPC()))
+	return nil
+}
+
+/*
+	journal shows how much history is recorded, journal <size> records up
+	to size bytes of it from then on, 0 to turn the journal off. Each
+	instruction takes 10 bytes plus 3 for each byte it writes.
+*/
+func journalCommand(ctx core6502.CPUContext, out io.Writer, args []string) error {
+	if len(args) == 0 {
+		if journal == nil {
+			fmt.Fprintln(out, "The journal is off")
+			return nil
+		}
+		used, size := journal.Usage()
+		fmt.Fprintf(out, "%d instructions in %d of %d bytes\n", journal.Len(), used, size)
+		return nil
+	}
+
+	val, _, err := evalArg(ctx, strings.Join(args, " "), 32)
+	if err != nil {
+		return err
+	}
+	if val > maxJournalSize {
+		return fmt.Errorf("The journal is limited to %d bytes", maxJournalSize)
+	}
+	startJournal(ctx, int(val))
+	return nil
+}
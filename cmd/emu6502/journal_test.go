@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/simulatedsimian/assert"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// changing the machine state other than by executing clears the journal,
+// so back cannot undo into a state that never existed
+func TestJournalClear(t *testing.T) {
+	pack := assert.Pack
+
+	ctx := &core6502.BasicCPUContext{}
+	startJournal(ctx, 1000)
+	defer startJournal(ctx, 0)
+
+	tests := []string{
+		"softreset",
+		"hardreset $0400",
+		"sm $10 1",
+		"sb $10 2 0",
+		"sr a 1",
+		"ps 1",
+		"asm $0500 nop",
+		"journal 1000",
+	}
+	for _, cmd := range tests {
+		var out bytes.Buffer
+		ctx.PokeWord(core6502.Vector_RST, 0x0400)
+		assert.NoError(t, pack(runCommand(ctx, "asm", "$0400 inx", &out)))
+		assert.NoError(t, pack(runCommand(ctx, "asm", "$0401 jmp $0400", &out)))
+		assert.NoError(t, pack(runCommand(ctx, "sr", "pc $0400", &out)))
+		assert.NoError(t, pack(runCommand(ctx, "x", "2", &out)))
+		assert.Equal(t, journal.Len(), 2)
+
+		_, err := DispatchCommand(ctx, cmd, &out)
+		assert.NoError(t, pack(err))
+		assert.Equal(t, journal.Len(), 0)
+		assert.Equal(t, fmt.Sprint(runCommand(ctx, "back", "", &out)), "No more history in the journal")
+	}
+}
+
+// a NES journal skips the PPU, APU and mapper registers, and is rebuilt
+// when a DAP launch replaces the machine
+func TestJournalNESLaunch(t *testing.T) {
+	pack := assert.Pack
+
+	dir, err := ioutil.TempDir("", "journal")
+	assert.NoError(t, pack(err))
+	defer os.RemoveAll(dir)
+
+	prg := make([]uint8, 0x4000)
+	prg[0x0000], prg[0x0001], prg[0x0002] = 0x4c, 0x00, 0x80 // jmp $8000
+	prg[0x3ffc], prg[0x3ffd] = 0x00, 0x80
+	rom := filepath.Join(dir, "test.nes")
+	assert.NoError(t, pack(ioutil.WriteFile(rom, append([]uint8("NES\x1a\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"), prg...), 0644)))
+
+	ctx := &core6502.BasicCPUContext{}
+	startJournal(ctx, 1000)
+	defer startJournal(ctx, 0)
+	assert.Equal(t, journal.IsDevice == nil, true)
+
+	server, conn := net.Pipe()
+	defer conn.Close()
+	s := newDAPSession(ctx, server, server)
+	done := make(chan error)
+	go func() {
+		done <- s.serve()
+		server.Close()
+	}()
+	c := &dapClient{t: t, conn: conn, in: newDAPSession(nil, conn, nil)}
+
+	c.call("launch", map[string]string{"nes": rom})
+	assert.Equal(t, c.evaluate("pc"), "$8000 (32768)")
+	assert.Equal(t, journal.IsDevice != nil, true)
+	assert.Equal(t, journal.IsDevice(0x2000), true)
+	assert.Equal(t, journal.IsDevice(0x8000), true)
+	assert.Equal(t, journal.IsDevice(0x0200), false)
+	_, size := journal.Usage()
+	assert.Equal(t, size, 1000)
+
+	c.call("disconnect", nil)
+	assert.NoError(t, pack(<-done))
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+/*
+	A JSON-RPC 2.0 control API, so test drivers and scripts can control a
+	running emulator over a Unix socket or a loopback TCP port. Each
+	request, batch and response is a line of JSON:
+
+	{"jsonrpc":"2.0","id":1,"method":"step","params":[3]}
+	{"jsonrpc":"2.0","id":1,"result":{"output":"","registers":{"a":5,...}}}
+
+	A method runs a command, as typed at the command prompt, with the
+	params, strings or numbers, as its arguments, so the two stay in
+	sync. Every command is a method of the same name, the methods below
+	name the common ones and add the state they read to the result,
+	"command" runs a whole command line and "quit" stops the emulator.
+	The result always has the command output.
+*/
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// error codes
+const (
+	RPCErr_Parse          = -32700
+	RPCErr_InvalidRequest = -32600
+	RPCErr_MethodNotFound = -32601
+	RPCErr_InvalidParams  = -32602
+	RPCErr_Command        = -32000 // the command failed, data has its output
+)
+
+type rpcResult map[string]interface{}
+
+// a method, the command it runs, if any, and the state it adds to the result
+type rpcMethod struct {
+	command string
+	result  func(ctx core6502.CPUContext, args []string) (rpcResult, error)
+}
+
+var rpcMethods = map[string]rpcMethod{
+	"load":            {"load", rpcRegisters},
+	"reset":           {"softreset", rpcRegisters},
+	"hardReset":       {"hardreset", rpcRegisters},
+	"step":            {"x", rpcRegisters},
+	"run":             {"g", rpcRegisters},
+	"runUntil":        {"until", rpcRegisters},
+	"setBreakpoint":   {"bp", nil},
+	"clearBreakpoint": {"bc", nil},
+	"setWatchpoint":   {"wp", nil},
+	"clearWatchpoint": {"wc", nil},
+	"breakpoints":     {"", rpcBreakpoints},
+	"getRegisters":    {"", rpcRegisters},
+	"setRegister":     {"sr", rpcRegisters},
+	"readMemory":      {"", rpcReadMemory},
+	"writeMemory":     {"sm", nil},
+	"disassemble":     {"d", rpcDisassembly},
+	"snapshot":        {"", rpcSnapshot},
+	"stepBack":        {"back", rpcRegisters},
+	"rewindToWrite":   {"rewind-to-write", rpcRegisters},
+	"irq":             {"irq", rpcRegisters},
+	"nmi":             {"nmi", rpcRegisters},
+	"journal":         {"journal", nil},
+}
+
+func registerValues(ctx core6502.CPUContext) map[string]int {
+	return map[string]int{
+		"a": int(ctx.RegA()), "x": int(ctx.RegX()), "y": int(ctx.RegY()),
+		"sp": int(ctx.RegSP()), "p": int(ctx.Flags()), "pc": int(ctx.RegPC()),
+	}
+}
+
+func rpcRegisters(ctx core6502.CPUContext, args []string) (rpcResult, error) {
+	return rpcResult{"registers": registerValues(ctx)}, nil
+}
+
+func rpcBreakpoints(ctx core6502.CPUContext, args []string) (rpcResult, error) {
+	type point struct {
+		Address   uint16 `json:"address"`
+		Condition string `json:"condition,omitempty"`
+		Hook      string `json:"hook,omitempty"`
+	}
+	exprString := func(cond *core6502.Expr) string {
+		if cond == nil {
+			return ""
+		}
+		return cond.String()
+	}
+	hookName := func(hook breakHook) string {
+		if hook == nil {
+			return ""
+		}
+		return hook.String()
+	}
+
+	var addrs []uint16
+	for addr := range breakpoints {
+		addrs = append(addrs, addr)
+	}
+	bps := []point{}
+	for _, addr := range sortAddrs(addrs) {
+		bp := breakpoints[addr]
+		bps = append(bps, point{addr, exprString(bp.cond), hookName(bp.hook)})
+	}
+
+	addrs = addrs[:0]
+	for addr := range watchpoints {
+		addrs = append(addrs, addr)
+	}
+	wps := []point{}
+	for _, addr := range sortAddrs(addrs) {
+		wp := watchpoints[addr]
+		wps = append(wps, point{addr, exprString(wp.cond), hookName(wp.hook)})
+	}
+	return rpcResult{"breakpoints": bps, "watchpoints": wps}, nil
+}
+
+// readMemory <address> <count>, the bytes as an array of numbers
+func rpcReadMemory(ctx core6502.CPUContext, args []string) (rpcResult, error) {
+	if len(args) != 2 {
+		return nil, &rpcError{Code: RPCErr_InvalidParams, Message: "Usage: readMemory <address> <count>"}
+	}
+	addr, _, err := evalArg(ctx, args[0], 16)
+	if err != nil {
+		return nil, err
+	}
+	count, _, err := evalArg(ctx, args[1], 16)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]int, count)
+	for n := range data {
+		data[n] = int(ctx.Peek(uint16(addr) + uint16(n)))
+	}
+	return rpcResult{"address": addr, "data": data}, nil
+}
+
+func rpcDisassembly(ctx core6502.CPUContext, args []string) (rpcResult, error) {
+	addr, _, err := evalArg(ctx, args[0], 16)
+	if err != nil {
+		return nil, err
+	}
+	count := uint64(10)
+	if len(args) > 1 {
+		if count, _, err = evalArg(ctx, strings.Join(args[1:], " "), 16); err != nil {
+			return nil, err
+		}
+	}
+
+	type instruction struct {
+		Address uint16 `json:"address"`
+		Label   string `json:"label,omitempty"`
+		Bytes   []int  `json:"bytes"`
+		Text    string `json:"text"`
+	}
+	opts := disasmOptions()
+	insts := []instruction{}
+	for _, inst := range decodeFrom(ctx, uint16(addr), int(count)) {
+		label, _ := opts.Label(inst.Addr)
+		raw := []int{int(inst.Opcode)}
+		for _, b := range inst.Operand {
+			raw = append(raw, int(b))
+		}
+		insts = append(insts, instruction{inst.Addr, strings.TrimSuffix(label, ":"), raw, opts.FormatInstruction(&inst)})
+	}
+	return rpcResult{"instructions": insts}, nil
+}
+
+// the registers and all 64K of memory, base64 encoded
+func rpcSnapshot(ctx core6502.CPUContext, args []string) (rpcResult, error) {
+	mem := make([]byte, 0x10000)
+	for addr := range mem {
+		mem[addr] = ctx.Peek(uint16(addr))
+	}
+	return rpcResult{"registers": registerValues(ctx), "memory": base64.StdEncoding.EncodeToString(mem)}, nil
+}
+
+// converts params to command arguments, numbers in decimal
+func rpcArgs(params json.RawMessage) ([]string, error) {
+	if len(params) == 0 || string(params) == "null" {
+		return nil, nil
+	}
+
+	var list []interface{}
+	dec := json.NewDecoder(bytes.NewReader(params))
+	dec.UseNumber()
+	if err := dec.Decode(&list); err != nil {
+		return nil, &rpcError{Code: RPCErr_InvalidParams, Message: "Params must be an array of strings and numbers"}
+	}
+
+	var args []string
+	for _, p := range list {
+		switch v := p.(type) {
+		case string:
+			args = append(args, v)
+		case json.Number:
+			args = append(args, v.String())
+		default:
+			return nil, &rpcError{Code: RPCErr_InvalidParams, Message: "Params must be an array of strings and numbers"}
+		}
+	}
+	return args, nil
+}
+
+/*
+	Serves connections, each in its own goroutine. Calls are run by
+	sending them on calls, to run where commands run, wake is called
+	first if the receiver needs waking. A call returns true to quit.
+*/
+type rpcServer struct {
+	ctx   core6502.CPUContext
+	calls chan<- func() bool
+	wake  func()
+}
+
+// runs f where commands run, waiting for it to finish
+func (s *rpcServer) call(f func() bool) {
+	done := make(chan struct{})
+	if s.wake != nil {
+		go s.wake()
+	}
+	s.calls <- func() bool {
+		defer close(done)
+		return f()
+	}
+	<-done
+}
+
+// handles a request, returning the response, nil for a notification, and true to quit
+func (s *rpcServer) handle(data json.RawMessage) (*rpcResponse, bool) {
+	var req rpcRequest
+	if err := json.Unmarshal(data, &req); err != nil || req.JSONRPC != "2.0" || req.Method == "" {
+		return &rpcResponse{JSONRPC: "2.0", Error: &rpcError{Code: RPCErr_InvalidRequest, Message: "Invalid request"}}, false
+	}
+
+	var result rpcResult
+	var quit bool
+	var err error
+	s.call(func() bool {
+		result, quit, err = s.invoke(req.Method, req.Params)
+		return false
+	})
+
+	if req.ID == nil {
+		return nil, quit
+	}
+	resp := &rpcResponse{JSONRPC: "2.0", ID: req.ID}
+	switch e := err.(type) {
+	case nil:
+		resp.Result = result
+	case *rpcError:
+		resp.Error = e
+	default:
+		resp.Error = &rpcError{Code: RPCErr_Command, Message: err.Error()}
+		if output, _ := result["output"].(string); output != "" {
+			resp.Error.Data = map[string]string{"output": output}
+		}
+	}
+	return resp, quit
+}
+
+// runs a method, returning true to quit
+func (s *rpcServer) invoke(name string, params json.RawMessage) (rpcResult, bool, error) {
+	args, err := rpcArgs(params)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var out bytes.Buffer
+	switch name {
+	case "quit":
+		if len(args) != 0 {
+			return nil, false, &rpcError{Code: RPCErr_InvalidParams, Message: "Usage: quit"}
+		}
+		return rpcResult{"output": ""}, true, nil
+
+	case "command":
+		if len(args) != 1 {
+			return nil, false, &rpcError{Code: RPCErr_InvalidParams, Message: "Usage: command <command line>"}
+		}
+		quit, err := execCommand(s.ctx, args[0], &out)
+		return rpcResult{"output": out.String()}, quit, err
+	}
+
+	m, ok := rpcMethods[name]
+	if !ok {
+		if _, ok := commands[name]; !ok {
+			return nil, false, &rpcError{Code: RPCErr_MethodNotFound, Message: fmt.Sprintf("Unknown method: %s", name)}
+		}
+		m = rpcMethod{command: name}
+	}
+
+	if m.command != "" {
+		if err := runCommand(s.ctx, m.command, strings.Join(args, " "), &out); err != nil {
+			return rpcResult{"output": out.String()}, false, err
+		}
+	}
+	result := rpcResult{}
+	if m.result != nil {
+		if result, err = m.result(s.ctx, args); err != nil {
+			return rpcResult{"output": out.String()}, false, err
+		}
+	}
+	result["output"] = out.String()
+	return result, false, nil
+}
+
+// handles requests on conn until it closes, or quit
+func (s *rpcServer) serve(conn io.ReadWriter) error {
+	dec := json.NewDecoder(conn)
+	enc := json.NewEncoder(conn)
+
+	for {
+		var data json.RawMessage
+		if err := dec.Decode(&data); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			if _, ok := err.(*json.SyntaxError); ok {
+				enc.Encode(&rpcResponse{JSONRPC: "2.0", Error: &rpcError{Code: RPCErr_Parse, Message: err.Error()}})
+			}
+			return err
+		}
+
+		var quit bool
+		if data[0] == '[' {
+			var batch []json.RawMessage
+			json.Unmarshal(data, &batch)
+			if len(batch) == 0 {
+				enc.Encode(&rpcResponse{JSONRPC: "2.0", Error: &rpcError{Code: RPCErr_InvalidRequest, Message: "Empty batch"}})
+				continue
+			}
+			resps := []*rpcResponse{}
+			for _, req := range batch {
+				resp, q := s.handle(req)
+				if resp != nil {
+					resps = append(resps, resp)
+				}
+				quit = quit || q
+			}
+			if len(resps) > 0 {
+				enc.Encode(resps)
+			}
+		} else {
+			var resp *rpcResponse
+			if resp, quit = s.handle(data); resp != nil {
+				enc.Encode(resp)
+			}
+		}
+
+		if quit {
+			s.call(func() bool { return true })
+			return nil
+		}
+	}
+}
+
+/*
+	Listens on the Unix socket path, or unix:path, or the TCP address
+	listen, which must be a loopback address as the API can read and
+	write files.
+*/
+func listenRPC(listen string) (net.Listener, error) {
+	if strings.HasPrefix(listen, "unix:") || strings.Contains(listen, "/") {
+		path := strings.TrimPrefix(listen, "unix:")
+		if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			if conn, err := net.Dial("unix", path); err == nil {
+				conn.Close()
+				return nil, fmt.Errorf("Socket in use: %s", path)
+			}
+			os.Remove(path) // left by an instance that exited without removing it
+		}
+		return net.Listen("unix", path)
+	}
+
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("JSON-RPC must listen on a loopback address: %s", listen)
+	}
+	return net.Listen("tcp", listen)
+}
+
+// serves each connection to l in its own goroutine until l is closed
+func serveRPC(l net.Listener, ctx core6502.CPUContext, calls chan<- func() bool, wake func()) error {
+	s := &rpcServer{ctx: ctx, calls: calls, wake: wake}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if err := s.serve(conn); err != nil && wake == nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}()
+	}
+}
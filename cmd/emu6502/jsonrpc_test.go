@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/simulatedsimian/assert"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"net"
+	"testing"
+)
+
+func TestRPCServer(t *testing.T) {
+	pack := assert.Pack
+
+	calls := make(chan func() bool)
+	quit := make(chan bool, 1)
+	go func() {
+		for call := range calls {
+			if call() {
+				quit <- true
+			}
+		}
+	}()
+	defer close(calls)
+
+	server, conn := net.Pipe()
+	defer conn.Close()
+	s := &rpcServer{ctx: &core6502.BasicCPUContext{}, calls: calls}
+	done := make(chan error)
+	go func() {
+		done <- s.serve(server)
+		server.Close()
+	}()
+
+	// each request and its response, none for notifications
+	tests := []struct {
+		req, resp string
+	}{
+		{`{"jsonrpc":"2.0","id":1,"method":"writeMemory","params":["$0400",169,"$05"]}`,
+			`{"jsonrpc":"2.0","id":1,"result":{"output":""}}`},
+		{`{"jsonrpc":"2.0","id":2,"method":"readMemory","params":["$0400",2]}`,
+			`{"jsonrpc":"2.0","id":2,"result":{"address":1024,"data":[169,5],"output":""}}`},
+		{`{"jsonrpc":"2.0","id":"pc","method":"setRegister","params":["pc","$0400"]}`,
+			`{"jsonrpc":"2.0","id":"pc","result":{"output":"","registers":{"a":0,"x":0,"y":0,"sp":0,"p":0,"pc":1024}}}`},
+		{`{"jsonrpc":"2.0","id":3,"method":"command","params":["x"]}`,
+			`{"jsonrpc":"2.0","id":3,"result":{"output":""}}`},
+		{`{"jsonrpc":"2.0","id":4,"method":"getRegisters"}`,
+			`{"jsonrpc":"2.0","id":4,"result":{"output":"","registers":{"a":5,"x":0,"y":0,"sp":0,"p":0,"pc":1026}}}`},
+
+		{`{"jsonrpc":"2.0","id":5,"method":"nosuch"}`,
+			`{"jsonrpc":"2.0","id":5,"error":{"code":-32601,"message":"Unknown method: nosuch"}}`},
+		{`{"jsonrpc":"2.0","id":6,"method":"readMemory","params":{"address":1024}}`,
+			`{"jsonrpc":"2.0","id":6,"error":{"code":-32602,"message":"Params must be an array of strings and numbers"}}`},
+		{`{"jsonrpc":"2.0","id":7,"method":"readMemory","params":["$0400"]}`,
+			`{"jsonrpc":"2.0","id":7,"error":{"code":-32602,"message":"Usage: readMemory <address> <count>"}}`},
+		{`{"jsonrpc":"2.0","id":8,"method":"quit","params":[1]}`,
+			`{"jsonrpc":"2.0","id":8,"error":{"code":-32602,"message":"Usage: quit"}}`},
+		{`{"jsonrpc":"2.0","id":9,"method":"clearBreakpoint","params":["$1234"]}`,
+			`{"jsonrpc":"2.0","id":9,"error":{"code":-32000,"message":"No Breakpoint at: $1234"}}`},
+		{`{"id":10,"method":"getRegisters"}`,
+			`{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"Invalid request"}}`},
+
+		// notifications run without a response, alone or in a batch
+		{`{"jsonrpc":"2.0","method":"writeMemory","params":["$10",1]}`, ``},
+		{`[{"jsonrpc":"2.0","method":"writeMemory","params":["$11",2]}]`, ``},
+		{`[{"jsonrpc":"2.0","id":11,"method":"readMemory","params":["$10",3]},` +
+			`{"jsonrpc":"2.0","method":"writeMemory","params":["$12",3]},` +
+			`{"jsonrpc":"2.0","id":12,"method":"readMemory","params":["$10",3]},` +
+			`{"jsonrpc":"2.0","id":13,"method":"nosuch"}]`,
+			`[{"jsonrpc":"2.0","id":11,"result":{"address":16,"data":[1,2,0],"output":""}},` +
+				`{"jsonrpc":"2.0","id":12,"result":{"address":16,"data":[1,2,3],"output":""}},` +
+				`{"jsonrpc":"2.0","id":13,"error":{"code":-32601,"message":"Unknown method: nosuch"}}]`},
+		{`[]`, `{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"Empty batch"}}`},
+
+		{`{"jsonrpc":"2.0","id":14,"method":"quit"}`,
+			`{"jsonrpc":"2.0","id":14,"result":{"output":""}}`},
+	}
+
+	r := bufio.NewReader(conn)
+	for _, test := range tests {
+		_, err := fmt.Fprintln(conn, test.req)
+		assert.NoError(t, pack(err))
+		if test.resp == "" {
+			continue
+		}
+
+		line, err := r.ReadBytes('\n')
+		assert.NoError(t, pack(err))
+		var got, want interface{}
+		assert.NoError(t, pack(json.Unmarshal(line, &got)))
+		assert.NoError(t, pack(json.Unmarshal([]byte(test.resp), &want)))
+		assert.Equal(t, got, want)
+	}
+
+	assert.NoError(t, pack(<-done))
+	assert.Equal(t, <-quit, true)
+}
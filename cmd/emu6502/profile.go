@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"github.com/simulatedsimian/emu6502/prof6502"
+	"io"
+)
+
+// the profile being recorded, nil when not profiling
+var profiler *prof6502.Profiler
+
+// the last profile, kept after prof stop for report and write
+var profile *prof6502.Profiler
+
+/*
+	prof start records every instruction executed by x and g from then on,
+	prof stop ends recording. prof report prints the hot spots and
+	subroutines of the profile, 10 of each by default, and prof write
+	saves it for go tool pprof.
+*/
+func profCommand(ctx core6502.CPUContext, out io.Writer, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("Usage: prof start | prof stop | prof report [count] | prof write <file>")
+	}
+
+	switch args[0] {
+	case "start":
+		profiler = prof6502.New()
+		profile = profiler
+
+	case "stop":
+		if profiler == nil {
+			return fmt.Errorf("Not profiling")
+		}
+		profiler = nil
+
+	case "report":
+		if profile == nil {
+			return fmt.Errorf("No profile, use prof start")
+		}
+		count := 10
+		if len(args) > 1 {
+			val, _, err := evalArg(ctx, args[1], 16)
+			if err != nil {
+				return err
+			}
+			count = int(val)
+		}
+		profile.Report(out, count, symbols)
+
+	case "write":
+		if len(args) != 2 {
+			return fmt.Errorf("Usage: prof write <file>")
+		}
+		if profile == nil {
+			return fmt.Errorf("No profile, use prof start")
+		}
+		if err := profile.WritePprofFile(args[1], symbols); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Wrote %s, view with go tool pprof %s\n", args[1], args[1])
+
+	default:
+		return fmt.Errorf("Unknown prof command: %s", args[0])
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+/*
+	Runs the CPU in the background for the DAP server and the VICE
+	monitor. A run holds cpu while it executes, releasing it every 1000
+	instructions; requests take cpu before touching the CPU, memory or
+	breakpoints, so they can be handled while running.
+*/
+type cpuRunner struct {
+	cpu      sync.Mutex
+	stopping int32 // set to stop a run
+	running  int32 // set while running
+}
+
+// stops a run, waiting for it to finish
+func (r *cpuRunner) stop() {
+	atomic.StoreInt32(&r.stopping, 1)
+	r.cpu.Lock()
+	r.cpu.Unlock()
+}
+
+func (r *cpuRunner) isRunning() bool {
+	return atomic.LoadInt32(&r.running) != 0
+}
+
+// calls run in the background holding cpu, unless a run is in progress
+func (r *cpuRunner) runInBackground(run func()) {
+	if !atomic.CompareAndSwapInt32(&r.running, 0, 1) {
+		return
+	}
+	atomic.StoreInt32(&r.stopping, 0)
+
+	r.cpu.Lock()
+	go func() {
+		defer r.cpu.Unlock()
+		defer atomic.StoreInt32(&r.running, 0)
+		run()
+	}()
+}
+
+/*
+	Calls step for each instruction until it returns true, returning
+	false if stop was called first. step is told if it is the first
+	instruction of the run, so a breakpoint at the starting PC can be
+	ignored to resume from it.
+*/
+func (r *cpuRunner) loop(step func(first bool) bool) bool {
+	for n := 0; ; n++ {
+		if n > 0 {
+			if n%1000 == 0 {
+				r.cpu.Unlock()
+				r.cpu.Lock()
+			}
+			if atomic.LoadInt32(&r.stopping) != 0 {
+				return false
+			}
+		}
+		if step(n == 0) {
+			return true
+		}
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+)
+
+/*
+	Starlark scripts, run with "script run <file> [args...]", to set up
+	test fixtures, patch memory, count events and write reports. Besides
+	the Starlark built-ins a script has:
+
+	argv                          the args, a list of strings
+	regs                          the registers, regs.a, regs.pc = $400 etc.
+	peek(addr), peekw(addr)       reads a byte or word
+	poke(addr, val...)            writes bytes from addr
+	pokew(addr, val)              writes a word
+	expr(text)                    evaluates an expression, as ? does
+	asm(addr, text)               assembles an instruction, returns the next address
+	disasm(addr, count=1)         decodes instructions, a list of structs with
+	                              addr, label, bytes and text
+	step(count=1), run()          execute as x and g do, returning their output
+	command(line)                 runs a command, returning its output
+	breakpoint(addr, hook=None, cond=None)
+	watchpoint(addr, hook=None, cond=None)
+	                              set a breakpoint or watchpoint, calling hook(addr)
+	                              when it is hit and stopping if it returns True
+
+	Addresses and values are ints or expression strings such as "ptr+1".
+	Unlike plain Starlark the script's globals are not frozen once it
+	has run, so hooks can update global lists and dicts.
+*/
+
+// added here as scripts run commands, so commands would refer to itself
+func init() {
+	commands["script"] = commandInfo{"Script:       script run <file> [args...]", reflect.ValueOf(scriptCommand)}
+}
+
+func scriptCommand(ctx core6502.CPUContext, out io.Writer, args []string) error {
+	if len(args) < 2 || args[0] != "run" {
+		return fmt.Errorf("Usage: script run <file> [args...]")
+	}
+	return runScript(ctx, out, args[1], args[2:])
+}
+
+var scriptOptions = &syntax.FileOptions{Set: true, While: true, TopLevelControl: true, GlobalReassign: true, Recursion: true}
+
+func runScript(ctx core6502.CPUContext, out io.Writer, file string, args []string) error {
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	env := scriptEnv{ctx}
+	predeclared := env.globals(args)
+	_, prog, err := starlark.SourceProgramOptions(scriptOptions, file, src, predeclared.Has)
+	if err != nil {
+		return err
+	}
+	_, err = prog.Init(newScriptThread(out), predeclared)
+	return scriptError(out, err)
+}
+
+func newScriptThread(out io.Writer) *starlark.Thread {
+	return &starlark.Thread{Name: "script", Print: func(_ *starlark.Thread, msg string) {
+		fmt.Fprintln(out, msg)
+	}}
+}
+
+// writes the traceback of a script error to out, returning the error message
+func scriptError(out io.Writer, err error) error {
+	var e *starlark.EvalError
+	if errors.As(err, &e) {
+		fmt.Fprint(out, e.CallStack.String())
+		return errors.New(e.Msg)
+	}
+	return err
+}
+
+// a script function called when a breakpoint or watchpoint is hit
+type scriptHook struct {
+	fn starlark.Callable
+}
+
+func (h scriptHook) Hit(ctx core6502.CPUContext, out io.Writer, addr uint16) (bool, error) {
+	v, err := starlark.Call(newScriptThread(out), h.fn, starlark.Tuple{starlark.MakeInt(int(addr))}, nil)
+	if err != nil {
+		return false, scriptError(out, err)
+	}
+	return v == starlark.True, nil
+}
+
+func (h scriptHook) String() string {
+	return h.fn.Name()
+}
+
+// converts v, an int or an expression string, to a value of bitSize bits,
+// negative values are two's complement
+func scriptValue(ctx core6502.CPUContext, v starlark.Value, bitSize uint) (uint64, error) {
+	switch v := v.(type) {
+	case starlark.String:
+		val, rest, err := evalArg(ctx, string(v), bitSize)
+		if err == nil && strings.TrimSpace(rest) != "" {
+			err = fmt.Errorf("Invalid expression: %s", string(v))
+		}
+		return val, err
+	case starlark.Int:
+		i, ok := v.Int64()
+		if !ok || i < -(1<<(bitSize-1)) || i >= 1<<bitSize {
+			return 0, fmt.Errorf("Value out of range: %s", v)
+		}
+		return uint64(i) & (1<<bitSize - 1), nil
+	}
+	return 0, fmt.Errorf("Want an int or expression, got %s", v.Type())
+}
+
+// the registers, as a value with a field for each
+type scriptRegisters struct {
+	ctx core6502.CPUContext
+}
+
+var scriptRegisterNames = []string{"a", "x", "y", "sp", "p", "pc"}
+
+func (r scriptRegisters) String() string        { return "registers" }
+func (r scriptRegisters) Type() string          { return "registers" }
+func (r scriptRegisters) Freeze()               {}
+func (r scriptRegisters) Truth() starlark.Bool  { return true }
+func (r scriptRegisters) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable: registers") }
+func (r scriptRegisters) AttrNames() []string   { return scriptRegisterNames }
+
+func (r scriptRegisters) Attr(name string) (starlark.Value, error) {
+	val, ok := registerValues(r.ctx)[name]
+	if !ok {
+		return nil, nil
+	}
+	return starlark.MakeInt(val), nil
+}
+
+func (r scriptRegisters) SetField(name string, v starlark.Value) error {
+	bits := uint(8)
+	if name == "pc" {
+		bits = 16
+	}
+	val, err := scriptValue(r.ctx, v, bits)
+	if err != nil {
+		return err
+	}
+
+	switch name {
+	case "a":
+		r.ctx.SetRegA(uint8(val))
+	case "x":
+		r.ctx.SetRegX(uint8(val))
+	case "y":
+		r.ctx.SetRegY(uint8(val))
+	case "sp":
+		r.ctx.SetRegSP(uint8(val))
+	case "p":
+		r.ctx.SetFlags(uint8(val))
+	case "pc":
+		r.ctx.SetRegPC(uint16(val))
+	default:
+		return starlark.NoSuchAttrError(fmt.Sprintf("registers has no .%s field", name))
+	}
+	clearJournal()
+	return nil
+}
+
+// the built-in functions a script has, on the CPU context
+type scriptEnv struct {
+	ctx core6502.CPUContext
+}
+
+func (e scriptEnv) globals(args []string) starlark.StringDict {
+	argv := make([]starlark.Value, len(args))
+	for n, arg := range args {
+		argv[n] = starlark.String(arg)
+	}
+
+	builtins := map[string]func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error){
+		"peek": e.peek, "peekw": e.peekw, "poke": e.poke, "pokew": e.pokew,
+		"expr": e.expr, "asm": e.asm, "disasm": e.disasm,
+		"step": e.step, "run": e.run, "command": e.command,
+		"breakpoint": e.breakpoint, "watchpoint": e.watchpoint,
+	}
+	globals := starlark.StringDict{"argv": starlark.NewList(argv), "regs": scriptRegisters{e.ctx}}
+	for name, fn := range builtins {
+		globals[name] = starlark.NewBuiltin(name, fn)
+	}
+	return globals
+}
+
+// unpacks an address and any more args
+func (e scriptEnv) addr(fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple, more ...interface{}) (uint16, error) {
+	var addr starlark.Value
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, append([]interface{}{"addr", &addr}, more...)...); err != nil {
+		return 0, err
+	}
+	val, err := scriptValue(e.ctx, addr, 16)
+	return uint16(val), err
+}
+
+func (e scriptEnv) peek(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	addr, err := e.addr(fn, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.MakeInt(int(e.ctx.Peek(addr))), nil
+}
+
+func (e scriptEnv) peekw(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	addr, err := e.addr(fn, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.MakeInt(int(e.ctx.PeekWord(addr))), nil
+}
+
+func (e scriptEnv) poke(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if len(args) < 2 || len(kwargs) > 0 {
+		return nil, fmt.Errorf("%s: want an address and values", fn.Name())
+	}
+	addr, err := scriptValue(e.ctx, args[0], 16)
+	if err != nil {
+		return nil, err
+	}
+
+	var vals []uint8
+	for _, arg := range args[1:] {
+		val, err := scriptValue(e.ctx, arg, 8)
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, uint8(val))
+	}
+	for n, val := range vals {
+		e.ctx.Poke(uint16(addr)+uint16(n), val)
+	}
+	clearJournal()
+	return starlark.None, nil
+}
+
+func (e scriptEnv) pokew(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var v starlark.Value
+	addr, err := e.addr(fn, args, kwargs, "val", &v)
+	if err != nil {
+		return nil, err
+	}
+	val, err := scriptValue(e.ctx, v, 16)
+	if err != nil {
+		return nil, err
+	}
+	e.ctx.PokeWord(addr, uint16(val))
+	clearJournal()
+	return starlark.None, nil
+}
+
+func (e scriptEnv) expr(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var text string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "text", &text); err != nil {
+		return nil, err
+	}
+	val, err := core6502.EvalExpr(text, exprEnv(e.ctx))
+	if err != nil {
+		return nil, err
+	}
+	return starlark.MakeInt(val), nil
+}
+
+func (e scriptEnv) asm(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var text string
+	addr, err := e.addr(fn, args, kwargs, "text", &text)
+	if err != nil {
+		return nil, err
+	}
+	next, err := core6502.AssembleSymbols(e.ctx, addr, text, symbols)
+	if err != nil {
+		return nil, err
+	}
+	clearJournal()
+	return starlark.MakeInt(int(next)), nil
+}
+
+func (e scriptEnv) disasm(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	count := 1
+	addr, err := e.addr(fn, args, kwargs, "count?", &count)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := disasmOptions()
+	var insts []starlark.Value
+	for _, inst := range decodeFrom(e.ctx, addr, count) {
+		label, _ := opts.Label(inst.Addr)
+		raw := []starlark.Value{starlark.MakeInt(int(inst.Opcode))}
+		for _, b := range inst.Operand {
+			raw = append(raw, starlark.MakeInt(int(b)))
+		}
+		insts = append(insts, starlarkstruct.FromStringDict(starlark.String("instruction"), starlark.StringDict{
+			"addr":  starlark.MakeInt(int(inst.Addr)),
+			"label": starlark.String(strings.TrimSuffix(label, ":")),
+			"bytes": starlark.NewList(raw),
+			"text":  starlark.String(opts.FormatInstruction(&inst)),
+		}))
+	}
+	return starlark.NewList(insts), nil
+}
+
+// runs the command name with args, returning its output
+func (e scriptEnv) runCommand(name, args string) (starlark.Value, error) {
+	var out bytes.Buffer
+	if err := runCommand(e.ctx, name, args, &out); err != nil {
+		return nil, err
+	}
+	return starlark.String(out.String()), nil
+}
+
+func (e scriptEnv) step(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	count := 1
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "count?", &count); err != nil {
+		return nil, err
+	}
+	return e.runCommand("x", fmt.Sprint(count))
+}
+
+func (e scriptEnv) run(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs); err != nil {
+		return nil, err
+	}
+	return e.runCommand("g", "")
+}
+
+func (e scriptEnv) command(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var line string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "line", &line); err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if _, err := execCommand(e.ctx, line, &out); err != nil {
+		return nil, err
+	}
+	return starlark.String(out.String()), nil
+}
+
+// unpacks the args of breakpoint and watchpoint
+func (e scriptEnv) breakArgs(fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (uint16, *core6502.Expr, breakHook, error) {
+	var hook starlark.Value = starlark.None
+	var cond string
+	addr, err := e.addr(fn, args, kwargs, "hook?", &hook, "cond?", &cond)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	var h breakHook
+	if hook != starlark.None {
+		callable, ok := hook.(starlark.Callable)
+		if !ok {
+			return 0, nil, nil, fmt.Errorf("%s: hook is a %s, not callable", fn.Name(), hook.Type())
+		}
+		h = scriptHook{callable}
+	}
+	c, err := parseCondition(cond)
+	return addr, c, h, err
+}
+
+func (e scriptEnv) breakpoint(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	addr, cond, hook, err := e.breakArgs(fn, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	breakpoints[addr] = &breakpoint{addr, cond, hook}
+	return starlark.None, nil
+}
+
+func (e scriptEnv) watchpoint(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	addr, cond, hook, err := e.breakArgs(fn, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	watchpoints[addr] = &watchpoint{addr, cond, hook}
+	return starlark.None, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/simulatedsimian/assert"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testScript = `
+start = int(argv[0])
+hits = []
+
+def on_break(addr):
+    hits.append(("break", addr, regs.x))
+    return regs.x == 3
+
+def on_write(addr):
+    hits.append(("write", addr, peek(addr)))
+
+pc = asm(start, "inx")
+pc = asm(pc, "stx $10")
+asm(pc, "jmp " + str(start))
+regs.pc = start
+breakpoint(start, on_break)
+watchpoint("$10", on_write)
+
+print(run().strip())
+print(hits)
+`
+
+const testHookErrorScript = `
+def bad(addr):
+    fail("bad hook at %d" % addr)
+
+breakpoint("$0400", bad)
+`
+
+func TestScript(t *testing.T) {
+	pack := assert.Pack
+
+	dir, err := ioutil.TempDir("", "script")
+	assert.NoError(t, pack(err))
+	defer os.RemoveAll(dir)
+	defer func() {
+		delete(breakpoints, 0x0400)
+		delete(watchpoints, 0x0010)
+	}()
+
+	ctx := &core6502.BasicCPUContext{}
+	file := filepath.Join(dir, "test.star")
+	assert.NoError(t, pack(ioutil.WriteFile(file, []byte(testScript), 0644)))
+
+	var out bytes.Buffer
+	assert.NoError(t, pack(runCommand(ctx, "script", "run "+file+" 1024", &out)))
+	assert.Equal(t, out.String(), "Breakpoint at $0400\n"+
+		`[("write", 16, 1), ("break", 1024, 1), ("write", 16, 2), ("break", 1024, 2), ("write", 16, 3), ("break", 1024, 3)]`+"\n")
+	assert.Equal(t, ctx.RegPC(), uint16(0x0400))
+
+	// a hook error stops execution and is reported with its traceback
+	assert.NoError(t, pack(ioutil.WriteFile(file, []byte(testHookErrorScript), 0644)))
+	out.Reset()
+	assert.NoError(t, pack(runCommand(ctx, "script", "run "+file, &out)))
+	err = runCommand(ctx, "g", "", &out)
+	assert.Equal(t, fmt.Sprint(err), "fail: bad hook at 1024")
+	assert.Equal(t, strings.Contains(out.String(), "in bad"), true)
+	assert.Equal(t, ctx.RegPC(), uint16(0x0400))
+	assert.Equal(t, ctx.RegX(), uint8(4))
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"io"
+	"os"
+)
+
+/*
+	snap save writes the registers, memory, cycle count and device state
+	to a file in the format described in core6502/snapshot.go, snap load
+	restores it. A NES snapshot only loads with the same ROM.
+*/
+func snapCommand(ctx core6502.CPUContext, out io.Writer, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("Usage: snap save <file> | snap load <file>")
+	}
+	state, ok := ctx.(core6502.Snapshotter)
+	if !ok {
+		return fmt.Errorf("Snapshots are not supported by this machine")
+	}
+
+	switch args[0] {
+	case "save":
+		snap := core6502.NewSnapshot()
+		state.SaveState(snap)
+		cycles := make([]uint8, 8)
+		binary.LittleEndian.PutUint64(cycles, totalCycles)
+		snap.Set("CYCL", cycles)
+
+		f, err := os.Create(args[1])
+		if err != nil {
+			return err
+		}
+		if _, err := snap.WriteTo(f); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Saved snapshot to %s\n", args[1])
+
+	case "load":
+		f, err := os.Open(args[1])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		snap, err := core6502.ReadSnapshot(f)
+		if err != nil {
+			return err
+		}
+		if err := state.LoadState(snap); err != nil {
+			return err
+		}
+		if cycles, err := snap.Need("CYCL", 8); err == nil {
+			totalCycles = binary.LittleEndian.Uint64(cycles)
+		}
+		calls.Clear()
+		clearJournal()
+		fmt.Fprintf(out, "Loaded snapshot from %s, PC: $%04x\n", args[1], ctx.RegPC())
+
+	default:
+		return fmt.Errorf("Unknown snap command: %s", args[0])
+	}
+	return nil
+}
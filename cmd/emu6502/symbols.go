@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"io"
+	"strings"
+)
+
+var symbols = core6502.NewSymbolTable()
+
+// comma separated or repeated -sym flags
+type symFileList []string
+
+func (l *symFileList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *symFileList) Set(s string) error {
+	*l = append(*l, core6502.Split(s, ",")...)
+	return nil
+}
+
+func loadSymbols(filename string, out io.Writer) error {
+	count, err := symbols.LoadFile(filename)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Loaded %d symbols from %s\n", count, filename)
+	return nil
+}
+
+func symCommand(ctx core6502.CPUContext, out io.Writer, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("Usage: sym load <file> | sym list [filter] | sym clear")
+	}
+
+	switch args[0] {
+	case "load":
+		if len(args) != 2 {
+			return fmt.Errorf("Usage: sym load <file>")
+		}
+		return loadSymbols(args[1], out)
+
+	case "list":
+		filter := ""
+		if len(args) > 1 {
+			filter = args[1]
+		}
+		for _, sym := range symbols.Symbols() {
+			if strings.Contains(sym.Name, filter) {
+				fmt.Fprintf(out, "$%04x %s\n", sym.Addr, sym.Name)
+			}
+		}
+
+	case "clear":
+		symbols.Clear()
+
+	default:
+		return fmt.Errorf("Unknown sym command: %s", args[0])
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"io"
+	"os"
+)
+
+// the trace file and its tracer while tracing is on
+var trace struct {
+	file   *os.File
+	tracer *core6502.Tracer
+}
+
+func closeTrace() error {
+	if trace.file == nil {
+		return nil
+	}
+	err := trace.file.Close()
+	trace.file, trace.tracer = nil, nil
+	return err
+}
+
+/*
+	trace on <file> writes a nestest format line to file for every
+	instruction executed by x and g, the cycle count starts at 7 as it
+	does in nestest.log. trace off closes the file.
+*/
+func traceCommand(ctx core6502.CPUContext, out io.Writer, args []string) error {
+	if len(args) == 0 {
+		if trace.file == nil {
+			fmt.Fprintln(out, "Trace off")
+		} else {
+			fmt.Fprintf(out, "Tracing to %s, %d cycles\n", trace.file.Name(), trace.tracer.Cycles)
+		}
+		return nil
+	}
+
+	switch args[0] {
+	case "on":
+		if len(args) != 2 {
+			return fmt.Errorf("Usage: trace on <file>")
+		}
+		if err := closeTrace(); err != nil {
+			return err
+		}
+		f, err := os.Create(args[1])
+		if err != nil {
+			return err
+		}
+		trace.file, trace.tracer = f, core6502.NewTracer(f)
+
+	case "off":
+		return closeTrace()
+
+	default:
+		return fmt.Errorf("Unknown trace command: %s", args[0])
+	}
+	return nil
+}
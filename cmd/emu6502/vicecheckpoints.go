@@ -0,0 +1,251 @@
+package main
+
+import (
+	"github.com/simulatedsimian/emu6502/core6502"
+	"sort"
+)
+
+// the operations a checkpoint triggers on
+const (
+	ViceOp_Load  = 0x01
+	ViceOp_Store = 0x02
+	ViceOp_Exec  = 0x04
+)
+
+// a VICE checkpoint, a breakpoint on execution or a watchpoint on loads and stores over start-end
+type viceCheckpoint struct {
+	num        uint32
+	start, end uint16
+	stop       bool // stop when hit, otherwise only counted
+	enabled    bool
+	op         uint8
+	temporary  bool // deleted when hit
+	hits       uint32
+	cond       *core6502.Expr
+}
+
+func (cp *viceCheckpoint) contains(addr uint16) bool {
+	return addr >= cp.start && addr <= cp.end
+}
+
+// the checkpoint info response body
+func (m *viceMonitor) checkpointInfo(cp *viceCheckpoint) viceWriter {
+	var w viceWriter
+	w.u32(cp.num)
+	w.u8(uint8(btoi(cp == m.lastHit)))
+	w.u16(cp.start)
+	w.u16(cp.end)
+	w.u8(uint8(btoi(cp.stop)))
+	w.u8(uint8(btoi(cp.enabled)))
+	w.u8(cp.op)
+	w.u8(uint8(btoi(cp.temporary)))
+	w.u32(cp.hits)
+	w.u32(0) // ignore count
+	w.u8(uint8(btoi(cp.cond != nil)))
+	w.u8(0) // main memory
+	return w
+}
+
+func (m *viceMonitor) checkpoint(r *viceReader) (*viceCheckpoint, error) {
+	num := r.u32()
+	if r.err != nil {
+		return nil, r.err
+	}
+	cp, ok := m.checkpoints[num]
+	if !ok {
+		return nil, viceErrorf(ViceErr_NotFound, "No checkpoint %d", num)
+	}
+	return cp, nil
+}
+
+func (m *viceMonitor) handleCheckpoint(id uint32, cmd uint8, r *viceReader) (uint8, viceWriter, error) {
+	switch cmd {
+	case ViceCmd_CheckpointGet:
+		cp, err := m.checkpoint(r)
+		if err != nil {
+			return 0, nil, err
+		}
+		return ViceCmd_CheckpointGet, m.checkpointInfo(cp), nil
+
+	case ViceCmd_CheckpointSet:
+		cp := &viceCheckpoint{num: m.nextCheckpoint}
+		cp.start, cp.end = r.u16(), r.u16()
+		cp.stop, cp.enabled = r.u8() != 0, r.u8() != 0
+		cp.op, cp.temporary = r.u8(), r.u8() != 0
+		if len(r.data) > 0 && r.u8() != 0 {
+			return 0, nil, viceErrorf(ViceErr_InvalidMemspace, "Only main memory is supported")
+		}
+		if r.err != nil {
+			return 0, nil, r.err
+		}
+		if cp.end < cp.start || cp.op&(ViceOp_Load|ViceOp_Store|ViceOp_Exec) == 0 {
+			return 0, nil, viceErrorf(ViceErr_Parameter, "Invalid checkpoint")
+		}
+		m.nextCheckpoint++
+		m.checkpoints[cp.num] = cp
+		return ViceCmd_CheckpointGet, m.checkpointInfo(cp), nil
+
+	case ViceCmd_CheckpointDelete:
+		cp, err := m.checkpoint(r)
+		if err != nil {
+			return 0, nil, err
+		}
+		delete(m.checkpoints, cp.num)
+
+	case ViceCmd_CheckpointList:
+		var nums []uint32
+		for num := range m.checkpoints {
+			nums = append(nums, num)
+		}
+		sort.Slice(nums, func(i, j int) bool { return nums[i] < nums[j] })
+		for _, num := range nums {
+			m.send(ViceCmd_CheckpointGet, ViceErr_OK, id, m.checkpointInfo(m.checkpoints[num]))
+		}
+		var w viceWriter
+		w.u32(uint32(len(nums)))
+		return ViceCmd_CheckpointList, w, nil
+
+	case ViceCmd_CheckpointToggle:
+		cp, err := m.checkpoint(r)
+		enabled := r.u8() != 0
+		if err == nil {
+			err = r.err
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		cp.enabled = enabled
+
+	case ViceCmd_ConditionSet:
+		cp, err := m.checkpoint(r)
+		if err != nil {
+			return 0, nil, err
+		}
+		text := string(r.bytes(int(r.u8())))
+		if r.err != nil {
+			return 0, nil, r.err
+		}
+		cond, err := core6502.ParseExpr(text)
+		if err != nil {
+			return 0, nil, viceErrorf(ViceErr_Parameter, "%v", err)
+		}
+		cp.cond = cond
+	}
+	return cmd, nil, nil
+}
+
+// counts a hit on each enabled checkpoint for op at any of addrs whose
+// condition holds, returning the first that stops
+func (m *viceMonitor) hit(op uint8, addrs ...uint16) *viceCheckpoint {
+	var nums []uint32
+	for num, cp := range m.checkpoints {
+		if cp.enabled && cp.op&op != 0 {
+			nums = append(nums, num)
+		}
+	}
+	sort.Slice(nums, func(i, j int) bool { return nums[i] < nums[j] })
+
+	var stop *viceCheckpoint
+	for _, num := range nums {
+		cp := m.checkpoints[num]
+		for _, addr := range addrs {
+			if !cp.contains(addr) {
+				continue
+			}
+			if hit, err := condTrue(m.ctx, cp.cond); err != nil || !hit {
+				continue
+			}
+			cp.hits++
+			if cp.temporary {
+				delete(m.checkpoints, num)
+			}
+			if cp.stop && stop == nil {
+				stop = cp
+			}
+			break
+		}
+	}
+	return stop
+}
+
+// stops on cp, sending its info then the stopped event
+func (m *viceMonitor) checkpointStop(cp *viceCheckpoint) {
+	m.lastHit = cp
+	m.event(ViceCmd_CheckpointGet, m.checkpointInfo(cp))
+	m.pcEvent(ViceEvent_Stopped)
+}
+
+/*
+	Executes until a checkpoint stops it, done returns true after an
+	instruction, an invalid instruction jams the CPU or a request stops
+	it, then sends the stopped or jam event. An exec checkpoint at the
+	starting PC is ignored so execution can resume from it; load and
+	store checkpoints stop after the instruction.
+*/
+func (m *viceMonitor) runUntil(done func(opcode uint8) bool) {
+	m.lastHit = nil
+
+	event := func() { m.pcEvent(ViceEvent_Stopped) }
+	m.loop(func(first bool) bool {
+		if !first {
+			if cp := m.hit(ViceOp_Exec, m.ctx.RegPC()); cp != nil {
+				event = func() { m.checkpointStop(cp) }
+				return true
+			}
+		}
+
+		pc := m.ctx.RegPC()
+		inst := core6502.Decode(m.ctx, pc)
+		var loads, stores []uint16
+		actx := &core6502.AccessContext{
+			CPUContext: m.ctx,
+			PC:         pc,
+			Length:     inst.Length,
+			Load:       func(addr uint16) { loads = append(loads, addr) },
+			Store:      func(addr uint16) { stores = append(stores, addr) },
+		}
+		if _, err := executeWith(m.ctx, actx); err != nil {
+			event = func() { m.pcEvent(ViceEvent_Jam) }
+			return true
+		}
+
+		cp := m.hit(ViceOp_Load, loads...)
+		if cp == nil {
+			cp = m.hit(ViceOp_Store, stores...)
+		}
+		if cp != nil {
+			event = func() { m.checkpointStop(cp) }
+			return true
+		}
+		return done != nil && done(inst.Opcode)
+	})
+	event()
+}
+
+// sets step to end after count instructions, counting a JSR and the
+// subroutine as one if stepOver
+func (m *viceMonitor) prepareStep(stepOver bool, count int) {
+	calls.Sync(m.ctx)
+	depth := len(calls.Frames)
+	m.step = func(opcode uint8) bool {
+		if stepOver && len(calls.Frames) > depth {
+			return false
+		}
+		depth = len(calls.Frames)
+		count--
+		return count <= 0
+	}
+}
+
+// sets step to end when the current subroutine returns, or after the next
+// RTS or RTI if there is no call on the shadow call stack
+func (m *viceMonitor) prepareReturn() {
+	calls.Sync(m.ctx)
+	depth := len(calls.Frames)
+	m.step = func(opcode uint8) bool {
+		if depth == 0 {
+			return opcode == 0x60 || opcode == 0x40
+		}
+		return len(calls.Frames) < depth
+	}
+}
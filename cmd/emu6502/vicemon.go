@@ -0,0 +1,446 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"io"
+	"net"
+	"os"
+	"sync"
+)
+
+/*
+	A subset of the VICE binary remote monitor protocol (VICE 3.5+, API
+	versions 1 and 2), so tools written for VICE can drive the emulator.
+	Requests and responses are little endian:
+
+	request:  $02 version length:4 id:4 command body[length]
+	response: $02 version length:4 type error id:4 body[length]
+
+	Events are responses with id $ffffffff. The CPU starts stopped, exit
+	runs it until a checkpoint, and any request stops it again, as the
+	VICE monitor does. Only main memory and the CPU registers are
+	supported.
+*/
+
+const (
+	vice_STX     = 0x02
+	vice_Version = 0x02
+	vice_EventID = 0xffffffff
+)
+
+// command and response types
+const (
+	ViceCmd_MemoryGet           = 0x01
+	ViceCmd_MemorySet           = 0x02
+	ViceCmd_CheckpointGet       = 0x11
+	ViceCmd_CheckpointSet       = 0x12
+	ViceCmd_CheckpointDelete    = 0x13
+	ViceCmd_CheckpointList      = 0x14
+	ViceCmd_CheckpointToggle    = 0x15
+	ViceCmd_ConditionSet        = 0x22
+	ViceCmd_RegistersGet        = 0x31
+	ViceCmd_RegistersSet        = 0x32
+	ViceCmd_AdvanceInstructions = 0x71
+	ViceCmd_ExecuteUntilReturn  = 0x73
+	ViceCmd_Ping                = 0x81
+	ViceCmd_BanksAvailable      = 0x82
+	ViceCmd_RegistersAvailable  = 0x83
+	ViceCmd_Info                = 0x85
+	ViceCmd_Exit                = 0xaa
+	ViceCmd_Quit                = 0xbb
+	ViceCmd_Reset               = 0xcc
+
+	ViceEvent_Jam     = 0x61
+	ViceEvent_Stopped = 0x62
+	ViceEvent_Resumed = 0x63
+)
+
+// response error codes
+const (
+	ViceErr_OK              = 0x00
+	ViceErr_NotFound        = 0x01
+	ViceErr_InvalidMemspace = 0x02
+	ViceErr_Length          = 0x80
+	ViceErr_Parameter       = 0x81
+	ViceErr_Version         = 0x82
+	ViceErr_Command         = 0x83
+	ViceErr_Failed          = 0x8f
+)
+
+// register ids, as VICE numbers them for the 6502
+var viceRegisters = []struct {
+	id   uint8
+	name string
+	bits uint8
+}{
+	{0x00, "A", 8}, {0x01, "X", 8}, {0x02, "Y", 8}, {0x03, "PC", 16}, {0x04, "SP", 8}, {0x05, "FL", 8},
+}
+
+// an error response, its error code and a message for the log
+type viceError struct {
+	code uint8
+	msg  string
+}
+
+func (e *viceError) Error() string {
+	return e.msg
+}
+
+func viceErrorf(code uint8, format string, args ...interface{}) error {
+	return &viceError{code, fmt.Sprintf(format, args...)}
+}
+
+// a request body, reading past its end sets err
+type viceReader struct {
+	data []byte
+	err  error
+}
+
+func (r *viceReader) bytes(n int) []byte {
+	if r.err != nil || len(r.data) < n {
+		r.err = viceErrorf(ViceErr_Length, "Request too short")
+		return make([]byte, n)
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *viceReader) u8() uint8 {
+	return r.bytes(1)[0]
+}
+
+func (r *viceReader) u16() uint16 {
+	return binary.LittleEndian.Uint16(r.bytes(2))
+}
+
+func (r *viceReader) u32() uint32 {
+	return binary.LittleEndian.Uint32(r.bytes(4))
+}
+
+// a response body
+type viceWriter []byte
+
+func (w *viceWriter) u8(v uint8) {
+	*w = append(*w, v)
+}
+
+func (w *viceWriter) u16(v uint16) {
+	*w = append(*w, uint8(v), uint8(v>>8))
+}
+
+func (w *viceWriter) u32(v uint32) {
+	*w = append(*w, uint8(v), uint8(v>>8), uint8(v>>16), uint8(v>>24))
+}
+
+/*
+	The monitor state shared by each connection in turn. The CPU runs in
+	the background, as in the DAP server.
+*/
+type viceMonitor struct {
+	wmu sync.Mutex // serialises writes to out
+	out io.Writer  // the current connection, nil between connections
+
+	cpuRunner
+	ctx core6502.CPUContext
+
+	checkpoints    map[uint32]*viceCheckpoint
+	nextCheckpoint uint32
+	lastHit        *viceCheckpoint         // the checkpoint that last stopped the CPU
+	step           func(opcode uint8) bool // ends an advance or execute until return
+	quit           bool
+}
+
+func newViceMonitor(ctx core6502.CPUContext) *viceMonitor {
+	return &viceMonitor{ctx: ctx, checkpoints: map[uint32]*viceCheckpoint{}, nextCheckpoint: 1}
+}
+
+func (m *viceMonitor) send(typ, errCode uint8, id uint32, body []byte) {
+	m.wmu.Lock()
+	defer m.wmu.Unlock()
+	if m.out == nil {
+		return
+	}
+
+	header := viceWriter{vice_STX, vice_Version}
+	header.u32(uint32(len(body)))
+	header.u8(typ)
+	header.u8(errCode)
+	header.u32(id)
+	m.out.Write(append(header, body...))
+}
+
+func (m *viceMonitor) event(typ uint8, body []byte) {
+	m.send(typ, ViceErr_OK, vice_EventID, body)
+}
+
+func (m *viceMonitor) pcEvent(typ uint8) {
+	var body viceWriter
+	body.u16(m.ctx.RegPC())
+	m.event(typ, body)
+}
+
+// reads a request, returning its id, command and body
+func readViceRequest(r io.Reader) (uint32, uint8, []byte, error) {
+	header := make([]byte, 11)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, 0, nil, err
+	}
+	if header[0] != vice_STX {
+		return 0, 0, nil, fmt.Errorf("Invalid VICE monitor request, no STX")
+	}
+
+	body := make([]byte, binary.LittleEndian.Uint32(header[2:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, 0, nil, err
+	}
+	id := binary.LittleEndian.Uint32(header[6:])
+	if header[1] < 1 || header[1] > vice_Version {
+		return id, header[10], nil, viceErrorf(ViceErr_Version, "Unsupported API version %d", header[1])
+	}
+	return id, header[10], body, nil
+}
+
+// handles requests on a connection until it closes or quit
+func (m *viceMonitor) serve(conn io.ReadWriter) error {
+	m.wmu.Lock()
+	m.out = conn
+	m.wmu.Unlock()
+	defer func() {
+		m.wmu.Lock()
+		m.out = nil
+		m.wmu.Unlock()
+	}()
+
+	for !m.quit {
+		id, cmd, body, err := readViceRequest(conn)
+		if e, ok := err.(*viceError); ok {
+			m.send(cmd, e.code, id, nil)
+			continue
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		m.stop()
+
+		m.cpu.Lock()
+		typ, resp, err := m.handle(id, cmd, &viceReader{data: body})
+		m.cpu.Unlock()
+		if e, ok := err.(*viceError); ok {
+			m.send(cmd, e.code, id, nil)
+			continue
+		}
+		m.send(typ, ViceErr_OK, id, resp)
+
+		switch cmd {
+		case ViceCmd_Exit:
+			m.resume(nil)
+		case ViceCmd_AdvanceInstructions, ViceCmd_ExecuteUntilReturn:
+			m.resume(m.step)
+		}
+	}
+	return nil
+}
+
+// runs in the background until done, a checkpoint or stop
+func (m *viceMonitor) resume(done func(opcode uint8) bool) {
+	m.runInBackground(func() {
+		m.pcEvent(ViceEvent_Resumed)
+		m.runUntil(done)
+	})
+}
+
+// handles a request, returning the response type, usually cmd, and body
+func (m *viceMonitor) handle(id uint32, cmd uint8, r *viceReader) (uint8, viceWriter, error) {
+	typ, resp := cmd, &viceWriter{}
+
+	switch cmd {
+	case ViceCmd_MemoryGet, ViceCmd_MemorySet:
+		r.u8() // side effects, the memory map has none that can be avoided
+		start, end := r.u16(), r.u16()
+		memspace := r.u8()
+		r.u16() // bank
+		if r.err != nil {
+			return 0, nil, r.err
+		}
+		if memspace != 0 {
+			return 0, nil, viceErrorf(ViceErr_InvalidMemspace, "Only main memory is supported")
+		}
+		if end < start {
+			return 0, nil, viceErrorf(ViceErr_Parameter, "End address is before the start")
+		}
+
+		if cmd == ViceCmd_MemoryGet {
+			resp.u16(end - start + 1)
+			for addr := int(start); addr <= int(end); addr++ {
+				resp.u8(m.ctx.Peek(uint16(addr)))
+			}
+			return typ, *resp, nil
+		}
+		data := r.bytes(int(end) - int(start) + 1)
+		if r.err != nil {
+			return 0, nil, r.err
+		}
+		for n, b := range data {
+			m.ctx.Poke(start+uint16(n), b)
+		}
+		clearJournal()
+
+	case ViceCmd_CheckpointGet, ViceCmd_CheckpointSet, ViceCmd_CheckpointDelete,
+		ViceCmd_CheckpointList, ViceCmd_CheckpointToggle, ViceCmd_ConditionSet:
+		return m.handleCheckpoint(id, cmd, r)
+
+	case ViceCmd_RegistersGet:
+		if r.u8() != 0 {
+			return 0, nil, viceErrorf(ViceErr_InvalidMemspace, "Only main memory is supported")
+		}
+		m.registers(resp)
+
+	case ViceCmd_RegistersSet:
+		memspace := r.u8()
+		count := int(r.u16())
+		for n := 0; n < count && r.err == nil; n++ {
+			size := int(r.u8())
+			item := &viceReader{data: r.bytes(size)}
+			regID, val := item.u8(), item.u16()
+			if r.err != nil || item.err != nil {
+				break
+			}
+			if memspace != 0 {
+				return 0, nil, viceErrorf(ViceErr_InvalidMemspace, "Only main memory is supported")
+			}
+			if err := m.setRegister(regID, val); err != nil {
+				return 0, nil, err
+			}
+		}
+		if r.err != nil {
+			return 0, nil, r.err
+		}
+		clearJournal()
+		typ = ViceCmd_RegistersGet
+		m.registers(resp)
+
+	case ViceCmd_AdvanceInstructions:
+		stepOver := r.u8() != 0
+		count := r.u16()
+		if r.err != nil {
+			return 0, nil, r.err
+		}
+		m.prepareStep(stepOver, int(count))
+
+	case ViceCmd_ExecuteUntilReturn:
+		m.prepareReturn()
+
+	case ViceCmd_Ping, ViceCmd_Exit:
+
+	case ViceCmd_BanksAvailable:
+		resp.u16(1)
+		resp.u8(uint8(2 + 1 + len("cpu")))
+		resp.u16(0)
+		resp.u8(uint8(len("cpu")))
+		*resp = append(*resp, "cpu"...)
+
+	case ViceCmd_RegistersAvailable:
+		if r.u8() != 0 {
+			return 0, nil, viceErrorf(ViceErr_InvalidMemspace, "Only main memory is supported")
+		}
+		resp.u16(uint16(len(viceRegisters)))
+		for _, reg := range viceRegisters {
+			resp.u8(uint8(3 + len(reg.name)))
+			resp.u8(reg.id)
+			resp.u8(reg.bits)
+			resp.u8(uint8(len(reg.name)))
+			*resp = append(*resp, reg.name...)
+		}
+
+	case ViceCmd_Info:
+		*resp = append(*resp, 4, 3, 6, 0, 0, 4, 0, 0, 0, 0) // version 3.6.0.0, no svn revision
+
+	case ViceCmd_Quit:
+		m.quit = true
+
+	case ViceCmd_Reset:
+		switch r.u8() {
+		case 0:
+			softReset(m.ctx)
+		case 1:
+			hardReset(m.ctx, m.ctx.PeekWord(core6502.Vector_RST))
+		default:
+			return 0, nil, viceErrorf(ViceErr_Parameter, "Only soft and hard resets are supported")
+		}
+		calls.Clear()
+
+	default:
+		return 0, nil, viceErrorf(ViceErr_Command, "Unsupported command $%02x", cmd)
+	}
+	return typ, *resp, nil
+}
+
+func (m *viceMonitor) registers(w *viceWriter) {
+	ctx := m.ctx
+	values := map[uint8]uint16{
+		0x00: uint16(ctx.RegA()), 0x01: uint16(ctx.RegX()), 0x02: uint16(ctx.RegY()),
+		0x03: ctx.RegPC(), 0x04: uint16(ctx.RegSP()), 0x05: uint16(ctx.Flags()),
+	}
+	w.u16(uint16(len(viceRegisters)))
+	for _, reg := range viceRegisters {
+		w.u8(3)
+		w.u8(reg.id)
+		w.u16(values[reg.id])
+	}
+}
+
+func (m *viceMonitor) setRegister(id uint8, val uint16) error {
+	ctx := m.ctx
+	switch id {
+	case 0x00:
+		ctx.SetRegA(uint8(val))
+	case 0x01:
+		ctx.SetRegX(uint8(val))
+	case 0x02:
+		ctx.SetRegY(uint8(val))
+	case 0x03:
+		ctx.SetRegPC(val)
+	case 0x04:
+		ctx.SetRegSP(uint8(val))
+	case 0x05:
+		ctx.SetFlags(uint8(val))
+	default:
+		return viceErrorf(ViceErr_NotFound, "Unknown register $%02x", id)
+	}
+	return nil
+}
+
+/*
+	Serves the VICE binary monitor protocol on each connection to the TCP
+	address listen in turn, until a quit command. VICE's own default is
+	localhost:6502.
+*/
+func serveViceMonitor(ctx core6502.CPUContext, listen string) error {
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	fmt.Fprintf(os.Stderr, "VICE binary monitor listening on %s\n", l.Addr())
+
+	m := newViceMonitor(ctx)
+	for !m.quit {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		if err := m.serve(conn); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		conn.Close()
+	}
+	m.stop()
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/binary"
+	"github.com/simulatedsimian/assert"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"io"
+	"net"
+	"testing"
+)
+
+type viceResponse struct {
+	typ, err uint8
+	event    bool // sent with the event id rather than the request's
+	body     []byte
+}
+
+func readViceResponse(r io.Reader) (viceResponse, uint32, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return viceResponse{}, 0, err
+	}
+	body := make([]byte, binary.LittleEndian.Uint32(header[2:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return viceResponse{}, 0, err
+	}
+	id := binary.LittleEndian.Uint32(header[8:])
+	return viceResponse{typ: header[6], err: header[7], event: id == vice_EventID, body: body}, id, nil
+}
+
+func viceRequest(id uint32, cmd uint8, body []byte) []byte {
+	req := viceWriter{vice_STX, vice_Version}
+	req.u32(uint32(len(body)))
+	req.u32(id)
+	req.u8(cmd)
+	return append(req, body...)
+}
+
+func viceRegs(a, x, y uint8, pc uint16, sp, fl uint8) []byte {
+	var w viceWriter
+	w.u16(6)
+	for n, val := range []uint16{uint16(a), uint16(x), uint16(y), pc, uint16(sp), uint16(fl)} {
+		w.u8(3)
+		w.u8(uint8(n))
+		w.u16(val)
+	}
+	return w
+}
+
+func viceCheckpointInfo(num uint32, lastHit bool, start, end uint16, op uint8, hits uint32, cond bool) []byte {
+	m := newViceMonitor(nil)
+	cp := &viceCheckpoint{num: num, start: start, end: end, stop: true, enabled: true, op: op, hits: hits}
+	if lastHit {
+		m.lastHit = cp
+	}
+	if cond {
+		cp.cond = &core6502.Expr{}
+	}
+	return m.checkpointInfo(cp)
+}
+
+func viceWord(addr uint16) []byte {
+	return []byte{uint8(addr), uint8(addr >> 8)}
+}
+
+func TestViceMonitor(t *testing.T) {
+	pack := assert.Pack
+
+	ctx := &core6502.BasicCPUContext{}
+	ctx.SetRegSP(0xff)
+	m := newViceMonitor(ctx)
+
+	server, client := net.Pipe()
+	defer client.Close()
+	done := make(chan error)
+	go func() {
+		done <- m.serve(server)
+		server.Close()
+	}()
+
+	ok := func(typ uint8, body []byte) []viceResponse {
+		return []viceResponse{{typ: typ, body: body}}
+	}
+	fail := func(typ, err uint8) []viceResponse {
+		return []viceResponse{{typ: typ, err: err, body: []byte{}}}
+	}
+	empty := []byte{}
+
+	tests := []struct {
+		cmd   uint8
+		body  []byte
+		resps []viceResponse
+	}{
+		// lda #$42, brk
+		{ViceCmd_MemorySet, []byte{1, 0x00, 0x10, 0x02, 0x10, 0, 0, 0, 0xa9, 0x42, 0x00}, ok(ViceCmd_MemorySet, empty)},
+		{ViceCmd_MemoryGet, []byte{0, 0x00, 0x10, 0x02, 0x10, 0, 0, 0}, ok(ViceCmd_MemoryGet, []byte{3, 0, 0xa9, 0x42, 0x00})},
+		{ViceCmd_MemoryGet, []byte{0, 0x00, 0x10, 0x02, 0x10, 1, 0, 0}, fail(ViceCmd_MemoryGet, ViceErr_InvalidMemspace)},
+		{ViceCmd_MemoryGet, []byte{0, 0x02, 0x10, 0x00, 0x10, 0, 0, 0}, fail(ViceCmd_MemoryGet, ViceErr_Parameter)},
+		{ViceCmd_MemorySet, []byte{0, 0x00, 0x10, 0x02, 0x10, 0, 0, 0, 0xa9}, fail(ViceCmd_MemorySet, ViceErr_Length)},
+
+		{ViceCmd_RegistersSet, []byte{0, 2, 0, 3, 0x03, 0x00, 0x10, 3, 0x00, 0x11, 0x00}, ok(ViceCmd_RegistersGet, viceRegs(0x11, 0, 0, 0x1000, 0xff, 0))},
+		{ViceCmd_RegistersGet, []byte{0}, ok(ViceCmd_RegistersGet, viceRegs(0x11, 0, 0, 0x1000, 0xff, 0))},
+		{ViceCmd_RegistersSet, []byte{0, 1, 0, 3, 0x09, 0x00, 0x00}, fail(ViceCmd_RegistersSet, ViceErr_NotFound)},
+
+		{ViceCmd_CheckpointSet, []byte{0x02, 0x10, 0x02, 0x10, 1, 1, ViceOp_Exec, 0}, ok(ViceCmd_CheckpointGet, viceCheckpointInfo(1, false, 0x1002, 0x1002, ViceOp_Exec, 0, false))},
+		{ViceCmd_CheckpointSet, []byte{0x00, 0x20, 0xff, 0x20, 1, 1, ViceOp_Store, 0}, ok(ViceCmd_CheckpointGet, viceCheckpointInfo(2, false, 0x2000, 0x20ff, ViceOp_Store, 0, false))},
+		{ViceCmd_CheckpointSet, []byte{0x00, 0x20, 0xff, 0x20, 1, 1, 0, 0}, fail(ViceCmd_CheckpointSet, ViceErr_Parameter)},
+		{ViceCmd_ConditionSet, append([]byte{1, 0, 0, 0, 8}, "a == $42"...), ok(ViceCmd_ConditionSet, empty)},
+		{ViceCmd_ConditionSet, append([]byte{9, 0, 0, 0, 8}, "a == $42"...), fail(ViceCmd_ConditionSet, ViceErr_NotFound)},
+		{ViceCmd_CheckpointList, nil, []viceResponse{
+			{typ: ViceCmd_CheckpointGet, body: viceCheckpointInfo(1, false, 0x1002, 0x1002, ViceOp_Exec, 0, true)},
+			{typ: ViceCmd_CheckpointGet, body: viceCheckpointInfo(2, false, 0x2000, 0x20ff, ViceOp_Store, 0, false)},
+			{typ: ViceCmd_CheckpointList, body: []byte{2, 0, 0, 0}},
+		}},
+		{ViceCmd_CheckpointDelete, []byte{2, 0, 0, 0}, ok(ViceCmd_CheckpointDelete, empty)},
+		{ViceCmd_CheckpointDelete, []byte{2, 0, 0, 0}, fail(ViceCmd_CheckpointDelete, ViceErr_NotFound)},
+		{ViceCmd_CheckpointList, nil, []viceResponse{
+			{typ: ViceCmd_CheckpointGet, body: viceCheckpointInfo(1, false, 0x1002, 0x1002, ViceOp_Exec, 0, true)},
+			{typ: ViceCmd_CheckpointList, body: []byte{1, 0, 0, 0}},
+		}},
+
+		// runs lda #$42 then stops on the exec checkpoint, whose condition holds
+		{ViceCmd_Exit, nil, []viceResponse{
+			{typ: ViceCmd_Exit, body: empty},
+			{typ: ViceEvent_Resumed, event: true, body: viceWord(0x1000)},
+			{typ: ViceCmd_CheckpointGet, event: true, body: viceCheckpointInfo(1, true, 0x1002, 0x1002, ViceOp_Exec, 1, true)},
+			{typ: ViceEvent_Stopped, event: true, body: viceWord(0x1002)},
+		}},
+		{ViceCmd_RegistersGet, []byte{0}, ok(ViceCmd_RegistersGet, viceRegs(0x42, 0, 0, 0x1002, 0xff, 0))},
+
+		// an invalid opcode jams the CPU
+		{ViceCmd_CheckpointDelete, []byte{1, 0, 0, 0}, ok(ViceCmd_CheckpointDelete, empty)},
+		{ViceCmd_MemorySet, []byte{0, 0x02, 0x10, 0x02, 0x10, 0, 0, 0, 0x02}, ok(ViceCmd_MemorySet, empty)},
+		{ViceCmd_Exit, nil, []viceResponse{
+			{typ: ViceCmd_Exit, body: empty},
+			{typ: ViceEvent_Resumed, event: true, body: viceWord(0x1002)},
+			{typ: ViceEvent_Jam, event: true, body: viceWord(0x1002)},
+		}},
+
+		{ViceCmd_Quit, nil, ok(ViceCmd_Quit, empty)},
+	}
+
+	for n, test := range tests {
+		id := uint32(n + 1)
+		_, err := client.Write(viceRequest(id, test.cmd, test.body))
+		assert.NoError(t, pack(err))
+
+		for _, want := range test.resps {
+			resp, respID, err := readViceResponse(client)
+			assert.NoError(t, pack(err))
+			if !want.event {
+				assert.Equal(t, respID, id)
+			}
+			assert.Equal(t, resp, want)
+		}
+	}
+	assert.NoError(t, pack(<-done))
+}
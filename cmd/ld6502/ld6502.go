@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/simulatedsimian/emu6502/obj6502"
+	"io/ioutil"
+	"os"
+)
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+func main() {
+	config := flag.String("C", "", "read the memory layout from `file`, default ZP at $0002 and RAM at $0400")
+	output := flag.String("o", "a.bin", "write the binary to `file`")
+	mapFile := flag.String("m", "", "write a map of segments and exported symbols to `file`, which emu6502 -sym loads")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] object.o ...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg := obj6502.DefaultConfig()
+	if *config != "" {
+		var err error
+		if cfg, err = obj6502.LoadConfig(*config); err != nil {
+			fail(err)
+		}
+	}
+
+	var objs []*obj6502.Object
+	for _, name := range flag.Args() {
+		obj, err := obj6502.ReadFile(name)
+		if err != nil {
+			fail(err)
+		}
+		objs = append(objs, obj)
+	}
+
+	prog, err := obj6502.Link(cfg, objs)
+	if err != nil {
+		fail(err)
+	}
+
+	if err := ioutil.WriteFile(*output, prog.Data, 0644); err != nil {
+		fail(err)
+	}
+
+	if *mapFile != "" {
+		var m bytes.Buffer
+		prog.WriteMap(&m)
+		if err := ioutil.WriteFile(*mapFile, m.Bytes(), 0644); err != nil {
+			fail(err)
+		}
+	}
+
+	if len(prog.Data) > 0 {
+		fmt.Printf("%s: %d bytes at $%04x-$%04x, load with emu6502 -load '%s@$%04x'\n",
+			*output, len(prog.Data), prog.Start, prog.Start+len(prog.Data)-1, *output, prog.Start)
+	} else {
+		fmt.Printf("%s: no output\n", *output)
+	}
+}
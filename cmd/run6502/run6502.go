@@ -0,0 +1,428 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"github.com/simulatedsimian/emu6502/cov6502"
+	"github.com/simulatedsimian/emu6502/image6502"
+	"github.com/simulatedsimian/emu6502/nes6502"
+	"github.com/simulatedsimian/emu6502/sim6502"
+	"io"
+	"os"
+	"strings"
+)
+
+// why a run stopped, each has its own exit code
+type stopReason int
+
+const (
+	Stop_Trap          stopReason = iota // the PC reached a -trap address
+	Stop_BRK                             // a BRK instruction is next
+	Stop_SelfLoop                        // an instruction left the PC unchanged, e.g. JMP *
+	Stop_InvalidOpcode                   // an opcode the executor does not implement is next
+	Stop_CycleLimit                      // -cycles were executed
+	Stop_Exit                            // a sim65 program called exit
+	Stop_Unloaded                        // a BRK is next in memory the image didn't load, the program went astray
+)
+
+func (r stopReason) String() string {
+	switch r {
+	case Stop_Trap:
+		return "Trap"
+	case Stop_BRK:
+		return "BRK"
+	case Stop_SelfLoop:
+		return "Self loop"
+	case Stop_InvalidOpcode:
+		return "Invalid opcode"
+	case Stop_CycleLimit:
+		return "Cycle limit"
+	case Stop_Exit:
+		return "Exit"
+	case Stop_Unloaded:
+		return "BRK in unloaded memory"
+	}
+	return "Unknown"
+}
+
+/*
+	0 for a trap or a BRK in the image, the usual ends of a test program.
+	Exit codes 1 and 2 are taken by errors and bad usage. A sim65 program's exit code
+	is passed on instead.
+*/
+func (r stopReason) exitCode() int {
+	switch r {
+	case Stop_Trap, Stop_BRK:
+		return 0
+	case Stop_SelfLoop:
+		return 3
+	case Stop_InvalidOpcode:
+		return 4
+	case Stop_Unloaded:
+		return 6
+	}
+	return 5
+}
+
+type runner struct {
+	ctx          core6502.CPUContext
+	traps        map[uint16]bool
+	limit        uint64
+	cycles       uint64
+	instructions uint64
+	tracer       *core6502.Tracer
+	host         *sim6502.Host     // sim65 host calls, if running a sim65 program
+	loaded       []addrRange       // the memory the image loaded, nil if all of it is
+	coverage     *cov6502.Coverage // if recording coverage
+	err          error             // the invalid opcode
+}
+
+func (r *runner) execute() (int, error) {
+	if r.tracer != nil {
+		r.tracer.Trace(r.ctx)
+	}
+
+	var cycles int
+	var err error
+	if r.coverage != nil {
+		cycles, err = r.coverage.Execute(r.ctx)
+	} else {
+		cycles, err = core6502.Execute(r.ctx)
+	}
+
+	if r.tracer != nil {
+		r.tracer.Cycles += uint64(cycles)
+	}
+	return cycles, err
+}
+
+func (r *runner) isLoaded(addr uint16) bool {
+	if r.loaded == nil {
+		return true
+	}
+	for _, l := range r.loaded {
+		if addr >= l.start && addr <= l.end {
+			return true
+		}
+	}
+	return false
+}
+
+// executes instructions until one of the stop conditions
+func (r *runner) run() stopReason {
+	for {
+		if r.host != nil && r.host.Call(r.ctx) {
+			if r.host.Exited {
+				return Stop_Exit
+			}
+			continue
+		}
+
+		pc := r.ctx.RegPC()
+		switch {
+		case r.traps[pc]:
+			return Stop_Trap
+		case r.ctx.Peek(pc) == 0x00 && !r.isLoaded(pc):
+			return Stop_Unloaded
+		case r.ctx.Peek(pc) == 0x00:
+			return Stop_BRK
+		case r.cycles >= r.limit:
+			return Stop_CycleLimit
+		}
+
+		cycles, err := r.execute()
+		if err != nil {
+			r.err = err
+			return Stop_InvalidOpcode
+		}
+		r.cycles += uint64(cycles)
+		r.instructions++
+
+		if r.ctx.RegPC() == pc {
+			return Stop_SelfLoop
+		}
+	}
+}
+
+// flags as letters, upper case when set
+func flagString(ctx core6502.CPUContext) string {
+	s := ""
+	for n, c := range "NV-BDIZC" {
+		if mask := uint8(0x80) >> uint(n); ctx.Flag(mask) {
+			s += string(c)
+		} else {
+			s += strings.ToLower(string(c))
+		}
+	}
+	return s
+}
+
+// a hex and ASCII dump of start-end inclusive, 16 bytes a line
+func dumpMemory(out io.Writer, mem core6502.CPUMemory, start, end uint16) {
+	for line := int(start) &^ 15; line <= int(end); line += 16 {
+		hex, text := "", ""
+		for addr := line; addr < line+16; addr++ {
+			if addr < int(start) || addr > int(end) {
+				hex, text = hex+"   ", text+" "
+				continue
+			}
+			b := mem.Peek(uint16(addr))
+			hex += fmt.Sprintf(" %02x", b)
+			if b < ' ' || b > 126 {
+				b = '.'
+			}
+			text += string(rune(b))
+		}
+		fmt.Fprintf(out, "$%04x:%s  %s\n", line, hex, strings.TrimRight(text, " "))
+	}
+}
+
+type addrRange struct {
+	start, end uint16
+}
+
+// -dump start:end, may be repeated
+type dumpList []addrRange
+
+func (d *dumpList) String() string {
+	return fmt.Sprint(*d)
+}
+
+func (d *dumpList) Set(arg string) error {
+	parts := strings.Split(arg, ":")
+	if len(parts) != 2 {
+		return fmt.Errorf("Memory range must be start:end")
+	}
+	var addrs [2]uint16
+	for n, part := range parts {
+		addr, err := core6502.EvalExpr(part, nil)
+		if err != nil {
+			return err
+		}
+		if addr < 0 || addr > 0xffff {
+			return fmt.Errorf("Address out of range: %s", part)
+		}
+		addrs[n] = uint16(addr)
+	}
+	if addrs[1] < addrs[0] {
+		return fmt.Errorf("End $%04x is before start $%04x", addrs[1], addrs[0])
+	}
+	*d = append(*d, addrRange{addrs[0], addrs[1]})
+	return nil
+}
+
+// -trap address, may be repeated
+type trapList map[uint16]bool
+
+func (t trapList) String() string {
+	return fmt.Sprint(map[uint16]bool(t))
+}
+
+func (t trapList) Set(arg string) error {
+	addr, err := core6502.EvalExpr(arg, nil)
+	if err != nil {
+		return err
+	}
+	if addr < 0 || addr > 0xffff {
+		return fmt.Errorf("Address out of range: %s", arg)
+	}
+	t[uint16(addr)] = true
+	return nil
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+/*
+	Sets up the machine and loads the image, returns a runner for it with
+	the CPU reset. A RAM image starts at its start address, or the start
+	of its first block, unless it loads its own reset vector. Status
+	messages are written to out. For sim65 the remaining args are passed
+	to the program and it can open files in dir.
+*/
+func loadMachine(out io.Writer, machine string, args []string, dir string) (*runner, error) {
+	switch machine {
+	case "ram":
+		img, name, err := image6502.LoadFileArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+		start := uint16(image6502.DefaultRawAddr)
+		if img.HasStart {
+			start = img.Start
+		} else if len(img.Segments) > 0 {
+			start = img.Segments[0].Addr
+		}
+
+		r := &runner{ctx: &core6502.BasicCPUContext{}, loaded: []addrRange{}}
+		core6502.HardResetCPU(r.ctx, start)
+		img.CopyTo(r.ctx)
+		for _, s := range img.Segments {
+			fmt.Fprintf(out, "Loaded $%04x-$%04x from %s (%v)\n", s.Addr, s.End()-1, name, img.Format)
+			r.loaded = append(r.loaded, addrRange{s.Addr, uint16(s.End() - 1)})
+		}
+		core6502.SoftResetCPU(r.ctx)
+		if img.HasStart {
+			r.ctx.SetRegPC(img.Start)
+		}
+		return r, nil
+
+	case "nes":
+		rom, err := nes6502.LoadROM(args[0])
+		if err != nil {
+			return nil, err
+		}
+		console, err := nes6502.New(rom)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(out, "Loaded %s, mapper %d, %dK PRG-ROM\n", args[0], rom.Mapper, len(rom.PRG)/1024)
+		core6502.SoftResetCPU(console)
+		return &runner{ctx: console}, nil
+
+	case "sim65":
+		prog, err := sim6502.LoadProgram(args[0])
+		if err != nil {
+			return nil, err
+		}
+		ctx := &core6502.BasicCPUContext{}
+		prog.Install(ctx)
+		end := int(prog.Load) + len(prog.Data) - 1
+		fmt.Fprintf(out, "Loaded $%04x-$%04x from %s (sim65)\n", prog.Load, end, args[0])
+		return &runner{ctx: ctx, host: sim6502.NewHost(prog, dir, args), loaded: []addrRange{{prog.Load, uint16(end)}}}, nil
+	}
+	return nil, fmt.Errorf("Unknown machine: %s", machine)
+}
+
+// creates filename and writes it with write
+func writeFile(filename string, write func(w io.Writer) error) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func main() {
+	traps := trapList{}
+	var dumps dumpList
+	machine := flag.String("machine", "ram", "`type` of machine: ram, 64K of RAM, nes, the image is an iNES ROM run with the NES memory map, "+
+		"or sim65, the image is a cc65 sim6502 program run with its host calls")
+	dir := flag.String("dir", ".", "`directory` a sim65 program opens files in")
+	pc := flag.String("pc", "", "start at `address` instead of the reset vector")
+	limit := flag.Uint64("cycles", 100000000, "stop after `n` cycles")
+	traceFile := flag.String("trace", "", "write a nestest format trace of every instruction to `file`")
+	covFile := flag.String("cov", "", "write a coverage report of each page touched to `file`")
+	lcovFile := flag.String("lcov", "", "write the coverage as an lcov tracefile to `file`, needs -dbg")
+	dbgFile := flag.String("dbg", "", "ld65 debug info `file` mapping the image to source lines for -lcov")
+	flag.Var(traps, "trap", "stop when the PC reaches `address`, may be repeated")
+	flag.Var(&dumps, "dump", "print memory `start:end` after the run, may be repeated")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] image[@address]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [options] -machine sim65 program [args...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Runs until BRK, a trap address, a self loop (JMP *), an invalid opcode or the cycle limit.\n")
+		fmt.Fprintf(os.Stderr, "Exits with 0 for BRK or a trap, 3 for a self loop, 4 for an invalid opcode, 5 for the cycle limit,\n")
+		fmt.Fprintf(os.Stderr, "6 for BRK in memory the image didn't load.\n")
+		fmt.Fprintf(os.Stderr, "A sim65 program exits with its own code, and the status goes to stderr to keep its output clean.\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 || flag.NArg() > 1 && *machine != "sim65" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if (*lcovFile == "") != (*dbgFile == "") {
+		fmt.Fprintln(os.Stderr, "-lcov and -dbg go together")
+		os.Exit(2)
+	}
+
+	var out io.Writer = os.Stdout
+	if *machine == "sim65" {
+		out = os.Stderr
+	}
+
+	r, err := loadMachine(out, *machine, flag.Args(), *dir)
+	if err != nil {
+		fail(err)
+	}
+	ctx, host := r.ctx, r.host
+
+	if *pc != "" {
+		addr, err := core6502.EvalExpr(*pc, nil)
+		if err != nil {
+			fail(err)
+		}
+		if addr < 0 || addr > 0xffff {
+			fail(fmt.Errorf("Address out of range: %s", *pc))
+		}
+		ctx.SetRegPC(uint16(addr))
+	}
+
+	r.traps, r.limit = traps, *limit
+	var trace *os.File
+	if *traceFile != "" {
+		if trace, err = os.Create(*traceFile); err != nil {
+			fail(err)
+		}
+		r.tracer = core6502.NewTracer(trace)
+	}
+	var lineInfo *core6502.LineInfo
+	if *lcovFile != "" {
+		if lineInfo, err = core6502.LoadLineInfo(*dbgFile); err != nil {
+			fail(err)
+		}
+	}
+	if *covFile != "" || *lcovFile != "" {
+		r.coverage = cov6502.New()
+	}
+
+	reason := r.run()
+	if host != nil {
+		host.Close()
+	}
+	fmt.Fprintf(out, "%v at $%04x after %d instructions, %d cycles\n", reason, ctx.RegPC(), r.instructions, r.cycles)
+	fmt.Fprintf(out, "PC: $%04x SP: $%02x A: $%02x X: $%02x Y: $%02x P: $%02x %s\n",
+		ctx.RegPC(), ctx.RegSP(), ctx.RegA(), ctx.RegX(), ctx.RegY(), ctx.Flags(), flagString(ctx))
+	if r.err != nil {
+		fmt.Fprintln(out, r.err)
+	}
+	for _, d := range dumps {
+		dumpMemory(out, ctx, d.start, d.end)
+	}
+
+	if trace != nil {
+		if err := trace.Close(); err != nil {
+			fail(err)
+		}
+	}
+	if *covFile != "" {
+		err := writeFile(*covFile, func(w io.Writer) error {
+			r.coverage.Report(w, ctx, r.coverage.PageRegions(), nil)
+			return nil
+		})
+		if err != nil {
+			fail(err)
+		}
+	}
+	if *lcovFile != "" {
+		err := writeFile(*lcovFile, func(w io.Writer) error {
+			return r.coverage.WriteLCOV(w, ctx, lineInfo)
+		})
+		if err != nil {
+			fail(err)
+		}
+	}
+
+	if reason == Stop_Exit {
+		os.Exit(host.ExitCode)
+	}
+	os.Exit(reason.exitCode())
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"github.com/simulatedsimian/assert"
+	"github.com/simulatedsimian/emu6502/asm6502"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunPRG(t *testing.T) {
+	pack := assert.Pack
+
+	dir, err := ioutil.TempDir("", "run6502")
+	assert.NoError(t, pack(err))
+	defer os.RemoveAll(dir)
+
+	programs := []struct {
+		prg    []uint8
+		reason stopReason
+		pc     uint16
+		exit   int
+	}{
+		{[]uint8{0x01, 0x08, 0x4c, 0x01, 0x08}, Stop_SelfLoop, 0x0801, 3}, // jmp *
+		{[]uint8{0x01, 0x08, 0xe8, 0x00}, Stop_BRK, 0x0802, 0},            // inx, brk
+		{[]uint8{0x01, 0x08, 0xe8}, Stop_Unloaded, 0x0802, 6},             // inx, off the end
+		{[]uint8{0x01, 0x08, 0x4c, 0x00, 0x10}, Stop_Unloaded, 0x1000, 6}, // jmp $1000
+	}
+
+	for _, p := range programs {
+		file := filepath.Join(dir, "test.prg")
+		assert.NoError(t, pack(ioutil.WriteFile(file, p.prg, 0644)))
+
+		r, err := loadMachine(ioutil.Discard, "ram", []string{file}, dir)
+		assert.NoError(t, pack(err))
+		assert.Equal(t, r.ctx.RegPC(), uint16(0x0801))
+
+		r.limit = 1000
+		reason := r.run()
+		assert.Equal(t, reason, p.reason)
+		assert.Equal(t, r.ctx.RegPC(), p.pc)
+		assert.Equal(t, reason.exitCode(), p.exit)
+	}
+}
+
+/*
+	A sim65 program laid out the way cc65 builds one: the startup sets the
+	cc65 stack pointer and calls main, parameters are pushed with pushax
+	and the host calls are made by JSR. main halves the sum of a table,
+	prints it in decimal with write(1, buf, count) and exits with the
+	count written.
+*/
+const testSim65Program = `
+sp       = $00
+num      = $02
+rem      = $04
+first    = $05
+stacktop = $c000
+write    = $fff7
+exit     = $fff9
+
+        .org $0200
+startup:
+        cld
+        ldx #$ff
+        txs
+        lda #<stacktop
+        ldx #>stacktop
+        sta sp
+        stx sp+1
+        jsr main
+        jmp exit
+
+main:   ldy #0
+        sty num
+        sty num+1
+sum:    lda num
+        clc
+        adc table,y
+        sta num
+        lda num+1
+        adc table+1,y
+        sta num+1
+        iny
+        iny
+        cpy #6
+        bne sum
+        lsr num+1
+        ror num
+
+        ldy #4
+        lda #10
+        sta buf,y
+digit:  jsr div10
+        lda rem
+        clc
+        adc #'0'
+        dey
+        sta buf,y
+        lda num
+        ora num+1
+        bne digit
+        sty first
+
+        lda #1
+        ldx #0
+        jsr pushax
+        lda #<buf
+        clc
+        adc first
+        pha
+        lda #>buf
+        adc #0
+        tax
+        pla
+        jsr pushax
+        lda #5
+        sec
+        sbc first
+        ldx #0
+        jsr write
+        rts
+
+; num /= 10, the remainder in rem
+div10:  lda #0
+        sta rem
+        ldx #16
+divbit: asl num
+        rol num+1
+        rol rem
+        lda rem
+        cmp #10
+        bcc divnext
+        sbc #10
+        sta rem
+        inc num
+divnext:
+        dex
+        bne divbit
+        rts
+
+; pushes AX onto the cc65 stack
+pushax: pha
+        lda sp
+        sec
+        sbc #2
+        sta sp
+        bcs pushed
+        dec sp+1
+pushed: ldy #1
+        txa
+        sta (sp),y
+        pla
+        dey
+        sta (sp),y
+        rts
+
+table:  .word 1234, 5678, 9012
+buf:    .fill 5, 0
+`
+
+func TestRunSim65(t *testing.T) {
+	pack := assert.Pack
+
+	dir, err := ioutil.TempDir("", "run6502")
+	assert.NoError(t, pack(err))
+	defer os.RemoveAll(dir)
+
+	a := asm6502.New()
+	assert.NoError(t, pack(a.AssembleSource("test.s", []byte(testSim65Program))))
+	load, bin := a.Binary()
+	header := []uint8{'s', 'i', 'm', '6', '5', 2, 0, 0x00, uint8(load), uint8(load >> 8), uint8(load), uint8(load >> 8)}
+	file := filepath.Join(dir, "test.sim")
+	assert.NoError(t, pack(ioutil.WriteFile(file, append(header, bin...), 0644)))
+
+	r, err := loadMachine(ioutil.Discard, "sim65", []string{file}, dir)
+	assert.NoError(t, pack(err))
+	var out bytes.Buffer
+	r.host.Stdout = &out
+
+	r.limit = 100000
+	assert.Equal(t, r.run(), Stop_Exit)
+	assert.Equal(t, out.String(), "7962\n")
+	assert.Equal(t, r.host.ExitCode, 5)
+}
@@ -0,0 +1,37 @@
+package core6502
+
+/*
+	Wraps a context to report the memory an instruction accesses. Load is
+	called with each address read, apart from the instruction's own bytes
+	from PC to PC+Length, and Store with each address written, before it
+	is written. Either may be nil.
+*/
+type AccessContext struct {
+	CPUContext
+	PC, Length uint16
+	Load       func(addr uint16)
+	Store      func(addr uint16)
+}
+
+func (a *AccessContext) Peek(addr uint16) uint8 {
+	if a.Load != nil && addr-a.PC >= a.Length {
+		a.Load(addr)
+	}
+	return a.CPUContext.Peek(addr)
+}
+
+func (a *AccessContext) Poke(addr uint16, val uint8) {
+	if a.Store != nil {
+		a.Store(addr)
+	}
+	a.CPUContext.Poke(addr, val)
+}
+
+func (a *AccessContext) PeekWord(addr uint16) uint16 {
+	return MakeWord(a.Peek(addr+1), a.Peek(addr))
+}
+
+func (a *AccessContext) PokeWord(addr uint16, val uint16) {
+	a.Poke(addr, uint8(val))
+	a.Poke(addr+1, uint8(val>>8))
+}
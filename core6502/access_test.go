@@ -0,0 +1,43 @@
+package core6502
+
+import (
+	"github.com/simulatedsimian/assert"
+	"testing"
+)
+
+func TestAccessContext(t *testing.T) {
+	pack := assert.Pack
+
+	var ctx BasicCPUContext
+	ctx.Poke(0x0400, 0xe6) // inc $10
+	ctx.Poke(0x0401, 0x10)
+	ctx.Poke(0x0402, 0x20) // jsr $0410
+	ctx.PokeWord(0x0403, 0x0410)
+	ctx.Poke(0x10, 0x41)
+	ctx.SetRegPC(0x0400)
+	ctx.SetRegSP(0xff)
+
+	var loads, stores []uint16
+	step := func() {
+		loads, stores = nil, nil
+		pc := ctx.RegPC()
+		_, err := Execute(&AccessContext{
+			CPUContext: &ctx,
+			PC:         pc,
+			Length:     Decode(&ctx, pc).Length,
+			Load:       func(addr uint16) { loads = append(loads, addr) },
+			Store:      func(addr uint16) { stores = append(stores, addr) },
+		})
+		assert.NoError(t, pack(err))
+	}
+
+	step()
+	assert.Equal(t, ctx.Peek(0x10), uint8(0x42))
+	assert.Equal(t, loads, []uint16{0x10})
+	assert.Equal(t, stores, []uint16{0x10})
+
+	step()
+	assert.Equal(t, ctx.RegPC(), uint16(0x0410))
+	assert.Equal(t, loads, []uint16(nil))
+	assert.Equal(t, stores, []uint16{0x01ff, 0x01fe})
+}
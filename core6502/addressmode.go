@@ -18,6 +18,37 @@ const (
 	AddrMode_Relative
 )
 
+// assembler operand syntax of the mode, as used in error messages
+func (mode AddressMode) String() string {
+	switch mode {
+	case AddrMode_Implicit:
+		return "implicit"
+	case AddrMode_Immediate:
+		return "#imm"
+	case AddrMode_AbsoluteZeroPage:
+		return "zp"
+	case AddrMode_Absolute:
+		return "abs"
+	case AddrMode_ZeroPageIdxX:
+		return "zp,X"
+	case AddrMode_ZeroPageIdxY:
+		return "zp,Y"
+	case AddrMode_PreIndexIndirect:
+		return "(zp,X)"
+	case AddrMode_PostIndexIndirect:
+		return "(zp),Y"
+	case AddrMode_AbsoluteIndexedX:
+		return "abs,X"
+	case AddrMode_AbsoluteIndexedY:
+		return "abs,Y"
+	case AddrMode_Indirect:
+		return "(abs)"
+	case AddrMode_Relative:
+		return "relative"
+	}
+	return "invalid"
+}
+
 type AddrModeReadFunc func(ctx CPUContext) (uint8, int)
 type AddrModeWriteFunc func(ctx CPUContext, val uint8) int
 
@@ -164,10 +195,19 @@ func WritePreIndexIndirect(ctx CPUContext, val uint8) int {
 	return 0
 }
 
+// 1 if indexing from base to addr crosses a page, which costs reads a cycle
+func pageCrossed(base, addr uint16) int {
+	if HiByte(base) != HiByte(addr) {
+		return 1
+	}
+	return 0
+}
+
 // ($ff), y
 func ReadPostIndexIndirect(ctx CPUContext) (uint8, int) {
-	addr := ctx.PeekWord(uint16(ctx.Peek(ctx.RegPC()+1))) + uint16(ctx.RegY())
-	return ctx.Peek(addr), 0
+	base := ctx.PeekWord(uint16(ctx.Peek(ctx.RegPC() + 1)))
+	addr := base + uint16(ctx.RegY())
+	return ctx.Peek(addr), pageCrossed(base, addr)
 }
 
 // ($ff), y
@@ -179,26 +219,28 @@ func WritePostIndexIndirect(ctx CPUContext, val uint8) int {
 
 // $ffff, x
 func ReadAboluteIndexedX(ctx CPUContext) (uint8, int) {
-	addr := ctx.PeekWord(ctx.PeekWord(ctx.RegPC()+1)) + uint16(ctx.RegX())
-	return ctx.Peek(addr), 0
+	base := ctx.PeekWord(ctx.RegPC() + 1)
+	addr := base + uint16(ctx.RegX())
+	return ctx.Peek(addr), pageCrossed(base, addr)
 }
 
 // $ffff, x
 func WriteAboluteIndexedX(ctx CPUContext, val uint8) int {
-	addr := ctx.PeekWord(ctx.PeekWord(ctx.RegPC()+1)) + uint16(ctx.RegX())
+	addr := ctx.PeekWord(ctx.RegPC()+1) + uint16(ctx.RegX())
 	ctx.Poke(addr, val)
 	return 0
 }
 
-// $ffff, x
+// $ffff, y
 func ReadAboluteIndexedY(ctx CPUContext) (uint8, int) {
-	addr := ctx.PeekWord(ctx.PeekWord(ctx.RegPC()+1)) + uint16(ctx.RegY())
-	return ctx.Peek(addr), 0
+	base := ctx.PeekWord(ctx.RegPC() + 1)
+	addr := base + uint16(ctx.RegY())
+	return ctx.Peek(addr), pageCrossed(base, addr)
 }
 
-// $ffff, x
+// $ffff, y
 func WriteAboluteIndexedY(ctx CPUContext, val uint8) int {
-	addr := ctx.PeekWord(ctx.PeekWord(ctx.RegPC()+1)) + uint16(ctx.RegY())
+	addr := ctx.PeekWord(ctx.RegPC()+1) + uint16(ctx.RegY())
 	ctx.Poke(addr, val)
 	return 0
 }
@@ -213,15 +255,11 @@ func ReadIndirect16(ctx CPUContext) uint16 {
 	return ctx.PeekWord(ctx.PeekWord(ctx.RegPC() + 1))
 }
 
-func signExtend(val uint8) uint16 {
-	return uint16(int16(int8(val)))
-}
-
 func CalcPCRelativeAddr(ctx CPUContext) (uint16, int) {
 	oldPC := ctx.RegPC()
-	newPC := signExtend(ctx.Peek(oldPC+1)) + oldPC
+	newPC := BranchTarget(ctx, oldPC)
 	exclock := 1
-	if HiByte(newPC) != HiByte(oldPC) {
+	if HiByte(newPC) != HiByte(oldPC+2) {
 		exclock++
 	}
 	return newPC, exclock
@@ -2,41 +2,324 @@ package core6502
 
 import (
 	"fmt"
-	"github.com/simulatedsimian/assert"
+	"strings"
 )
 
-type asmInfo map[AddressMode]uint8
+// Operand syntax of an assembly instruction, before an addressing
+// mode is chosen
+type OperandSyntax int
 
-var asmData map[string]asmInfo = make(map[string]asmInfo)
+const (
+	Syntax_None        OperandSyntax = iota // implicit
+	Syntax_Accumulator                      // A
+	Syntax_Immediate                        // #expr
+	Syntax_Direct                           // expr: zero page, absolute or branch target
+	Syntax_IndexedX                         // expr,X
+	Syntax_IndexedY                         // expr,Y
+	Syntax_Indirect                         // (expr)
+	Syntax_IndirectX                        // (expr,X)
+	Syntax_IndirectY                        // (expr),Y
+)
+
+// A parsed assembly instruction
+type AsmInstruction struct {
+	Mnemonic string // upper case
+	Syntax   OperandSyntax
+	Operand  *Expr // nil for Syntax_None and Syntax_Accumulator
+}
+
+// returns the index of the last comma in s that is not inside brackets
+// or a character literal, or -1
+func topLevelComma(s string) int {
+	depth := 0
+	comma := -1
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(', '[':
+			depth++
+		case ')', ']':
+			depth--
+		case '\'':
+			i += 2
+		case ',':
+			if depth == 0 {
+				comma = i
+			}
+		}
+	}
+	return comma
+}
+
+// returns the index of the bracket closing the one at s[0], or -1
+func matchingParen(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		case '\'':
+			i += 2
+		}
+	}
+	return -1
+}
+
+func isIndexReg(s, reg string) bool {
+	return strings.EqualFold(strings.TrimSpace(s), reg)
+}
+
+// splits "expr,R" into expr and the register, or returns reg "" if there is no index
+func splitIndex(s string) (string, string, error) {
+	comma := topLevelComma(s)
+	if comma < 0 {
+		return s, "", nil
+	}
+	switch {
+	case isIndexReg(s[comma+1:], "X"):
+		return s[:comma], "X", nil
+	case isIndexReg(s[comma+1:], "Y"):
+		return s[:comma], "Y", nil
+	}
+	return "", "", fmt.Errorf("Invalid index register: %s", strings.TrimSpace(s[comma+1:]))
+}
+
+func parseOperandSyntax(s string) (OperandSyntax, string, error) {
+	switch {
+	case s == "":
+		return Syntax_None, "", nil
+
+	case strings.EqualFold(s, "A"):
+		return Syntax_Accumulator, "", nil
+
+	case s[0] == '#':
+		return Syntax_Immediate, s[1:], nil
 
-func init() {
-	for n := 0; n < len(InstructionData); n++ {
-		info := &InstructionData[n]
-		name := assert.GetShortFuncName(info.execMaker)
+	case s[0] == '(':
+		close := matchingParen(s)
+		if close < 0 {
+			return Syntax_None, "", fmt.Errorf("Missing ')': %s", s)
+		}
+		inner, after := s[1:close], strings.TrimSpace(s[close+1:])
+
+		if after == "" {
+			expr, reg, err := splitIndex(inner)
+			switch {
+			case err != nil:
+				return Syntax_None, "", err
+			case reg == "X":
+				return Syntax_IndirectX, expr, nil
+			case reg == "":
+				return Syntax_Indirect, inner, nil
+			}
+			return Syntax_None, "", fmt.Errorf("Invalid indirect operand: %s", s)
+		}
+
+		if after[0] == ',' && isIndexReg(after[1:], "Y") {
+			return Syntax_IndirectY, inner, nil
+		}
+		// an expression that starts with a bracket, e.g. (1+2)*3
+	}
+
+	expr, reg, err := splitIndex(s)
+	switch {
+	case err != nil:
+		return Syntax_None, "", err
+	case reg == "X":
+		return Syntax_IndexedX, expr, nil
+	case reg == "Y":
+		return Syntax_IndexedY, expr, nil
+	}
+	return Syntax_Direct, expr, nil
+}
+
+// Parses a single instruction such as "lda ($fb),y". The operand is not evaluated.
+func ParseAsmInstruction(s string) (*AsmInstruction, error) {
+	s = strings.TrimSpace(s)
+	parts := Split(s, " \t")
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("Missing instruction")
+	}
+
+	mnemonic := strings.ToUpper(parts[0])
+	if InstructionModes(mnemonic) == nil {
+		return nil, fmt.Errorf("'%s' not valid instruction", parts[0])
+	}
+
+	syntax, operand, err := parseOperandSyntax(strings.TrimSpace(s[len(parts[0]):]))
+	if err != nil {
+		return nil, err
+	}
 
-		if _, ok := asmData[name]; !ok {
-			asmData[name] = asmInfo{}
+	ai := &AsmInstruction{Mnemonic: mnemonic, Syntax: syntax}
+	if syntax != Syntax_None && syntax != Syntax_Accumulator {
+		if ai.Operand, err = ParseExpr(operand); err != nil {
+			return nil, err
 		}
-		asmData[name][info.mode] = info.opcode
 	}
+	return ai, nil
 }
 
-func DetermineAddresMode(parts []string) AddressMode {
-	return AddrMode_Absolute
+func (ai *AsmInstruction) unsupported(mode AddressMode) error {
+	return fmt.Errorf("%s does not support %v", ai.Mnemonic, mode)
+}
+
+// returns the encoding of the first supported mode in modes, the first is
+// the mode as written and the error reports it
+func (ai *AsmInstruction) lookup(modes ...AddressMode) (OpcodeInfo, error) {
+	for _, mode := range modes {
+		if info, ok := LookupInstruction(ai.Mnemonic, mode); ok {
+			return info, nil
+		}
+	}
+	return OpcodeInfo{}, ai.unsupported(modes[0])
+}
+
+/*
+	Selects the encoding for the operand value. The zero page form is
+	chosen when the value fits in a byte and the instruction supports it.
+	Pass known false while the value is still unresolved (a forward
+	reference) to get the absolute form; a multi pass assembler should
+	keep passing false for that operand so the instruction size is stable.
+*/
+func (ai *AsmInstruction) SelectMode(value int, known bool) (OpcodeInfo, error) {
+	zeroPage := known && value >= 0 && value < 0x100
+
+	switch ai.Syntax {
+	case Syntax_None, Syntax_Accumulator:
+		info, ok := LookupInstruction(ai.Mnemonic, AddrMode_Implicit)
+		if !ok {
+			if ai.Syntax == Syntax_Accumulator {
+				return info, fmt.Errorf("%s does not support A", ai.Mnemonic)
+			}
+			return info, fmt.Errorf("%s requires an operand", ai.Mnemonic)
+		}
+		return info, nil
+
+	case Syntax_Immediate:
+		return ai.lookup(AddrMode_Immediate)
+
+	case Syntax_Direct:
+		if info, ok := LookupInstruction(ai.Mnemonic, AddrMode_Relative); ok {
+			return info, nil
+		}
+		if zeroPage {
+			return ai.lookup(AddrMode_AbsoluteZeroPage, AddrMode_Absolute)
+		}
+		return ai.lookup(AddrMode_Absolute, AddrMode_AbsoluteZeroPage)
+
+	case Syntax_IndexedX:
+		if zeroPage {
+			return ai.lookup(AddrMode_ZeroPageIdxX, AddrMode_AbsoluteIndexedX)
+		}
+		return ai.lookup(AddrMode_AbsoluteIndexedX, AddrMode_ZeroPageIdxX)
+
+	case Syntax_IndexedY:
+		if zeroPage {
+			return ai.lookup(AddrMode_ZeroPageIdxY, AddrMode_AbsoluteIndexedY)
+		}
+		return ai.lookup(AddrMode_AbsoluteIndexedY, AddrMode_ZeroPageIdxY)
+
+	case Syntax_Indirect:
+		return ai.lookup(AddrMode_Indirect)
+
+	case Syntax_IndirectX:
+		return ai.lookup(AddrMode_PreIndexIndirect)
+
+	case Syntax_IndirectY:
+		return ai.lookup(AddrMode_PostIndexIndirect)
+	}
+	panic("Invalid operand syntax")
+}
+
+// Encodes the instruction at addr using the encoding from SelectMode
+func (ai *AsmInstruction) Encode(info OpcodeInfo, addr uint16, value int) ([]uint8, error) {
+	outOfRange := func() ([]uint8, error) {
+		return nil, fmt.Errorf("Operand %s = $%04x out of range for %v", ai.Operand, value, info.Mode)
+	}
+
+	switch info.Length {
+	case 1:
+		return []uint8{info.Opcode}, nil
+
+	case 2:
+		if info.Mode == AddrMode_Relative {
+			offset := value - int(addr) - 2
+			if value < 0 || value > 0xffff {
+				return outOfRange()
+			}
+			if offset < -128 || offset > 127 {
+				return nil, fmt.Errorf("Branch target $%04x out of range from $%04x", value, addr)
+			}
+			return []uint8{info.Opcode, uint8(offset)}, nil
+		}
+		if value < -128 || value > 0xff || (value < 0 && info.Mode != AddrMode_Immediate) {
+			return outOfRange()
+		}
+		return []uint8{info.Opcode, uint8(value)}, nil
+	}
+
+	if value < 0 || value > 0xffff {
+		return outOfRange()
+	}
+	return []uint8{info.Opcode, LoByte(uint16(value)), HiByte(uint16(value))}, nil
+}
+
+// resolves * to the address being assembled, other names through symbols
+type asmSymbols struct {
+	addr    uint16
+	symbols SymbolResolver
+}
+
+func (s asmSymbols) ResolveSymbol(name string) (int, bool) {
+	if name == "*" {
+		return int(s.addr), true
+	}
+	if s.symbols != nil {
+		return s.symbols.ResolveSymbol(name)
+	}
+	return 0, false
 }
 
 func Assemble(ctx CPUContext, addr uint16, s string) (nextAddr uint16, err error) {
-	parts := Split(s, " ,")
-	addrMode := DetermineAddresMode(parts)
+	return AssembleSymbols(ctx, addr, s, nil)
+}
 
-	info, ok := asmData[parts[0]]
-	if !ok {
-		err = fmt.Errorf("'%s' not valid instruction", parts[0])
-		return
+/*
+	Assembles the single instruction s at addr, writing it to ctx.
+	Operands may use symbols, and * for addr. Branch operands are
+	target addresses. Returns the address following the instruction.
+*/
+func AssembleSymbols(ctx CPUContext, addr uint16, s string, symbols SymbolResolver) (nextAddr uint16, err error) {
+	ai, err := ParseAsmInstruction(s)
+	if err != nil {
+		return addr, err
 	}
 
-	addrMode = addrMode
-	info = info
+	value := 0
+	if ai.Operand != nil {
+		value, err = ai.Operand.Eval(&ExprEnv{Symbols: asmSymbols{addr, symbols}})
+		if err != nil {
+			return addr, err
+		}
+	}
 
-	return
+	info, err := ai.SelectMode(value, true)
+	if err != nil {
+		return addr, err
+	}
+
+	bytes, err := ai.Encode(info, addr, value)
+	if err != nil {
+		return addr, err
+	}
+
+	for n, b := range bytes {
+		ctx.Poke(addr+uint16(n), b)
+	}
+	return addr + uint16(len(bytes)), nil
 }
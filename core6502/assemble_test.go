@@ -0,0 +1,86 @@
+package core6502
+
+import (
+	"fmt"
+	"github.com/simulatedsimian/assert"
+	"testing"
+)
+
+func TestAssemble(t *testing.T) {
+	pack := assert.Pack
+
+	var ctx BasicCPUContext
+
+	st := NewSymbolTable()
+	st.Add("ptr", 0xfb)
+	st.Add("port", 0xc010)
+
+	tests := []struct {
+		src   string
+		addr  uint16
+		bytes []uint8
+	}{
+		{"nop", 0x400, []uint8{0xea}},
+		{"LDA #$10", 0x400, []uint8{0xa9, 0x10}},
+		{"lda #-1", 0x400, []uint8{0xa9, 0xff}},
+		{"lda #'A'", 0x400, []uint8{0xa9, 0x41}},
+		{"lda $10", 0x400, []uint8{0xa5, 0x10}},
+		{"lda $0010+$100", 0x400, []uint8{0xad, 0x10, 0x01}},
+		{"lda port", 0x400, []uint8{0xad, 0x10, 0xc0}},
+		{"lda ptr,x", 0x400, []uint8{0xb5, 0xfb}},
+		{"lda port, X", 0x400, []uint8{0xbd, 0x10, 0xc0}},
+		{"lda $10,y", 0x400, []uint8{0xb9, 0x10, 0x00}},
+		{"ldx $10,y", 0x400, []uint8{0xb6, 0x10}},
+		{"lda (ptr,x)", 0x400, []uint8{0xa1, 0xfb}},
+		{"lda (ptr),y", 0x400, []uint8{0xb1, 0xfb}},
+		{"lda (1+2)*3", 0x400, []uint8{0xa5, 0x09}},
+		{"jmp ($fffc)", 0x400, []uint8{0x6c, 0xfc, 0xff}},
+		{"jmp $10", 0x400, []uint8{0x4c, 0x10, 0x00}},
+		{"jsr port", 0x400, []uint8{0x20, 0x10, 0xc0}},
+		{"jmp *", 0x400, []uint8{0x4c, 0x00, 0x04}},
+		{"bne $3fe", 0x400, []uint8{0xd0, 0xfc}},
+		{"beq *+2", 0x400, []uint8{0xf0, 0x00}},
+		{"bcc $47f", 0x3fe, []uint8{0x90, 0x7f}},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, pack(AssembleSymbols(&ctx, test.addr, test.src, st)),
+			[]interface{}{test.addr + uint16(len(test.bytes)), nil})
+		for n, b := range test.bytes {
+			assert.Equal(t, ctx.Peek(test.addr+uint16(n)), b)
+		}
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	var ctx BasicCPUContext
+
+	errors := []struct {
+		src string
+		msg string
+	}{
+		{"", "Missing instruction"},
+		{"xyz $10", "'xyz' not valid instruction"},
+		{"ldx ($10,x)", "LDX does not support (zp,X)"},
+		{"sta #1", "STA does not support #imm"},
+		{"lda ($1000)", "LDA does not support (abs)"},
+		{"inx $10", "INX does not support zp"},
+		{"inx $1234", "INX does not support abs"},
+		{"stx $1234,x", "STX does not support abs,X"},
+		{"sty $1234,y", "STY does not support abs,Y"},
+		{"lda a", "LDA does not support A"},
+		{"lda", "LDA requires an operand"},
+		{"lda $10,z", "Invalid index register: z"},
+		{"lda #$100", "Operand $100 = $0100 out of range for #imm"},
+		{"lda ($100),y", "Operand $100 = $0100 out of range for (zp),Y"},
+		{"lda $10000", "Operand $10000 = $10000 out of range for abs"},
+		{"bne $500", "Branch target $0500 out of range from $0400"},
+		{"lda label", "Unknown Symbol: label"},
+	}
+
+	for _, test := range errors {
+		next, err := Assemble(&ctx, 0x400, test.src)
+		assert.Equal(t, next, uint16(0x400))
+		assert.Equal(t, fmt.Sprint(err), test.msg)
+	}
+}
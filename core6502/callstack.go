@@ -0,0 +1,147 @@
+package core6502
+
+import (
+	"fmt"
+)
+
+// how a call stack frame was entered
+type FrameKind int
+
+const (
+	Frame_JSR FrameKind = iota
+	Frame_BRK
+	Frame_Interrupt
+)
+
+func (k FrameKind) String() string {
+	switch k {
+	case Frame_JSR:
+		return "JSR"
+	case Frame_BRK:
+		return "BRK"
+	case Frame_Interrupt:
+		return "interrupt"
+	}
+	return fmt.Sprintf("FrameKind(%d)", int(k))
+}
+
+/*
+	A call in progress. SP is the stack pointer just after the call pushed
+	its return address, so the return address is at $0101+SP for a JSR,
+	and the flags at $0101+SP and the return address above them for BRK
+	and interrupts.
+*/
+type Frame struct {
+	Kind   FrameKind
+	Site   uint16 // the JSR or BRK, or the instruction interrupted
+	Target uint16 // the subroutine or handler called
+	Return uint16 // where the call returns to
+	SP     uint8
+}
+
+// the number of bytes the call pushed
+func (f *Frame) Size() int {
+	if f.Kind == Frame_JSR {
+		return 2
+	}
+	return 3
+}
+
+// the address of the lowest byte the call pushed
+func (f *Frame) Addr() uint16 {
+	return 0x101 + uint16(f.SP)
+}
+
+// the address of the return address on the stack
+func (f *Frame) ReturnAddr() uint16 {
+	return 0x100 + uint16(f.SP) + uint16(f.Size()) - 1
+}
+
+// where the return address now on the stack returns to
+func (f *Frame) Returns(mem CPUMemory) uint16 {
+	ret := mem.PeekWord(f.ReturnAddr())
+	if f.Kind == Frame_JSR {
+		ret++ // RTS adds one
+	}
+	return ret
+}
+
+// false if the return address on the stack has been changed since the call
+func (f *Frame) Intact(mem CPUMemory) bool {
+	return f.Returns(mem) == f.Return
+}
+
+/*
+	A shadow call stack, kept by recording each instruction executed. JSR
+	and BRK push a frame, and frames are popped when SP rises above their
+	return address, which handles RTS and RTI as well as return addresses
+	dropped or replaced by stack tricks such as RTS dispatch, and SP being
+	reset. Code delivering an interrupt calls Interrupt after pushing the
+	PC and flags.
+*/
+type CallStack struct {
+	Frames []Frame // outermost first
+}
+
+// records an executed instruction, pc and opcode are from before it was executed
+func (cs *CallStack) Record(ctx CPUContext, pc uint16, opcode uint8) {
+	cs.Sync(ctx)
+
+	switch opcode {
+	case 0x20: // JSR
+		cs.push(ctx, Frame_JSR, pc)
+	case 0x00: // BRK
+		cs.push(ctx, Frame_BRK, pc)
+	}
+}
+
+// pushes a frame for a call that has just pushed its return address
+func (cs *CallStack) push(ctx CPUContext, kind FrameKind, site uint16) {
+	f := Frame{Kind: kind, Site: site, Target: ctx.RegPC(), SP: ctx.RegSP()}
+	f.Return = f.Returns(ctx)
+	cs.Frames = append(cs.Frames, f)
+}
+
+// records an interrupt, after its return address and the flags were pushed
+func (cs *CallStack) Interrupt(ctx CPUContext) {
+	cs.Sync(ctx)
+	cs.push(ctx, Frame_Interrupt, ctx.PeekWord(0x102+uint16(ctx.RegSP())))
+}
+
+// pops the frames whose return address is above SP
+func (cs *CallStack) Sync(ctx CPURegisters) {
+	sp := ctx.RegSP()
+	for len(cs.Frames) > 0 && cs.Frames[len(cs.Frames)-1].SP < sp {
+		cs.Frames = cs.Frames[:len(cs.Frames)-1]
+	}
+}
+
+func (cs *CallStack) Clear() {
+	cs.Frames = nil
+}
+
+// the frame that pushed the byte at addr, if a frame did
+func (cs *CallStack) FrameAt(addr uint16) (*Frame, bool) {
+	for n := range cs.Frames {
+		f := &cs.Frames[n]
+		if addr >= f.Addr() && int(addr) < int(f.Addr())+f.Size() {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// the bytes pushed between frame n and the next frame in, or SP for the innermost
+func (cs *CallStack) Pushed(ctx CPUContext, n int) []uint8 {
+	inner := ctx.RegSP()
+	if n+1 < len(cs.Frames) {
+		f := &cs.Frames[n+1]
+		inner = f.SP + uint8(f.Size())
+	}
+
+	var pushed []uint8
+	for sp := int(cs.Frames[n].SP); sp > int(inner); sp-- {
+		pushed = append(pushed, ctx.Peek(0x100+uint16(sp)))
+	}
+	return pushed
+}
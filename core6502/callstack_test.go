@@ -0,0 +1,77 @@
+package core6502
+
+import (
+	"github.com/simulatedsimian/assert"
+	"testing"
+)
+
+func TestCallStack(t *testing.T) {
+	pack := assert.Pack
+
+	var ctx BasicCPUContext
+	for addr, code := range map[uint16][]uint8{
+		0x0400: {0x20, 0x10, 0x04},                         // jsr outer
+		0x0410: {0xa9, 0x55, 0x48, 0x20, 0x20, 0x04},       // outer: lda #$55, pha, jsr inner
+		0x0420: {0xa9, 0x04, 0x48, 0xa9, 0x2f, 0x48, 0x60}, // inner: push $042f, rts
+		0x0430: {0x00},                                     // brk
+		0x0500: {0x40},                                     // rti
+	} {
+		for n, b := range code {
+			ctx.Poke(addr+uint16(n), b)
+		}
+	}
+	ctx.PokeWord(Vector_IRQ, 0x0500)
+	ctx.SetRegPC(0x0400)
+	ctx.SetRegSP(0xff)
+
+	var cs CallStack
+	step := func(n int) {
+		for ; n > 0; n-- {
+			pc := ctx.RegPC()
+			opcode := ctx.Peek(pc)
+			_, err := Execute(&ctx)
+			assert.NoError(t, pack(err))
+			cs.Record(&ctx, pc, opcode)
+		}
+	}
+
+	step(4)
+	assert.Equal(t, cs.Frames, []Frame{
+		{Frame_JSR, 0x0400, 0x0410, 0x0403, 0xfd},
+		{Frame_JSR, 0x0413, 0x0420, 0x0416, 0xfa},
+	})
+	assert.Equal(t, cs.Pushed(&ctx, 0), []uint8{0x55})
+	assert.Equal(t, cs.Frames[1].Intact(&ctx), true)
+
+	// pushing an address for RTS to dispatch to leaves the frames alone
+	step(4)
+	assert.Equal(t, len(cs.Frames), 2)
+	assert.Equal(t, cs.Pushed(&ctx, 1), []uint8{0x04, 0x2f})
+	f, ok := cs.FrameAt(0x01fb)
+	assert.Equal(t, pack(f.ReturnAddr(), ok), []interface{}{uint16(0x01fb), true})
+	_, ok = cs.FrameAt(0x01f9)
+	assert.Equal(t, ok, false)
+
+	step(2)
+	assert.Equal(t, ctx.RegPC(), uint16(0x0500))
+	assert.Equal(t, cs.Frames[2], Frame{Frame_BRK, 0x0430, 0x0500, 0x0432, 0xf7})
+	assert.Equal(t, cs.Frames[2].Intact(&ctx), true)
+
+	// an RTI, then SP reset past every frame
+	step(1)
+	assert.Equal(t, ctx.RegPC(), uint16(0x0432))
+	assert.Equal(t, len(cs.Frames), 2)
+	ctx.SetRegSP(0xff)
+	cs.Sync(&ctx)
+	assert.Equal(t, len(cs.Frames), 0)
+
+	ctx.SetRegPC(0x1234)
+	ctx.SetFlag(Flag_I, false)
+	_, ok = IRQ(&ctx)
+	assert.Equal(t, ok, true)
+	cs.Interrupt(&ctx)
+	assert.Equal(t, cs.Frames, []Frame{{Frame_Interrupt, 0x1234, 0x0500, 0x1234, 0xfc}})
+	step(1)
+	assert.Equal(t, ctx.RegPC(), uint16(0x1234))
+	assert.Equal(t, len(cs.Frames), 0)
+}
@@ -0,0 +1,73 @@
+package core6502
+
+// A decoded instruction
+type Instruction struct {
+	Addr     uint16
+	Opcode   uint8
+	Mnemonic string
+	Mode     AddressMode
+	Operand  []uint8 // raw operand bytes, little endian
+	Value    uint16  // operand bytes as a value, the raw offset for relative branches
+	Target   uint16  // branch or jump destination, valid if HasTarget
+	Length   uint16
+	Cycles   int // base cycle count, excluding page crossing and branch penalties
+	Legal    bool
+
+	HasTarget bool
+}
+
+/*
+	Decodes the instruction at addr. An undocumented opcode decodes as a
+	one byte instruction with Legal false and no mnemonic. For relative
+	branches, JMP and JSR the destination is returned in Target; for
+	JMP indirect it is read through the vector in mem.
+*/
+func Decode(mem CPUMemory, addr uint16) Instruction {
+	opcode := mem.Peek(addr)
+	info := &opcodeTable[opcode]
+
+	inst := Instruction{
+		Addr:   addr,
+		Opcode: opcode,
+		Length: 1,
+		Legal:  info.Documented,
+	}
+
+	if !inst.Legal {
+		return inst
+	}
+
+	inst.Mnemonic = info.Mnemonic
+	inst.Mode = info.Mode
+	inst.Cycles = info.Cycles
+	inst.Length = info.Length
+	for n := uint16(1); n < inst.Length; n++ {
+		inst.Operand = append(inst.Operand, mem.Peek(addr+n))
+	}
+
+	switch len(inst.Operand) {
+	case 1:
+		inst.Value = uint16(inst.Operand[0])
+	case 2:
+		inst.Value = MakeWord(inst.Operand[1], inst.Operand[0])
+	}
+
+	switch {
+	case info.Mode == AddrMode_Relative:
+		inst.Target = addr + 2 + SignExtend8To16(inst.Operand[0])
+		inst.HasTarget = true
+	case info.Mode == AddrMode_Indirect:
+		inst.Target = mem.PeekWord(inst.Value)
+		inst.HasTarget = true
+	case info.Mnemonic == "JMP" || info.Mnemonic == "JSR":
+		inst.Target = inst.Value
+		inst.HasTarget = true
+	}
+
+	return inst
+}
+
+// target of the relative branch at addr, the offset is relative to the following instruction
+func BranchTarget(mem CPUMemory, addr uint16) uint16 {
+	return addr + 2 + SignExtend8To16(mem.Peek(addr+1))
+}
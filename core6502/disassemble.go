@@ -2,59 +2,175 @@ package core6502
 
 import (
 	"fmt"
-	"github.com/simulatedsimian/assert"
+	"strings"
 )
 
-type disasmInfo struct {
-	name string
-	mode AddressMode
+// Formats decoded instructions as text
+type InstructionFormatter interface {
+	FormatInstruction(inst *Instruction) string
 }
 
-var disasmData [256]disasmInfo
+// Supplies names for addresses shown in disassembly
+type SymbolProvider interface {
+	// exact label at addr
+	Name(addr uint16) (string, bool)
+	// label or label+offset near addr, "" if none
+	Format(addr uint16) string
+}
+
+type DisasmStyle int
+
+const (
+	DisasmStyle_Default DisasmStyle = iota // LDA ($10), Y  BNE -4  db  $02
+	DisasmStyle_CA65                       // LDA ($10),Y  BNE $03fe  .byte $02, assembles with ca65
+)
+
+type DisasmCase int
+
+const (
+	DisasmCase_Default DisasmCase = iota // upper case mnemonics, lower case hex
+	DisasmCase_Upper
+	DisasmCase_Lower
+)
+
+/*
+	Controls disassembly output and implements InstructionFormatter.
+	A nil *DisasmOptions gives the plain format: relative branches as
+	signed offsets and all operands in hex. Symbol names are never
+	case converted.
+*/
+type DisasmOptions struct {
+	Style DisasmStyle
+	Case  DisasmCase
+	// show relative branch operands as the absolute target address,
+	// always set for DisasmStyle_CA65
+	AbsoluteBranches bool
+	// if set, operand addresses are replaced by label or label+offset
+	Symbols SymbolProvider
+}
+
+func (opts *DisasmOptions) style() DisasmStyle {
+	if opts == nil {
+		return DisasmStyle_Default
+	}
+	return opts.Style
+}
+
+func (opts *DisasmOptions) setCase(s string) string {
+	if opts != nil {
+		switch opts.Case {
+		case DisasmCase_Upper:
+			return strings.ToUpper(s)
+		case DisasmCase_Lower:
+			return strings.ToLower(s)
+		}
+	}
+	return s
+}
+
+func (opts *DisasmOptions) symbol(addr uint16) (string, bool) {
+	if opts == nil || opts.Symbols == nil {
+		return "", false
+	}
+	s := opts.Symbols.Format(addr)
+	return s, s != ""
+}
+
+func (opts *DisasmOptions) hex8(val uint8) string {
+	return opts.setCase(fmt.Sprintf("$%02x", val))
+}
+
+func (opts *DisasmOptions) addr8(addr uint8) string {
+	if s, ok := opts.symbol(uint16(addr)); ok {
+		return s
+	}
+	return opts.hex8(addr)
+}
+
+func (opts *DisasmOptions) addr16(addr uint16) string {
+	if s, ok := opts.symbol(addr); ok {
+		return s
+	}
+	return opts.setCase(fmt.Sprintf("$%04x", addr))
+}
+
+// absolute operands that fit in zero page need an a: prefix for ca65
+// to keep the absolute encoding
+func (opts *DisasmOptions) absolute(addr uint16) string {
+	if opts.style() == DisasmStyle_CA65 && addr < 0x100 {
+		return "a:" + opts.addr16(addr)
+	}
+	return opts.addr16(addr)
+}
 
-func init() {
-	for n := 0; n < len(InstructionData); n++ {
-		info := &InstructionData[n]
-		disasmData[info.opcode] = disasmInfo{assert.GetShortFuncName(info.execMaker), info.mode}
+func (opts *DisasmOptions) index(reg string) string {
+	if opts.style() == DisasmStyle_CA65 {
+		return "," + opts.setCase(reg)
 	}
+	return ", " + opts.setCase(reg)
 }
 
-func addressModeToStr(mode AddressMode, ctx CPUContext, addr uint16) string {
-	switch mode {
+// returns the label line ("name:") to show before the instruction at addr
+func (opts *DisasmOptions) Label(addr uint16) (string, bool) {
+	if opts == nil || opts.Symbols == nil {
+		return "", false
+	}
+	if name, ok := opts.Symbols.Name(addr); ok {
+		return name + ":", true
+	}
+	return "", false
+}
+
+func (opts *DisasmOptions) operand(inst *Instruction) string {
+	switch inst.Mode {
 	case AddrMode_Immediate:
-		return fmt.Sprintf("#$%02x", ctx.Peek(addr))
+		return "#" + opts.hex8(uint8(inst.Value))
 	case AddrMode_Implicit:
 		return ""
 	case AddrMode_Absolute:
-		return fmt.Sprintf("$%04x", ctx.PeekWord(addr))
+		return opts.absolute(inst.Value)
 	case AddrMode_AbsoluteZeroPage:
-		return fmt.Sprintf("$%02x", ctx.Peek(addr))
+		return opts.addr8(uint8(inst.Value))
 	case AddrMode_ZeroPageIdxX:
-		return fmt.Sprintf("$%02x, X", ctx.Peek(addr))
+		return opts.addr8(uint8(inst.Value)) + opts.index("X")
 	case AddrMode_ZeroPageIdxY:
-		return fmt.Sprintf("$%02x, X", ctx.Peek(addr))
+		return opts.addr8(uint8(inst.Value)) + opts.index("Y")
 	case AddrMode_PreIndexIndirect:
-		return fmt.Sprintf("($%02x, X)", ctx.Peek(addr))
+		return "(" + opts.addr8(uint8(inst.Value)) + opts.index("X") + ")"
 	case AddrMode_PostIndexIndirect:
-		return fmt.Sprintf("($%02x), Y", ctx.Peek(addr))
+		return "(" + opts.addr8(uint8(inst.Value)) + ")" + opts.index("Y")
 	case AddrMode_AbsoluteIndexedX:
-		return fmt.Sprintf("$%04x, X", ctx.PeekWord(addr))
+		return opts.absolute(inst.Value) + opts.index("X")
 	case AddrMode_AbsoluteIndexedY:
-		return fmt.Sprintf("$%04x, Y", ctx.PeekWord(addr))
+		return opts.absolute(inst.Value) + opts.index("Y")
 	case AddrMode_Indirect:
-		return fmt.Sprintf("($%04x)", ctx.PeekWord(addr))
+		return "(" + opts.addr16(inst.Value) + ")"
 	case AddrMode_Relative:
-		return fmt.Sprintf("%v", int8(ctx.Peek(addr)))
+		if opts != nil && (opts.AbsoluteBranches || opts.Style == DisasmStyle_CA65) {
+			return opts.addr16(inst.Target)
+		}
+		return fmt.Sprintf("%v", int8(inst.Value))
 	}
 	return "Invalid"
 }
 
+func (opts *DisasmOptions) FormatInstruction(inst *Instruction) string {
+	if !inst.Legal {
+		if opts.style() == DisasmStyle_CA65 {
+			return opts.setCase(".byte ") + opts.hex8(inst.Opcode)
+		}
+		return opts.setCase("db  ") + opts.hex8(inst.Opcode)
+	}
+	return opts.setCase(inst.Mnemonic+" ") + opts.operand(inst)
+}
+
 func Disassemble(ctx CPUContext, addr uint16) (string, uint16, bool) {
-	info := &disasmData[ctx.Peek(addr)]
+	return DisassembleOpts(ctx, addr, nil)
+}
 
-	if info.mode == AddrMode_Invalid {
-		return fmt.Sprintf("db  $%02x", ctx.Peek(addr)), 1, false
-	}
-	return info.name + " " + addressModeToStr(info.mode, ctx, addr+1),
-		InstructionBytes(info.mode), true
+// disassembles the instruction at addr formatted according to opts.
+// returns the instruction text, its length and false if the opcode is invalid
+func DisassembleOpts(ctx CPUContext, addr uint16, opts *DisasmOptions) (string, uint16, bool) {
+	inst := Decode(ctx, addr)
+	return opts.FormatInstruction(&inst), inst.Length, inst.Legal
 }
@@ -0,0 +1,72 @@
+package core6502
+
+import (
+	"github.com/simulatedsimian/assert"
+	"testing"
+)
+
+func TestDisassembleOpts(t *testing.T) {
+	pack := assert.Pack
+
+	var ctx BasicCPUContext
+	ctx.Poke(0x400, 0xd0) // bne
+	ctx.Poke(0x401, 0xfc)
+	ctx.Poke(0x402, 0xad) // lda $c010
+	ctx.PokeWord(0x403, 0xc010)
+
+	st := NewSymbolTable()
+	st.Add("loop", 0x3fe)
+	st.Add("port", 0xc00e)
+
+	assert.Equal(t, pack(Disassemble(&ctx, 0x400)), []interface{}{"BNE -4", uint16(2), true})
+	assert.Equal(t, pack(DisassembleOpts(&ctx, 0x400, &DisasmOptions{AbsoluteBranches: true})),
+		[]interface{}{"BNE $03fe", uint16(2), true})
+	assert.Equal(t, pack(DisassembleOpts(&ctx, 0x400, &DisasmOptions{AbsoluteBranches: true, Symbols: st})),
+		[]interface{}{"BNE loop", uint16(2), true})
+	assert.Equal(t, pack(DisassembleOpts(&ctx, 0x402, &DisasmOptions{Symbols: st})),
+		[]interface{}{"LDA port+2", uint16(3), true})
+
+	opts := &DisasmOptions{Symbols: st}
+	assert.Equal(t, pack(opts.Label(0x3fe)), []interface{}{"loop:", true})
+	assert.Equal(t, pack(opts.Label(0x400)), []interface{}{"", false})
+}
+
+func TestDecode(t *testing.T) {
+	var ctx BasicCPUContext
+	ctx.Poke(0x400, 0x6c) // jmp ($fffc)
+	ctx.PokeWord(0x401, 0xfffc)
+	ctx.PokeWord(0xfffc, 0xe000)
+	ctx.Poke(0x403, 0x02)
+
+	inst := Decode(&ctx, 0x400)
+	assert.Equal(t, inst.Mnemonic, "JMP")
+	assert.Equal(t, inst.Mode, AddrMode_Indirect)
+	assert.Equal(t, inst.Operand, []uint8{0xfc, 0xff})
+	assert.Equal(t, inst.Value, uint16(0xfffc))
+	assert.Equal(t, inst.Target, uint16(0xe000))
+	assert.Equal(t, inst.HasTarget, true)
+	assert.Equal(t, inst.Length, uint16(3))
+	assert.Equal(t, inst.Legal, true)
+
+	inst = Decode(&ctx, 0x403)
+	assert.Equal(t, inst.Legal, false)
+	assert.Equal(t, inst.Length, uint16(1))
+}
+
+func TestFormatStyles(t *testing.T) {
+	var ctx BasicCPUContext
+	ctx.Poke(0x400, 0xbd) // lda $0010, x
+	ctx.PokeWord(0x401, 0x0010)
+	ctx.Poke(0x403, 0x02)
+
+	inst := Decode(&ctx, 0x400)
+	var plain *DisasmOptions
+	assert.Equal(t, plain.FormatInstruction(&inst), "LDA $0010, X")
+	assert.Equal(t, (&DisasmOptions{Style: DisasmStyle_CA65}).FormatInstruction(&inst), "LDA a:$0010,X")
+	assert.Equal(t, (&DisasmOptions{Case: DisasmCase_Lower}).FormatInstruction(&inst), "lda $0010, x")
+	assert.Equal(t, (&DisasmOptions{Case: DisasmCase_Upper}).FormatInstruction(&inst), "LDA $0010, X")
+
+	inst = Decode(&ctx, 0x403)
+	assert.Equal(t, plain.FormatInstruction(&inst), "db  $02")
+	assert.Equal(t, (&DisasmOptions{Style: DisasmStyle_CA65, Case: DisasmCase_Upper}).FormatInstruction(&inst), ".BYTE $02")
+}
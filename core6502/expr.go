@@ -0,0 +1,603 @@
+package core6502
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Resolves symbol names used in expressions to values
+type SymbolResolver interface {
+	ResolveSymbol(name string) (int, bool)
+}
+
+// Environment an expression is evaluated against.
+// Ctx supplies registers and memory, Symbols supplies named values.
+// Either may be nil, in which case references to them are errors.
+type ExprEnv struct {
+	Ctx     CPUContext
+	Symbols SymbolResolver
+}
+
+/*
+	A parsed expression. Supported syntax:
+
+	literals:   1234 $ffd2 0xffd2 %1010 'c'
+	registers:  a x y sp pc p, flags n v b d i z c (0 or 1)
+	memory:     [addr] (byte), w[addr] (word)
+	symbols:    any other name, * is the current pc
+	unary:      - + ~ ! < (low byte) > (high byte)
+	binary:     * / % + - << >> < <= > >= == != & ^ | && ||
+
+	Binary operators follow C precedence. Comparisons and logical
+	operators produce 0 or 1.
+*/
+type Expr struct {
+	src  string
+	root exprNode
+}
+
+// Parses s as a single expression
+func ParseExpr(s string) (*Expr, error) {
+	p := exprParser{lex: exprLexer{src: s}}
+	e, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, p.unexpected(tok)
+	}
+	return e, nil
+}
+
+/*
+	Parses the leading expression of s and returns it with the unparsed
+	remainder. Used to read several space separated expressions from one
+	line: an operator that is preceded by a space but not followed by one
+	(e.g. "$400 -1") is taken as the start of the next expression.
+*/
+func ParseExprPrefix(s string) (*Expr, string, error) {
+	p := exprParser{lex: exprLexer{src: s}, prefix: true}
+	e, err := p.parse()
+	if err != nil {
+		return nil, "", err
+	}
+	return e, s[p.peek().pos:], nil
+}
+
+// Parses and evaluates s
+func EvalExpr(s string, env *ExprEnv) (int, error) {
+	e, err := ParseExpr(s)
+	if err != nil {
+		return 0, err
+	}
+	return e.Eval(env)
+}
+
+func (e *Expr) Eval(env *ExprEnv) (int, error) {
+	if env == nil {
+		env = &ExprEnv{}
+	}
+	return e.root.eval(env)
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+// if the outermost operation of e is a unary operator, e.g. <label,
+// returns the operator and its operand
+func (e *Expr) Unary() (string, *Expr, bool) {
+	if n, ok := e.root.(*unaryNode); ok {
+		src := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(e.src), n.op))
+		return n.op, &Expr{src, n.val}, true
+	}
+	return "", nil, false
+}
+
+type exprNode interface {
+	eval(env *ExprEnv) (int, error)
+}
+
+type numberNode int
+
+func (n numberNode) eval(env *ExprEnv) (int, error) {
+	return int(n), nil
+}
+
+type nameNode string
+
+func (n nameNode) eval(env *ExprEnv) (int, error) {
+	name := string(n)
+	if env.Ctx != nil {
+		if val, ok := registerValue(env.Ctx, name); ok {
+			return val, nil
+		}
+	}
+	if env.Symbols != nil {
+		if val, ok := env.Symbols.ResolveSymbol(name); ok {
+			return val, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown Symbol: %s", name)
+}
+
+func registerValue(ctx CPUContext, name string) (int, bool) {
+	flag := func(mask uint8) (int, bool) {
+		if ctx.Flag(mask) {
+			return 1, true
+		}
+		return 0, true
+	}
+
+	switch strings.ToLower(name) {
+	case "a":
+		return int(ctx.RegA()), true
+	case "x":
+		return int(ctx.RegX()), true
+	case "y":
+		return int(ctx.RegY()), true
+	case "sp":
+		return int(ctx.RegSP()), true
+	case "pc", "*":
+		return int(ctx.RegPC()), true
+	case "p":
+		return int(ctx.Flags()), true
+	case "n":
+		return flag(Flag_N)
+	case "v":
+		return flag(Flag_V)
+	case "b":
+		return flag(Flag_B)
+	case "d":
+		return flag(Flag_D)
+	case "i":
+		return flag(Flag_I)
+	case "z":
+		return flag(Flag_Z)
+	case "c":
+		return flag(Flag_C)
+	}
+	return 0, false
+}
+
+type memoryNode struct {
+	addr exprNode
+	word bool
+}
+
+func (n *memoryNode) eval(env *ExprEnv) (int, error) {
+	addr, err := n.addr.eval(env)
+	if err != nil {
+		return 0, err
+	}
+	if env.Ctx == nil {
+		return 0, fmt.Errorf("Memory not available: $%04x", uint16(addr))
+	}
+	if n.word {
+		return int(env.Ctx.PeekWord(uint16(addr))), nil
+	}
+	return int(env.Ctx.Peek(uint16(addr))), nil
+}
+
+type unaryNode struct {
+	op  string
+	val exprNode
+}
+
+func (n *unaryNode) eval(env *ExprEnv) (int, error) {
+	val, err := n.val.eval(env)
+	if err != nil {
+		return 0, err
+	}
+
+	switch n.op {
+	case "-":
+		return -val, nil
+	case "+":
+		return val, nil
+	case "~":
+		return ^val, nil
+	case "!":
+		return boolToInt(val == 0), nil
+	case "<":
+		return val & 0xff, nil
+	case ">":
+		return (val >> 8) & 0xff, nil
+	}
+	panic("Invalid unary operator: " + n.op)
+}
+
+type binaryNode struct {
+	op          string
+	left, right exprNode
+}
+
+func (n *binaryNode) eval(env *ExprEnv) (int, error) {
+	l, err := n.left.eval(env)
+	if err != nil {
+		return 0, err
+	}
+
+	// logical operators short circuit
+	switch n.op {
+	case "&&":
+		if l == 0 {
+			return 0, nil
+		}
+	case "||":
+		if l != 0 {
+			return 1, nil
+		}
+	}
+
+	r, err := n.right.eval(env)
+	if err != nil {
+		return 0, err
+	}
+
+	switch n.op {
+	case "&&", "||":
+		return boolToInt(r != 0), nil
+	case "*":
+		return l * r, nil
+	case "/", "%":
+		if r == 0 {
+			return 0, fmt.Errorf("Division by zero")
+		}
+		if n.op == "/" {
+			return l / r, nil
+		}
+		return l % r, nil
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "<<", ">>":
+		if r < 0 {
+			return 0, fmt.Errorf("Negative shift count: %d", r)
+		}
+		if n.op == "<<" {
+			return l << uint(r), nil
+		}
+		return l >> uint(r), nil
+	case "<":
+		return boolToInt(l < r), nil
+	case "<=":
+		return boolToInt(l <= r), nil
+	case ">":
+		return boolToInt(l > r), nil
+	case ">=":
+		return boolToInt(l >= r), nil
+	case "==":
+		return boolToInt(l == r), nil
+	case "!=":
+		return boolToInt(l != r), nil
+	case "&":
+		return l & r, nil
+	case "^":
+		return l ^ r, nil
+	case "|":
+		return l | r, nil
+	}
+	panic("Invalid binary operator: " + n.op)
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokInvalid
+	tokNumber
+	tokName
+	tokOp
+)
+
+type token struct {
+	kind        tokenKind
+	text        string
+	val         int
+	pos         int
+	spaceBefore bool
+	spaceAfter  bool
+}
+
+type exprLexer struct {
+	src         string
+	pos         int
+	prevOperand bool
+}
+
+var exprOperators = []string{
+	"<<", ">>", "<=", ">=", "==", "!=", "&&", "||",
+	"+", "-", "*", "/", "%", "&", "|", "^", "~", "!", "<", ">", "=",
+	"(", ")", "[", "]",
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || c == '.' || c == '@' || c < 0x80 && unicode.IsLetter(rune(c))
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t'
+}
+
+func (l *exprLexer) next() (token, error) {
+	start := l.pos
+	for l.pos < len(l.src) && isSpace(l.src[l.pos]) {
+		l.pos++
+	}
+
+	tok := token{pos: l.pos, spaceBefore: l.pos > start}
+	if l.pos >= len(l.src) {
+		tok.kind = tokEOF
+		return tok, nil
+	}
+
+	s := l.src[l.pos:]
+	c := s[0]
+	n := 0
+	var err error
+
+	switch {
+	case c == '$':
+		n = 1 + scanDigits(s[1:], 16)
+		tok.kind = tokNumber
+		tok.val, err = parseLiteral(s[1:n], 16)
+
+	case c == '%' && !l.prevOperand && len(s) > 1 && (s[1] == '0' || s[1] == '1'):
+		n = 1 + scanDigits(s[1:], 2)
+		tok.kind = tokNumber
+		tok.val, err = parseLiteral(s[1:n], 2)
+
+	case c == '0' && len(s) > 2 && (s[1] == 'x' || s[1] == 'X'):
+		n = 2 + scanDigits(s[2:], 16)
+		tok.kind = tokNumber
+		tok.val, err = parseLiteral(s[2:n], 16)
+
+	case c >= '0' && c <= '9':
+		n = scanDigits(s, 10)
+		tok.kind = tokNumber
+		tok.val, err = parseLiteral(s[:n], 10)
+
+	case c == '\'':
+		if len(s) < 3 || s[2] != '\'' {
+			return tok, fmt.Errorf("Invalid character literal: %s", s)
+		}
+		n = 3
+		tok.kind = tokNumber
+		tok.val = int(s[1])
+
+	case isNameStart(c):
+		for n < len(s) && (isNameChar(s[n]) || strings.HasPrefix(s[n:], "::")) {
+			if s[n] == ':' {
+				n++
+			}
+			n++
+		}
+		tok.kind = tokName
+
+	default:
+		for _, op := range exprOperators {
+			if strings.HasPrefix(s, op) {
+				n = len(op)
+				tok.kind = tokOp
+				break
+			}
+		}
+		if n == 0 {
+			return tok, fmt.Errorf("Unexpected character '%c' in expression: %s", c, l.src)
+		}
+	}
+
+	if err != nil {
+		return tok, err
+	}
+
+	tok.text = s[:n]
+	l.pos += n
+	tok.spaceAfter = l.pos < len(l.src) && isSpace(l.src[l.pos])
+	l.prevOperand = tok.kind == tokNumber || tok.kind == tokName || tok.text == ")" || tok.text == "]"
+	return tok, nil
+}
+
+func scanDigits(s string, base int) int {
+	n := 0
+	for n < len(s) {
+		c := unicode.ToLower(rune(s[n]))
+		var digit int
+		switch {
+		case c >= '0' && c <= '9':
+			digit = int(c - '0')
+		case c >= 'a' && c <= 'z':
+			digit = int(c-'a') + 10
+		default:
+			return n
+		}
+		if digit >= base {
+			return n
+		}
+		n++
+	}
+	return n
+}
+
+func parseLiteral(s string, base int) (int, error) {
+	if len(s) == 0 {
+		return 0, fmt.Errorf("Missing digits in number")
+	}
+	val, err := strconv.ParseUint(s, base, 32)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid number: %s", s)
+	}
+	return int(val), nil
+}
+
+type exprParser struct {
+	lex    exprLexer
+	tok    *token
+	err    error
+	prefix bool
+}
+
+func (p *exprParser) peek() token {
+	if p.tok == nil {
+		tok, err := p.lex.next()
+		if err != nil {
+			// reported by unexpected() if the parser tries to consume it
+			tok.kind = tokInvalid
+			p.err = err
+		}
+		p.tok = &tok
+	}
+	return *p.tok
+}
+
+func (p *exprParser) next() token {
+	tok := p.peek()
+	p.tok = nil
+	return tok
+}
+
+func (p *exprParser) expect(text string) error {
+	if tok := p.next(); tok.kind != tokOp || tok.text != text {
+		return p.unexpected(tok)
+	}
+	return nil
+}
+
+func (p *exprParser) unexpected(tok token) error {
+	switch tok.kind {
+	case tokEOF:
+		return fmt.Errorf("Unexpected end of expression: %s", p.lex.src)
+	case tokInvalid:
+		return p.err
+	}
+	return fmt.Errorf("Unexpected '%s' in expression: %s", tok.text, p.lex.src)
+}
+
+func (p *exprParser) parse() (*Expr, error) {
+	start := p.peek().pos
+	root, err := p.parseBinary(1)
+	if err != nil {
+		return nil, err
+	}
+	return &Expr{strings.TrimSpace(p.lex.src[start:p.peek().pos]), root}, nil
+}
+
+var binaryPrecedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"|":  3,
+	"^":  4,
+	"&":  5,
+	"==": 6, "!=": 6, "=": 6,
+	"<": 7, "<=": 7, ">": 7, ">=": 7,
+	"<<": 8, ">>": 8,
+	"+": 9, "-": 9,
+	"*": 10, "/": 10, "%": 10,
+}
+
+var unaryOperators = "-+~!<>"
+
+func (p *exprParser) parseBinary(minPrec int) (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		tok := p.peek()
+		prec, ok := binaryPrecedence[tok.text]
+		if tok.kind != tokOp || !ok || prec < minPrec {
+			return left, nil
+		}
+		if p.prefix && tok.spaceBefore && !tok.spaceAfter && strings.Contains(unaryOperators, tok.text) {
+			return left, nil
+		}
+		p.next()
+
+		right, err := p.parseBinary(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		op := tok.text
+		if op == "=" {
+			op = "=="
+		}
+		left = &binaryNode{op, left, right}
+	}
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	tok := p.peek()
+	if tok.kind == tokOp && len(tok.text) == 1 && strings.Contains(unaryOperators, tok.text) {
+		p.next()
+		val, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{tok.text, val}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	tok := p.next()
+
+	switch tok.kind {
+	case tokNumber:
+		return numberNode(tok.val), nil
+
+	case tokName:
+		if tok.text == "w" || tok.text == "W" {
+			if next := p.peek(); next.text == "[" && !next.spaceBefore {
+				p.next()
+				return p.parseMemory(true)
+			}
+		}
+		return nameNode(tok.text), nil
+
+	case tokOp:
+		switch tok.text {
+		case "*":
+			return nameNode("*"), nil
+		case "[":
+			return p.parseMemory(false)
+		case "(":
+			val, err := p.parseBinary(1)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return val, nil
+		}
+	}
+	return nil, p.unexpected(tok)
+}
+
+func (p *exprParser) parseMemory(word bool) (exprNode, error) {
+	addr, err := p.parseBinary(1)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("]"); err != nil {
+		return nil, err
+	}
+	return &memoryNode{addr, word}, nil
+}
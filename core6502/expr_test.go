@@ -0,0 +1,106 @@
+package core6502
+
+import (
+	"github.com/simulatedsimian/assert"
+	"testing"
+)
+
+type testSymbols map[string]int
+
+func (s testSymbols) ResolveSymbol(name string) (int, bool) {
+	val, ok := s[name]
+	return val, ok
+}
+
+func TestEvalExprLiterals(t *testing.T) {
+	pack := assert.Pack
+
+	assert.Equal(t, pack(EvalExpr("1234", nil))[0], 1234)
+	assert.Equal(t, pack(EvalExpr("$ffd2", nil))[0], 0xffd2)
+	assert.Equal(t, pack(EvalExpr("0x10", nil))[0], 0x10)
+	assert.Equal(t, pack(EvalExpr("%1010", nil))[0], 10)
+	assert.Equal(t, pack(EvalExpr("'A'", nil))[0], 0x41)
+
+	assert.HasError(t, pack(EvalExpr("$", nil)))
+	assert.HasError(t, pack(EvalExpr("'AB'", nil)))
+	assert.HasError(t, pack(EvalExpr("", nil)))
+}
+
+func TestEvalExprOperators(t *testing.T) {
+	pack := assert.Pack
+
+	assert.Equal(t, pack(EvalExpr("1 + 2 * 3", nil))[0], 7)
+	assert.Equal(t, pack(EvalExpr("(1 + 2) * 3", nil))[0], 9)
+	assert.Equal(t, pack(EvalExpr("7 % 4", nil))[0], 3)
+	assert.Equal(t, pack(EvalExpr("7%%11", nil))[0], 1)
+	assert.Equal(t, pack(EvalExpr("<$1234", nil))[0], 0x34)
+	assert.Equal(t, pack(EvalExpr(">$1234", nil))[0], 0x12)
+	assert.Equal(t, pack(EvalExpr("1 << 4 | 1", nil))[0], 0x11)
+	assert.Equal(t, pack(EvalExpr("~0 & $ff", nil))[0], 0xff)
+	assert.Equal(t, pack(EvalExpr("-1", nil))[0], -1)
+	assert.Equal(t, pack(EvalExpr("3 < 4 && 4 >= 4", nil))[0], 1)
+	assert.Equal(t, pack(EvalExpr("!(3 == 3) || 0", nil))[0], 0)
+	assert.Equal(t, pack(EvalExpr("2 = 2", nil))[0], 1)
+
+	assert.HasError(t, pack(EvalExpr("1 / 0", nil)))
+	assert.HasError(t, pack(EvalExpr("1 +", nil)))
+	assert.HasError(t, pack(EvalExpr("(1", nil)))
+	assert.HasError(t, pack(EvalExpr("1 2", nil)))
+}
+
+func TestEvalExprContext(t *testing.T) {
+	pack := assert.Pack
+
+	var ctx BasicCPUContext
+	ctx.SetRegA(0x20)
+	ctx.SetRegX(2)
+	ctx.SetRegPC(0x400)
+	ctx.SetFlag(Flag_C, true)
+	ctx.Poke(0xfb, 4)
+	ctx.PokeWord(0x10, 0xc000)
+
+	env := &ExprEnv{Ctx: &ctx, Symbols: testSymbols{"ptr": 0x10, "loop": 0x410}}
+
+	assert.Equal(t, pack(EvalExpr("a == $20 && [$fb] > 3", env))[0], 1)
+	assert.Equal(t, pack(EvalExpr("w[ptr] + x", env))[0], 0xc002)
+	assert.Equal(t, pack(EvalExpr("[ptr+1]", env))[0], 0xc0)
+	assert.Equal(t, pack(EvalExpr("c + z", env))[0], 1)
+	assert.Equal(t, pack(EvalExpr("loop - pc", env))[0], 0x10)
+	assert.Equal(t, pack(EvalExpr("* + 2", env))[0], 0x402)
+
+	assert.HasError(t, pack(EvalExpr("missing", env)))
+	assert.HasError(t, pack(EvalExpr("[0]", nil)))
+	assert.HasError(t, pack(EvalExpr("a", nil)))
+}
+
+func TestParseExprPrefix(t *testing.T) {
+	e, rest, err := ParseExprPrefix("$400 + 2 $ff")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, e.String(), "$400 + 2")
+	assert.Equal(t, rest, "$ff")
+
+	e, rest, err = ParseExprPrefix("$400 -1")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, e.String(), "$400")
+	assert.Equal(t, rest, "-1")
+
+	e, rest, err = ParseExprPrefix("$400-1 <label")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, e.String(), "$400-1")
+	assert.Equal(t, rest, "<label")
+}
+
+func TestExprUnary(t *testing.T) {
+	pack := assert.Pack
+
+	e, _ := ParseExpr(">(label + 2)")
+	op, operand, ok := e.Unary()
+	assert.Equal(t, op, ">")
+	assert.Equal(t, operand.String(), "(label + 2)")
+	assert.Equal(t, ok, true)
+	assert.Equal(t, pack(operand.Eval(&ExprEnv{Symbols: testSymbols{"label": 0x1234}})), []interface{}{0x1236, nil})
+
+	e, _ = ParseExpr("<label + 1")
+	_, _, ok = e.Unary()
+	assert.Equal(t, ok, false)
+}
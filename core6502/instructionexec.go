@@ -26,124 +26,20 @@ type InstructionExecFunc func(ctx CPUContext) int
 type ExecFuncMakerFunc func(InstructionInfo *InstructionInfo) InstructionExecFunc
 
 type InstructionInfo struct {
-	opcode    uint8
-	execMaker ExecFuncMakerFunc
-	tstates   int
-	mode      AddressMode
-}
-
-var InstructionData = []InstructionInfo{
-	{0xa9, LDA, 2, AddrMode_Immediate},
-	{0xa5, LDA, 3, AddrMode_AbsoluteZeroPage},
-	{0xb5, LDA, 4, AddrMode_ZeroPageIdxX},
-	{0xa1, LDA, 6, AddrMode_PostIndexIndirect},
-	{0xb1, LDA, 5, AddrMode_PreIndexIndirect},
-	{0xad, LDA, 4, AddrMode_Absolute},
-	{0xbd, LDA, 4, AddrMode_AbsoluteIndexedX},
-	{0xb9, LDA, 4, AddrMode_AbsoluteIndexedY},
-	{0xa2, LDX, 2, AddrMode_Immediate},
-	{0xa6, LDX, 3, AddrMode_AbsoluteZeroPage},
-	{0xb6, LDX, 4, AddrMode_ZeroPageIdxY},
-	{0xae, LDX, 4, AddrMode_Absolute},
-	{0xbe, LDX, 4, AddrMode_AbsoluteIndexedY},
-	{0xa0, LDY, 2, AddrMode_Immediate},
-	{0xa4, LDY, 3, AddrMode_AbsoluteZeroPage},
-	{0xb4, LDY, 4, AddrMode_ZeroPageIdxX},
-	{0xac, LDY, 4, AddrMode_Absolute},
-	{0xbc, LDY, 4, AddrMode_AbsoluteIndexedX},
-	{0x85, STA, 3, AddrMode_AbsoluteZeroPage},
-	{0x95, STA, 4, AddrMode_ZeroPageIdxX},
-	{0x81, STA, 6, AddrMode_PostIndexIndirect},
-	{0x91, STA, 6, AddrMode_PreIndexIndirect},
-	{0x8d, STA, 4, AddrMode_Absolute},
-	{0x9d, STA, 5, AddrMode_AbsoluteIndexedX},
-	{0x99, STA, 5, AddrMode_AbsoluteIndexedY},
-	{0x86, STX, 3, AddrMode_AbsoluteZeroPage},
-	{0x96, STX, 4, AddrMode_ZeroPageIdxY},
-	{0x8e, STX, 4, AddrMode_Absolute},
-	{0x84, STY, 3, AddrMode_AbsoluteZeroPage},
-	{0x94, STY, 4, AddrMode_ZeroPageIdxX},
-	{0x8c, STY, 4, AddrMode_Absolute},
-	{0xe6, INC, 4, AddrMode_AbsoluteZeroPage},
-	{0xf6, INC, 4, AddrMode_ZeroPageIdxX},
-	{0xee, INC, 4, AddrMode_Absolute},
-	{0xfe, INC, 4, AddrMode_AbsoluteIndexedX},
-	{0xe8, INX, 4, AddrMode_Implicit},
-	{0xc8, INY, 4, AddrMode_Implicit},
-	{0xc6, DEC, 4, AddrMode_AbsoluteZeroPage},
-	{0xd6, DEC, 4, AddrMode_ZeroPageIdxX},
-	{0xce, DEC, 4, AddrMode_Absolute},
-	{0xde, DEC, 4, AddrMode_AbsoluteIndexedX},
-	{0xca, DEX, 4, AddrMode_Implicit},
-	{0x88, DEY, 4, AddrMode_Implicit},
-	{0xaa, TAX, 2, AddrMode_Implicit},
-	{0xa8, TAY, 2, AddrMode_Implicit},
-	{0xba, TSX, 2, AddrMode_Implicit},
-	{0x8a, TXA, 2, AddrMode_Implicit},
-	{0x9a, TXS, 2, AddrMode_Implicit},
-	{0x98, TYA, 2, AddrMode_Implicit},
-	{0x18, CLC, 2, AddrMode_Implicit},
-	{0x38, SEC, 2, AddrMode_Implicit},
-	{0xD8, CLD, 2, AddrMode_Implicit},
-	{0xF8, SED, 2, AddrMode_Implicit},
-	{0x58, CLI, 2, AddrMode_Implicit},
-	{0x78, SEI, 2, AddrMode_Implicit},
-	{0xB8, CLV, 2, AddrMode_Implicit},
-	{0xea, NOP, 1, AddrMode_Implicit},
-	{0x00, BRK, 7, AddrMode_Implicit},
-	{0x48, PHA, 3, AddrMode_Implicit},
-	{0x68, PLA, 4, AddrMode_Implicit},
-	{0x08, PHP, 3, AddrMode_Implicit},
-	{0x28, PLP, 4, AddrMode_Implicit},
-
-	{0x10, BPL, 2, AddrMode_Relative},
-	{0x30, BMI, 2, AddrMode_Relative},
-	{0x50, BVC, 2, AddrMode_Relative},
-	{0x70, BVS, 2, AddrMode_Relative},
-	{0x90, BCC, 2, AddrMode_Relative},
-	{0xB0, BCS, 2, AddrMode_Relative},
-	{0xD0, BNE, 2, AddrMode_Relative},
-	{0xF0, BEQ, 2, AddrMode_Relative},
-
-	{0x20, JSR, 6, AddrMode_Absolute},
-	{0x60, RTS, 6, AddrMode_Implicit},
-	{0x4C, JMP, 3, AddrMode_Absolute},
-	{0x6C, JMP, 5, AddrMode_Indirect},
-
-	{0x09, ORA, 5, AddrMode_Immediate},
-	{0x05, ORA, 5, AddrMode_AbsoluteZeroPage},
-	{0x15, ORA, 5, AddrMode_ZeroPageIdxX},
-	{0x01, ORA, 5, AddrMode_PostIndexIndirect},
-	{0x11, ORA, 5, AddrMode_PreIndexIndirect},
-	{0x0D, ORA, 5, AddrMode_Absolute},
-	{0x1D, ORA, 5, AddrMode_AbsoluteIndexedX},
-	{0x19, ORA, 5, AddrMode_AbsoluteIndexedY},
-
-	{0x29, AND, 5, AddrMode_Immediate},
-	{0x25, AND, 5, AddrMode_AbsoluteZeroPage},
-	{0x35, AND, 5, AddrMode_ZeroPageIdxX},
-	{0x21, AND, 5, AddrMode_PostIndexIndirect},
-	{0x31, AND, 5, AddrMode_PreIndexIndirect},
-	{0x2D, AND, 5, AddrMode_Absolute},
-	{0x3D, AND, 5, AddrMode_AbsoluteIndexedX},
-	{0x39, AND, 5, AddrMode_AbsoluteIndexedY},
-
-	{0x49, EOR, 5, AddrMode_Immediate},
-	{0x45, EOR, 5, AddrMode_AbsoluteZeroPage},
-	{0x55, EOR, 5, AddrMode_ZeroPageIdxX},
-	{0x41, EOR, 5, AddrMode_PostIndexIndirect},
-	{0x51, EOR, 5, AddrMode_PreIndexIndirect},
-	{0x4D, EOR, 5, AddrMode_Absolute},
-	{0x5D, EOR, 5, AddrMode_AbsoluteIndexedX},
-	{0x59, EOR, 5, AddrMode_AbsoluteIndexedY},
+	opcode   uint8
+	mnemonic string
+	tstates  int
+	mode     AddressMode
 }
 
 var executors [256]InstructionExecFunc
 
 func init() {
-	for n := 0; n < len(InstructionData); n++ {
-		info := &InstructionData[n]
-		executors[info.opcode] = info.execMaker(info)
+	for _, op := range opcodeTable {
+		execMaker := mnemonicData[op.Mnemonic].execMaker
+		if op.Documented && execMaker != nil {
+			executors[op.Opcode] = execMaker(&InstructionInfo{op.Opcode, op.Mnemonic, op.Cycles, op.Mode})
+		}
 	}
 }
 
@@ -159,10 +55,10 @@ func INC(info *InstructionInfo) InstructionExecFunc {
 	length := InstructionBytes(info.mode)
 
 	return func(ctx CPUContext) int {
-		val, exclock := readFunc(ctx)
+		val, _ := readFunc(ctx) // no page crossing cycle, it is in the base count
 		writeFunc(ctx, setFlagsFromValue(ctx, val+1))
 		ctx.SetRegPC(ctx.RegPC() + length)
-		return info.tstates + exclock
+		return info.tstates
 	}
 }
 
@@ -192,10 +88,10 @@ func DEC(info *InstructionInfo) InstructionExecFunc {
 	length := InstructionBytes(info.mode)
 
 	return func(ctx CPUContext) int {
-		val, exclock := readFunc(ctx)
+		val, _ := readFunc(ctx) // no page crossing cycle, it is in the base count
 		writeFunc(ctx, setFlagsFromValue(ctx, val-1))
 		ctx.SetRegPC(ctx.RegPC() + length)
-		return info.tstates + exclock
+		return info.tstates
 	}
 }
 
@@ -237,7 +133,7 @@ func LDX(info *InstructionInfo) InstructionExecFunc {
 
 	return func(ctx CPUContext) int {
 		val, exclock := readFunc(ctx)
-		ctx.SetRegY(setFlagsFromValue(ctx, val))
+		ctx.SetRegX(setFlagsFromValue(ctx, val))
 		ctx.SetRegPC(ctx.RegPC() + length)
 		return info.tstates + exclock
 	}
@@ -427,10 +323,11 @@ func NOP(info *InstructionInfo) InstructionExecFunc {
 	}
 }
 
+// pushes PC+2, skipping the padding byte after the BRK, and the flags with B set
 func BRK(info *InstructionInfo) InstructionExecFunc {
 
 	return func(ctx CPUContext) int {
-		Push16(ctx, ctx.RegPC()+1)
+		Push16(ctx, ctx.RegPC()+2)
 		ctx.SetFlag(Flag_B, true)
 		Push8(ctx, ctx.Flags())
 		ctx.SetFlag(Flag_I, true)
@@ -439,6 +336,28 @@ func BRK(info *InstructionInfo) InstructionExecFunc {
 	}
 }
 
+// delivers an interrupt through vector before the instruction at the PC,
+// pushing the PC and the flags with B clear. returns the cycles taken
+func interrupt(ctx CPUContext, vector uint16) int {
+	Push16(ctx, ctx.RegPC())
+	Push8(ctx, ctx.Flags()&^Flag_B)
+	ctx.SetFlag(Flag_I, true)
+	ctx.SetRegPC(ctx.PeekWord(vector))
+	return 7
+}
+
+// delivers an IRQ, returns false if it is masked by the I flag
+func IRQ(ctx CPUContext) (int, bool) {
+	if ctx.Flag(Flag_I) {
+		return 0, false
+	}
+	return interrupt(ctx, Vector_IRQ), true
+}
+
+func NMI(ctx CPUContext) int {
+	return interrupt(ctx, Vector_NMI)
+}
+
 func PHA(info *InstructionInfo) InstructionExecFunc {
 	length := InstructionBytes(info.mode)
 
@@ -574,6 +493,15 @@ func RTS(info *InstructionInfo) InstructionExecFunc {
 	}
 }
 
+func RTI(info *InstructionInfo) InstructionExecFunc {
+
+	return func(ctx CPUContext) int {
+		ctx.SetFlags(Pop8(ctx))
+		ctx.SetRegPC(Pop16(ctx))
+		return info.tstates
+	}
+}
+
 func ORA(info *InstructionInfo) InstructionExecFunc {
 	readFunc := GetReadFunc(info.mode)
 	length := InstructionBytes(info.mode)
@@ -609,3 +537,134 @@ func EOR(info *InstructionInfo) InstructionExecFunc {
 		return info.tstates + exclock
 	}
 }
+
+// adds val and the carry to A, setting C, V, N and Z. decimal mode is not
+// emulated, as on the NES
+func addWithCarry(ctx CPUContext, val uint8) {
+	a := ctx.RegA()
+	res, carry := AddWithCarry8(a, val, ctx.Flag(Flag_C))
+	ctx.SetFlag(Flag_C, carry)
+	ctx.SetFlag(Flag_V, (a^res)&(val^res)&0x80 != 0)
+	ctx.SetRegA(setFlagsFromValue(ctx, res))
+}
+
+func ADC(info *InstructionInfo) InstructionExecFunc {
+	readFunc := GetReadFunc(info.mode)
+	length := InstructionBytes(info.mode)
+
+	return func(ctx CPUContext) int {
+		val, exclock := readFunc(ctx)
+		addWithCarry(ctx, val)
+		ctx.SetRegPC(ctx.RegPC() + length)
+		return info.tstates + exclock
+	}
+}
+
+// A - val - !C is A + ^val + C, with C clear on a borrow
+func SBC(info *InstructionInfo) InstructionExecFunc {
+	readFunc := GetReadFunc(info.mode)
+	length := InstructionBytes(info.mode)
+
+	return func(ctx CPUContext) int {
+		val, exclock := readFunc(ctx)
+		addWithCarry(ctx, ^val)
+		ctx.SetRegPC(ctx.RegPC() + length)
+		return info.tstates + exclock
+	}
+}
+
+// sets N and Z from reg - val, and C if there is no borrow
+func makeCompareExecFunc(info *InstructionInfo, regFunc func(CPUContext) uint8) InstructionExecFunc {
+	readFunc := GetReadFunc(info.mode)
+	length := InstructionBytes(info.mode)
+
+	return func(ctx CPUContext) int {
+		val, exclock := readFunc(ctx)
+		res, borrow := SubWithCarry8(regFunc(ctx), val, false)
+		ctx.SetFlag(Flag_C, !borrow)
+		setFlagsFromValue(ctx, res)
+		ctx.SetRegPC(ctx.RegPC() + length)
+		return info.tstates + exclock
+	}
+}
+
+func CMP(info *InstructionInfo) InstructionExecFunc {
+	return makeCompareExecFunc(info, func(ctx CPUContext) uint8 {
+		return ctx.RegA()
+	})
+}
+
+func CPX(info *InstructionInfo) InstructionExecFunc {
+	return makeCompareExecFunc(info, func(ctx CPUContext) uint8 {
+		return ctx.RegX()
+	})
+}
+
+func CPY(info *InstructionInfo) InstructionExecFunc {
+	return makeCompareExecFunc(info, func(ctx CPUContext) uint8 {
+		return ctx.RegY()
+	})
+}
+
+// Z from A & val, N and V from bits 7 and 6 of val
+func BIT(info *InstructionInfo) InstructionExecFunc {
+	readFunc := GetReadFunc(info.mode)
+	length := InstructionBytes(info.mode)
+
+	return func(ctx CPUContext) int {
+		val, exclock := readFunc(ctx)
+		ctx.SetFlag(Flag_Z, ctx.RegA()&val == 0)
+		ctx.SetFlag(Flag_N, val&0x80 != 0)
+		ctx.SetFlag(Flag_V, val&0x40 != 0)
+		ctx.SetRegPC(ctx.RegPC() + length)
+		return info.tstates + exclock
+	}
+}
+
+// shiftFunc shifts a value given the carry, returning the result and the
+// new carry. the implicit mode shifts A
+func makeShiftExecFunc(info *InstructionInfo, shiftFunc func(uint8, bool) (uint8, bool)) InstructionExecFunc {
+	length := InstructionBytes(info.mode)
+
+	if info.mode == AddrMode_Implicit {
+		return func(ctx CPUContext) int {
+			res, carry := shiftFunc(ctx.RegA(), ctx.Flag(Flag_C))
+			ctx.SetFlag(Flag_C, carry)
+			ctx.SetRegA(setFlagsFromValue(ctx, res))
+			ctx.SetRegPC(ctx.RegPC() + length)
+			return info.tstates
+		}
+	}
+
+	readFunc := GetReadFunc(info.mode)
+	writeFunc := GetWriteFunc(info.mode)
+
+	return func(ctx CPUContext) int {
+		val, _ := readFunc(ctx) // no page crossing cycle, it is in the base count
+		res, carry := shiftFunc(val, ctx.Flag(Flag_C))
+		ctx.SetFlag(Flag_C, carry)
+		writeFunc(ctx, setFlagsFromValue(ctx, res))
+		ctx.SetRegPC(ctx.RegPC() + length)
+		return info.tstates
+	}
+}
+
+func ASL(info *InstructionInfo) InstructionExecFunc {
+	return makeShiftExecFunc(info, func(val uint8, carry bool) (uint8, bool) {
+		return LogicalShiftLeft8(val, false)
+	})
+}
+
+func LSR(info *InstructionInfo) InstructionExecFunc {
+	return makeShiftExecFunc(info, func(val uint8, carry bool) (uint8, bool) {
+		return LogicalShiftRight8(val, false)
+	})
+}
+
+func ROL(info *InstructionInfo) InstructionExecFunc {
+	return makeShiftExecFunc(info, LogicalShiftLeft8)
+}
+
+func ROR(info *InstructionInfo) InstructionExecFunc {
+	return makeShiftExecFunc(info, LogicalShiftRight8)
+}
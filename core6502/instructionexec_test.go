@@ -0,0 +1,212 @@
+package core6502
+
+import (
+	"github.com/simulatedsimian/assert"
+	"testing"
+)
+
+// the (zp,X) and (zp),Y modes, and base and page crossing cycle counts
+func TestExecute(t *testing.T) {
+	pack := assert.Pack
+
+	tests := []struct {
+		code   []uint8
+		cycles int
+		a      uint8
+		addr   uint16
+		val    uint8
+	}{
+		{[]uint8{0xa1, 0x10}, 6, 0x55, 0x0300, 0x55},       // lda ($10,x)
+		{[]uint8{0xb1, 0x10}, 5, 0xaa, 0x0202, 0xaa},       // lda ($10),y
+		{[]uint8{0x81, 0x10}, 6, 0x01, 0x0300, 0x01},       // sta ($10,x)
+		{[]uint8{0x91, 0x10}, 6, 0x01, 0x0202, 0x01},       // sta ($10),y
+		{[]uint8{0x09, 0x0f}, 2, 0x0f, 0x0300, 0x55},       // ora #$0f
+		{[]uint8{0x01, 0x10}, 6, 0x55, 0x0300, 0x55},       // ora ($10,x)
+		{[]uint8{0x11, 0x10}, 5, 0xab, 0x0202, 0xaa},       // ora ($10),y
+		{[]uint8{0x2d, 0x00, 0x03}, 4, 0x01, 0x0300, 0x55}, // and $0300
+		{[]uint8{0xbd, 0x00, 0x03}, 4, 0x33, 0x0302, 0x33}, // lda $0300,x
+		{[]uint8{0xb9, 0x00, 0x02}, 4, 0xaa, 0x0202, 0xaa}, // lda $0200,y
+		{[]uint8{0x9d, 0x00, 0x03}, 5, 0x01, 0x0302, 0x01}, // sta $0300,x
+		{[]uint8{0x99, 0x00, 0x02}, 5, 0x01, 0x0202, 0x01}, // sta $0200,y
+		{[]uint8{0xbd, 0xfe, 0x02}, 5, 0x55, 0x0300, 0x55}, // lda $02fe,x crossing a page
+		{[]uint8{0xb9, 0xfe, 0x02}, 5, 0x55, 0x0300, 0x55}, // lda $02fe,y crossing a page
+		{[]uint8{0xb1, 0x14}, 6, 0x55, 0x0300, 0x55},       // lda ($14),y crossing a page
+		{[]uint8{0x1d, 0xfe, 0x02}, 5, 0x55, 0x0300, 0x55}, // ora $02fe,x crossing a page
+		{[]uint8{0x9d, 0xfe, 0x02}, 5, 0x01, 0x0300, 0x01}, // sta $02fe,x
+		{[]uint8{0xfe, 0xfe, 0x02}, 7, 0x01, 0x0300, 0x56}, // inc $02fe,x
+		{[]uint8{0x55, 0x20}, 4, 0x01, 0x0300, 0x55},       // eor $20,x
+		{[]uint8{0xe6, 0x20}, 5, 0x01, 0x0020, 0x01},       // inc $20
+		{[]uint8{0xee, 0x00, 0x03}, 6, 0x01, 0x0300, 0x56}, // inc $0300
+		{[]uint8{0xd6, 0x20}, 6, 0x01, 0x0022, 0xff},       // dec $20,x
+		{[]uint8{0xe8}, 2, 0x01, 0x0300, 0x55},             // inx
+		{[]uint8{0x88}, 2, 0x01, 0x0300, 0x55},             // dey
+		{[]uint8{0xea}, 2, 0x01, 0x0300, 0x55},             // nop
+	}
+
+	for _, test := range tests {
+		var ctx BasicCPUContext
+		ctx.PokeWord(0x10, 0x0200)
+		ctx.PokeWord(0x12, 0x0300)
+		ctx.PokeWord(0x14, 0x02fe)
+		ctx.Poke(0x0202, 0xaa)
+		ctx.Poke(0x0300, 0x55)
+		ctx.Poke(0x0302, 0x33)
+		ctx.SetRegA(0x01)
+		ctx.SetRegX(2)
+		ctx.SetRegY(2)
+		for n, b := range test.code {
+			ctx.Poke(0x0400+uint16(n), b)
+		}
+		ctx.SetRegPC(0x0400)
+
+		cycles, err := Execute(&ctx)
+		assert.NoError(t, pack(err))
+		assert.Equal(t, cycles, test.cycles)
+		assert.Equal(t, ctx.RegPC(), 0x0400+uint16(len(test.code)))
+		assert.Equal(t, ctx.RegA(), test.a)
+		assert.Equal(t, ctx.Peek(test.addr), test.val)
+	}
+}
+
+// arithmetic, compare, BIT and shifts, with A, the flags and the byte at $10
+func TestALU(t *testing.T) {
+	pack := assert.Pack
+
+	tests := []struct {
+		code         []uint8
+		a, p, val    uint8 // before
+		wantA, wantP uint8
+		wantVal      uint8
+	}{
+		{[]uint8{0x69, 0x01}, 0x7f, 0, 0, 0x80, Flag_N | Flag_V, 0},                // adc #$01
+		{[]uint8{0x65, 0x10}, 0xff, Flag_C, 0x01, 0x01, Flag_C, 0x01},              // adc $10
+		{[]uint8{0x69, 0x80}, 0x80, 0, 0, 0x00, Flag_Z | Flag_V | Flag_C, 0},       // adc #$80
+		{[]uint8{0xe9, 0x01}, 0x00, Flag_C, 0, 0xff, Flag_N, 0},                    // sbc #$01
+		{[]uint8{0xe9, 0x01}, 0x80, Flag_C, 0, 0x7f, Flag_V | Flag_C, 0},           // sbc #$01
+		{[]uint8{0xe5, 0x10}, 0x05, 0, 0x02, 0x02, Flag_C, 0x02},                   // sbc $10
+		{[]uint8{0xc9, 0x05}, 0x05, 0, 0, 0x05, Flag_Z | Flag_C, 0},                // cmp #$05
+		{[]uint8{0xc5, 0x10}, 0x04, Flag_C, 0x05, 0x04, Flag_N, 0x05},              // cmp $10
+		{[]uint8{0xe0, 0x02}, 0, 0, 0, 0, Flag_Z | Flag_C, 0},                      // cpx #$02
+		{[]uint8{0xc4, 0x10}, 0, 0, 0x10, 0, Flag_C, 0x10},                         // cpy $10
+		{[]uint8{0x24, 0x10}, 0x01, 0, 0xc0, 0x01, Flag_N | Flag_V | Flag_Z, 0xc0}, // bit $10
+		{[]uint8{0x2c, 0x10, 0x00}, 0x41, Flag_N, 0x41, 0x41, Flag_V, 0x41},        // bit $0010
+		{[]uint8{0x0a}, 0x81, 0, 0, 0x02, Flag_C, 0},                               // asl
+		{[]uint8{0x4a}, 0x01, 0, 0, 0x00, Flag_Z | Flag_C, 0},                      // lsr
+		{[]uint8{0x2a}, 0x80, Flag_C, 0, 0x01, Flag_C, 0},                          // rol
+		{[]uint8{0x6a}, 0x01, Flag_C, 0, 0x80, Flag_N | Flag_C, 0},                 // ror
+		{[]uint8{0x06, 0x10}, 0, 0, 0x40, 0, Flag_N, 0x80},                         // asl $10
+		{[]uint8{0x56, 0x0e}, 0, Flag_C, 0x03, 0, Flag_C, 0x01},                    // lsr $0e,x
+		{[]uint8{0x2e, 0x10, 0x00}, 0, Flag_C, 0x00, 0, 0, 0x01},                   // rol $0010
+		{[]uint8{0x7e, 0x0e, 0x00}, 0, 0, 0x01, 0, Flag_Z | Flag_C, 0x00},          // ror $000e,x
+	}
+
+	for _, test := range tests {
+		var ctx BasicCPUContext
+		ctx.SetRegA(test.a)
+		ctx.SetRegX(0x02)
+		ctx.SetRegY(0x20)
+		ctx.SetFlags(test.p)
+		ctx.Poke(0x10, test.val)
+		for n, b := range test.code {
+			ctx.Poke(0x0400+uint16(n), b)
+		}
+		ctx.SetRegPC(0x0400)
+
+		_, err := Execute(&ctx)
+		assert.NoError(t, pack(err))
+		assert.Equal(t, ctx.RegPC(), 0x0400+uint16(len(test.code)))
+		assert.Equal(t, pack(ctx.RegA(), ctx.Flags(), ctx.Peek(0x10)), pack(test.wantA, test.wantP, test.wantVal))
+	}
+
+	// cycles of the read-modify-write shifts do not depend on the page
+	var ctx BasicCPUContext
+	ctx.SetRegX(0x02)
+	ctx.Poke(0x0400, 0x1e) // asl $02fe,x
+	ctx.PokeWord(0x0401, 0x02fe)
+	ctx.SetRegPC(0x0400)
+	cycles, err := Execute(&ctx)
+	assert.NoError(t, pack(err))
+	assert.Equal(t, cycles, 7)
+
+	// ldx loads X
+	ctx.Poke(0x0403, 0xa2) // ldx #$42
+	ctx.Poke(0x0404, 0x42)
+	_, err = Execute(&ctx)
+	assert.NoError(t, pack(err))
+	assert.Equal(t, pack(ctx.RegX(), ctx.RegY()), pack(uint8(0x42), uint8(0)))
+}
+
+func TestBranch(t *testing.T) {
+	pack := assert.Pack
+
+	branches := []struct {
+		pc     uint16
+		offset uint8
+		taken  bool
+		target uint16
+		cycles int
+	}{
+		{0x0410, 0x10, true, 0x0422, 3},
+		{0x0410, 0x10, false, 0x0412, 2},
+		{0x04f0, 0x10, true, 0x0502, 4}, // crosses a page
+		{0x0402, 0xf0, true, 0x03f4, 4}, // crosses a page backwards
+		{0x04fe, 0x00, true, 0x0500, 3}, // the page of the next instruction
+		{0x04fe, 0xfe, true, 0x04fe, 4}, // back to the previous page
+	}
+
+	for _, b := range branches {
+		var ctx BasicCPUContext
+		ctx.Poke(b.pc, 0xd0) // bne
+		ctx.Poke(b.pc+1, b.offset)
+		ctx.SetFlag(Flag_Z, !b.taken)
+		ctx.SetRegPC(b.pc)
+
+		cycles, err := Execute(&ctx)
+		assert.NoError(t, pack(err))
+		assert.Equal(t, ctx.RegPC(), b.target)
+		assert.Equal(t, cycles, b.cycles)
+	}
+}
+
+func TestInterrupts(t *testing.T) {
+	pack := assert.Pack
+
+	var ctx BasicCPUContext
+	ctx.PokeWord(Vector_IRQ, 0x0500)
+	ctx.PokeWord(Vector_NMI, 0x0600)
+	ctx.Poke(0x0500, 0x40) // rti
+	ctx.Poke(0x0600, 0x40)
+	ctx.SetRegSP(0xff)
+	ctx.SetRegPC(0x0400)
+
+	// brk skips its padding byte
+	cycles, err := Execute(&ctx)
+	assert.NoError(t, pack(err))
+	assert.Equal(t, pack(ctx.RegPC(), cycles), pack(uint16(0x0500), 7))
+	assert.Equal(t, ctx.PeekWord(0x01fe), uint16(0x0402))
+	assert.Equal(t, ctx.Peek(0x01fd), Flag_B)
+	cycles, err = Execute(&ctx)
+	assert.NoError(t, pack(err))
+	assert.Equal(t, pack(ctx.RegPC(), ctx.RegSP(), cycles), pack(uint16(0x0402), uint8(0xff), 6))
+
+	// an IRQ is masked by I, an NMI isn't
+	ctx.SetFlags(Flag_I | Flag_C)
+	_, ok := IRQ(&ctx)
+	assert.Equal(t, ok, false)
+	assert.Equal(t, ctx.RegPC(), uint16(0x0402))
+	assert.Equal(t, NMI(&ctx), 7)
+	assert.Equal(t, ctx.RegPC(), uint16(0x0600))
+	assert.Equal(t, ctx.PeekWord(0x01fe), uint16(0x0402))
+
+	ctx.SetFlags(0)
+	Execute(&ctx)
+	assert.Equal(t, pack(ctx.RegPC(), ctx.Flags()), pack(uint16(0x0402), Flag_I|Flag_C))
+
+	// an IRQ pushes the flags with B clear
+	ctx.SetFlags(Flag_B | Flag_Z)
+	cycles, ok = IRQ(&ctx)
+	assert.Equal(t, pack(cycles, ok), pack(7, true))
+	assert.Equal(t, ctx.RegPC(), uint16(0x0500))
+	assert.Equal(t, ctx.Peek(0x01fd), Flag_Z)
+	assert.Equal(t, ctx.Flag(Flag_I), true)
+}
@@ -0,0 +1,133 @@
+package core6502
+
+/*
+	An undo journal of the instructions executed, kept in a ring buffer of
+	a fixed size, dropping the oldest instructions when it is full. Each
+	instruction is recorded as:
+
+	n a x y sp p pc:2 cycles (addr:2 old)[n] n
+
+	the registers before it executed, its cycles, and the address and
+	previous value of the n bytes it wrote. n is at both ends so records
+	can be walked in either direction.
+*/
+type Journal struct {
+	IsDevice func(addr uint16) bool // writes to addresses it returns true for are not recorded
+
+	buf         []uint8
+	start, used int // the offset of the oldest record, and the bytes in use
+	records     int
+	pending     []uint8
+}
+
+const journalRecordSize = 10 // the size of a record without writes
+
+func NewJournal(size int) *Journal {
+	return &Journal{buf: make([]uint8, size)}
+}
+
+// the number of instructions recorded
+func (j *Journal) Len() int {
+	return j.records
+}
+
+// the bytes in use and the size of the journal
+func (j *Journal) Usage() (int, int) {
+	return j.used, len(j.buf)
+}
+
+func (j *Journal) Clear() {
+	j.start, j.used, j.records = 0, 0, 0
+}
+
+// records the previous value of a byte about to be written with ctx
+func (j *Journal) store(ctx CPUContext, addr uint16) {
+	if j.IsDevice == nil || !j.IsDevice(addr) {
+		j.pending = append(j.pending, uint8(addr), uint8(addr>>8), ctx.Peek(addr))
+	}
+}
+
+// starts recording the instruction about to execute with ctx, returning ctx
+// wrapped to record the bytes it writes. Commit adds it to the journal
+func (j *Journal) Begin(ctx CPUContext) CPUContext {
+	pc := ctx.RegPC()
+	j.pending = append(j.pending[:0], 0, ctx.RegA(), ctx.RegX(), ctx.RegY(), ctx.RegSP(), ctx.Flags(), uint8(pc), uint8(pc>>8), 0)
+	return &AccessContext{
+		CPUContext: ctx,
+		Store:      func(addr uint16) { j.store(ctx, addr) },
+	}
+}
+
+// adds the instruction started by Begin, which took cycles, to the journal
+func (j *Journal) Commit(cycles int) {
+	n := (len(j.pending) - 9) / 3
+	j.pending[0], j.pending[8] = uint8(n), uint8(cycles)
+	j.pending = append(j.pending, uint8(n))
+	if len(j.pending) > len(j.buf) {
+		j.Clear()
+		return
+	}
+
+	for j.used+len(j.pending) > len(j.buf) {
+		size := journalRecordSize + 3*int(j.buf[j.start])
+		j.start = (j.start + size) % len(j.buf)
+		j.used -= size
+		j.records--
+	}
+	for i, b := range j.pending {
+		j.buf[(j.start+j.used+i)%len(j.buf)] = b
+	}
+	j.used += len(j.pending)
+	j.records++
+}
+
+// the byte at offset from the oldest record
+func (j *Journal) at(offset int) uint8 {
+	return j.buf[(j.start+offset)%len(j.buf)]
+}
+
+// the offset of the record before the one at end, and its number of writes
+func (j *Journal) previous(end int) (int, int) {
+	n := int(j.at(end - 1))
+	return end - journalRecordSize - 3*n, n
+}
+
+// undoes the last instruction recorded, returning its cycles, false if
+// the journal is empty
+func (j *Journal) Back(ctx CPUContext) (int, bool) {
+	if j.records == 0 {
+		return 0, false
+	}
+	rec, n := j.previous(j.used)
+
+	for w := n - 1; w >= 0; w-- {
+		addr := rec + 9 + 3*w
+		ctx.Poke(MakeWord(j.at(addr+1), j.at(addr)), j.at(addr+2))
+	}
+	ctx.SetRegA(j.at(rec + 1))
+	ctx.SetRegX(j.at(rec + 2))
+	ctx.SetRegY(j.at(rec + 3))
+	ctx.SetRegSP(j.at(rec + 4))
+	ctx.SetFlags(j.at(rec + 5))
+	ctx.SetRegPC(MakeWord(j.at(rec+7), j.at(rec+6)))
+
+	j.used = rec
+	j.records--
+	return int(j.at(rec + 8)), true
+}
+
+// how many instructions back the last write to addr was, 0 if there is
+// none in the journal
+func (j *Journal) LastWrite(addr uint16) int {
+	end := j.used
+	for count := 1; count <= j.records; count++ {
+		rec, n := j.previous(end)
+		for w := 0; w < n; w++ {
+			if MakeWord(j.at(rec+10+3*w), j.at(rec+9+3*w)) == addr {
+				return count
+			}
+		}
+		end = rec
+	}
+	return 0
+}
@@ -0,0 +1,78 @@
+package core6502
+
+import (
+	"github.com/simulatedsimian/assert"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	pack := assert.Pack
+
+	var ctx BasicCPUContext
+	code := []uint8{
+		0xa9, 0x11, // lda #$11
+		0x85, 0x10, // sta $10
+		0x20, 0x10, 0x04, // jsr $0410
+	}
+	for n, b := range code {
+		ctx.Poke(0x0400+uint16(n), b)
+	}
+	ctx.Poke(0x0410, 0xe6) // inc $10
+	ctx.Poke(0x0411, 0x10)
+	ctx.SetRegPC(0x0400)
+	ctx.SetRegSP(0xff)
+	start := ctx
+
+	j := NewJournal(1000)
+	step := func(n int) {
+		for ; n > 0; n-- {
+			mem := j.Begin(&ctx)
+			cycles, err := Execute(mem)
+			assert.NoError(t, pack(err))
+			j.Commit(cycles)
+		}
+	}
+
+	step(4)
+	assert.Equal(t, ctx.Peek(0x10), uint8(0x12))
+	assert.Equal(t, j.Len(), 4)
+	used, size := j.Usage()
+	assert.Equal(t, used, 4*10+3+3*2+3)
+	assert.Equal(t, size, 1000)
+	assert.Equal(t, j.LastWrite(0x10), 1)
+	assert.Equal(t, j.LastWrite(0x1ff), 2)
+	assert.Equal(t, j.LastWrite(0x20), 0)
+
+	cycles, ok := j.Back(&ctx)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, cycles, 5)
+	assert.Equal(t, ctx.RegPC(), uint16(0x0410))
+	assert.Equal(t, ctx.Peek(0x10), uint8(0x11))
+	assert.Equal(t, j.LastWrite(0x10), 2)
+
+	for j.Len() > 0 {
+		j.Back(&ctx)
+	}
+	assert.Equal(t, ctx, start)
+	_, ok = j.Back(&ctx)
+	assert.Equal(t, ok, false)
+
+	// a full journal drops the oldest instructions
+	j = NewJournal(30)
+	step(3)
+	assert.Equal(t, j.Len(), 2)
+	used, _ = j.Usage()
+	assert.Equal(t, used, 10+3+10+3*2)
+	j.Back(&ctx)
+	j.Back(&ctx)
+	assert.Equal(t, ctx.RegPC(), uint16(0x0402))
+
+	// writes to devices are not recorded
+	j = NewJournal(100)
+	j.IsDevice = func(addr uint16) bool { return addr == 0x10 }
+	ctx.SetRegPC(0x0402)
+	step(1)
+	used, _ = j.Usage()
+	assert.Equal(t, used, 10)
+	assert.Equal(t, j.LastWrite(0x10), 0)
+}
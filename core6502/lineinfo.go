@@ -0,0 +1,111 @@
+package core6502
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// A source line and the bytes generated for it
+type SourceLine struct {
+	File  string
+	Line  int
+	Start uint16
+	Size  int
+}
+
+func (l SourceLine) Contains(addr uint16) bool {
+	return int(addr) >= int(l.Start) && int(addr) < int(l.Start)+l.Size
+}
+
+// The source lines of a program, from ld65 debug info
+type LineInfo struct {
+	Lines []SourceLine // ordered by file, line then address
+}
+
+/*
+	Parses the line, span, seg and file records of an ld65 debug info
+	file (ld65 --dbgfile, with ca65 -g). A line with several spans, e.g.
+	a macro invocation, gives a SourceLine for each.
+
+	line id=0,file=0,line=12,span=8+9
+	span id=8,seg=0,start=0,size=3
+	seg id=0,name="CODE",start=0x000200,size=0x0026,...
+	file id=0,name="hello.s",...
+*/
+func ParseLineInfo(data []byte, name string) (*LineInfo, error) {
+	recs, err := parseDbgRecords(data, name)
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string]string{}
+	segs := map[string]int{}
+	spans := map[string]dbgRecord{}
+	for _, rec := range recs {
+		switch rec.kind {
+		case "file":
+			files[rec.attrs["id"]] = rec.attrs["name"]
+		case "seg":
+			start, _ := rec.int("start")
+			segs[rec.attrs["id"]] = start
+		case "span":
+			spans[rec.attrs["id"]] = rec
+		}
+	}
+
+	li := &LineInfo{}
+	for _, rec := range recs {
+		if rec.kind != "line" || rec.attrs["span"] == "" {
+			continue
+		}
+		file, ok := files[rec.attrs["file"]]
+		if !ok {
+			return nil, fmt.Errorf("%s: Line %s has an unknown file %s", name, rec.attrs["id"], rec.attrs["file"])
+		}
+		line, _ := rec.int("line")
+
+		for _, id := range strings.Split(rec.attrs["span"], "+") {
+			span, ok := spans[id]
+			if !ok {
+				return nil, fmt.Errorf("%s: Line %s has an unknown span %s", name, rec.attrs["id"], id)
+			}
+			start, _ := span.int("start")
+			size, _ := span.int("size")
+			li.Lines = append(li.Lines, SourceLine{file, line, uint16(segs[span.attrs["seg"]] + start), size})
+		}
+	}
+
+	sort.SliceStable(li.Lines, func(i, j int) bool {
+		a, b := li.Lines[i], li.Lines[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Start < b.Start
+	})
+	return li, nil
+}
+
+func LoadLineInfo(filename string) (*LineInfo, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseLineInfo(data, filename)
+}
+
+// the line generating addr, the one with the smallest span if several do (e.g. a macro and its invocation)
+func (li *LineInfo) Find(addr uint16) (SourceLine, bool) {
+	var found SourceLine
+	ok := false
+	for _, l := range li.Lines {
+		if l.Contains(addr) && (!ok || l.Size < found.Size) {
+			found, ok = l, true
+		}
+	}
+	return found, ok
+}
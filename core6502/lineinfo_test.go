@@ -0,0 +1,41 @@
+package core6502
+
+import (
+	"fmt"
+	"github.com/simulatedsimian/assert"
+	"testing"
+)
+
+const testLineInfo = "version\tmajor=2,minor=0\n" +
+	"file\tid=0,name=\"main.s\",size=100,mtime=0x5e5ba4e8,mod=0\n" +
+	"file\tid=1,name=\"macros.inc\",size=40,mtime=0x5e5ba4e8,mod=0\n" +
+	"seg\tid=0,name=\"CODE\",start=0x000400,size=0x0008,addrsize=absolute,type=ro,oname=\"a.bin\",ooffs=0\n" +
+	"span\tid=0,seg=0,start=0,size=2\n" +
+	"span\tid=1,seg=0,start=2,size=6\n" +
+	"span\tid=2,seg=0,start=2,size=3\n" +
+	"span\tid=3,seg=0,start=5,size=3\n" +
+	"line\tid=0,file=0,line=5,span=0\n" +
+	"line\tid=1,file=0,line=6,span=1\n" +
+	"line\tid=2,file=1,line=2,type=2,count=1,span=2+3\n" +
+	"line\tid=3,file=0,line=1\n"
+
+func TestParseLineInfo(t *testing.T) {
+	pack := assert.Pack
+
+	li, err := ParseLineInfo([]byte(testLineInfo), "test.dbg")
+	assert.NoError(t, pack(err))
+	assert.Equal(t, li.Lines, []SourceLine{
+		{"macros.inc", 2, 0x0402, 3},
+		{"macros.inc", 2, 0x0405, 3},
+		{"main.s", 5, 0x0400, 2},
+		{"main.s", 6, 0x0402, 6},
+	})
+
+	assert.Equal(t, pack(li.Find(0x0401)), []interface{}{SourceLine{"main.s", 5, 0x0400, 2}, true})
+	assert.Equal(t, pack(li.Find(0x0406)), []interface{}{SourceLine{"macros.inc", 2, 0x0405, 3}, true})
+	_, ok := li.Find(0x0408)
+	assert.Equal(t, ok, false)
+
+	_, err = ParseLineInfo([]byte("line\tid=0,file=0,line=5,span=0\n"), "test.dbg")
+	assert.Equal(t, fmt.Sprint(err), "test.dbg: Line 0 has an unknown file 0")
+}
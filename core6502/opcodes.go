@@ -0,0 +1,567 @@
+package core6502
+
+import (
+	"sort"
+	"strings"
+)
+
+type InstructionCategory int
+
+const (
+	Category_Load InstructionCategory = iota
+	Category_Store
+	Category_Transfer
+	Category_Stack
+	Category_Logical
+	Category_Arithmetic
+	Category_Compare
+	Category_IncDec
+	Category_Shift
+	Category_Jump
+	Category_Branch
+	Category_Flag
+	Category_System
+)
+
+func (c InstructionCategory) String() string {
+	switch c {
+	case Category_Load:
+		return "load"
+	case Category_Store:
+		return "store"
+	case Category_Transfer:
+		return "transfer"
+	case Category_Stack:
+		return "stack"
+	case Category_Logical:
+		return "logical"
+	case Category_Arithmetic:
+		return "arithmetic"
+	case Category_Compare:
+		return "compare"
+	case Category_IncDec:
+		return "increment/decrement"
+	case Category_Shift:
+		return "shift"
+	case Category_Jump:
+		return "jump"
+	case Category_Branch:
+		return "branch"
+	case Category_Flag:
+		return "flag"
+	}
+	return "system"
+}
+
+// extra cycles an instruction may take beyond its base cycle count
+type PageCrossPenalty int
+
+const (
+	PageCross_None    PageCrossPenalty = iota
+	PageCross_Indexed                  // +1 if the indexed address crosses a page
+	PageCross_Branch                   // +1 if taken, +1 more if the target is on another page
+)
+
+// all processor status flags that have a real effect
+const flagsAll = Flag_C | Flag_Z | Flag_I | Flag_D | Flag_V | Flag_N
+
+// Read-only description of an opcode
+type OpcodeInfo struct {
+	Opcode       uint8
+	Mnemonic     string
+	Mode         AddressMode
+	Length       uint16
+	Cycles       int // base cycle count
+	PageCross    PageCrossPenalty
+	FlagsRead    uint8 // Flag_ masks
+	FlagsWritten uint8
+	Category     InstructionCategory
+	Documented   bool // false for undocumented (illegal) opcodes
+}
+
+// per mnemonic properties, shared by all addressing modes
+type mnemonicInfo struct {
+	execMaker    ExecFuncMakerFunc
+	category     InstructionCategory
+	flagsRead    uint8
+	flagsWritten uint8
+}
+
+var mnemonicData = map[string]mnemonicInfo{
+	"LDA": {LDA, Category_Load, 0, Flag_N | Flag_Z},
+	"LDX": {LDX, Category_Load, 0, Flag_N | Flag_Z},
+	"LDY": {LDY, Category_Load, 0, Flag_N | Flag_Z},
+	"STA": {STA, Category_Store, 0, 0},
+	"STX": {STX, Category_Store, 0, 0},
+	"STY": {STY, Category_Store, 0, 0},
+	"INC": {INC, Category_IncDec, 0, Flag_N | Flag_Z},
+	"INX": {INX, Category_IncDec, 0, Flag_N | Flag_Z},
+	"INY": {INY, Category_IncDec, 0, Flag_N | Flag_Z},
+	"DEC": {DEC, Category_IncDec, 0, Flag_N | Flag_Z},
+	"DEX": {DEX, Category_IncDec, 0, Flag_N | Flag_Z},
+	"DEY": {DEY, Category_IncDec, 0, Flag_N | Flag_Z},
+	"TAX": {TAX, Category_Transfer, 0, Flag_N | Flag_Z},
+	"TAY": {TAY, Category_Transfer, 0, Flag_N | Flag_Z},
+	"TSX": {TSX, Category_Transfer, 0, Flag_N | Flag_Z},
+	"TXA": {TXA, Category_Transfer, 0, Flag_N | Flag_Z},
+	"TXS": {TXS, Category_Transfer, 0, 0},
+	"TYA": {TYA, Category_Transfer, 0, Flag_N | Flag_Z},
+	"CLC": {CLC, Category_Flag, 0, Flag_C},
+	"SEC": {SEC, Category_Flag, 0, Flag_C},
+	"CLD": {CLD, Category_Flag, 0, Flag_D},
+	"SED": {SED, Category_Flag, 0, Flag_D},
+	"CLI": {CLI, Category_Flag, 0, Flag_I},
+	"SEI": {SEI, Category_Flag, 0, Flag_I},
+	"CLV": {CLV, Category_Flag, 0, Flag_V},
+	"NOP": {NOP, Category_System, 0, 0},
+	"BRK": {BRK, Category_System, flagsAll, Flag_B | Flag_I},
+	"PHA": {PHA, Category_Stack, 0, 0},
+	"PLA": {PLA, Category_Stack, 0, Flag_N | Flag_Z},
+	"PHP": {PHP, Category_Stack, flagsAll, 0},
+	"PLP": {PLP, Category_Stack, 0, flagsAll},
+	"BPL": {BPL, Category_Branch, Flag_N, 0},
+	"BMI": {BMI, Category_Branch, Flag_N, 0},
+	"BVC": {BVC, Category_Branch, Flag_V, 0},
+	"BVS": {BVS, Category_Branch, Flag_V, 0},
+	"BCC": {BCC, Category_Branch, Flag_C, 0},
+	"BCS": {BCS, Category_Branch, Flag_C, 0},
+	"BNE": {BNE, Category_Branch, Flag_Z, 0},
+	"BEQ": {BEQ, Category_Branch, Flag_Z, 0},
+	"JSR": {JSR, Category_Jump, 0, 0},
+	"RTS": {RTS, Category_Jump, 0, 0},
+	"RTI": {RTI, Category_Jump, 0, flagsAll},
+	"JMP": {JMP, Category_Jump, 0, 0},
+	"ORA": {ORA, Category_Logical, 0, Flag_N | Flag_Z},
+	"AND": {AND, Category_Logical, 0, Flag_N | Flag_Z},
+	"EOR": {EOR, Category_Logical, 0, Flag_N | Flag_Z},
+	"ADC": {ADC, Category_Arithmetic, Flag_C | Flag_D, Flag_N | Flag_V | Flag_Z | Flag_C},
+	"SBC": {SBC, Category_Arithmetic, Flag_C | Flag_D, Flag_N | Flag_V | Flag_Z | Flag_C},
+	"CMP": {CMP, Category_Compare, 0, Flag_N | Flag_Z | Flag_C},
+	"CPX": {CPX, Category_Compare, 0, Flag_N | Flag_Z | Flag_C},
+	"CPY": {CPY, Category_Compare, 0, Flag_N | Flag_Z | Flag_C},
+	"BIT": {BIT, Category_Logical, 0, Flag_N | Flag_V | Flag_Z},
+	"ASL": {ASL, Category_Shift, 0, Flag_N | Flag_Z | Flag_C},
+	"LSR": {LSR, Category_Shift, 0, Flag_N | Flag_Z | Flag_C},
+	"ROL": {ROL, Category_Shift, Flag_C, Flag_N | Flag_Z | Flag_C},
+	"ROR": {ROR, Category_Shift, Flag_C, Flag_N | Flag_Z | Flag_C},
+
+	// undocumented, these are never executed
+	"SLO": {nil, Category_Shift, 0, Flag_N | Flag_Z | Flag_C},
+	"RLA": {nil, Category_Shift, Flag_C, Flag_N | Flag_Z | Flag_C},
+	"SRE": {nil, Category_Shift, 0, Flag_N | Flag_Z | Flag_C},
+	"RRA": {nil, Category_Shift, Flag_C | Flag_D, Flag_N | Flag_V | Flag_Z | Flag_C},
+	"DCP": {nil, Category_IncDec, 0, Flag_N | Flag_Z | Flag_C},
+	"ISC": {nil, Category_IncDec, Flag_C | Flag_D, Flag_N | Flag_V | Flag_Z | Flag_C},
+	"SAX": {nil, Category_Store, 0, 0},
+	"LAX": {nil, Category_Load, 0, Flag_N | Flag_Z},
+	"LAS": {nil, Category_Load, 0, Flag_N | Flag_Z},
+	"ANC": {nil, Category_Logical, 0, Flag_N | Flag_Z | Flag_C},
+	"ALR": {nil, Category_Logical, 0, Flag_N | Flag_Z | Flag_C},
+	"ARR": {nil, Category_Logical, Flag_C | Flag_D, Flag_N | Flag_V | Flag_Z | Flag_C},
+	"XAA": {nil, Category_Logical, 0, Flag_N | Flag_Z},
+	"SBX": {nil, Category_Arithmetic, 0, Flag_N | Flag_Z | Flag_C},
+	"TAS": {nil, Category_Store, 0, 0},
+	"SHA": {nil, Category_Store, 0, 0},
+	"SHX": {nil, Category_Store, 0, 0},
+	"SHY": {nil, Category_Store, 0, 0},
+	"JAM": {nil, Category_System, 0, 0}, // halts the CPU
+}
+
+// an opcode as listed in the opcode tables
+type opcodeDef struct {
+	opcode   uint8
+	mnemonic string
+	cycles   int
+	mode     AddressMode
+}
+
+// the 151 documented opcodes
+var documentedOpcodes = []opcodeDef{
+	{0xa9, "LDA", 2, AddrMode_Immediate},
+	{0xa5, "LDA", 3, AddrMode_AbsoluteZeroPage},
+	{0xb5, "LDA", 4, AddrMode_ZeroPageIdxX},
+	{0xa1, "LDA", 6, AddrMode_PreIndexIndirect},
+	{0xb1, "LDA", 5, AddrMode_PostIndexIndirect},
+	{0xad, "LDA", 4, AddrMode_Absolute},
+	{0xbd, "LDA", 4, AddrMode_AbsoluteIndexedX},
+	{0xb9, "LDA", 4, AddrMode_AbsoluteIndexedY},
+	{0xa2, "LDX", 2, AddrMode_Immediate},
+	{0xa6, "LDX", 3, AddrMode_AbsoluteZeroPage},
+	{0xb6, "LDX", 4, AddrMode_ZeroPageIdxY},
+	{0xae, "LDX", 4, AddrMode_Absolute},
+	{0xbe, "LDX", 4, AddrMode_AbsoluteIndexedY},
+	{0xa0, "LDY", 2, AddrMode_Immediate},
+	{0xa4, "LDY", 3, AddrMode_AbsoluteZeroPage},
+	{0xb4, "LDY", 4, AddrMode_ZeroPageIdxX},
+	{0xac, "LDY", 4, AddrMode_Absolute},
+	{0xbc, "LDY", 4, AddrMode_AbsoluteIndexedX},
+	{0x85, "STA", 3, AddrMode_AbsoluteZeroPage},
+	{0x95, "STA", 4, AddrMode_ZeroPageIdxX},
+	{0x81, "STA", 6, AddrMode_PreIndexIndirect},
+	{0x91, "STA", 6, AddrMode_PostIndexIndirect},
+	{0x8d, "STA", 4, AddrMode_Absolute},
+	{0x9d, "STA", 5, AddrMode_AbsoluteIndexedX},
+	{0x99, "STA", 5, AddrMode_AbsoluteIndexedY},
+	{0x86, "STX", 3, AddrMode_AbsoluteZeroPage},
+	{0x96, "STX", 4, AddrMode_ZeroPageIdxY},
+	{0x8e, "STX", 4, AddrMode_Absolute},
+	{0x84, "STY", 3, AddrMode_AbsoluteZeroPage},
+	{0x94, "STY", 4, AddrMode_ZeroPageIdxX},
+	{0x8c, "STY", 4, AddrMode_Absolute},
+	{0xe6, "INC", 5, AddrMode_AbsoluteZeroPage},
+	{0xf6, "INC", 6, AddrMode_ZeroPageIdxX},
+	{0xee, "INC", 6, AddrMode_Absolute},
+	{0xfe, "INC", 7, AddrMode_AbsoluteIndexedX},
+	{0xe8, "INX", 2, AddrMode_Implicit},
+	{0xc8, "INY", 2, AddrMode_Implicit},
+	{0xc6, "DEC", 5, AddrMode_AbsoluteZeroPage},
+	{0xd6, "DEC", 6, AddrMode_ZeroPageIdxX},
+	{0xce, "DEC", 6, AddrMode_Absolute},
+	{0xde, "DEC", 7, AddrMode_AbsoluteIndexedX},
+	{0xca, "DEX", 2, AddrMode_Implicit},
+	{0x88, "DEY", 2, AddrMode_Implicit},
+	{0xaa, "TAX", 2, AddrMode_Implicit},
+	{0xa8, "TAY", 2, AddrMode_Implicit},
+	{0xba, "TSX", 2, AddrMode_Implicit},
+	{0x8a, "TXA", 2, AddrMode_Implicit},
+	{0x9a, "TXS", 2, AddrMode_Implicit},
+	{0x98, "TYA", 2, AddrMode_Implicit},
+	{0x18, "CLC", 2, AddrMode_Implicit},
+	{0x38, "SEC", 2, AddrMode_Implicit},
+	{0xD8, "CLD", 2, AddrMode_Implicit},
+	{0xF8, "SED", 2, AddrMode_Implicit},
+	{0x58, "CLI", 2, AddrMode_Implicit},
+	{0x78, "SEI", 2, AddrMode_Implicit},
+	{0xB8, "CLV", 2, AddrMode_Implicit},
+	{0xea, "NOP", 2, AddrMode_Implicit},
+	{0x00, "BRK", 7, AddrMode_Implicit},
+	{0x48, "PHA", 3, AddrMode_Implicit},
+	{0x68, "PLA", 4, AddrMode_Implicit},
+	{0x08, "PHP", 3, AddrMode_Implicit},
+	{0x28, "PLP", 4, AddrMode_Implicit},
+
+	{0x10, "BPL", 2, AddrMode_Relative},
+	{0x30, "BMI", 2, AddrMode_Relative},
+	{0x50, "BVC", 2, AddrMode_Relative},
+	{0x70, "BVS", 2, AddrMode_Relative},
+	{0x90, "BCC", 2, AddrMode_Relative},
+	{0xB0, "BCS", 2, AddrMode_Relative},
+	{0xD0, "BNE", 2, AddrMode_Relative},
+	{0xF0, "BEQ", 2, AddrMode_Relative},
+
+	{0x20, "JSR", 6, AddrMode_Absolute},
+	{0x60, "RTS", 6, AddrMode_Implicit},
+	{0x40, "RTI", 6, AddrMode_Implicit},
+	{0x4C, "JMP", 3, AddrMode_Absolute},
+	{0x6C, "JMP", 5, AddrMode_Indirect},
+
+	{0x09, "ORA", 2, AddrMode_Immediate},
+	{0x05, "ORA", 3, AddrMode_AbsoluteZeroPage},
+	{0x15, "ORA", 4, AddrMode_ZeroPageIdxX},
+	{0x01, "ORA", 6, AddrMode_PreIndexIndirect},
+	{0x11, "ORA", 5, AddrMode_PostIndexIndirect},
+	{0x0D, "ORA", 4, AddrMode_Absolute},
+	{0x1D, "ORA", 4, AddrMode_AbsoluteIndexedX},
+	{0x19, "ORA", 4, AddrMode_AbsoluteIndexedY},
+
+	{0x29, "AND", 2, AddrMode_Immediate},
+	{0x25, "AND", 3, AddrMode_AbsoluteZeroPage},
+	{0x35, "AND", 4, AddrMode_ZeroPageIdxX},
+	{0x21, "AND", 6, AddrMode_PreIndexIndirect},
+	{0x31, "AND", 5, AddrMode_PostIndexIndirect},
+	{0x2D, "AND", 4, AddrMode_Absolute},
+	{0x3D, "AND", 4, AddrMode_AbsoluteIndexedX},
+	{0x39, "AND", 4, AddrMode_AbsoluteIndexedY},
+
+	{0x49, "EOR", 2, AddrMode_Immediate},
+	{0x45, "EOR", 3, AddrMode_AbsoluteZeroPage},
+	{0x55, "EOR", 4, AddrMode_ZeroPageIdxX},
+	{0x41, "EOR", 6, AddrMode_PreIndexIndirect},
+	{0x51, "EOR", 5, AddrMode_PostIndexIndirect},
+	{0x4D, "EOR", 4, AddrMode_Absolute},
+	{0x5D, "EOR", 4, AddrMode_AbsoluteIndexedX},
+	{0x59, "EOR", 4, AddrMode_AbsoluteIndexedY},
+
+	{0x69, "ADC", 2, AddrMode_Immediate},
+	{0x65, "ADC", 3, AddrMode_AbsoluteZeroPage},
+	{0x75, "ADC", 4, AddrMode_ZeroPageIdxX},
+	{0x61, "ADC", 6, AddrMode_PreIndexIndirect},
+	{0x71, "ADC", 5, AddrMode_PostIndexIndirect},
+	{0x6D, "ADC", 4, AddrMode_Absolute},
+	{0x7D, "ADC", 4, AddrMode_AbsoluteIndexedX},
+	{0x79, "ADC", 4, AddrMode_AbsoluteIndexedY},
+
+	{0xE9, "SBC", 2, AddrMode_Immediate},
+	{0xE5, "SBC", 3, AddrMode_AbsoluteZeroPage},
+	{0xF5, "SBC", 4, AddrMode_ZeroPageIdxX},
+	{0xE1, "SBC", 6, AddrMode_PreIndexIndirect},
+	{0xF1, "SBC", 5, AddrMode_PostIndexIndirect},
+	{0xED, "SBC", 4, AddrMode_Absolute},
+	{0xFD, "SBC", 4, AddrMode_AbsoluteIndexedX},
+	{0xF9, "SBC", 4, AddrMode_AbsoluteIndexedY},
+
+	{0xC9, "CMP", 2, AddrMode_Immediate},
+	{0xC5, "CMP", 3, AddrMode_AbsoluteZeroPage},
+	{0xD5, "CMP", 4, AddrMode_ZeroPageIdxX},
+	{0xC1, "CMP", 6, AddrMode_PreIndexIndirect},
+	{0xD1, "CMP", 5, AddrMode_PostIndexIndirect},
+	{0xCD, "CMP", 4, AddrMode_Absolute},
+	{0xDD, "CMP", 4, AddrMode_AbsoluteIndexedX},
+	{0xD9, "CMP", 4, AddrMode_AbsoluteIndexedY},
+	{0xE0, "CPX", 2, AddrMode_Immediate},
+	{0xE4, "CPX", 3, AddrMode_AbsoluteZeroPage},
+	{0xEC, "CPX", 4, AddrMode_Absolute},
+	{0xC0, "CPY", 2, AddrMode_Immediate},
+	{0xC4, "CPY", 3, AddrMode_AbsoluteZeroPage},
+	{0xCC, "CPY", 4, AddrMode_Absolute},
+	{0x24, "BIT", 3, AddrMode_AbsoluteZeroPage},
+	{0x2C, "BIT", 4, AddrMode_Absolute},
+
+	// the accumulator forms are implicit: "asl" or "asl a"
+	{0x0A, "ASL", 2, AddrMode_Implicit},
+	{0x06, "ASL", 5, AddrMode_AbsoluteZeroPage},
+	{0x16, "ASL", 6, AddrMode_ZeroPageIdxX},
+	{0x0E, "ASL", 6, AddrMode_Absolute},
+	{0x1E, "ASL", 7, AddrMode_AbsoluteIndexedX},
+	{0x4A, "LSR", 2, AddrMode_Implicit},
+	{0x46, "LSR", 5, AddrMode_AbsoluteZeroPage},
+	{0x56, "LSR", 6, AddrMode_ZeroPageIdxX},
+	{0x4E, "LSR", 6, AddrMode_Absolute},
+	{0x5E, "LSR", 7, AddrMode_AbsoluteIndexedX},
+	{0x2A, "ROL", 2, AddrMode_Implicit},
+	{0x26, "ROL", 5, AddrMode_AbsoluteZeroPage},
+	{0x36, "ROL", 6, AddrMode_ZeroPageIdxX},
+	{0x2E, "ROL", 6, AddrMode_Absolute},
+	{0x3E, "ROL", 7, AddrMode_AbsoluteIndexedX},
+	{0x6A, "ROR", 2, AddrMode_Implicit},
+	{0x66, "ROR", 5, AddrMode_AbsoluteZeroPage},
+	{0x76, "ROR", 6, AddrMode_ZeroPageIdxX},
+	{0x6E, "ROR", 6, AddrMode_Absolute},
+	{0x7E, "ROR", 7, AddrMode_AbsoluteIndexedX},
+}
+
+// the 105 undocumented opcodes of the NMOS 6502, named as in the common
+// illegal opcode tables. SBC #imm and NOP also have documented encodings
+var undocumentedOpcodes = []opcodeDef{
+	{0x03, "SLO", 8, AddrMode_PreIndexIndirect},
+	{0x07, "SLO", 5, AddrMode_AbsoluteZeroPage},
+	{0x0F, "SLO", 6, AddrMode_Absolute},
+	{0x13, "SLO", 8, AddrMode_PostIndexIndirect},
+	{0x17, "SLO", 6, AddrMode_ZeroPageIdxX},
+	{0x1B, "SLO", 7, AddrMode_AbsoluteIndexedY},
+	{0x1F, "SLO", 7, AddrMode_AbsoluteIndexedX},
+	{0x23, "RLA", 8, AddrMode_PreIndexIndirect},
+	{0x27, "RLA", 5, AddrMode_AbsoluteZeroPage},
+	{0x2F, "RLA", 6, AddrMode_Absolute},
+	{0x33, "RLA", 8, AddrMode_PostIndexIndirect},
+	{0x37, "RLA", 6, AddrMode_ZeroPageIdxX},
+	{0x3B, "RLA", 7, AddrMode_AbsoluteIndexedY},
+	{0x3F, "RLA", 7, AddrMode_AbsoluteIndexedX},
+	{0x43, "SRE", 8, AddrMode_PreIndexIndirect},
+	{0x47, "SRE", 5, AddrMode_AbsoluteZeroPage},
+	{0x4F, "SRE", 6, AddrMode_Absolute},
+	{0x53, "SRE", 8, AddrMode_PostIndexIndirect},
+	{0x57, "SRE", 6, AddrMode_ZeroPageIdxX},
+	{0x5B, "SRE", 7, AddrMode_AbsoluteIndexedY},
+	{0x5F, "SRE", 7, AddrMode_AbsoluteIndexedX},
+	{0x63, "RRA", 8, AddrMode_PreIndexIndirect},
+	{0x67, "RRA", 5, AddrMode_AbsoluteZeroPage},
+	{0x6F, "RRA", 6, AddrMode_Absolute},
+	{0x73, "RRA", 8, AddrMode_PostIndexIndirect},
+	{0x77, "RRA", 6, AddrMode_ZeroPageIdxX},
+	{0x7B, "RRA", 7, AddrMode_AbsoluteIndexedY},
+	{0x7F, "RRA", 7, AddrMode_AbsoluteIndexedX},
+	{0xC3, "DCP", 8, AddrMode_PreIndexIndirect},
+	{0xC7, "DCP", 5, AddrMode_AbsoluteZeroPage},
+	{0xCF, "DCP", 6, AddrMode_Absolute},
+	{0xD3, "DCP", 8, AddrMode_PostIndexIndirect},
+	{0xD7, "DCP", 6, AddrMode_ZeroPageIdxX},
+	{0xDB, "DCP", 7, AddrMode_AbsoluteIndexedY},
+	{0xDF, "DCP", 7, AddrMode_AbsoluteIndexedX},
+	{0xE3, "ISC", 8, AddrMode_PreIndexIndirect},
+	{0xE7, "ISC", 5, AddrMode_AbsoluteZeroPage},
+	{0xEF, "ISC", 6, AddrMode_Absolute},
+	{0xF3, "ISC", 8, AddrMode_PostIndexIndirect},
+	{0xF7, "ISC", 6, AddrMode_ZeroPageIdxX},
+	{0xFB, "ISC", 7, AddrMode_AbsoluteIndexedY},
+	{0xFF, "ISC", 7, AddrMode_AbsoluteIndexedX},
+
+	{0x87, "SAX", 3, AddrMode_AbsoluteZeroPage},
+	{0x97, "SAX", 4, AddrMode_ZeroPageIdxY},
+	{0x83, "SAX", 6, AddrMode_PreIndexIndirect},
+	{0x8F, "SAX", 4, AddrMode_Absolute},
+
+	{0xA7, "LAX", 3, AddrMode_AbsoluteZeroPage},
+	{0xB7, "LAX", 4, AddrMode_ZeroPageIdxY},
+	{0xA3, "LAX", 6, AddrMode_PreIndexIndirect},
+	{0xB3, "LAX", 5, AddrMode_PostIndexIndirect},
+	{0xAF, "LAX", 4, AddrMode_Absolute},
+	{0xBF, "LAX", 4, AddrMode_AbsoluteIndexedY},
+	{0xAB, "LAX", 2, AddrMode_Immediate},
+
+	{0x0B, "ANC", 2, AddrMode_Immediate},
+	{0x2B, "ANC", 2, AddrMode_Immediate},
+
+	{0x4B, "ALR", 2, AddrMode_Immediate},
+
+	{0x6B, "ARR", 2, AddrMode_Immediate},
+
+	{0x8B, "XAA", 2, AddrMode_Immediate},
+
+	{0xCB, "SBX", 2, AddrMode_Immediate},
+
+	{0xEB, "SBC", 2, AddrMode_Immediate},
+
+	{0xBB, "LAS", 4, AddrMode_AbsoluteIndexedY},
+
+	{0x9B, "TAS", 5, AddrMode_AbsoluteIndexedY},
+
+	{0x93, "SHA", 6, AddrMode_PostIndexIndirect},
+	{0x9F, "SHA", 5, AddrMode_AbsoluteIndexedY},
+
+	{0x9C, "SHY", 5, AddrMode_AbsoluteIndexedX},
+
+	{0x9E, "SHX", 5, AddrMode_AbsoluteIndexedY},
+
+	{0x1A, "NOP", 2, AddrMode_Implicit},
+	{0x3A, "NOP", 2, AddrMode_Implicit},
+	{0x5A, "NOP", 2, AddrMode_Implicit},
+	{0x7A, "NOP", 2, AddrMode_Implicit},
+	{0xDA, "NOP", 2, AddrMode_Implicit},
+	{0xFA, "NOP", 2, AddrMode_Implicit},
+	{0x80, "NOP", 2, AddrMode_Immediate},
+	{0x82, "NOP", 2, AddrMode_Immediate},
+	{0x89, "NOP", 2, AddrMode_Immediate},
+	{0xC2, "NOP", 2, AddrMode_Immediate},
+	{0xE2, "NOP", 2, AddrMode_Immediate},
+	{0x04, "NOP", 3, AddrMode_AbsoluteZeroPage},
+	{0x44, "NOP", 3, AddrMode_AbsoluteZeroPage},
+	{0x64, "NOP", 3, AddrMode_AbsoluteZeroPage},
+	{0x14, "NOP", 4, AddrMode_ZeroPageIdxX},
+	{0x34, "NOP", 4, AddrMode_ZeroPageIdxX},
+	{0x54, "NOP", 4, AddrMode_ZeroPageIdxX},
+	{0x74, "NOP", 4, AddrMode_ZeroPageIdxX},
+	{0xD4, "NOP", 4, AddrMode_ZeroPageIdxX},
+	{0xF4, "NOP", 4, AddrMode_ZeroPageIdxX},
+	{0x0C, "NOP", 4, AddrMode_Absolute},
+	{0x1C, "NOP", 4, AddrMode_AbsoluteIndexedX},
+	{0x3C, "NOP", 4, AddrMode_AbsoluteIndexedX},
+	{0x5C, "NOP", 4, AddrMode_AbsoluteIndexedX},
+	{0x7C, "NOP", 4, AddrMode_AbsoluteIndexedX},
+	{0xDC, "NOP", 4, AddrMode_AbsoluteIndexedX},
+	{0xFC, "NOP", 4, AddrMode_AbsoluteIndexedX},
+
+	{0x02, "JAM", 0, AddrMode_Implicit},
+	{0x12, "JAM", 0, AddrMode_Implicit},
+	{0x22, "JAM", 0, AddrMode_Implicit},
+	{0x32, "JAM", 0, AddrMode_Implicit},
+	{0x42, "JAM", 0, AddrMode_Implicit},
+	{0x52, "JAM", 0, AddrMode_Implicit},
+	{0x62, "JAM", 0, AddrMode_Implicit},
+	{0x72, "JAM", 0, AddrMode_Implicit},
+	{0x92, "JAM", 0, AddrMode_Implicit},
+	{0xB2, "JAM", 0, AddrMode_Implicit},
+	{0xD2, "JAM", 0, AddrMode_Implicit},
+	{0xF2, "JAM", 0, AddrMode_Implicit},
+}
+
+func pageCrossPenalty(category InstructionCategory, mode AddressMode) PageCrossPenalty {
+	if category == Category_Branch {
+		return PageCross_Branch
+	}
+
+	switch category {
+	case Category_Load, Category_Logical, Category_Arithmetic, Category_Compare:
+		switch mode {
+		case AddrMode_AbsoluteIndexedX, AddrMode_AbsoluteIndexedY, AddrMode_PostIndexIndirect:
+			return PageCross_Indexed
+		}
+	case Category_System:
+		// the undocumented NOP abs,X reads its operand
+		if mode == AddrMode_AbsoluteIndexedX {
+			return PageCross_Indexed
+		}
+	}
+	return PageCross_None
+}
+
+// indexed by opcode
+var opcodeTable = buildOpcodeTable()
+
+// documented encodings of each mnemonic, indexed by mnemonic and mode
+var mnemonicModes = buildMnemonicModes()
+
+func buildOpcodeTable() (table [256]OpcodeInfo) {
+	add := func(defs []opcodeDef, documented bool) {
+		for _, def := range defs {
+			mn := mnemonicData[def.mnemonic]
+			table[def.opcode] = OpcodeInfo{
+				Opcode:       def.opcode,
+				Mnemonic:     def.mnemonic,
+				Mode:         def.mode,
+				Length:       InstructionBytes(def.mode),
+				Cycles:       def.cycles,
+				PageCross:    pageCrossPenalty(mn.category, def.mode),
+				FlagsRead:    mn.flagsRead,
+				FlagsWritten: mn.flagsWritten,
+				Category:     mn.category,
+				Documented:   documented,
+			}
+		}
+	}
+
+	add(documentedOpcodes, true)
+	add(undocumentedOpcodes, false)
+	return
+}
+
+func buildMnemonicModes() map[string]map[AddressMode]*OpcodeInfo {
+	modes := map[string]map[AddressMode]*OpcodeInfo{}
+	for n := range opcodeTable {
+		info := &opcodeTable[n]
+		if !info.Documented {
+			continue
+		}
+		if modes[info.Mnemonic] == nil {
+			modes[info.Mnemonic] = map[AddressMode]*OpcodeInfo{}
+		}
+		modes[info.Mnemonic][info.Mode] = info
+	}
+	return modes
+}
+
+// returns the description of opcode, false if the opcode is undocumented
+func LookupOpcode(opcode uint8) (OpcodeInfo, bool) {
+	info := opcodeTable[opcode]
+	return info, info.Documented
+}
+
+// returns the documented encoding of mnemonic (case insensitive) in mode
+func LookupInstruction(mnemonic string, mode AddressMode) (OpcodeInfo, bool) {
+	if info, ok := mnemonicModes[strings.ToUpper(mnemonic)][mode]; ok {
+		return *info, true
+	}
+	return OpcodeInfo{}, false
+}
+
+// returns all documented encodings of mnemonic (case insensitive) ordered by
+// addressing mode, or nil if it is not a documented mnemonic
+func InstructionModes(mnemonic string) []OpcodeInfo {
+	var infos []OpcodeInfo
+	for _, info := range mnemonicModes[strings.ToUpper(mnemonic)] {
+		infos = append(infos, *info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Mode < infos[j].Mode })
+	return infos
+}
+
+// returns all documented opcodes in opcode order
+func Opcodes() []OpcodeInfo {
+	var infos []OpcodeInfo
+	for _, info := range opcodeTable {
+		if info.Documented {
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
@@ -0,0 +1,111 @@
+package core6502
+
+import (
+	"github.com/simulatedsimian/assert"
+	"testing"
+)
+
+func TestLookupOpcode(t *testing.T) {
+	info, ok := LookupOpcode(0xb1)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, info.Mnemonic, "LDA")
+	assert.Equal(t, info.Mode, AddrMode_PostIndexIndirect)
+	assert.Equal(t, info.Length, uint16(2))
+	assert.Equal(t, info.Cycles, 5)
+	assert.Equal(t, info.PageCross, PageCross_Indexed)
+	assert.Equal(t, info.FlagsWritten, Flag_N|Flag_Z)
+	assert.Equal(t, info.Category, Category_Load)
+	assert.Equal(t, info.Documented, true)
+
+	info, _ = LookupOpcode(0x91)
+	assert.Equal(t, info.PageCross, PageCross_None)
+
+	info, _ = LookupOpcode(0xd0)
+	assert.Equal(t, info.PageCross, PageCross_Branch)
+	assert.Equal(t, info.FlagsRead, Flag_Z)
+
+	_, ok = LookupOpcode(0x02)
+	assert.Equal(t, ok, false)
+}
+
+func TestLookupInstruction(t *testing.T) {
+	info, ok := LookupInstruction("sta", AddrMode_PreIndexIndirect)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, info.Opcode, uint8(0x81))
+
+	_, ok = LookupInstruction("LDX", AddrMode_PreIndexIndirect)
+	assert.Equal(t, ok, false)
+
+	modes := InstructionModes("JMP")
+	assert.Equal(t, len(modes), 2)
+	assert.Equal(t, modes[0].Mode, AddrMode_Absolute)
+	assert.Equal(t, modes[1].Mode, AddrMode_Indirect)
+	assert.Equal(t, InstructionModes("XYZ") == nil, true)
+
+	for _, info := range Opcodes() {
+		found, ok := LookupInstruction(info.Mnemonic, info.Mode)
+		assert.Equal(t, ok, true)
+		assert.Equal(t, found, info)
+	}
+}
+
+func TestOpcodeTable(t *testing.T) {
+	pack := assert.Pack
+
+	documented, undocumented := 0, 0
+	for n := 0; n < 256; n++ {
+		info, ok := LookupOpcode(uint8(n))
+		assert.Equal(t, info.Opcode, uint8(n))
+		assert.Equal(t, ok, info.Documented)
+		assert.Equal(t, info.Mode != AddrMode_Invalid, true)
+		assert.Equal(t, info.Length, InstructionBytes(info.Mode))
+		assert.Equal(t, info.Cycles > 0, info.Mnemonic != "JAM")
+
+		// the assembler only uses documented encodings
+		found, ok := LookupInstruction(info.Mnemonic, info.Mode)
+		if info.Documented {
+			documented++
+			assert.Equal(t, found, info)
+		} else {
+			undocumented++
+			assert.Equal(t, ok && found.Opcode == info.Opcode, false)
+		}
+	}
+	assert.Equal(t, documented, 151)
+	assert.Equal(t, undocumented, 105)
+	assert.Equal(t, len(Opcodes()), 151)
+
+	tests := []struct {
+		opcode     uint8
+		mnemonic   string
+		mode       AddressMode
+		cycles     int
+		pageCross  PageCrossPenalty
+		documented bool
+	}{
+		{0x69, "ADC", AddrMode_Immediate, 2, PageCross_None, true},
+		{0xf1, "SBC", AddrMode_PostIndexIndirect, 5, PageCross_Indexed, true},
+		{0xdd, "CMP", AddrMode_AbsoluteIndexedX, 4, PageCross_Indexed, true},
+		{0x2c, "BIT", AddrMode_Absolute, 4, PageCross_None, true},
+		{0x0a, "ASL", AddrMode_Implicit, 2, PageCross_None, true},
+		{0x7e, "ROR", AddrMode_AbsoluteIndexedX, 7, PageCross_None, true},
+		{0xeb, "SBC", AddrMode_Immediate, 2, PageCross_None, false},
+		{0xbf, "LAX", AddrMode_AbsoluteIndexedY, 4, PageCross_Indexed, false},
+		{0xdf, "DCP", AddrMode_AbsoluteIndexedX, 7, PageCross_None, false},
+		{0xfc, "NOP", AddrMode_AbsoluteIndexedX, 4, PageCross_Indexed, false},
+		{0x02, "JAM", AddrMode_Implicit, 0, PageCross_None, false},
+	}
+	for _, test := range tests {
+		info, _ := LookupOpcode(test.opcode)
+		assert.Equal(t, info.Mnemonic, test.mnemonic)
+		assert.Equal(t, info.Mode, test.mode)
+		assert.Equal(t, info.Cycles, test.cycles)
+		assert.Equal(t, info.PageCross, test.pageCross)
+		assert.Equal(t, info.Documented, test.documented)
+	}
+
+	info, ok := LookupInstruction("sbc", AddrMode_Immediate)
+	assert.Equal(t, pack(info.Opcode, ok), pack(uint8(0xe9), true))
+	_, ok = LookupInstruction("lax", AddrMode_AbsoluteZeroPage)
+	assert.Equal(t, ok, false)
+}
@@ -0,0 +1,174 @@
+package core6502
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+/*
+	A snapshot of the emulator state, saved as a header followed by
+	chunks, all little endian:
+
+	header:  "6502SNAP" version:2
+	chunk:   id:4 length:4 data[length]
+
+	Each part of the machine saves its state in chunks of its own, so a
+	new device adds chunks without changing the others. Readers skip
+	chunks they don't know, and a chunk may grow in a later version by
+	appending fields, which readers ignore. The version only changes if
+	existing fields change. The chunks are:
+
+	"CPU "  a x y sp p pc:2
+	"RAM "  the 64K of memory of a BasicCPUContext
+	"CYCL"  cycles:8, the cycles executed
+
+	and for the NES, in nes6502, "NRAM", "PPU ", "APU ", "CART", "MAPR"
+	and "PRAM".
+*/
+type Snapshot struct {
+	Version int
+	chunks  []snapshotChunk
+}
+
+type snapshotChunk struct {
+	id   string
+	data []uint8
+}
+
+const (
+	snapshotMagic   = "6502SNAP"
+	SnapshotVersion = 1
+)
+
+// implemented by contexts and devices that can save and restore their state
+type Snapshotter interface {
+	SaveState(snap *Snapshot)
+	LoadState(snap *Snapshot) error
+}
+
+func NewSnapshot() *Snapshot {
+	return &Snapshot{Version: SnapshotVersion}
+}
+
+// sets the chunk id, replacing any chunk with the same id
+func (s *Snapshot) Set(id string, data []uint8) {
+	if len(id) != 4 {
+		panic("snapshot chunk id must be 4 characters: " + id)
+	}
+	for n := range s.chunks {
+		if s.chunks[n].id == id {
+			s.chunks[n].data = data
+			return
+		}
+	}
+	s.chunks = append(s.chunks, snapshotChunk{id, data})
+}
+
+func (s *Snapshot) Get(id string) ([]uint8, bool) {
+	for _, c := range s.chunks {
+		if c.id == id {
+			return c.data, true
+		}
+	}
+	return nil, false
+}
+
+// the chunk id, an error if it is missing or shorter than size
+func (s *Snapshot) Need(id string, size int) ([]uint8, error) {
+	data, ok := s.Get(id)
+	if !ok {
+		return nil, fmt.Errorf("Snapshot has no %q chunk", id)
+	}
+	if len(data) < size {
+		return nil, fmt.Errorf("Snapshot %q chunk is too short", id)
+	}
+	return data, nil
+}
+
+// the chunk ids, in the order they were added or read
+func (s *Snapshot) IDs() []string {
+	var ids []string
+	for _, c := range s.chunks {
+		ids = append(ids, c.id)
+	}
+	return ids
+}
+
+func (s *Snapshot) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	buf.WriteString(snapshotMagic)
+	binary.Write(&buf, binary.LittleEndian, uint16(s.Version))
+	for _, c := range s.chunks {
+		buf.WriteString(c.id)
+		binary.Write(&buf, binary.LittleEndian, uint32(len(c.data)))
+		buf.Write(c.data)
+	}
+	return buf.WriteTo(w)
+}
+
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	header := make([]uint8, len(snapshotMagic)+2)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, fmt.Errorf("Not a snapshot")
+	}
+	s := &Snapshot{Version: int(binary.LittleEndian.Uint16(header[len(snapshotMagic):]))}
+	if s.Version < 1 || s.Version > SnapshotVersion {
+		return nil, fmt.Errorf("Unsupported snapshot version %d", s.Version)
+	}
+
+	for {
+		chunk := make([]uint8, 8)
+		if _, err := io.ReadFull(r, chunk); err == io.EOF {
+			return s, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("Truncated snapshot")
+		}
+		// copied as it is read, a corrupt length cannot allocate 4G up front
+		var data bytes.Buffer
+		if _, err := io.CopyN(&data, r, int64(binary.LittleEndian.Uint32(chunk[4:]))); err != nil {
+			return nil, fmt.Errorf("Truncated snapshot %q chunk", chunk[:4])
+		}
+		s.chunks = append(s.chunks, snapshotChunk{string(chunk[:4]), data.Bytes()})
+	}
+}
+
+// saves the registers in the "CPU " chunk
+func SaveRegisters(snap *Snapshot, regs CPURegisters) {
+	pc := regs.RegPC()
+	snap.Set("CPU ", []uint8{regs.RegA(), regs.RegX(), regs.RegY(), regs.RegSP(), regs.Flags(), uint8(pc), uint8(pc >> 8)})
+}
+
+func LoadRegisters(snap *Snapshot, regs CPURegisters) error {
+	data, err := snap.Need("CPU ", 7)
+	if err != nil {
+		return err
+	}
+	regs.SetRegA(data[0])
+	regs.SetRegX(data[1])
+	regs.SetRegY(data[2])
+	regs.SetRegSP(data[3])
+	regs.SetFlags(data[4])
+	regs.SetRegPC(MakeWord(data[6], data[5]))
+	return nil
+}
+
+func (c *BasicCPUContext) SaveState(snap *Snapshot) {
+	SaveRegisters(snap, c)
+	ram := make([]uint8, len(c.ram))
+	copy(ram, c.ram[:])
+	snap.Set("RAM ", ram)
+}
+
+func (c *BasicCPUContext) LoadState(snap *Snapshot) error {
+	ram, err := snap.Need("RAM ", len(c.ram))
+	if err != nil {
+		return err
+	}
+	if err := LoadRegisters(snap, c); err != nil {
+		return err
+	}
+	copy(c.ram[:], ram)
+	return nil
+}
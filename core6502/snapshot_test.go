@@ -0,0 +1,56 @@
+package core6502
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/simulatedsimian/assert"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	pack := assert.Pack
+
+	var ctx BasicCPUContext
+	ctx.SetRegA(1)
+	ctx.SetRegX(2)
+	ctx.SetRegY(3)
+	ctx.SetRegSP(0xfd)
+	ctx.SetFlags(0xa5)
+	ctx.SetRegPC(0x1234)
+	ctx.Poke(0x0000, 0x11)
+	ctx.Poke(0xffff, 0x22)
+
+	snap := NewSnapshot()
+	ctx.SaveState(snap)
+	snap.Set("XTRA", []uint8{1, 2, 3})
+	var buf bytes.Buffer
+	_, err := snap.WriteTo(&buf)
+	assert.NoError(t, pack(err))
+	data := buf.Bytes()
+
+	snap, err = ReadSnapshot(bytes.NewReader(data))
+	assert.NoError(t, pack(err))
+	assert.Equal(t, snap.IDs(), []string{"CPU ", "RAM ", "XTRA"})
+
+	var loaded BasicCPUContext
+	assert.NoError(t, pack(loaded.LoadState(snap)))
+	assert.Equal(t, loaded, ctx)
+
+	// a missing chunk leaves the context unchanged
+	snap = NewSnapshot()
+	SaveRegisters(snap, &ctx)
+	loaded = BasicCPUContext{}
+	assert.Equal(t, fmt.Sprint(loaded.LoadState(snap)), `Snapshot has no "RAM " chunk`)
+	assert.Equal(t, loaded, BasicCPUContext{})
+
+	_, err = ReadSnapshot(bytes.NewReader([]uint8("6502SNAX\x01\x00")))
+	assert.Equal(t, fmt.Sprint(err), "Not a snapshot")
+	_, err = ReadSnapshot(bytes.NewReader([]uint8("6502SNAP\x02\x00")))
+	assert.Equal(t, fmt.Sprint(err), "Unsupported snapshot version 2")
+	_, err = ReadSnapshot(bytes.NewReader(data[:len(data)-1]))
+	assert.Equal(t, fmt.Sprint(err), `Truncated snapshot "XTRA" chunk`)
+	_, err = ReadSnapshot(bytes.NewReader(data[:len(data)-9]))
+	assert.Equal(t, fmt.Sprint(err), "Truncated snapshot")
+	_, err = ReadSnapshot(bytes.NewReader([]uint8("6502SNAP\x01\x00RAM \xff\xff\xff\xff\x01\x02")))
+	assert.Equal(t, fmt.Sprint(err), `Truncated snapshot "RAM " chunk`)
+}
@@ -0,0 +1,137 @@
+package core6502
+
+import (
+	"fmt"
+	"sort"
+)
+
+// largest offset from a symbol shown as label+offset
+const MaxSymbolOffset = 0x100
+
+type Symbol struct {
+	Name string
+	Addr uint16
+}
+
+// Maps symbol names to addresses and addresses back to names.
+// An address may have several names, the first one added is preferred.
+type SymbolTable struct {
+	byName map[string]uint16
+	byAddr map[uint16][]string
+	sorted []uint16
+}
+
+func NewSymbolTable() *SymbolTable {
+	st := &SymbolTable{}
+	st.Clear()
+	return st
+}
+
+func (st *SymbolTable) Clear() {
+	st.byName = make(map[string]uint16)
+	st.byAddr = make(map[uint16][]string)
+	st.sorted = nil
+}
+
+func (st *SymbolTable) Len() int {
+	return len(st.byName)
+}
+
+// Adds a symbol, replacing any existing symbol of the same name
+func (st *SymbolTable) Add(name string, addr uint16) {
+	if old, ok := st.byName[name]; ok {
+		if old == addr {
+			return
+		}
+		st.removeName(old, name)
+	}
+
+	st.byName[name] = addr
+	st.byAddr[addr] = append(st.byAddr[addr], name)
+	st.sorted = nil
+}
+
+func (st *SymbolTable) removeName(addr uint16, name string) {
+	names := st.byAddr[addr]
+	for i, n := range names {
+		if n == name {
+			names = append(names[:i], names[i+1:]...)
+			break
+		}
+	}
+	if len(names) == 0 {
+		delete(st.byAddr, addr)
+	} else {
+		st.byAddr[addr] = names
+	}
+}
+
+func (st *SymbolTable) Lookup(name string) (uint16, bool) {
+	addr, ok := st.byName[name]
+	return addr, ok
+}
+
+// implements SymbolResolver
+func (st *SymbolTable) ResolveSymbol(name string) (int, bool) {
+	addr, ok := st.byName[name]
+	return int(addr), ok
+}
+
+// returns the preferred name of addr
+func (st *SymbolTable) Name(addr uint16) (string, bool) {
+	if names, ok := st.byAddr[addr]; ok {
+		return names[0], true
+	}
+	return "", false
+}
+
+// returns all names of addr in the order they were added
+func (st *SymbolTable) Names(addr uint16) []string {
+	return st.byAddr[addr]
+}
+
+// returns the symbol at or below addr closest to it, and the offset of addr from it
+func (st *SymbolTable) Nearest(addr uint16) (Symbol, uint16, bool) {
+	if st.sorted == nil {
+		st.sorted = make([]uint16, 0, len(st.byAddr))
+		for a := range st.byAddr {
+			st.sorted = append(st.sorted, a)
+		}
+		sort.Slice(st.sorted, func(i, j int) bool { return st.sorted[i] < st.sorted[j] })
+	}
+
+	i := sort.Search(len(st.sorted), func(i int) bool { return st.sorted[i] > addr })
+	if i == 0 {
+		return Symbol{}, 0, false
+	}
+	found := st.sorted[i-1]
+	return Symbol{st.byAddr[found][0], found}, addr - found, true
+}
+
+// formats addr as label or label+offset, or returns "" if no symbol
+// lies within MaxSymbolOffset below it
+func (st *SymbolTable) Format(addr uint16) string {
+	sym, offset, ok := st.Nearest(addr)
+	if !ok || offset >= MaxSymbolOffset {
+		return ""
+	}
+	if offset == 0 {
+		return sym.Name
+	}
+	return fmt.Sprintf("%s+%d", sym.Name, offset)
+}
+
+// returns all symbols ordered by address then name
+func (st *SymbolTable) Symbols() []Symbol {
+	syms := make([]Symbol, 0, len(st.byName))
+	for name, addr := range st.byName {
+		syms = append(syms, Symbol{name, addr})
+	}
+	sort.Slice(syms, func(i, j int) bool {
+		if syms[i].Addr != syms[j].Addr {
+			return syms[i].Addr < syms[j].Addr
+		}
+		return syms[i].Name < syms[j].Name
+	})
+	return syms
+}
@@ -0,0 +1,92 @@
+package core6502
+
+import (
+	"github.com/simulatedsimian/assert"
+	"strings"
+	"testing"
+)
+
+func TestSymbolTable(t *testing.T) {
+	st := NewSymbolTable()
+	st.Add("start", 0x400)
+	st.Add("loop", 0x410)
+	st.Add("entry", 0x400)
+
+	assert.Equal(t, st.Len(), 3)
+	assert.Equal(t, st.Names(0x400), []string{"start", "entry"})
+	assert.Equal(t, st.Format(0x400), "start")
+	assert.Equal(t, st.Format(0x413), "loop+3")
+	assert.Equal(t, st.Format(0x3ff), "")
+	assert.Equal(t, st.Format(0x410+MaxSymbolOffset), "")
+
+	st.Add("start", 0x500)
+	assert.Equal(t, st.Names(0x400), []string{"entry"})
+	assert.Equal(t, st.Format(0x501), "start+1")
+
+	val, err := EvalExpr("loop+2", &ExprEnv{Symbols: st})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, val, 0x412)
+}
+
+func loadSymbols(t *testing.T, data string) *SymbolTable {
+	st := NewSymbolTable()
+	if _, err := st.Load(strings.NewReader(data), "test"); err != nil {
+		t.Fatal(err)
+	}
+	return st
+}
+
+func TestLoadVICELabels(t *testing.T) {
+	pack := assert.Pack
+
+	st := loadSymbols(t, "al C:0400 .start\nal 00C010 .irq\n\n")
+	assert.Equal(t, pack(st.Lookup("start")), []interface{}{uint16(0x400), true})
+	assert.Equal(t, pack(st.Lookup("irq")), []interface{}{uint16(0xc010), true})
+
+	// reloading counts the symbols again
+	assert.Equal(t, pack(st.Load(strings.NewReader("al C:0400 .start\nal 00C010 .irq\n"), "test")), []interface{}{2, nil})
+	assert.Equal(t, st.Len(), 2)
+
+	_, err := NewSymbolTable().Load(strings.NewReader("al C:04zz .start\n"), "test")
+	assert.Equal(t, err.Error(), "test:1: Invalid address: 04zz")
+}
+
+func TestLoadLD65Map(t *testing.T) {
+	pack := assert.Pack
+
+	st := loadSymbols(t, `Modules list:
+-------------
+hello.o:
+    CODE              Offs=000000  Size=000039  Align=00001  Fill=0000
+
+Exports list by name:
+---------------------
+__STACKSIZE__             000800 REA    _main                     000239 RLA
+
+Exports list by value:
+---------------------
+_main                     000239 RLA
+`)
+	assert.Equal(t, st.Len(), 2)
+	assert.Equal(t, pack(st.Lookup("_main")), []interface{}{uint16(0x239), true})
+}
+
+func TestLoadCA65Dbg(t *testing.T) {
+	pack := assert.Pack
+
+	st := loadSymbols(t, "version\tmajor=2,minor=0\n"+
+		"sym\tid=0,name=\"main\",addrsize=absolute,scope=0,def=1,val=0x200,seg=0,type=lab\n"+
+		"sym\tid=1,name=\"@loop\",addrsize=absolute,scope=0,parent=0,def=2,val=0x204,seg=0,type=lab\n"+
+		"sym\tid=2,name=\"COUNT\",addrsize=zeropage,scope=0,def=3,val=0x3,type=equ\n"+
+		"sym\tid=3,name=\"extern\",addrsize=absolute,scope=0,ref=4,type=imp\n")
+	assert.Equal(t, st.Len(), 2)
+	assert.Equal(t, pack(st.Lookup("main@loop")), []interface{}{uint16(0x204), true})
+}
+
+func TestLoadSimpleSymbols(t *testing.T) {
+	pack := assert.Pack
+
+	st := loadSymbols(t, "; screen\nSCREEN = $0400\nCOLOR $d800 # colour ram\nEND = SCREEN + 1000\n")
+	assert.Equal(t, pack(st.Lookup("COLOR")), []interface{}{uint16(0xd800), true})
+	assert.Equal(t, pack(st.Lookup("END")), []interface{}{uint16(0x7e8), true})
+}
@@ -0,0 +1,301 @@
+package core6502
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type SymbolFileFormat int
+
+const (
+	SymFile_Simple  SymbolFileFormat = iota // name = value, one per line
+	SymFile_VICE                            // al C:1234 .label
+	SymFile_LD65Map                         // ld65 -m map file
+	SymFile_CA65Dbg                         // ld65 --dbgfile debug info
+)
+
+func (f SymbolFileFormat) String() string {
+	switch f {
+	case SymFile_VICE:
+		return "VICE labels"
+	case SymFile_LD65Map:
+		return "ld65 map"
+	case SymFile_CA65Dbg:
+		return "ca65 debug info"
+	}
+	return "simple"
+}
+
+// determines the format of a symbol file from its contents
+func DetectSymbolFileFormat(data []byte) SymbolFileFormat {
+	switch {
+	case bytes.Contains(data, []byte("Exports list by name:")):
+		return SymFile_LD65Map
+	case bytes.HasPrefix(data, []byte("version\tmajor=")):
+		return SymFile_CA65Dbg
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 {
+			if fields[0] == "al" {
+				return SymFile_VICE
+			}
+			break
+		}
+	}
+	return SymFile_Simple
+}
+
+// loads symbols from filename, detecting its format.
+// returns the number of symbols loaded
+func (st *SymbolTable) LoadFile(filename string) (int, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return st.Load(f, filename)
+}
+
+// loads symbols from r, detecting its format. name is used in error messages.
+// returns the number of symbols loaded, including any already in the table
+func (st *SymbolTable) Load(r io.Reader, name string) (int, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	add := func(sym string, addr uint16) {
+		st.Add(sym, addr)
+		count++
+	}
+
+	switch DetectSymbolFileFormat(data) {
+	case SymFile_VICE:
+		err = st.loadVICE(data, name, add)
+	case SymFile_LD65Map:
+		err = st.loadLD65Map(data, name, add)
+	case SymFile_CA65Dbg:
+		err = st.loadCA65Dbg(data, name, add)
+	default:
+		err = st.loadSimple(data, name, add)
+	}
+	return count, err
+}
+
+func forEachLine(data []byte, f func(lineNo int, line string) error) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		if err := f(lineNo, strings.TrimSpace(scanner.Text())); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func parseHexAddr(s string) (uint16, error) {
+	val, err := strconv.ParseUint(s, 16, 32)
+	if err != nil || val > 0xffff {
+		return 0, fmt.Errorf("Invalid address: %s", s)
+	}
+	return uint16(val), nil
+}
+
+// al C:1234 .label
+func (st *SymbolTable) loadVICE(data []byte, name string, add func(string, uint16)) error {
+	return forEachLine(data, func(lineNo int, line string) error {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], ";") {
+			return nil
+		}
+		if fields[0] != "al" || len(fields) != 3 {
+			return fmt.Errorf("%s:%d: Invalid label: %s", name, lineNo, line)
+		}
+
+		addrStr := fields[1]
+		if i := strings.Index(addrStr, ":"); i >= 0 {
+			addrStr = addrStr[i+1:]
+		}
+		addr, err := parseHexAddr(addrStr)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", name, lineNo, err)
+		}
+
+		add(strings.TrimPrefix(fields[2], "."), addr)
+		return nil
+	})
+}
+
+/*
+	Reads the exports section of an ld65 map file:
+
+	Exports list by name:
+	---------------------
+	__STACKSIZE__             000800 REA    _main                     000239 RLA
+*/
+func (st *SymbolTable) loadLD65Map(data []byte, name string, add func(string, uint16)) error {
+	inExports := false
+	return forEachLine(data, func(lineNo int, line string) error {
+		switch {
+		case line == "Exports list by name:":
+			inExports = true
+			return nil
+		case !inExports || strings.HasPrefix(line, "---"):
+			return nil
+		case line == "":
+			inExports = false
+			return nil
+		}
+
+		fields := strings.Fields(line)
+		if len(fields)%3 != 0 {
+			return fmt.Errorf("%s:%d: Invalid export: %s", name, lineNo, line)
+		}
+		for n := 0; n < len(fields); n += 3 {
+			addr, err := parseHexAddr(fields[n+1])
+			if err != nil {
+				return fmt.Errorf("%s:%d: %v", name, lineNo, err)
+			}
+			add(fields[n], addr)
+		}
+		return nil
+	})
+}
+
+// a line of an ld65 debug info file: type followed by key=value attributes
+type dbgRecord struct {
+	kind  string
+	attrs map[string]string
+}
+
+func parseDbgRecord(line string) (dbgRecord, error) {
+	rec := dbgRecord{attrs: map[string]string{}}
+	tab := strings.IndexAny(line, "\t ")
+	if tab < 0 {
+		return rec, fmt.Errorf("Invalid record: %s", line)
+	}
+	rec.kind = line[:tab]
+
+	rest := strings.TrimSpace(line[tab+1:])
+	for len(rest) > 0 {
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			return rec, fmt.Errorf("Invalid attribute: %s", rest)
+		}
+		key := rest[:eq]
+		rest = rest[eq+1:]
+
+		var val string
+		if strings.HasPrefix(rest, "\"") {
+			end := strings.Index(rest[1:], "\"")
+			if end < 0 {
+				return rec, fmt.Errorf("Unterminated string: %s", rest)
+			}
+			val = rest[1 : end+1]
+			rest = rest[end+2:]
+		} else {
+			end := strings.Index(rest, ",")
+			if end < 0 {
+				end = len(rest)
+			}
+			val = rest[:end]
+			rest = rest[end:]
+		}
+		rec.attrs[key] = val
+		rest = strings.TrimPrefix(rest, ",")
+	}
+	return rec, nil
+}
+
+func (rec dbgRecord) int(key string) (int, bool) {
+	s, ok := rec.attrs[key]
+	if !ok {
+		return 0, false
+	}
+	val, err := strconv.ParseInt(s, 0, 32)
+	return int(val), err == nil
+}
+
+func parseDbgRecords(data []byte, name string) ([]dbgRecord, error) {
+	var recs []dbgRecord
+	err := forEachLine(data, func(lineNo int, line string) error {
+		if line == "" {
+			return nil
+		}
+		rec, err := parseDbgRecord(line)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", name, lineNo, err)
+		}
+		recs = append(recs, rec)
+		return nil
+	})
+	return recs, err
+}
+
+// sym id=0,name="main",addrsize=absolute,scope=0,def=1,val=0x200,seg=0,type=lab
+// cheap locals (with a parent attribute) are named parent@local
+func (st *SymbolTable) loadCA65Dbg(data []byte, name string, add func(string, uint16)) error {
+	recs, err := parseDbgRecords(data, name)
+	if err != nil {
+		return err
+	}
+
+	names := map[string]string{}
+	for _, rec := range recs {
+		if rec.kind == "sym" {
+			names[rec.attrs["id"]] = rec.attrs["name"]
+		}
+	}
+
+	for _, rec := range recs {
+		if rec.kind != "sym" || rec.attrs["type"] != "lab" {
+			continue
+		}
+		val, ok := rec.int("val")
+		if !ok {
+			continue
+		}
+		symName := rec.attrs["name"]
+		if parent, ok := rec.attrs["parent"]; ok {
+			symName = names[parent] + symName
+		}
+		add(symName, uint16(val))
+	}
+	return nil
+}
+
+// name = value or name value, value being any constant expression.
+// ; and # start comments
+func (st *SymbolTable) loadSimple(data []byte, name string, add func(string, uint16)) error {
+	return forEachLine(data, func(lineNo int, line string) error {
+		if i := strings.IndexAny(line, ";#"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		if line == "" {
+			return nil
+		}
+
+		fields := Split(line, " \t=")
+		if len(fields) < 2 {
+			return fmt.Errorf("%s:%d: Invalid symbol: %s", name, lineNo, line)
+		}
+
+		valStr := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line[len(fields[0]):]), "="))
+		val, err := EvalExpr(valStr, &ExprEnv{Symbols: st})
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", name, lineNo, err)
+		}
+		add(fields[0], uint16(val))
+		return nil
+	})
+}
@@ -0,0 +1,120 @@
+package core6502
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+/*
+	Writes one line per executed instruction in the format of nestest.log
+	as produced by Nintendulator, so a trace can be diffed against a
+	reference emulator:
+
+	C72D  B1 89     LDA ($89),Y = 0300 @ 0300 = 89  A:00 X:00 Y:00 P:24 SP:FD PPU:  0, 21 CYC:7
+
+	Cycles is the total before the instruction; nestest starts at 7, the
+	length of the reset sequence. The PPU dot and scanline are derived from
+	it at 3 dots per CPU cycle.
+*/
+type Tracer struct {
+	W      io.Writer
+	Cycles uint64
+}
+
+func NewTracer(w io.Writer) *Tracer {
+	return &Tracer{W: w, Cycles: 7}
+}
+
+// writes the trace line for the instruction at the PC, executes it and
+// adds its cycles to the total
+func (t *Tracer) Execute(ctx CPUContext) (int, error) {
+	t.Trace(ctx)
+	cycles, err := Execute(ctx)
+	t.Cycles += uint64(cycles)
+	return cycles, err
+}
+
+// writes the trace line for the instruction at the PC, for a caller
+// executing it some other way, which then adds its cycles to Cycles
+func (t *Tracer) Trace(ctx CPUContext) {
+	fmt.Fprintln(t.W, TraceLine(ctx, t.Cycles))
+}
+
+// the trace line for the instruction at the PC, cycles is the CYC field
+func TraceLine(ctx CPUContext, cycles uint64) string {
+	inst := Decode(ctx, ctx.RegPC())
+
+	raw := []string{fmt.Sprintf("%02X", inst.Opcode)}
+	for _, b := range inst.Operand {
+		raw = append(raw, fmt.Sprintf("%02X", b))
+	}
+
+	mark, text := " ", traceOperand(ctx, &inst)
+	if !inst.Legal {
+		mark = "*"
+	}
+
+	dots := cycles * 3
+	return fmt.Sprintf("%04X  %-8s %s%-32sA:%02X X:%02X Y:%02X P:%02X SP:%02X PPU:%3d,%3d CYC:%d",
+		inst.Addr, strings.Join(raw, " "), mark, text,
+		ctx.RegA(), ctx.RegX(), ctx.RegY(), ctx.Flags(), ctx.RegSP(),
+		dots/341%262, dots%341, cycles)
+}
+
+// the disassembly with the effective address and the value there, before execution
+func traceOperand(ctx CPUContext, inst *Instruction) string {
+	if !inst.Legal {
+		return fmt.Sprintf(".byte $%02X", inst.Opcode)
+	}
+
+	text := inst.Mnemonic + " "
+	value := func(addr uint16) string {
+		return fmt.Sprintf(" = %02X", ctx.Peek(addr))
+	}
+
+	switch inst.Mode {
+	case AddrMode_Implicit:
+		switch inst.Mnemonic {
+		case "ASL", "LSR", "ROL", "ROR":
+			return text + "A"
+		}
+		return inst.Mnemonic
+	case AddrMode_Immediate:
+		return text + fmt.Sprintf("#$%02X", inst.Value)
+	case AddrMode_AbsoluteZeroPage:
+		return text + fmt.Sprintf("$%02X", inst.Value) + value(inst.Value)
+	case AddrMode_ZeroPageIdxX, AddrMode_ZeroPageIdxY:
+		reg, index := "X", ctx.RegX()
+		if inst.Mode == AddrMode_ZeroPageIdxY {
+			reg, index = "Y", ctx.RegY()
+		}
+		addr := uint16(uint8(inst.Value) + index)
+		return text + fmt.Sprintf("$%02X,%s @ %02X", inst.Value, reg, addr) + value(addr)
+	case AddrMode_Absolute:
+		if inst.Mnemonic == "JMP" || inst.Mnemonic == "JSR" {
+			return text + fmt.Sprintf("$%04X", inst.Value)
+		}
+		return text + fmt.Sprintf("$%04X", inst.Value) + value(inst.Value)
+	case AddrMode_AbsoluteIndexedX, AddrMode_AbsoluteIndexedY:
+		reg, index := "X", ctx.RegX()
+		if inst.Mode == AddrMode_AbsoluteIndexedY {
+			reg, index = "Y", ctx.RegY()
+		}
+		addr := inst.Value + uint16(index)
+		return text + fmt.Sprintf("$%04X,%s @ %04X", inst.Value, reg, addr) + value(addr)
+	case AddrMode_PreIndexIndirect:
+		ptr := uint16(uint8(inst.Value) + ctx.RegX())
+		addr := ctx.PeekWord(ptr)
+		return text + fmt.Sprintf("($%02X,X) @ %02X = %04X", inst.Value, ptr, addr) + value(addr)
+	case AddrMode_PostIndexIndirect:
+		base := ctx.PeekWord(inst.Value)
+		addr := base + uint16(ctx.RegY())
+		return text + fmt.Sprintf("($%02X),Y = %04X @ %04X", inst.Value, base, addr) + value(addr)
+	case AddrMode_Indirect:
+		return text + fmt.Sprintf("($%04X) = %04X", inst.Value, inst.Target)
+	case AddrMode_Relative:
+		return text + fmt.Sprintf("$%04X", inst.Target)
+	}
+	return inst.Mnemonic
+}
@@ -0,0 +1,101 @@
+package core6502
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/simulatedsimian/assert"
+	"strings"
+	"testing"
+)
+
+func TestTracer(t *testing.T) {
+	pack := assert.Pack
+
+	var ctx BasicCPUContext
+	for addr, b := range map[uint16][]uint8{
+		0xc000: {0x4c, 0xf5, 0xc5},
+		0xc5f5: {0xa2, 0x00, 0x86, 0x00, 0x86, 0x10, 0x86, 0x11, 0x20, 0x2d, 0xc7},
+		0xc72d: {0xea},
+	} {
+		for n, val := range b {
+			ctx.Poke(addr+uint16(n), val)
+		}
+	}
+	ctx.SetRegPC(0xc000)
+	ctx.SetRegSP(0xfd)
+	ctx.SetFlags(0x24)
+
+	// the start of nestest.log
+	var out bytes.Buffer
+	tracer := NewTracer(&out)
+	for n := 0; n < 7; n++ {
+		_, err := tracer.Execute(&ctx)
+		assert.NoError(t, pack(err))
+	}
+	assert.Equal(t, strings.Split(out.String(), "\n"), []string{
+		"C000  4C F5 C5  JMP $C5F5                       A:00 X:00 Y:00 P:24 SP:FD PPU:  0, 21 CYC:7",
+		"C5F5  A2 00     LDX #$00                        A:00 X:00 Y:00 P:24 SP:FD PPU:  0, 30 CYC:10",
+		"C5F7  86 00     STX $00 = 00                    A:00 X:00 Y:00 P:26 SP:FD PPU:  0, 36 CYC:12",
+		"C5F9  86 10     STX $10 = 00                    A:00 X:00 Y:00 P:26 SP:FD PPU:  0, 45 CYC:15",
+		"C5FB  86 11     STX $11 = 00                    A:00 X:00 Y:00 P:26 SP:FD PPU:  0, 54 CYC:18",
+		"C5FD  20 2D C7  JSR $C72D                       A:00 X:00 Y:00 P:26 SP:FD PPU:  0, 63 CYC:21",
+		"C72D  EA        NOP                             A:00 X:00 Y:00 P:26 SP:FB PPU:  0, 81 CYC:27",
+		"",
+	})
+	assert.Equal(t, tracer.Cycles, uint64(29))
+}
+
+func TestTraceOperands(t *testing.T) {
+	var ctx BasicCPUContext
+	ctx.SetRegX(0x02)
+	ctx.SetRegY(0x10)
+	ctx.PokeWord(0x0080, 0x0300)
+	ctx.PokeWord(0x0200, 0xdb7e)
+	ctx.Poke(0x0033, 0xaa)
+	ctx.Poke(0x0300, 0x5a)
+	ctx.Poke(0x0302, 0x89)
+	ctx.Poke(0x0310, 0x77)
+
+	tests := []struct {
+		code []uint8
+		text string
+	}{
+		{[]uint8{0xb5, 0x31}, "LDA $31,X @ 33 = AA"},
+		{[]uint8{0xb6, 0x23}, "LDX $23,Y @ 33 = AA"},
+		{[]uint8{0xad, 0x00, 0x03}, "LDA $0300 = 5A"},
+		{[]uint8{0xbd, 0x00, 0x03}, "LDA $0300,X @ 0302 = 89"},
+		{[]uint8{0xb9, 0x00, 0x03}, "LDA $0300,Y @ 0310 = 77"},
+		{[]uint8{0x9d, 0x00, 0x03}, "STA $0300,X @ 0302 = 89"},
+		{[]uint8{0xa1, 0x7e}, "LDA ($7E,X) @ 80 = 0300 = 5A"},
+		{[]uint8{0xb1, 0x80}, "LDA ($80),Y = 0300 @ 0310 = 77"},
+		{[]uint8{0x6c, 0x00, 0x02}, "JMP ($0200) = DB7E"},
+		{[]uint8{0xb0, 0xfe}, "BCS $0400"},
+		{[]uint8{0x4a}, "LSR A"},
+		{[]uint8{0xe8}, "INX"},
+		{[]uint8{0x02}, ".byte $02"},
+	}
+
+	for _, test := range tests {
+		for n, b := range test.code {
+			ctx.Poke(0x400+uint16(n), b)
+		}
+		ctx.SetRegPC(0x400)
+		assert.Equal(t, strings.TrimSpace(TraceLine(&ctx, 0)[16:48]), test.text)
+	}
+
+	line := TraceLine(&ctx, 29781)
+	assert.Equal(t, line[14:16], " *")
+	assert.Equal(t, line[strings.Index(line, "PPU:"):], "PPU:  0,  1 CYC:29781") // wrapped past scanline 261
+
+	// abs,X and abs,Y load the value traced
+	for _, code := range [][]uint8{{0xbd, 0x00, 0x03}, {0xb9, 0x00, 0x03}} {
+		for n, b := range code {
+			ctx.Poke(0x400+uint16(n), b)
+		}
+		ctx.SetRegPC(0x400)
+		text := strings.TrimSpace(TraceLine(&ctx, 0)[16:48])
+		_, err := Execute(&ctx)
+		assert.NoError(t, assert.Pack(err))
+		assert.Equal(t, text[len(text)-2:], fmt.Sprintf("%02X", ctx.RegA()))
+	}
+}
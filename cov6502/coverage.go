@@ -0,0 +1,125 @@
+package cov6502
+
+import (
+	"github.com/simulatedsimian/emu6502/core6502"
+	"sort"
+)
+
+// per byte coverage flags
+const (
+	Cov_Opcode  uint8 = 1 << iota // executed as an opcode
+	Cov_Operand                   // executed as an operand
+	Cov_Read                      // read as data
+	Cov_Write                     // written as data
+)
+
+// outcomes seen of a conditional branch
+const (
+	branchTaken uint8 = 1 << iota
+	branchNotTaken
+)
+
+// Coverage of each byte of memory and the outcomes of each conditional branch
+type Coverage struct {
+	Flags    [0x10000]uint8
+	branches map[uint16]uint8
+}
+
+func New() *Coverage {
+	return &Coverage{branches: map[uint16]uint8{}}
+}
+
+/*
+	Executes the instruction at the PC, recording its bytes as executed,
+	the memory it reads and writes apart from its own bytes, and for a
+	conditional branch whether it was taken.
+*/
+func (c *Coverage) Execute(ctx core6502.CPUContext) (int, error) {
+	pc := ctx.RegPC()
+	inst := core6502.Decode(ctx, pc)
+
+	cycles, err := core6502.Execute(&core6502.AccessContext{
+		CPUContext: ctx,
+		PC:         pc,
+		Length:     inst.Length,
+		Load:       func(addr uint16) { c.Flags[addr] |= Cov_Read },
+		Store:      func(addr uint16) { c.Flags[addr] |= Cov_Write },
+	})
+	if err != nil {
+		return cycles, err
+	}
+
+	c.Flags[pc] |= Cov_Opcode
+	for n := uint16(1); n < inst.Length; n++ {
+		c.Flags[pc+n] |= Cov_Operand
+	}
+
+	if inst.Mode == core6502.AddrMode_Relative {
+		switch next := ctx.RegPC(); {
+		case inst.Target == pc+2:
+			c.branches[pc] |= branchTaken | branchNotTaken
+		case next == inst.Target:
+			c.branches[pc] |= branchTaken
+		default:
+			c.branches[pc] |= branchNotTaken
+		}
+	}
+	return cycles, nil
+}
+
+func (c *Coverage) Executed(addr uint16) bool {
+	return c.Flags[addr]&(Cov_Opcode|Cov_Operand) != 0
+}
+
+// A conditional branch that has executed, and which ways it went
+type Branch struct {
+	Addr     uint16
+	Taken    bool
+	NotTaken bool
+}
+
+// the conditional branches executed, in address order
+func (c *Coverage) Branches() []Branch {
+	var branches []Branch
+	for addr, seen := range c.branches {
+		branches = append(branches, Branch{addr, seen&branchTaken != 0, seen&branchNotTaken != 0})
+	}
+	sort.Slice(branches, func(i, j int) bool { return branches[i].Addr < branches[j].Addr })
+	return branches
+}
+
+// the conditional branches only ever taken one way
+func (c *Coverage) PartialBranches() []Branch {
+	var partial []Branch
+	for _, b := range c.Branches() {
+		if !b.Taken || !b.NotTaken {
+			partial = append(partial, b)
+		}
+	}
+	return partial
+}
+
+// the number of bytes of start-end inclusive that were executed, read and written
+type Summary struct {
+	Size     int
+	Executed int
+	Read     int
+	Written  int
+}
+
+func (c *Coverage) Summary(start, end uint16) Summary {
+	s := Summary{Size: int(end) - int(start) + 1}
+	for addr := int(start); addr <= int(end); addr++ {
+		flags := c.Flags[addr]
+		if flags&(Cov_Opcode|Cov_Operand) != 0 {
+			s.Executed++
+		}
+		if flags&Cov_Read != 0 {
+			s.Read++
+		}
+		if flags&Cov_Write != 0 {
+			s.Written++
+		}
+	}
+	return s
+}
@@ -0,0 +1,118 @@
+package cov6502
+
+import (
+	"bytes"
+	"github.com/simulatedsimian/assert"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"strings"
+	"testing"
+)
+
+// a loop reading and writing data, then a branch always taken over a nop
+var testProgram = []uint8{
+	0xa0, 0x02, // $0400      ldy #$02
+	0xad, 0x20, 0x04, // $0402 loop: lda $0420
+	0x8d, 0x21, 0x04, // $0405      sta $0421
+	0x88,       // $0408      dey
+	0xd0, 0xf7, // $0409      bne loop
+	0xf0, 0x01, // $040b      beq done
+	0xea, // $040d      nop
+	0xea, // $040e done: nop
+}
+
+func coverProgram(t *testing.T) (*Coverage, *core6502.BasicCPUContext) {
+	pack := assert.Pack
+
+	ctx := &core6502.BasicCPUContext{}
+	for n, b := range testProgram {
+		ctx.Poke(0x0400+uint16(n), b)
+	}
+	ctx.SetRegPC(0x0400)
+
+	c := New()
+	for n := 0; n < 11; n++ {
+		_, err := c.Execute(ctx)
+		assert.NoError(t, pack(err))
+	}
+	return c, ctx
+}
+
+func TestCoverage(t *testing.T) {
+	c, _ := coverProgram(t)
+
+	assert.Equal(t, c.Flags[0x0400], Cov_Opcode)
+	assert.Equal(t, c.Flags[0x0403], Cov_Operand)
+	assert.Equal(t, c.Flags[0x040d], uint8(0))
+	assert.Equal(t, c.Flags[0x0420], Cov_Read)
+	assert.Equal(t, c.Flags[0x0421], Cov_Write)
+	assert.Equal(t, c.Executed(0x040e), true)
+
+	assert.Equal(t, c.Branches(), []Branch{{0x0409, true, true}, {0x040b, true, false}})
+	assert.Equal(t, c.PartialBranches(), []Branch{{0x040b, true, false}})
+	assert.Equal(t, c.Summary(0x0400, 0x040f), Summary{16, 14, 0, 0})
+	assert.Equal(t, c.Summary(0x0420, 0x0421), Summary{2, 0, 1, 1})
+}
+
+func TestReport(t *testing.T) {
+	c, ctx := coverProgram(t)
+	syms := core6502.NewSymbolTable()
+	syms.Add("loop", 0x0402)
+	syms.Add("done", 0x040e)
+
+	var buf bytes.Buffer
+	c.Report(&buf, ctx, SymbolRegions(syms, 0x0400, 0x040f), syms)
+	assert.Equal(t, buf.String(), ""+
+		" Executed      %     Read  Written     Size  Region\n"+
+		"        2 100.0%        0        0        2  $0400 $0400-$0401\n"+
+		"       11  91.7%        0        0       12  loop $0402-$040d\n"+
+		"        1  50.0%        0        0        2  done $040e-$040f\n"+
+		"Branches taken one way:\n"+
+		"$040b BEQ done         always taken (loop+9)\n")
+
+	buf.Reset()
+	c.Report(&buf, ctx, c.PageRegions(), nil)
+	assert.Equal(t, buf.String(), ""+
+		" Executed      %     Read  Written     Size  Region\n"+
+		"       14   5.5%        1        1      256  $0400 $0400-$04ff\n"+
+		"Branches taken one way:\n"+
+		"$040b BEQ $040e        always taken\n")
+
+	buf.Reset()
+	assert.NoError(t, assert.Pack(c.Annotate(&buf, ctx, 0x0409, 0x0421, syms)))
+	lines := strings.Split(buf.String(), "\n")
+	assert.Equal(t, lines[:6], []string{
+		"*  $0409  d0 f7     BNE loop",
+		"*  $040b  f0 01     BEQ done         ; always taken",
+		"-  $040d  ea        NOP",
+		"done:",
+		"*  $040e  ea        NOP",
+		"-  $040f  00        BRK",
+	})
+	assert.Equal(t, lines[len(lines)-3:], []string{
+		"r  $0420  00        .byte $00",
+		"w  $0421  00        .byte $00",
+		"",
+	})
+}
+
+func TestWriteLCOV(t *testing.T) {
+	c, ctx := coverProgram(t)
+	li := &core6502.LineInfo{Lines: []core6502.SourceLine{
+		{File: "test.s", Line: 1, Start: 0x0400, Size: 2},
+		{File: "test.s", Line: 2, Start: 0x0402, Size: 3},
+		{File: "test.s", Line: 4, Start: 0x0409, Size: 2},
+		{File: "test.s", Line: 5, Start: 0x040b, Size: 2},
+		{File: "test.s", Line: 6, Start: 0x040d, Size: 1},
+		{File: "test.s", Line: 9, Start: 0x0420, Size: 2},
+	}}
+
+	var buf bytes.Buffer
+	assert.NoError(t, assert.Pack(c.WriteLCOV(&buf, ctx, li)))
+	assert.Equal(t, buf.String(), ""+
+		"TN:\nSF:test.s\n"+
+		"DA:1,1\nDA:2,1\n"+
+		"BRDA:4,0,0,1\nBRDA:4,0,1,1\nDA:4,1\n"+
+		"BRDA:5,0,0,1\nBRDA:5,0,1,0\nDA:5,1\n"+
+		"DA:6,0\n"+
+		"LF:5\nLH:4\nBRF:4\nBRH:3\nend_of_record\n")
+}
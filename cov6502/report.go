@@ -0,0 +1,277 @@
+package cov6502
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"io"
+	"strings"
+)
+
+// A named address range, start-end inclusive
+type Region struct {
+	Name       string
+	Start, End uint16
+}
+
+/*
+	Splits start-end at each symbol in it, a region running from a symbol
+	to the byte before the next. Bytes before the first symbol are a
+	region named by their address.
+*/
+func SymbolRegions(syms *core6502.SymbolTable, start, end uint16) []Region {
+	var regions []Region
+	if syms != nil {
+		for _, sym := range syms.Symbols() {
+			if sym.Addr < start || sym.Addr > end {
+				continue
+			}
+			if n := len(regions); n > 0 && regions[n-1].Start == sym.Addr {
+				continue
+			}
+			if n := len(regions); n > 0 {
+				regions[n-1].End = sym.Addr - 1
+			}
+			regions = append(regions, Region{sym.Name, sym.Addr, end})
+		}
+	}
+
+	if len(regions) == 0 || regions[0].Start > start {
+		first := Region{fmt.Sprintf("$%04x", start), start, end}
+		if len(regions) > 0 {
+			first.End = regions[0].Start - 1
+		}
+		regions = append([]Region{first}, regions...)
+	}
+	return regions
+}
+
+// a region for each 256 byte page with any coverage
+func (c *Coverage) PageRegions() []Region {
+	var regions []Region
+	for page := 0; page < 0x10000; page += 0x100 {
+		for addr := page; addr < page+0x100; addr++ {
+			if c.Flags[addr] != 0 {
+				regions = append(regions, Region{fmt.Sprintf("$%04x", page), uint16(page), uint16(page + 0xff)})
+				break
+			}
+		}
+	}
+	return regions
+}
+
+func percent(val, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(val) * 100 / float64(total)
+}
+
+// syms may be nil, which must not become a non-nil Symbols interface
+func disasmOptions(syms *core6502.SymbolTable) *core6502.DisasmOptions {
+	opts := &core6502.DisasmOptions{AbsoluteBranches: true}
+	if syms != nil {
+		opts.Symbols = syms
+	}
+	return opts
+}
+
+// how a branch has gone, for a branch taken only one way
+func (b Branch) String() string {
+	if b.Taken {
+		return "always taken"
+	}
+	return "never taken"
+}
+
+// writes a summary of each region and the conditional branches only taken one way
+func (c *Coverage) Report(w io.Writer, mem core6502.CPUMemory, regions []Region, syms *core6502.SymbolTable) {
+	fmt.Fprintf(w, " Executed      %%     Read  Written     Size  Region\n")
+	for _, r := range regions {
+		s := c.Summary(r.Start, r.End)
+		fmt.Fprintf(w, "%9d %5.1f%% %8d %8d %8d  %s $%04x-$%04x\n",
+			s.Executed, percent(s.Executed, s.Size), s.Read, s.Written, s.Size, r.Name, r.Start, r.End)
+	}
+
+	partial := c.PartialBranches()
+	if len(partial) == 0 {
+		return
+	}
+	opts := disasmOptions(syms)
+	fmt.Fprintf(w, "Branches taken one way:\n")
+	for _, b := range partial {
+		inst := core6502.Decode(mem, b.Addr)
+		line := fmt.Sprintf("$%04x %-16s %s", b.Addr, opts.FormatInstruction(&inst), b)
+		if syms != nil && syms.Format(b.Addr) != "" {
+			line += fmt.Sprintf(" (%s)", syms.Format(b.Addr))
+		}
+		fmt.Fprintln(w, line)
+	}
+}
+
+// the coverage marker column of an annotated line
+func (c *Coverage) marker(addr uint16, length uint16) string {
+	var flags uint8
+	for n := uint16(0); n < length; n++ {
+		flags |= c.Flags[addr+n]
+	}
+	switch {
+	case flags&Cov_Opcode != 0:
+		return "* "
+	case flags&(Cov_Read|Cov_Write) == Cov_Read|Cov_Write:
+		return "rw"
+	case flags&Cov_Read != 0:
+		return "r "
+	case flags&Cov_Write != 0:
+		return "w "
+	}
+	return "  "
+}
+
+/*
+	Writes a disassembly of start-end with a coverage marker on each line:
+	* executed, - never executed, r, w or rw for bytes read or written as
+	data. Executed bytes are disassembled from the opcodes executed,
+	untouched bytes as instructions where they decode as one, and
+	anything else as .byte.
+*/
+func (c *Coverage) Annotate(w io.Writer, mem core6502.CPUMemory, start, end uint16, syms *core6502.SymbolTable) error {
+	bw := bufio.NewWriter(w)
+	opts := disasmOptions(syms)
+	branches := map[uint16]Branch{}
+	for _, b := range c.PartialBranches() {
+		branches[b.Addr] = b
+	}
+
+	for addr := int(start); addr <= int(end); {
+		if label, ok := opts.Label(uint16(addr)); ok {
+			fmt.Fprintln(bw, label)
+		}
+
+		inst := core6502.Decode(mem, uint16(addr))
+		marker, text := "", ""
+		switch {
+		case c.Flags[addr]&Cov_Opcode != 0:
+			marker, text = c.marker(uint16(addr), inst.Length), opts.FormatInstruction(&inst)
+			if b, ok := branches[uint16(addr)]; ok {
+				text = fmt.Sprintf("%-16s ; %v", text, b)
+			}
+		case inst.Legal && addr+int(inst.Length) <= int(end)+1 && c.marker(uint16(addr), inst.Length) == "  ":
+			marker, text = "- ", opts.FormatInstruction(&inst)
+		default:
+			inst.Length, inst.Operand = 1, nil
+			marker, text = c.marker(uint16(addr), 1), fmt.Sprintf(".byte $%02x", inst.Opcode)
+		}
+
+		raw := fmt.Sprintf("%02x", inst.Opcode)
+		for _, b := range inst.Operand {
+			raw += fmt.Sprintf(" %02x", b)
+		}
+		fmt.Fprintln(bw, strings.TrimRight(fmt.Sprintf("%s $%04x  %-8s  %s", marker, addr, raw, text), " "))
+		addr += int(inst.Length)
+	}
+	return bw.Flush()
+}
+
+// a source line's coverage for lcov
+type lcovLine struct {
+	line     int
+	code     bool
+	hit      bool
+	branches []string // BRDA taken counts, taken then not taken for each branch
+}
+
+func lcovCount(seen bool) string {
+	if seen {
+		return "1"
+	}
+	return "0"
+}
+
+// true if the bytes of l disassemble to whole legal instructions
+func isCode(mem core6502.CPUMemory, l core6502.SourceLine) bool {
+	addr := int(l.Start)
+	for addr < int(l.Start)+l.Size {
+		inst := core6502.Decode(mem, uint16(addr))
+		if !inst.Legal {
+			return false
+		}
+		addr += int(inst.Length)
+	}
+	return addr == int(l.Start)+l.Size
+}
+
+/*
+	Writes an lcov tracefile, for genhtml and the like, mapping coverage
+	to source lines through ld65 debug info. A line is code if any of its
+	bytes were executed, or if they were not accessed as data and
+	disassemble to whole instructions; other lines are left out. Each
+	conditional branch is reported as taken and not taken.
+*/
+func (c *Coverage) WriteLCOV(w io.Writer, mem core6502.CPUMemory, li *core6502.LineInfo) error {
+	bw := bufio.NewWriter(w)
+
+	var files []string
+	byFile := map[string][]*lcovLine{}
+	for _, l := range li.Lines {
+		lines := byFile[l.File]
+		if lines == nil {
+			files = append(files, l.File)
+		}
+		if len(lines) == 0 || lines[len(lines)-1].line != l.Line {
+			lines = append(lines, &lcovLine{line: l.Line})
+			byFile[l.File] = lines
+		}
+		ll := lines[len(lines)-1]
+
+		if l.Size == 0 {
+			continue
+		}
+		summary := c.Summary(l.Start, l.Start+uint16(l.Size-1))
+		if summary.Executed == 0 && (summary.Read > 0 || summary.Written > 0 || !isCode(mem, l)) {
+			continue
+		}
+		ll.code = true
+
+		for addr := int(l.Start); addr < int(l.Start)+l.Size; {
+			inst := core6502.Decode(mem, uint16(addr))
+			if c.Flags[addr]&Cov_Opcode != 0 {
+				ll.hit = true
+			}
+			if inst.Mode == core6502.AddrMode_Relative {
+				if outcome, ok := c.branches[uint16(addr)]; ok {
+					ll.branches = append(ll.branches, lcovCount(outcome&branchTaken != 0), lcovCount(outcome&branchNotTaken != 0))
+				} else {
+					ll.branches = append(ll.branches, "-", "-")
+				}
+			}
+			addr += int(inst.Length)
+		}
+	}
+
+	for _, file := range files {
+		fmt.Fprintf(bw, "TN:\nSF:%s\n", file)
+		found, hit, branches, taken := 0, 0, 0, 0
+		for _, l := range byFile[file] {
+			if !l.code {
+				continue
+			}
+			for n, count := range l.branches {
+				fmt.Fprintf(bw, "BRDA:%d,%d,%d,%s\n", l.line, n/2, n%2, count)
+				branches++
+				if count == "1" {
+					taken++
+				}
+			}
+			count := 0
+			if l.hit {
+				count = 1
+				hit++
+			}
+			fmt.Fprintf(bw, "DA:%d,%d\n", l.line, count)
+			found++
+		}
+		fmt.Fprintf(bw, "LF:%d\nLH:%d\nBRF:%d\nBRH:%d\nend_of_record\n", found, hit, branches, taken)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,109 @@
+package image6502
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+const recordBytes = 16 // data bytes per record written
+
+/*
+	Reads Intel HEX records: data (00), end of file (01), extended
+	segment and linear addresses (02, 04), and start segment and linear
+	addresses (03, 05). Every record's checksum is checked.
+*/
+func readIntelHex(img *Image, name string, data []byte) error {
+	r := newRecordReader(name, data)
+	base := 0 // from the extended address records
+
+	for {
+		text, ok := r.next()
+		if !ok {
+			return nil
+		}
+		if text[0] != ':' {
+			return r.errorf("Record does not start with ':'")
+		}
+
+		rec, err := r.hexBytes(text[1:])
+		if err != nil {
+			return err
+		}
+		if len(rec) < 5 || len(rec) != int(rec[0])+5 {
+			return r.errorf("Invalid record length")
+		}
+
+		sum := 0
+		for _, b := range rec[:len(rec)-1] {
+			sum += int(b)
+		}
+		if expected := uint8(-sum); rec[len(rec)-1] != expected {
+			return r.errorf("Checksum error, expected %02X", expected)
+		}
+
+		addr := int(rec[1])<<8 | int(rec[2])
+		payload := rec[4 : len(rec)-1]
+		word := func() int { return int(payload[0])<<8 | int(payload[1]) }
+
+		switch typ := rec[3]; typ {
+		case 0x00:
+			if base+addr+len(payload) > 0x10000 {
+				return r.errorf("Data at $%04x beyond $ffff", base+addr)
+			}
+			img.add(uint16(base+addr), payload)
+		case 0x01:
+			return nil
+		case 0x02, 0x04:
+			if len(payload) != 2 {
+				return r.errorf("Invalid record length")
+			}
+			if base = word() << 4; typ == 0x04 {
+				base = word() << 16
+			}
+		case 0x03, 0x05:
+			if len(payload) != 4 {
+				return r.errorf("Invalid record length")
+			}
+			start := int(payload[0])<<24 | int(payload[1])<<16 | int(payload[2])<<8 | int(payload[3])
+			if typ == 0x03 {
+				start = (start>>16)<<4 + start&0xffff
+			}
+			if start > 0xffff {
+				return r.errorf("Start address $%x beyond $ffff", start)
+			}
+			img.Start, img.HasStart = uint16(start), true
+		default:
+			return r.errorf("Unknown record type %02X", typ)
+		}
+	}
+}
+
+func writeIntelHexRecord(w io.Writer, typ uint8, addr uint16, data []uint8) {
+	rec := append([]uint8{uint8(len(data)), uint8(addr >> 8), uint8(addr), typ}, data...)
+	sum := 0
+	fmt.Fprint(w, ":")
+	for _, b := range rec {
+		fmt.Fprintf(w, "%02X", b)
+		sum += int(b)
+	}
+	fmt.Fprintf(w, "%02X\n", uint8(-sum))
+}
+
+func writeIntelHex(w io.Writer, img *Image) error {
+	bw := bufio.NewWriter(w)
+	for _, s := range img.Segments {
+		for n := 0; n < len(s.Data); n += recordBytes {
+			end := n + recordBytes
+			if end > len(s.Data) {
+				end = len(s.Data)
+			}
+			writeIntelHexRecord(bw, 0x00, s.Addr+uint16(n), s.Data[n:end])
+		}
+	}
+	if img.HasStart {
+		writeIntelHexRecord(bw, 0x05, 0, []uint8{0, 0, uint8(img.Start >> 8), uint8(img.Start)})
+	}
+	writeIntelHexRecord(bw, 0x01, 0, nil)
+	return bw.Flush()
+}
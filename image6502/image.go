@@ -0,0 +1,311 @@
+package image6502
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type Format int
+
+const (
+	Format_Raw      Format = iota // bytes with no address
+	Format_IntelHex               // Intel HEX records
+	Format_SRecord                // Motorola S-records
+	Format_PRG                    // CBM PRG, two byte little endian load address then the bytes
+)
+
+func (f Format) String() string {
+	switch f {
+	case Format_Raw:
+		return "raw"
+	case Format_IntelHex:
+		return "Intel HEX"
+	case Format_SRecord:
+		return "S-record"
+	case Format_PRG:
+		return "PRG"
+	}
+	return "invalid"
+}
+
+// bytes loaded at an address
+type Segment struct {
+	Addr uint16
+	Data []uint8
+}
+
+func (s Segment) End() int {
+	return int(s.Addr) + len(s.Data)
+}
+
+// A memory image, with the start address from an Intel HEX or S-record file if it has one
+type Image struct {
+	Format   Format
+	Segments []Segment
+	Start    uint16
+	HasStart bool
+}
+
+// adds data at addr, joining it to the last segment if it follows on
+func (img *Image) add(addr uint16, data []uint8) error {
+	if int(addr)+len(data) > 0x10000 {
+		return fmt.Errorf("Data beyond $ffff at $%04x", addr)
+	}
+	if n := len(img.Segments) - 1; n >= 0 && img.Segments[n].End() == int(addr) {
+		img.Segments[n].Data = append(img.Segments[n].Data, data...)
+	} else if len(data) > 0 {
+		img.Segments = append(img.Segments, Segment{addr, append([]uint8(nil), data...)})
+	}
+	return nil
+}
+
+// total bytes in the image
+func (img *Image) Size() int {
+	size := 0
+	for _, s := range img.Segments {
+		size += len(s.Data)
+	}
+	return size
+}
+
+/*
+	Moves a raw or PRG image to addr, raw images are read at address 0
+	and PRG images at their load address. The other formats give the
+	address of every record so can not be moved.
+*/
+func (img *Image) Relocate(addr uint16) error {
+	if img.Format != Format_Raw && img.Format != Format_PRG {
+		return fmt.Errorf("%v files give their own addresses", img.Format)
+	}
+	if len(img.Segments) == 0 {
+		return nil
+	}
+	if int(addr)+len(img.Segments[0].Data) > 0x10000 {
+		return fmt.Errorf("Data beyond $ffff at $%04x", addr)
+	}
+	img.Segments[0].Addr = addr
+	return nil
+}
+
+func (img *Image) CopyTo(mem core6502.CPUMemory) {
+	for _, s := range img.Segments {
+		for n, b := range s.Data {
+			mem.Poke(s.Addr+uint16(n), b)
+		}
+	}
+}
+
+// an image of mem from start to end inclusive
+func FromMemory(mem core6502.CPUMemory, start, end uint16) *Image {
+	data := make([]uint8, int(end)-int(start)+1)
+	for n := range data {
+		data[n] = mem.Peek(start + uint16(n))
+	}
+	return &Image{Segments: []Segment{{start, data}}}
+}
+
+// the format implied by the file extension, raw if it is not recognised
+func FormatFromName(name string) (Format, bool) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".hex", ".ihx", ".ihex":
+		return Format_IntelHex, true
+	case ".srec", ".s19", ".s28", ".s37", ".mot":
+		return Format_SRecord, true
+	case ".prg":
+		return Format_PRG, true
+	case ".bin", ".raw":
+		return Format_Raw, true
+	}
+	return Format_Raw, false
+}
+
+// true if the first line of data is a record of the form start followed by hex digits
+func firstLineIs(data []byte, start string) bool {
+	line := data
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	line = bytes.TrimRight(line, "\r")
+	if !bytes.HasPrefix(line, []byte(start)) || len(line) < len(start)+2 {
+		return false
+	}
+	for _, c := range line[len(start):] {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", rune(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// the format of a file from its extension, or if that is not recognised its contents
+func Detect(name string, data []byte) Format {
+	if f, ok := FormatFromName(name); ok {
+		return f
+	}
+	switch {
+	case firstLineIs(data, ":"):
+		return Format_IntelHex
+	case len(data) > 1 && data[1] >= '0' && data[1] <= '9' && firstLineIs(data, "S"+string(data[1])):
+		return Format_SRecord
+	}
+	return Format_Raw
+}
+
+// reads an image in format, name is used in error messages
+func Read(name string, data []byte, format Format) (*Image, error) {
+	img := &Image{Format: format}
+
+	var err error
+	switch format {
+	case Format_Raw:
+		if err = img.add(0, data); err != nil {
+			err = fmt.Errorf("%s: %v", name, err)
+		}
+	case Format_PRG:
+		if len(data) < 2 {
+			err = fmt.Errorf("%s: PRG file has no load address", name)
+		} else if err = img.add(uint16(data[0])|uint16(data[1])<<8, data[2:]); err != nil {
+			err = fmt.Errorf("%s: %v", name, err)
+		}
+	case Format_IntelHex:
+		err = readIntelHex(img, name, data)
+	case Format_SRecord:
+		err = readSRecord(img, name, data)
+	default:
+		err = fmt.Errorf("Invalid format: %d", format)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// reads filename in the format it is detected as
+func LoadFile(filename string) (*Image, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return Read(filename, data, Detect(filename, data))
+}
+
+// the address raw images are loaded at when none is given
+const DefaultRawAddr = 0x400
+
+// reads filename, relocated to addr if hasAddr, raw images default to DefaultRawAddr
+func LoadFileAt(filename string, addr uint16, hasAddr bool) (*Image, error) {
+	img, err := LoadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if img.Format == Format_Raw && !hasAddr {
+		addr, hasAddr = DefaultRawAddr, true
+	}
+	if hasAddr {
+		if err := img.Relocate(addr); err != nil {
+			return nil, err
+		}
+	}
+	return img, nil
+}
+
+// reads a command line image argument, file[@address], returns the image and the file name
+func LoadFileArg(arg string) (*Image, string, error) {
+	addr, hasAddr := 0, false
+	if i := strings.LastIndex(arg, "@"); i >= 0 {
+		var err error
+		if addr, err = core6502.EvalExpr(arg[i+1:], nil); err != nil {
+			return nil, "", err
+		}
+		if addr < 0 || addr > 0xffff {
+			return nil, "", fmt.Errorf("Address out of range: %s", arg[i+1:])
+		}
+		arg, hasAddr = arg[:i], true
+	}
+
+	img, err := LoadFileAt(arg, uint16(addr), hasAddr)
+	return img, arg, err
+}
+
+// writes img in format, raw and PRG images must have exactly one segment
+func (img *Image) Write(w io.Writer, format Format) error {
+	switch format {
+	case Format_Raw, Format_PRG:
+		if len(img.Segments) != 1 {
+			return fmt.Errorf("%v files hold exactly one block of memory", format)
+		}
+		s := img.Segments[0]
+		if format == Format_PRG {
+			if _, err := w.Write([]uint8{uint8(s.Addr), uint8(s.Addr >> 8)}); err != nil {
+				return err
+			}
+		}
+		_, err := w.Write(s.Data)
+		return err
+	case Format_IntelHex:
+		return writeIntelHex(w, img)
+	case Format_SRecord:
+		return writeSRecord(w, img)
+	}
+	return fmt.Errorf("Invalid format: %d", format)
+}
+
+// writes img to filename in the format given by its extension, raw if not recognised
+func (img *Image) SaveFile(filename string) error {
+	format, _ := FormatFromName(filename)
+	var buf bytes.Buffer
+	if err := img.Write(&buf, format); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, buf.Bytes(), 0644)
+}
+
+// a text record file, split into lines numbered for error messages
+type recordReader struct {
+	name  string
+	lines []string
+	line  int
+}
+
+func newRecordReader(name string, data []byte) *recordReader {
+	return &recordReader{name: name, lines: strings.Split(string(data), "\n")}
+}
+
+// the next non-empty line, false at the end
+func (r *recordReader) next() (string, bool) {
+	for r.line < len(r.lines) {
+		text := strings.TrimSpace(r.lines[r.line])
+		r.line++
+		if text != "" {
+			return text, true
+		}
+	}
+	return "", false
+}
+
+func (r *recordReader) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", r.name, r.line, fmt.Sprintf(format, args...))
+}
+
+// decodes the hex digits of a record
+func (r *recordReader) hexBytes(s string) ([]uint8, error) {
+	if len(s)%2 != 0 {
+		return nil, r.errorf("Odd number of hex digits")
+	}
+	vals := make([]uint8, len(s)/2)
+	for n := range vals {
+		b, err := strconv.ParseUint(s[n*2:n*2+2], 16, 8)
+		if err != nil {
+			return nil, r.errorf("Invalid hex digits: %s", s[n*2:n*2+2])
+		}
+		vals[n] = uint8(b)
+	}
+	return vals, nil
+}
@@ -0,0 +1,116 @@
+package image6502
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/simulatedsimian/assert"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	assert.Equal(t, Detect("a.hex", nil), Format_IntelHex)
+	assert.Equal(t, Detect("a.S19", nil), Format_SRecord)
+	assert.Equal(t, Detect("a.prg", nil), Format_PRG)
+	assert.Equal(t, Detect("a.bin", []byte(":00000001FF\n")), Format_Raw)
+	assert.Equal(t, Detect("a", []byte(":00000001FF\r\n")), Format_IntelHex)
+	assert.Equal(t, Detect("a", []byte("S9030000FC\n")), Format_SRecord)
+	assert.Equal(t, Detect("a", []byte("Some text\n")), Format_Raw)
+	assert.Equal(t, Detect("a", []byte{0x01, 0x08, 0x0b}), Format_Raw)
+}
+
+func TestIntelHex(t *testing.T) {
+	pack := assert.Pack
+
+	img, err := Read("test.hex", []byte(`
+:03040000A9018DC2
+:020000020100FB
+:01100000EA05
+:0400000500000400F3
+:00000001FF
+:0100000000FF
+`), Format_IntelHex)
+	assert.NoError(t, pack(err))
+	assert.Equal(t, img.Segments, []Segment{{0x400, []uint8{0xa9, 0x01, 0x8d}}, {0x2000, []uint8{0xea}}})
+	assert.Equal(t, img.Start, uint16(0x400))
+	assert.Equal(t, img.HasStart, true)
+
+	var buf bytes.Buffer
+	assert.NoError(t, pack(img.Write(&buf, Format_IntelHex)))
+	assert.Equal(t, buf.String(), ":03040000A9018DC2\n:01200000EAF5\n:0400000500000400F3\n:00000001FF\n")
+
+	errors := []struct {
+		src string
+		msg string
+	}{
+		{"\n:03040000A9018DC3", "test.hex:2: Checksum error, expected C2"},
+		{":0304000", "test.hex:1: Odd number of hex digits"},
+		{":04040000A9018DC2", "test.hex:1: Invalid record length"},
+		{"03040000A9018DC2", "test.hex:1: Record does not start with ':'"},
+		{":020000040001F9\n:01000000EA15", "test.hex:2: Data at $10000 beyond $ffff"},
+		{":0100000600F9", "test.hex:1: Unknown record type 06"},
+	}
+	for _, test := range errors {
+		_, err := Read("test.hex", []byte(test.src), Format_IntelHex)
+		assert.Equal(t, fmt.Sprint(err), test.msg)
+	}
+}
+
+func TestSRecord(t *testing.T) {
+	pack := assert.Pack
+
+	img, err := Read("test.s19", []byte(`S00600004844521B
+S1060400A9018DBE
+S10504038D0066
+S9030400F8
+`), Format_SRecord)
+	assert.NoError(t, pack(err))
+	assert.Equal(t, img.Segments, []Segment{{0x400, []uint8{0xa9, 0x01, 0x8d, 0x8d, 0x00}}})
+	assert.Equal(t, img.Start, uint16(0x400))
+	assert.Equal(t, img.HasStart, true)
+
+	var buf bytes.Buffer
+	assert.NoError(t, pack(img.Write(&buf, Format_SRecord)))
+	assert.Equal(t, buf.String(), "S0030000FC\nS1080400A9018D8D002F\nS9030400F8\n")
+
+	errors := []struct {
+		src string
+		msg string
+	}{
+		{"S0030000FC\nS1060400A9018DBF", "test.s19:2: Checksum error, expected BE"},
+		{"S4030000FC", "test.s19:1: Invalid record type: S4"},
+		{"S1070400A9018DBE", "test.s19:1: Invalid record length"},
+		{"S105FFFF0102F9", "test.s19:1: Data at $ffff beyond $ffff"},
+	}
+	for _, test := range errors {
+		_, err := Read("test.s19", []byte(test.src), Format_SRecord)
+		assert.Equal(t, fmt.Sprint(err), test.msg)
+	}
+}
+
+func TestPRGAndRaw(t *testing.T) {
+	pack := assert.Pack
+
+	img, err := Read("test.prg", []byte{0x01, 0x08, 0x0b, 0x08}, Format_PRG)
+	assert.NoError(t, pack(err))
+	assert.Equal(t, img.Segments, []Segment{{0x801, []uint8{0x0b, 0x08}}})
+	assert.Equal(t, img.HasStart, false)
+
+	var buf bytes.Buffer
+	assert.NoError(t, pack(img.Write(&buf, Format_PRG)))
+	assert.Equal(t, buf.Bytes(), []uint8{0x01, 0x08, 0x0b, 0x08})
+
+	assert.NoError(t, pack(img.Relocate(0xc000)))
+	assert.Equal(t, img.Segments, []Segment{{0xc000, []uint8{0x0b, 0x08}}})
+
+	_, err = Read("test.prg", []byte{0x01}, Format_PRG)
+	assert.Equal(t, fmt.Sprint(err), "test.prg: PRG file has no load address")
+
+	img, err = Read("test.bin", []byte{1, 2, 3}, Format_Raw)
+	assert.NoError(t, pack(err))
+	assert.Equal(t, fmt.Sprint(img.Relocate(0xfffe)), "Data beyond $ffff at $fffe")
+	assert.NoError(t, pack(img.Relocate(0x400)))
+	assert.Equal(t, img.Segments, []Segment{{0x400, []uint8{1, 2, 3}}})
+
+	img, _ = Read("test.hex", []byte(":00000001FF"), Format_IntelHex)
+	assert.Equal(t, fmt.Sprint(img.Relocate(0x400)), "Intel HEX files give their own addresses")
+}
@@ -0,0 +1,100 @@
+package image6502
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// address bytes of each S-record type, 0 for types that are not valid
+var srecAddrSize = [10]int{2, 2, 3, 4, 0, 2, 3, 4, 3, 2}
+
+/*
+	Reads Motorola S-records: data (S1, S2, S3) and start addresses
+	(S7, S8, S9). Headers (S0) and counts (S5, S6) are checked but
+	otherwise ignored.
+*/
+func readSRecord(img *Image, name string, data []byte) error {
+	r := newRecordReader(name, data)
+
+	for {
+		text, ok := r.next()
+		if !ok {
+			return nil
+		}
+		if len(text) < 2 || text[0] != 'S' || text[1] < '0' || text[1] > '9' || srecAddrSize[text[1]-'0'] == 0 {
+			return r.errorf("Invalid record type: %.2s", text)
+		}
+		typ := text[1] - '0'
+		size := srecAddrSize[typ]
+
+		rec, err := r.hexBytes(text[2:])
+		if err != nil {
+			return err
+		}
+		if len(rec) < size+2 || len(rec) != int(rec[0])+1 {
+			return r.errorf("Invalid record length")
+		}
+
+		sum := 0
+		for _, b := range rec[:len(rec)-1] {
+			sum += int(b)
+		}
+		if expected := ^uint8(sum); rec[len(rec)-1] != expected {
+			return r.errorf("Checksum error, expected %02X", expected)
+		}
+
+		addr := 0
+		for _, b := range rec[1 : size+1] {
+			addr = addr<<8 | int(b)
+		}
+		payload := rec[size+1 : len(rec)-1]
+
+		switch typ {
+		case 1, 2, 3:
+			if addr+len(payload) > 0x10000 {
+				return r.errorf("Data at $%04x beyond $ffff", addr)
+			}
+			img.add(uint16(addr), payload)
+		case 7, 8, 9:
+			if addr > 0xffff {
+				return r.errorf("Start address $%x beyond $ffff", addr)
+			}
+			img.Start, img.HasStart = uint16(addr), true
+		}
+	}
+}
+
+func writeSRecordLine(w io.Writer, typ int, addr uint16, data []uint8) {
+	rec := append([]uint8{uint8(len(data) + 3), uint8(addr >> 8), uint8(addr)}, data...)
+	sum := 0
+	fmt.Fprintf(w, "S%d", typ)
+	for _, b := range rec {
+		fmt.Fprintf(w, "%02X", b)
+		sum += int(b)
+	}
+	fmt.Fprintf(w, "%02X\n", ^uint8(sum))
+}
+
+// writes S1 records, ending with an S9 record that must hold a start
+// address, the first address written if img has none
+func writeSRecord(w io.Writer, img *Image) error {
+	start := img.Start
+	if !img.HasStart && len(img.Segments) > 0 {
+		start = img.Segments[0].Addr
+	}
+
+	bw := bufio.NewWriter(w)
+	writeSRecordLine(bw, 0, 0, nil)
+	for _, s := range img.Segments {
+		for n := 0; n < len(s.Data); n += recordBytes {
+			end := n + recordBytes
+			if end > len(s.Data) {
+				end = len(s.Data)
+			}
+			writeSRecordLine(bw, 1, s.Addr+uint16(n), s.Data[n:end])
+		}
+	}
+	writeSRecordLine(bw, 9, start, nil)
+	return bw.Flush()
+}
@@ -0,0 +1,147 @@
+package nes6502
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+)
+
+const (
+	headerSize  = 16
+	trainerSize = 512
+	prgUnit     = 0x4000 // PRG-ROM size unit in the header
+	chrUnit     = 0x2000 // CHR-ROM size unit in the header
+)
+
+type Mirroring int
+
+const (
+	Mirror_Horizontal Mirroring = iota
+	Mirror_Vertical
+	Mirror_FourScreen
+)
+
+func (m Mirroring) String() string {
+	switch m {
+	case Mirror_Horizontal:
+		return "horizontal"
+	case Mirror_Vertical:
+		return "vertical"
+	case Mirror_FourScreen:
+		return "four screen"
+	}
+	return "invalid"
+}
+
+// The parts of an iNES or NES 2.0 header that affect the CPU, sizes are in bytes
+type Header struct {
+	NES2       bool
+	Mapper     int
+	SubMapper  int
+	PRGROMSize int
+	CHRROMSize int
+	PRGRAMSize int // including battery backed PRG-NVRAM
+	Mirroring  Mirroring
+	Battery    bool
+	Trainer    bool // 512 bytes loaded at $7000
+}
+
+// NES 2.0 ROM size: units of unit, or if the MSB nibble is $f an exponent and multiplier
+func nes2ROMSize(lsb, msb uint8, unit int) int {
+	if msb == 0xf {
+		return (1 << (lsb >> 2)) * int(lsb&3*2+1)
+	}
+	return (int(msb)<<8 | int(lsb)) * unit
+}
+
+/*
+	Parses the 16 byte header at the start of an iNES file. NES 2.0 is
+	recognised by bits 2-3 of byte 7 being %10. Old iNES files with
+	junk in bytes 12-15, e.g. "DiskDude!", lose the upper mapper nibble.
+*/
+func ParseHeader(data []uint8) (*Header, error) {
+	if len(data) < headerSize || !bytes.Equal(data[:4], []uint8("NES\x1a")) {
+		return nil, fmt.Errorf("Not an iNES file")
+	}
+
+	h := &Header{
+		Battery: data[6]&0x02 != 0,
+		Trainer: data[6]&0x04 != 0,
+		NES2:    data[7]&0x0c == 0x08,
+	}
+	switch {
+	case data[6]&0x08 != 0:
+		h.Mirroring = Mirror_FourScreen
+	case data[6]&0x01 != 0:
+		h.Mirroring = Mirror_Vertical
+	}
+
+	h.Mapper = int(data[6]>>4 | data[7]&0xf0)
+	if h.NES2 {
+		h.Mapper |= int(data[8]&0x0f) << 8
+		h.SubMapper = int(data[8] >> 4)
+		h.PRGROMSize = nes2ROMSize(data[4], data[9]&0x0f, prgUnit)
+		h.CHRROMSize = nes2ROMSize(data[5], data[9]>>4, chrUnit)
+		for _, shift := range []uint8{data[10] & 0x0f, data[10] >> 4} {
+			if shift != 0 {
+				h.PRGRAMSize += 64 << shift
+			}
+		}
+		return h, nil
+	}
+
+	if !bytes.Equal(data[12:16], []uint8{0, 0, 0, 0}) {
+		h.Mapper &= 0x0f
+	}
+	h.PRGROMSize = int(data[4]) * prgUnit
+	h.CHRROMSize = int(data[5]) * chrUnit
+	h.PRGRAMSize = int(data[8]) * 0x2000
+	if h.PRGRAMSize == 0 {
+		h.PRGRAMSize = 0x2000
+	}
+	return h, nil
+}
+
+// An iNES ROM image
+type ROM struct {
+	Header
+	Trainer []uint8
+	PRG     []uint8
+	CHR     []uint8
+}
+
+// reads an iNES ROM image, name is used in error messages
+func ReadROM(name string, data []uint8) (*ROM, error) {
+	h, err := ParseHeader(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	size := headerSize + h.PRGROMSize + h.CHRROMSize
+	if h.Trainer {
+		size += trainerSize
+	}
+	if len(data) < size {
+		return nil, fmt.Errorf("%s: File is %d bytes, the header needs %d", name, len(data), size)
+	}
+	if h.PRGROMSize == 0 {
+		return nil, fmt.Errorf("%s: No PRG-ROM", name)
+	}
+
+	rom := &ROM{Header: *h}
+	data = data[headerSize:]
+	if h.Trainer {
+		rom.Trainer, data = data[:trainerSize], data[trainerSize:]
+	}
+	rom.PRG, data = data[:h.PRGROMSize], data[h.PRGROMSize:]
+	rom.CHR = data[:h.CHRROMSize]
+	return rom, nil
+}
+
+func LoadROM(filename string) (*ROM, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ReadROM(filename, data)
+}
@@ -0,0 +1,146 @@
+package nes6502
+
+import "fmt"
+
+// Cartridge PRG space, $6000-$ffff: PRG-RAM then banked PRG-ROM
+type Mapper interface {
+	Read(addr uint16) uint8
+	Write(addr uint16, val uint8)
+	Reset() // returns the registers to their power on state, PRG-RAM is kept
+}
+
+func NewMapper(rom *ROM) (Mapper, error) {
+	ram := make([]uint8, rom.PRGRAMSize)
+	if len(rom.Trainer) > 0 && len(ram) >= 0x1200 {
+		copy(ram[0x1000:], rom.Trainer)
+	}
+
+	switch rom.Mapper {
+	case 0:
+		return &nrom{rom.PRG, ram}, nil
+	case 1:
+		m := &mmc1{prg: rom.PRG, ram: ram}
+		m.Reset()
+		return m, nil
+	}
+	return nil, fmt.Errorf("Unsupported mapper %d", rom.Mapper)
+}
+
+func readRAM(ram []uint8, addr uint16) uint8 {
+	if len(ram) == 0 {
+		return 0
+	}
+	return ram[int(addr-0x6000)%len(ram)]
+}
+
+func writeRAM(ram []uint8, addr uint16, val uint8) {
+	if len(ram) > 0 {
+		ram[int(addr-0x6000)%len(ram)] = val
+	}
+}
+
+// mapper 0, 16K (NROM-128, mirrored at $c000) or 32K (NROM-256) of PRG-ROM
+type nrom struct {
+	prg []uint8
+	ram []uint8
+}
+
+func (m *nrom) Read(addr uint16) uint8 {
+	if addr < 0x8000 {
+		return readRAM(m.ram, addr)
+	}
+	return m.prg[int(addr-0x8000)%len(m.prg)]
+}
+
+func (m *nrom) Write(addr uint16, val uint8) {
+	if addr < 0x8000 {
+		writeRAM(m.ram, addr, val)
+	}
+}
+
+func (m *nrom) Reset() {
+}
+
+/*
+	mapper 1, MMC1. Registers are loaded a bit at a time: five writes to
+	$8000-$ffff shift in bit 0 of each value, and the fifth write stores
+	the result in the register chosen by bits 13-14 of its address. A
+	write with bit 7 set resets the shift register and selects PRG mode 3.
+
+	control ($8000): bits 2-3 PRG mode, 0/1 32K at $8000, 2 first bank
+	fixed at $8000, 3 last bank fixed at $c000. The CHR registers are
+	kept but have no effect without a PPU. prg ($e000): bits 0-3 16K
+	bank, bit 4 disables PRG-RAM.
+*/
+type mmc1 struct {
+	prg []uint8
+	ram []uint8
+
+	shift, count uint8
+	control      uint8
+	chr0, chr1   uint8
+	prgBank      uint8
+}
+
+func (m *mmc1) Read(addr uint16) uint8 {
+	if addr < 0x8000 {
+		if m.prgBank&0x10 != 0 {
+			return 0
+		}
+		return readRAM(m.ram, addr)
+	}
+
+	banks := len(m.prg) / prgUnit
+	bank := int(m.prgBank & 0x0f)
+	switch m.control >> 2 & 3 {
+	case 0, 1:
+		bank = bank&^1 + int(addr-0x8000)/prgUnit
+	case 2:
+		if addr < 0xc000 {
+			bank = 0
+		}
+	case 3:
+		if addr >= 0xc000 {
+			bank = banks - 1
+		}
+	}
+	return m.prg[(bank%banks)*prgUnit+int(addr&(prgUnit-1))]
+}
+
+func (m *mmc1) Write(addr uint16, val uint8) {
+	if addr < 0x8000 {
+		if m.prgBank&0x10 == 0 {
+			writeRAM(m.ram, addr, val)
+		}
+		return
+	}
+
+	if val&0x80 != 0 {
+		m.shift, m.count = 0, 0
+		m.control |= 0x0c
+		return
+	}
+
+	m.shift |= (val & 1) << m.count
+	if m.count++; m.count < 5 {
+		return
+	}
+
+	switch addr >> 13 & 3 {
+	case 0:
+		m.control = m.shift
+	case 1:
+		m.chr0 = m.shift
+	case 2:
+		m.chr1 = m.shift
+	case 3:
+		m.prgBank = m.shift
+	}
+	m.shift, m.count = 0, 0
+}
+
+func (m *mmc1) Reset() {
+	m.shift, m.count = 0, 0
+	m.control = 0x0c
+	m.chr0, m.chr1, m.prgBank = 0, 0, 0
+}
@@ -0,0 +1,131 @@
+package nes6502
+
+import (
+	"fmt"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"io"
+)
+
+/*
+	A register range with nothing emulated behind it. Reads return the
+	value last written to the register, and every access is written to
+	Log if it is not nil.
+*/
+type StubDevice struct {
+	Name string
+	Log  io.Writer
+	regs map[uint16]uint8
+}
+
+func NewStubDevice(name string) *StubDevice {
+	return &StubDevice{Name: name, regs: map[uint16]uint8{}}
+}
+
+func (d *StubDevice) Read(addr uint16) uint8 {
+	val := d.regs[addr]
+	if d.Log != nil {
+		fmt.Fprintf(d.Log, "%s: read $%04x = $%02x\n", d.Name, addr, val)
+	}
+	return val
+}
+
+func (d *StubDevice) Write(addr uint16, val uint8) {
+	d.regs[addr] = val
+	if d.Log != nil {
+		fmt.Fprintf(d.Log, "%s: write $%04x = $%02x\n", d.Name, addr, val)
+	}
+}
+
+/*
+	The NES CPU address space, as a core6502.CPUContext:
+
+	$0000-$1fff  2K RAM, mirrored
+	$2000-$3fff  PPU registers, 8 mirrored, stubbed
+	$4000-$401f  APU and I/O registers, stubbed
+	$4020-$5fff  expansion, reads 0
+	$6000-$ffff  cartridge, PRG-RAM and PRG-ROM through the mapper
+*/
+type NES struct {
+	core6502.BasicCPUContext // registers only, its memory is not used
+
+	ROM    *ROM
+	RAM    [0x800]uint8
+	PPU    *StubDevice
+	APU    *StubDevice
+	Mapper Mapper
+}
+
+// a NES with rom inserted, use core6502.SoftResetCPU to start it from the reset vector
+func New(rom *ROM) (*NES, error) {
+	mapper, err := NewMapper(rom)
+	if err != nil {
+		return nil, err
+	}
+	return &NES{ROM: rom, PPU: NewStubDevice("PPU"), APU: NewStubDevice("APU"), Mapper: mapper}, nil
+}
+
+/*
+	Resets the NES as on power up: clears RAM and the device registers,
+	resets the mapper and the registers, and starts from the reset
+	vector. Unlike core6502.HardResetCPU it doesn't write every address,
+	which would write the device and mapper registers.
+*/
+func (n *NES) HardReset() {
+	n.RAM = [len(n.RAM)]uint8{}
+	n.PPU.regs = map[uint16]uint8{}
+	n.APU.regs = map[uint16]uint8{}
+	n.Mapper.Reset()
+
+	n.SetFlags(0)
+	n.SetRegA(0)
+	n.SetRegX(0)
+	n.SetRegY(0)
+	core6502.SoftResetCPU(n)
+}
+
+// logs accesses to the stubbed devices to w, nil to stop logging
+func (n *NES) SetLog(w io.Writer) {
+	n.PPU.Log = w
+	n.APU.Log = w
+}
+
+func (n *NES) Peek(addr uint16) uint8 {
+	switch {
+	case addr < 0x2000:
+		return n.RAM[addr&0x7ff]
+	case addr < 0x4000:
+		return n.PPU.Read(0x2000 + addr&7)
+	case addr < 0x4020:
+		return n.APU.Read(addr)
+	case addr < 0x6000:
+		return 0
+	}
+	return n.Mapper.Read(addr)
+}
+
+func (n *NES) Poke(addr uint16, val uint8) {
+	switch {
+	case addr < 0x2000:
+		n.RAM[addr&0x7ff] = val
+	case addr < 0x4000:
+		n.PPU.Write(0x2000+addr&7, val)
+	case addr < 0x4020:
+		n.APU.Write(addr, val)
+	case addr >= 0x6000:
+		n.Mapper.Write(addr, val)
+	}
+}
+
+// true if addr is a device or mapper register, or ROM, rather than RAM
+func (n *NES) IsDevice(addr uint16) bool {
+	return addr >= 0x2000 && addr < 0x6000 || addr >= 0x8000
+}
+
+func (n *NES) PeekWord(addr uint16) uint16 {
+	return core6502.MakeWord(n.Peek(addr+1), n.Peek(addr))
+}
+
+func (n *NES) PokeWord(addr uint16, val uint16) {
+	n.Poke(addr, uint8(val))
+	n.Poke(addr+1, uint8(val>>8))
+}
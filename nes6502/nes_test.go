@@ -0,0 +1,203 @@
+package nes6502
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/simulatedsimian/assert"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"testing"
+)
+
+// an iNES file with the given header bytes 4-15 and PRG banks where
+// each byte is its bank number, the reset vector points at $8000 + bank
+func testROM(header []uint8) []uint8 {
+	data := append([]uint8("NES\x1a"), header...)
+	for bank := 0; bank < int(header[0]); bank++ {
+		prg := bytes.Repeat([]uint8{uint8(bank)}, prgUnit)
+		prg[prgUnit-4], prg[prgUnit-3] = uint8(bank), 0x80
+		data = append(data, prg...)
+	}
+	return append(data, make([]uint8, int(header[1])*chrUnit)...)
+}
+
+func TestParseHeader(t *testing.T) {
+	pack := assert.Pack
+
+	h, err := ParseHeader(testROM([]uint8{2, 1, 0x13, 0x00, 0, 0, 0, 0, 0, 0, 0, 0}))
+	assert.NoError(t, pack(err))
+	assert.Equal(t, *h, Header{Mapper: 1, PRGROMSize: 0x8000, CHRROMSize: 0x2000, PRGRAMSize: 0x2000,
+		Mirroring: Mirror_Vertical, Battery: true})
+
+	// NES 2.0: mapper 0x101 submapper 2, 8K PRG-RAM + 8K PRG-NVRAM
+	h, err = ParseHeader(testROM([]uint8{1, 0, 0x18, 0x08, 0x21, 0, 0x77, 0, 0, 0, 0, 0}))
+	assert.NoError(t, pack(err))
+	assert.Equal(t, *h, Header{NES2: true, Mapper: 0x101, SubMapper: 2, PRGROMSize: 0x4000, PRGRAMSize: 0x4000,
+		Mirroring: Mirror_FourScreen})
+
+	// junk at the end of an old header
+	h, err = ParseHeader(append([]uint8("NES\x1a\x01\x00\x10\x40\x00\x00\x00\x00"), "Dude"...))
+	assert.NoError(t, pack(err))
+	assert.Equal(t, h.Mapper, 1)
+
+	_, err = ReadROM("test.nes", []uint8("NES\x00"))
+	assert.Equal(t, fmt.Sprint(err), "test.nes: Not an iNES file")
+	_, err = ReadROM("test.nes", testROM([]uint8{2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})[:0x4010])
+	assert.Equal(t, fmt.Sprint(err), "test.nes: File is 16400 bytes, the header needs 32784")
+}
+
+func testNES(t *testing.T, header []uint8) *NES {
+	pack := assert.Pack
+
+	rom, err := ReadROM("test.nes", testROM(header))
+	assert.NoError(t, pack(err))
+	nes, err := New(rom)
+	assert.NoError(t, pack(err))
+	return nes
+}
+
+func TestNROM(t *testing.T) {
+	nes := testNES(t, []uint8{1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	assert.Equal(t, nes.Peek(0xc000), nes.Peek(0x8000))
+	assert.Equal(t, nes.PeekWord(core6502.Vector_RST), uint16(0x8000))
+
+	nes = testNES(t, []uint8{2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	assert.Equal(t, nes.Peek(0x8000), uint8(0))
+	assert.Equal(t, nes.Peek(0xc000), uint8(1))
+
+	core6502.SoftResetCPU(nes)
+	assert.Equal(t, nes.RegPC(), uint16(0x8001))
+
+	nes.Poke(0x8000, 0xff)
+	assert.Equal(t, nes.Peek(0x8000), uint8(0))
+	nes.Poke(0x6000, 0x42)
+	assert.Equal(t, nes.Peek(0x6000), uint8(0x42))
+	nes.Poke(0x0801, 0x55)
+	assert.Equal(t, nes.Peek(0x0001), uint8(0x55))
+
+	_, err := New(&ROM{Header: Header{Mapper: 4}})
+	assert.Equal(t, fmt.Sprint(err), "Unsupported mapper 4")
+}
+
+// loads an MMC1 register a bit at a time
+func writeMMC1(nes *NES, addr uint16, val uint8) {
+	for n := uint(0); n < 5; n++ {
+		nes.Poke(addr, val>>n&1)
+	}
+}
+
+func TestMMC1(t *testing.T) {
+	nes := testNES(t, []uint8{8, 0, 0x10, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+
+	// power on: PRG mode 3, last bank fixed at $c000
+	assert.Equal(t, nes.Peek(0x8000), uint8(0))
+	assert.Equal(t, nes.Peek(0xc000), uint8(7))
+	core6502.SoftResetCPU(nes)
+	assert.Equal(t, nes.RegPC(), uint16(0x8007))
+
+	writeMMC1(nes, 0xe000, 3)
+	assert.Equal(t, nes.Peek(0x8000), uint8(3))
+	assert.Equal(t, nes.PeekWord(core6502.Vector_RST), uint16(0x8007))
+
+	// mode 2: first bank fixed at $8000
+	writeMMC1(nes, 0x8000, 0x08)
+	assert.Equal(t, nes.Peek(0x8000), uint8(0))
+	assert.Equal(t, nes.Peek(0xc000), uint8(3))
+
+	// mode 0: 32K, the low bit of the bank is ignored
+	writeMMC1(nes, 0x8000, 0x00)
+	writeMMC1(nes, 0xe000, 5)
+	assert.Equal(t, nes.Peek(0x8000), uint8(4))
+	assert.Equal(t, nes.Peek(0xc000), uint8(5))
+
+	// a write with bit 7 set resets to mode 3 part way through loading
+	nes.Poke(0x8000, 1)
+	nes.Poke(0x8000, 0x80)
+	assert.Equal(t, nes.Peek(0xc000), uint8(7))
+	writeMMC1(nes, 0xe000, 2)
+	assert.Equal(t, nes.Peek(0x8000), uint8(2))
+
+	// PRG-RAM disabled by bit 4 of the PRG bank
+	nes.Poke(0x6000, 0x42)
+	writeMMC1(nes, 0xe000, 0x12)
+	assert.Equal(t, nes.Peek(0x6000), uint8(0))
+	writeMMC1(nes, 0xe000, 0x02)
+	assert.Equal(t, nes.Peek(0x6000), uint8(0x42))
+}
+
+func TestStubDevices(t *testing.T) {
+	nes := testNES(t, []uint8{1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+
+	var log bytes.Buffer
+	nes.SetLog(&log)
+	nes.Poke(0x2000, 0x80)
+	nes.Peek(0x3ff8)
+	nes.Poke(0x4015, 0x0f)
+	assert.Equal(t, log.String(), "PPU: write $2000 = $80\nPPU: read $2000 = $80\nAPU: write $4015 = $0f\n")
+}
+
+func TestSnapshot(t *testing.T) {
+	pack := assert.Pack
+
+	header := []uint8{8, 0, 0x10, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	nes := testNES(t, header)
+	core6502.SoftResetCPU(nes)
+	nes.SetRegA(0x42)
+	nes.Poke(0x0010, 0x55)
+	nes.Poke(0x6000, 0x66)
+	nes.Poke(0x2000, 0x80)
+	nes.Poke(0x4015, 0x0f)
+	writeMMC1(nes, 0xe000, 3)
+	nes.Poke(0x8000, 1) // part way through loading a register
+
+	snap := core6502.NewSnapshot()
+	nes.SaveState(snap)
+	var buf bytes.Buffer
+	_, err := snap.WriteTo(&buf)
+	assert.NoError(t, pack(err))
+	snap, err = core6502.ReadSnapshot(&buf)
+	assert.NoError(t, pack(err))
+
+	loaded := testNES(t, header)
+	assert.NoError(t, pack(loaded.LoadState(snap)))
+	assert.Equal(t, loaded.RegA(), uint8(0x42))
+	assert.Equal(t, loaded.RegPC(), uint16(0x8007))
+	assert.Equal(t, loaded.Peek(0x0010), uint8(0x55))
+	assert.Equal(t, loaded.Peek(0x6000), uint8(0x66))
+	assert.Equal(t, loaded.Peek(0x8000), uint8(3))
+	assert.Equal(t, loaded.PPU.state(), nes.PPU.state())
+	assert.Equal(t, loaded.APU.state(), nes.APU.state())
+	assert.Equal(t, loaded.Mapper, nes.Mapper)
+
+	other := testNES(t, []uint8{2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	assert.Equal(t, fmt.Sprint(other.LoadState(snap)), "Snapshot is of a different ROM")
+}
+
+func TestHardReset(t *testing.T) {
+	nes := testNES(t, []uint8{8, 0, 0x10, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	nes.Poke(0x0010, 0x55)
+	nes.Poke(0x6000, 0x66)
+	nes.Poke(0x2000, 0x80)
+	writeMMC1(nes, 0x8000, 0x08)
+	writeMMC1(nes, 0xe000, 3)
+	nes.Poke(0x8000, 1)
+	nes.SetRegA(0x42)
+	nes.SetRegPC(0x1234)
+
+	var log bytes.Buffer
+	nes.SetLog(&log)
+	nes.HardReset()
+	assert.Equal(t, log.String(), "")
+	nes.SetLog(nil)
+
+	assert.Equal(t, nes.RegA(), uint8(0))
+	assert.Equal(t, nes.RegPC(), uint16(0x8007))
+	assert.Equal(t, nes.Peek(0x0010), uint8(0))
+	assert.Equal(t, nes.Peek(0x2000), uint8(0))
+	assert.Equal(t, nes.Peek(0x6000), uint8(0x66)) // PRG-RAM is kept
+	assert.Equal(t, nes.Peek(0x8000), uint8(0))
+	assert.Equal(t, nes.Peek(0xc000), uint8(7))
+
+	// the shift register was cleared
+	writeMMC1(nes, 0xe000, 2)
+	assert.Equal(t, nes.Peek(0x8000), uint8(2))
+}
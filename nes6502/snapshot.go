@@ -0,0 +1,123 @@
+package nes6502
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"hash/crc32"
+	"sort"
+)
+
+/*
+	The NES snapshot chunks, as well as the "CPU " registers:
+
+	"NRAM"  the 2K of RAM
+	"PPU "  the PPU registers last written, (addr:2 val) each
+	"APU "  the APU and I/O registers, the same
+	"CART"  crc32:4 of the PRG-ROM and the mapper:2, which must match
+	"MAPR"  the mapper registers, none for NROM, MMC1 shift count
+	        control chr0 chr1 prg
+	"PRAM"  the PRG-RAM
+*/
+
+func (n *NES) SaveState(snap *core6502.Snapshot) {
+	core6502.SaveRegisters(snap, n)
+	snap.Set("NRAM", append([]uint8(nil), n.RAM[:]...))
+	snap.Set("PPU ", n.PPU.state())
+	snap.Set("APU ", n.APU.state())
+
+	cart := make([]uint8, 6)
+	binary.LittleEndian.PutUint32(cart, crc32.ChecksumIEEE(n.ROM.PRG))
+	binary.LittleEndian.PutUint16(cart[4:], uint16(n.ROM.Mapper))
+	snap.Set("CART", cart)
+
+	regs, ram := mapperState(n.Mapper)
+	snap.Set("MAPR", regs)
+	snap.Set("PRAM", append([]uint8(nil), ram...))
+}
+
+// loads a snapshot of a NES with the same ROM
+func (n *NES) LoadState(snap *core6502.Snapshot) error {
+	cart, err := snap.Need("CART", 6)
+	if err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint32(cart) != crc32.ChecksumIEEE(n.ROM.PRG) || int(binary.LittleEndian.Uint16(cart[4:])) != n.ROM.Mapper {
+		return fmt.Errorf("Snapshot is of a different ROM")
+	}
+
+	ram, err := snap.Need("NRAM", len(n.RAM))
+	if err != nil {
+		return err
+	}
+	ppu, err := snap.Need("PPU ", 0)
+	if err != nil {
+		return err
+	}
+	apu, err := snap.Need("APU ", 0)
+	if err != nil {
+		return err
+	}
+	cur, _ := mapperState(n.Mapper)
+	regs, err := snap.Need("MAPR", len(cur))
+	if err != nil {
+		return err
+	}
+	prgRAM, err := snap.Need("PRAM", 0)
+	if err != nil {
+		return err
+	}
+	if err := core6502.LoadRegisters(snap, n); err != nil {
+		return err
+	}
+
+	copy(n.RAM[:], ram)
+	n.PPU.setState(ppu)
+	n.APU.setState(apu)
+	setMapperState(n.Mapper, regs, prgRAM)
+	return nil
+}
+
+// the registers written, in address order
+func (d *StubDevice) state() []uint8 {
+	var addrs []int
+	for addr := range d.regs {
+		addrs = append(addrs, int(addr))
+	}
+	sort.Ints(addrs)
+
+	var data []uint8
+	for _, addr := range addrs {
+		data = append(data, uint8(addr), uint8(addr>>8), d.regs[uint16(addr)])
+	}
+	return data
+}
+
+func (d *StubDevice) setState(data []uint8) {
+	d.regs = map[uint16]uint8{}
+	for ; len(data) >= 3; data = data[3:] {
+		d.regs[core6502.MakeWord(data[1], data[0])] = data[2]
+	}
+}
+
+// the mapper registers and PRG-RAM
+func mapperState(m Mapper) (regs []uint8, ram []uint8) {
+	switch m := m.(type) {
+	case *nrom:
+		return nil, m.ram
+	case *mmc1:
+		return []uint8{m.shift, m.count, m.control, m.chr0, m.chr1, m.prgBank}, m.ram
+	}
+	return nil, nil
+}
+
+// sets the state saved by mapperState, regs is at least as long
+func setMapperState(m Mapper, regs []uint8, ram []uint8) {
+	switch m := m.(type) {
+	case *nrom:
+		copy(m.ram, ram)
+	case *mmc1:
+		m.shift, m.count, m.control, m.chr0, m.chr1, m.prgBank = regs[0], regs[1], regs[2], regs[3], regs[4], regs[5]
+		copy(m.ram, ram)
+	}
+}
@@ -0,0 +1,299 @@
+package obj6502
+
+import (
+	"fmt"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"io/ioutil"
+	"strings"
+)
+
+// A range of memory that segments are placed in
+type MemoryArea struct {
+	Name  string
+	Start int
+	Size  int
+}
+
+// Where a segment is placed
+type SegmentConfig struct {
+	Name     string
+	Load     string // memory area
+	BSS      bool   // uninitialised, takes space but is not written to the output
+	Start    int    // fixed address, if HasStart
+	HasStart bool
+	Align    int
+}
+
+/*
+	Memory layout for the linker, read from a file in a subset of the
+	ld65 configuration syntax:
+
+	MEMORY {
+		ZP:  start = $0002, size = $00fe;
+		RAM: start = $0400, size = $bc00;
+	}
+	SEGMENTS {
+		ZEROPAGE: load = ZP;
+		CODE:     load = RAM;
+		DATA:     load = RAM, align = $100;
+		BSS:      load = RAM, type = bss;
+	}
+
+	Segments are placed in the order listed, each following the
+	previous one in its memory area unless it has a start address.
+*/
+type Config struct {
+	Memory   []MemoryArea
+	Segments []SegmentConfig
+}
+
+const DefaultConfigText = `
+MEMORY {
+	ZP:  start = $0002, size = $00fe;
+	RAM: start = $0400, size = $bc00;
+}
+SEGMENTS {
+	ZEROPAGE: load = ZP, type = bss;
+	CODE:     load = RAM;
+	DATA:     load = RAM;
+	BSS:      load = RAM, type = bss;
+}
+`
+
+func DefaultConfig() *Config {
+	cfg, err := ParseConfig("default", []byte(DefaultConfigText))
+	if err != nil {
+		panic(err)
+	}
+	return cfg
+}
+
+func (cfg *Config) memoryArea(name string) *MemoryArea {
+	for n := range cfg.Memory {
+		if cfg.Memory[n].Name == name {
+			return &cfg.Memory[n]
+		}
+	}
+	return nil
+}
+
+func (cfg *Config) segment(name string) *SegmentConfig {
+	for n := range cfg.Segments {
+		if cfg.Segments[n].Name == name {
+			return &cfg.Segments[n]
+		}
+	}
+	return nil
+}
+
+type configToken struct {
+	text string
+	line int
+}
+
+// splits the config into names, numbers and punctuation, # starts a comment
+func tokenizeConfig(data []byte) []configToken {
+	var toks []configToken
+	for n, line := range strings.Split(string(data), "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		for len(line) > 0 {
+			switch c := line[0]; {
+			case c == ' ' || c == '\t' || c == '\r':
+				line = line[1:]
+			case strings.IndexByte("{}:=,;", c) >= 0:
+				toks = append(toks, configToken{line[:1], n + 1})
+				line = line[1:]
+			default:
+				end := strings.IndexAny(line, " \t\r{}:=,;")
+				if end < 0 {
+					end = len(line)
+				}
+				toks = append(toks, configToken{line[:end], n + 1})
+				line = line[end:]
+			}
+		}
+	}
+	return toks
+}
+
+type configParser struct {
+	name string
+	toks []configToken
+	pos  int
+}
+
+func (p *configParser) errorf(format string, args ...interface{}) error {
+	// the line of the last token read
+	line := 0
+	if n := p.pos - 1; n >= 0 && len(p.toks) > 0 {
+		if n >= len(p.toks) {
+			n = len(p.toks) - 1
+		}
+		line = p.toks[n].line
+	}
+	return fmt.Errorf("%s:%d: %s", p.name, line, fmt.Sprintf(format, args...))
+}
+
+func (p *configParser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos].text
+	}
+	return ""
+}
+
+func (p *configParser) next() string {
+	tok := p.peek()
+	p.pos++
+	return tok
+}
+
+func (p *configParser) expect(text string) error {
+	if tok := p.peek(); tok != text {
+		return p.errorf("Expected '%s' but found '%s'", text, tok)
+	}
+	p.pos++
+	return nil
+}
+
+// name: attr = value, ... ;
+func (p *configParser) entry() (string, map[string]string, error) {
+	name := p.next()
+	if err := p.expect(":"); err != nil {
+		return "", nil, err
+	}
+
+	attrs := map[string]string{}
+	for {
+		attr := strings.ToLower(p.next())
+		if err := p.expect("="); err != nil {
+			return "", nil, err
+		}
+		attrs[attr] = p.next()
+
+		switch p.next() {
+		case ",":
+			continue
+		case ";":
+			return name, attrs, nil
+		}
+		p.pos--
+		return "", nil, p.errorf("Expected ',' or ';'")
+	}
+}
+
+func (p *configParser) number(attrs map[string]string, attr string) (int, error) {
+	val, err := core6502.EvalExpr(attrs[attr], nil)
+	if err != nil {
+		return 0, p.errorf("Invalid %s: %v", attr, err)
+	}
+	return val, nil
+}
+
+func (p *configParser) memoryArea(cfg *Config, name string, attrs map[string]string) error {
+	if cfg.memoryArea(name) != nil {
+		return p.errorf("Duplicate memory area: %s", name)
+	}
+	for _, attr := range []string{"start", "size"} {
+		if _, ok := attrs[attr]; !ok {
+			return p.errorf("Memory area %s has no %s", name, attr)
+		}
+	}
+
+	area := MemoryArea{Name: name}
+	var err error
+	if area.Start, err = p.number(attrs, "start"); err != nil {
+		return err
+	}
+	if area.Size, err = p.number(attrs, "size"); err != nil {
+		return err
+	}
+	if area.Start < 0 || area.Size < 0 || area.Start+area.Size > 0x10000 {
+		return p.errorf("Memory area %s outside $0000-$ffff", name)
+	}
+	cfg.Memory = append(cfg.Memory, area)
+	return nil
+}
+
+func (p *configParser) segment(cfg *Config, name string, attrs map[string]string) error {
+	if cfg.segment(name) != nil {
+		return p.errorf("Duplicate segment: %s", name)
+	}
+
+	seg := SegmentConfig{Name: name, Load: attrs["load"], Align: 1}
+	if cfg.memoryArea(seg.Load) == nil {
+		return p.errorf("Segment %s loads to unknown memory area '%s'", name, seg.Load)
+	}
+
+	switch strings.ToLower(attrs["type"]) {
+	case "", "ro", "rw":
+	case "bss", "zp":
+		seg.BSS = true
+	default:
+		return p.errorf("Invalid segment type: %s", attrs["type"])
+	}
+
+	var err error
+	if _, ok := attrs["start"]; ok {
+		if seg.Start, err = p.number(attrs, "start"); err != nil {
+			return err
+		}
+		seg.HasStart = true
+	}
+	if _, ok := attrs["align"]; ok {
+		if seg.Align, err = p.number(attrs, "align"); err != nil {
+			return err
+		}
+		if seg.Align < 1 {
+			return p.errorf("Invalid align: %d", seg.Align)
+		}
+	}
+	cfg.Segments = append(cfg.Segments, seg)
+	return nil
+}
+
+// parses a linker configuration, name is used in error messages
+func ParseConfig(name string, data []byte) (*Config, error) {
+	p := configParser{name: name, toks: tokenizeConfig(data)}
+	cfg := &Config{}
+
+	for p.peek() != "" {
+		section := strings.ToUpper(p.next())
+		if section != "MEMORY" && section != "SEGMENTS" {
+			return nil, p.errorf("Unknown section: %s", section)
+		}
+		if err := p.expect("{"); err != nil {
+			return nil, err
+		}
+
+		for p.peek() != "}" {
+			if p.peek() == "" {
+				return nil, p.errorf("Missing '}'")
+			}
+			entry, attrs, err := p.entry()
+			if err != nil {
+				return nil, err
+			}
+
+			if section == "MEMORY" {
+				err = p.memoryArea(cfg, entry, attrs)
+			} else {
+				err = p.segment(cfg, entry, attrs)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		p.next()
+	}
+	return cfg, nil
+}
+
+func LoadConfig(filename string) (*Config, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(filename, data)
+}
@@ -0,0 +1,255 @@
+package obj6502
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// A segment as placed by the linker
+type PlacedSegment struct {
+	Name   string
+	Memory string
+	Start  int
+	Size   int
+	Align  int
+	BSS    bool
+}
+
+// The part of a placed segment that came from one object
+type ModuleSegment struct {
+	Module  string // object source
+	Segment string
+	Offset  int // from the start of the placed segment
+	Size    int
+}
+
+// A linked program
+type Program struct {
+	Start    int     // address of Data[0]
+	Data     []uint8 // initialised segments, from the lowest address to the highest, gaps are zero
+	Segments []PlacedSegment
+	Modules  []ModuleSegment
+	Symbols  map[string]int // exported symbols
+}
+
+func align(addr, alignment int) int {
+	return (addr + alignment - 1) / alignment * alignment
+}
+
+/*
+	Links objs according to cfg. Each segment of the configuration is
+	made from the segments of the same name in objs, in order, and
+	placed after the previous segment in its memory area. It is an
+	error for a segment to overflow its memory area or to overlap
+	another, or for an import to have no export.
+*/
+func Link(cfg *Config, objs []*Object) (*Program, error) {
+	prog := &Program{Symbols: map[string]int{}}
+
+	for _, obj := range objs {
+		for _, seg := range obj.Segments {
+			if cfg.segment(seg.Name) == nil {
+				return nil, fmt.Errorf("%s: Segment %s is not in the memory configuration", obj.Source, seg.Name)
+			}
+		}
+	}
+
+	// address of each object's part of each segment
+	bases := make([]map[string]int, len(objs))
+	for n := range bases {
+		bases[n] = map[string]int{}
+	}
+
+	cursors := map[string]int{}
+	for _, area := range cfg.Memory {
+		cursors[area.Name] = area.Start
+	}
+
+	for _, sc := range cfg.Segments {
+		area := cfg.memoryArea(sc.Load)
+
+		start := align(cursors[area.Name], sc.Align)
+		if sc.HasStart {
+			start = sc.Start
+		}
+		if start < area.Start || start > area.Start+area.Size {
+			return nil, fmt.Errorf("Segment %s start $%04x is outside memory area %s", sc.Name, start, area.Name)
+		}
+
+		size := 0
+		for n, obj := range objs {
+			for _, seg := range obj.Segments {
+				if seg.Name == sc.Name {
+					bases[n][seg.Name] = start + size
+					prog.Modules = append(prog.Modules, ModuleSegment{obj.Source, seg.Name, size, len(seg.Data)})
+					size += len(seg.Data)
+				}
+			}
+		}
+
+		if over := start + size - (area.Start + area.Size); over > 0 {
+			return nil, fmt.Errorf("Segment %s overflows memory area %s by %d bytes", sc.Name, area.Name, over)
+		}
+		cursors[area.Name] = start + size
+
+		if size > 0 {
+			prog.Segments = append(prog.Segments, PlacedSegment{sc.Name, area.Name, start, size, sc.Align, sc.BSS})
+		}
+	}
+
+	// the map lists the segments of each module together
+	module := map[string]int{}
+	for n, obj := range objs {
+		module[obj.Source] = n
+	}
+	sort.SliceStable(prog.Modules, func(i, j int) bool {
+		return module[prog.Modules[i].Module] < module[prog.Modules[j].Module]
+	})
+
+	placed := append([]PlacedSegment(nil), prog.Segments...)
+	sort.Slice(placed, func(i, j int) bool { return placed[i].Start < placed[j].Start })
+	for n := 1; n < len(placed); n++ {
+		if prev := placed[n-1]; prev.Start+prev.Size > placed[n].Start {
+			return nil, fmt.Errorf("Segments %s and %s overlap at $%04x", prev.Name, placed[n].Name, placed[n].Start)
+		}
+	}
+
+	defined := map[string]string{}
+	for n, obj := range objs {
+		for _, exp := range obj.Exports {
+			if other, ok := defined[exp.Name]; ok {
+				return nil, fmt.Errorf("Duplicate export %s in %s and %s", exp.Name, other, obj.Source)
+			}
+			defined[exp.Name] = obj.Source
+
+			val := exp.Value
+			if exp.Segment != "" {
+				base, ok := bases[n][exp.Segment]
+				if !ok {
+					return nil, fmt.Errorf("%s: Export %s refers to missing segment %s", obj.Source, exp.Name, exp.Segment)
+				}
+				val += base
+			}
+			prog.Symbols[exp.Name] = val
+		}
+	}
+
+	for _, obj := range objs {
+		for _, imp := range obj.Imports {
+			if _, ok := prog.Symbols[imp]; !ok {
+				return nil, fmt.Errorf("%s: Unresolved import: %s", obj.Source, imp)
+			}
+		}
+	}
+
+	var mem [0x10000]uint8
+	lo, hi := 0x10000, 0
+
+	for n, obj := range objs {
+		for _, seg := range obj.Segments {
+			base := bases[n][seg.Name]
+			data := append([]uint8(nil), seg.Data...)
+
+			for _, r := range seg.Relocs {
+				if err := relocate(data, r, bases[n], prog.Symbols); err != nil {
+					return nil, fmt.Errorf("%s: Segment %s offset $%04x: %v", obj.Source, seg.Name, r.Offset, err)
+				}
+			}
+
+			if cfg.segment(seg.Name).BSS || len(data) == 0 {
+				continue
+			}
+			copy(mem[base:], data)
+			if base < lo {
+				lo = base
+			}
+			if base+len(data) > hi {
+				hi = base + len(data)
+			}
+		}
+	}
+
+	if lo < hi {
+		prog.Start = lo
+		prog.Data = append([]uint8(nil), mem[lo:hi]...)
+	}
+	return prog, nil
+}
+
+// patches the field of data at r.Offset
+func relocate(data []uint8, r Reloc, bases map[string]int, symbols map[string]int) error {
+	if r.Offset < 0 || r.Offset+r.Kind.Size() > len(data) {
+		return fmt.Errorf("Relocation outside segment")
+	}
+
+	var target int
+	if r.Segment != "" {
+		base, ok := bases[r.Segment]
+		if !ok {
+			return fmt.Errorf("Relocation refers to missing segment %s", r.Segment)
+		}
+		target = base
+	} else {
+		target = symbols[r.Symbol]
+	}
+
+	val := target + r.Addend
+	switch r.Kind {
+	case Reloc_Word:
+		if val < 0 || val > 0xffff {
+			return fmt.Errorf("Address $%x out of range", val)
+		}
+		data[r.Offset] = uint8(val)
+		data[r.Offset+1] = uint8(val >> 8)
+	case Reloc_Byte:
+		if val < 0 || val > 0xff {
+			return fmt.Errorf("Address $%04x does not fit in a byte", val)
+		}
+		data[r.Offset] = uint8(val)
+	case Reloc_Lo:
+		data[r.Offset] = uint8(val)
+	case Reloc_Hi:
+		data[r.Offset] = uint8(val >> 8)
+	default:
+		return fmt.Errorf("Invalid relocation kind: %d", r.Kind)
+	}
+	return nil
+}
+
+// writes a map file in the ld65 layout, so emu6502 can load its exports as symbols
+func (p *Program) WriteMap(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "Modules list:\n-------------\n")
+	module := ""
+	for _, m := range p.Modules {
+		if m.Module != module {
+			module = m.Module
+			fmt.Fprintf(bw, "%s:\n", module)
+		}
+		fmt.Fprintf(bw, "    %-20s  Offs=%06X  Size=%06X\n", m.Segment, m.Offset, m.Size)
+	}
+
+	fmt.Fprintf(bw, "\n\nSegment list:\n-------------\n")
+	fmt.Fprintf(bw, "Name                   Start     End    Size  Align\n")
+	fmt.Fprintf(bw, "----------------------------------------------------\n")
+	for _, seg := range p.Segments {
+		fmt.Fprintf(bw, "%-20s  %06X  %06X  %06X  %05X\n", seg.Name, seg.Start, seg.Start+seg.Size-1, seg.Size, seg.Align)
+	}
+
+	var names []string
+	for name := range p.Symbols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(bw, "\n\nExports list by name:\n---------------------\n")
+	for _, name := range names {
+		fmt.Fprintf(bw, "%-25s %06X RLA\n", name, p.Symbols[name])
+	}
+	fmt.Fprintln(bw)
+
+	return bw.Flush()
+}
@@ -0,0 +1,168 @@
+package obj6502
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/simulatedsimian/assert"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	pack := assert.Pack
+
+	cfg, err := ParseConfig("test.cfg", []byte(`
+# comment
+MEMORY {
+	ZP:  start = $80, size = $80;
+	ROM: start = $c000, size = $4000;
+}
+SEGMENTS {
+	ZEROPAGE: load = ZP, type = zp;
+	CODE:     load = ROM, type = ro;
+	VECTORS:  load = ROM, start = $fffa;
+	DATA:     load = ROM, align = $100;
+}
+`))
+	assert.NoError(t, pack(err))
+	assert.Equal(t, cfg.Memory, []MemoryArea{{"ZP", 0x80, 0x80}, {"ROM", 0xc000, 0x4000}})
+	assert.Equal(t, cfg.Segments, []SegmentConfig{
+		{Name: "ZEROPAGE", Load: "ZP", BSS: true, Align: 1},
+		{Name: "CODE", Load: "ROM", Align: 1},
+		{Name: "VECTORS", Load: "ROM", Start: 0xfffa, HasStart: true, Align: 1},
+		{Name: "DATA", Load: "ROM", Align: 0x100},
+	})
+
+	errors := []struct {
+		cfg string
+		msg string
+	}{
+		{"FILES {}", "test.cfg:1: Unknown section: FILES"},
+		{"MEMORY {\n RAM: start = 0 }", "test.cfg:2: Expected ',' or ';'"},
+		{"MEMORY {\n RAM: start = 0;\n}", "test.cfg:2: Memory area RAM has no size"},
+		{"MEMORY { RAM: start = $ff00, size = $200; }", "test.cfg:1: Memory area RAM outside $0000-$ffff"},
+		{"SEGMENTS {\n CODE: load = ROM;\n}", "test.cfg:2: Segment CODE loads to unknown memory area 'ROM'"},
+		{"MEMORY { RAM: start = 0, size = 1; RAM: start = 0, size = 1; }", "test.cfg:1: Duplicate memory area: RAM"},
+		{"MEMORY { RAM: start = 0, size = 1; } SEGMENTS { A: load = RAM, type = xx; }", "test.cfg:1: Invalid segment type: xx"},
+		{"MEMORY {", "test.cfg:1: Missing '}'"},
+	}
+
+	for _, test := range errors {
+		_, err := ParseConfig("test.cfg", []byte(test.cfg))
+		assert.Equal(t, fmt.Sprint(err), test.msg)
+	}
+}
+
+func TestObjectReadWrite(t *testing.T) {
+	pack := assert.Pack
+
+	obj := New("test.s")
+	obj.Segment("CODE").Data = []uint8{0x4c, 0, 0}
+	obj.Segment("CODE").Relocs = []Reloc{{Offset: 1, Kind: Reloc_Word, Segment: "CODE"}}
+	obj.Exports = []Export{{"start", "CODE", 0}}
+	obj.Imports = []string{"print"}
+
+	var buf bytes.Buffer
+	assert.NoError(t, pack(obj.Write(&buf)))
+	read, err := Read(&buf, "test.o")
+	assert.NoError(t, pack(err))
+	assert.Equal(t, read, obj)
+
+	_, err = Read(bytes.NewReader([]byte(`{"Format": "obj6502", "Version": 99}`)), "test.o")
+	assert.Equal(t, fmt.Sprint(err), "test.o: Unsupported object file version 99")
+	_, err = Read(bytes.NewReader([]byte("\x01\x02")), "test.o")
+	assert.Equal(t, fmt.Sprint(err), "test.o: Not an obj6502 object file")
+}
+
+// main.o: jmp start, lda #<message, lda #>message ; start: rts
+// lib.o:  message: .byte "x", table: .word message+1
+func testObjects() []*Object {
+	main := New("main.o")
+	code := main.Segment("CODE")
+	code.Data = []uint8{0x4c, 0, 0, 0xa9, 0, 0xa9, 0, 0x60}
+	code.Relocs = []Reloc{
+		{Offset: 1, Kind: Reloc_Word, Segment: "CODE", Addend: 7},
+		{Offset: 4, Kind: Reloc_Lo, Symbol: "message"},
+		{Offset: 6, Kind: Reloc_Hi, Symbol: "message"},
+	}
+	main.Segment("ZEROPAGE").Data = []uint8{0, 0}
+	main.Exports = []Export{{"start", "CODE", 7}, {"ptr", "ZEROPAGE", 0}, {"screen", "", 0x8000}}
+	main.Imports = []string{"message"}
+
+	lib := New("lib.o")
+	data := lib.Segment("DATA")
+	data.Data = []uint8{'x', 0, 0}
+	data.Relocs = []Reloc{{Offset: 1, Kind: Reloc_Word, Segment: "DATA", Addend: 1}}
+	lib.Exports = []Export{{"message", "DATA", 0}}
+	return []*Object{main, lib}
+}
+
+func TestLink(t *testing.T) {
+	pack := assert.Pack
+
+	cfg, err := ParseConfig("test.cfg", []byte(`
+MEMORY {
+	ZP:  start = $10, size = $10;
+	RAM: start = $1000, size = $1000;
+}
+SEGMENTS {
+	ZEROPAGE: load = ZP, type = bss;
+	CODE:     load = RAM;
+	DATA:     load = RAM, align = $10;
+}
+`))
+	assert.NoError(t, pack(err))
+
+	prog, err := Link(cfg, testObjects())
+	assert.NoError(t, pack(err))
+	assert.Equal(t, prog.Start, 0x1000)
+	assert.Equal(t, prog.Data, []uint8{
+		0x4c, 0x07, 0x10, 0xa9, 0x10, 0xa9, 0x10, 0x60,
+		0, 0, 0, 0, 0, 0, 0, 0,
+		'x', 0x11, 0x10,
+	})
+	assert.Equal(t, prog.Segments, []PlacedSegment{
+		{"ZEROPAGE", "ZP", 0x10, 2, 1, true},
+		{"CODE", "RAM", 0x1000, 8, 1, false},
+		{"DATA", "RAM", 0x1010, 3, 0x10, false},
+	})
+	assert.Equal(t, prog.Symbols, map[string]int{"start": 0x1007, "ptr": 0x10, "screen": 0x8000, "message": 0x1010})
+
+	// the map loads into a symbol table
+	var m bytes.Buffer
+	assert.NoError(t, pack(prog.WriteMap(&m)))
+	st := core6502.NewSymbolTable()
+	assert.Equal(t, pack(st.Load(&m, "test.map")), []interface{}{4, nil})
+	assert.Equal(t, pack(st.Lookup("message")), []interface{}{uint16(0x1010), true})
+}
+
+func TestLinkErrors(t *testing.T) {
+	small := &Config{
+		Memory:   []MemoryArea{{"ZP", 0x10, 0x10}, {"RAM", 0x1000, 8}},
+		Segments: []SegmentConfig{{Name: "ZEROPAGE", Load: "ZP", Align: 1}, {Name: "CODE", Load: "RAM", Align: 1}},
+	}
+	_, err := Link(small, testObjects())
+	assert.Equal(t, fmt.Sprint(err), "lib.o: Segment DATA is not in the memory configuration")
+
+	small.Segments = append(small.Segments, SegmentConfig{Name: "DATA", Load: "RAM", Align: 1})
+	_, err = Link(small, testObjects())
+	assert.Equal(t, fmt.Sprint(err), "Segment DATA overflows memory area RAM by 3 bytes")
+
+	overlap := DefaultConfig()
+	overlap.segment("DATA").Start, overlap.segment("DATA").HasStart = 0x404, true
+	_, err = Link(overlap, testObjects())
+	assert.Equal(t, fmt.Sprint(err), "Segments CODE and DATA overlap at $0404")
+
+	objs := testObjects()
+	_, err = Link(DefaultConfig(), objs[:1])
+	assert.Equal(t, fmt.Sprint(err), "main.o: Unresolved import: message")
+
+	objs = append(objs, testObjects()[1])
+	_, err = Link(DefaultConfig(), objs)
+	assert.Equal(t, fmt.Sprint(err), "Duplicate export message in lib.o and lib.o")
+
+	objs = testObjects()
+	objs[0].Segment("CODE").Relocs[0].Kind = Reloc_Byte
+	_, err = Link(DefaultConfig(), objs)
+	assert.Equal(t, fmt.Sprint(err), "main.o: Segment CODE offset $0001: Address $0407 does not fit in a byte")
+}
@@ -0,0 +1,140 @@
+package obj6502
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// identifies object files, and the version of their layout
+const (
+	FormatName    = "obj6502"
+	FormatVersion = 1
+)
+
+type RelocKind int
+
+const (
+	Reloc_Word RelocKind = iota // 16 bit little endian address
+	Reloc_Byte                  // 8 bit address, must be below $100
+	Reloc_Lo                    // low byte of the address
+	Reloc_Hi                    // high byte of the address
+)
+
+func (k RelocKind) String() string {
+	switch k {
+	case Reloc_Word:
+		return "word"
+	case Reloc_Byte:
+		return "byte"
+	case Reloc_Lo:
+		return "lo"
+	case Reloc_Hi:
+		return "hi"
+	}
+	return "invalid"
+}
+
+// field size of the relocation in bytes
+func (k RelocKind) Size() int {
+	if k == Reloc_Word {
+		return 2
+	}
+	return 1
+}
+
+/*
+	A field of segment data to be patched when the object is linked,
+	with the address of Segment (of the same object) or of the imported
+	Symbol, plus Addend.
+*/
+type Reloc struct {
+	Offset  int // of the field in the segment data
+	Kind    RelocKind
+	Segment string `json:",omitempty"`
+	Symbol  string `json:",omitempty"`
+	Addend  int
+}
+
+// A named segment of an object, placed at an address by the linker
+type Segment struct {
+	Name   string
+	Data   []uint8
+	Relocs []Reloc `json:",omitempty"`
+}
+
+// A symbol made visible to other objects
+type Export struct {
+	Name    string
+	Segment string `json:",omitempty"` // "" for an absolute value
+	Value   int    // offset in Segment, or the absolute value
+}
+
+// A relocatable object file
+type Object struct {
+	Format   string
+	Version  int
+	Source   string
+	Segments []*Segment
+	Exports  []Export `json:",omitempty"`
+	Imports  []string `json:",omitempty"`
+}
+
+func New(source string) *Object {
+	return &Object{Format: FormatName, Version: FormatVersion, Source: source}
+}
+
+// returns the segment called name, adding it if it does not exist
+func (o *Object) Segment(name string) *Segment {
+	for _, seg := range o.Segments {
+		if seg.Name == name {
+			return seg
+		}
+	}
+	seg := &Segment{Name: name}
+	o.Segments = append(o.Segments, seg)
+	return seg
+}
+
+func (o *Object) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(o)
+}
+
+func (o *Object) WriteFile(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := o.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// reads an object, name is used in error messages
+func Read(r io.Reader, name string) (*Object, error) {
+	var o Object
+	if err := json.NewDecoder(r).Decode(&o); err != nil || o.Format != FormatName {
+		return nil, fmt.Errorf("%s: Not an %s object file", name, FormatName)
+	}
+	if o.Version != FormatVersion {
+		return nil, fmt.Errorf("%s: Unsupported object file version %d", name, o.Version)
+	}
+	if o.Source == "" {
+		o.Source = name
+	}
+	return &o, nil
+}
+
+func ReadFile(filename string) (*Object, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f, filename)
+}
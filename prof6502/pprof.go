@@ -0,0 +1,204 @@
+package prof6502
+
+import (
+	"compress/gzip"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"io"
+	"os"
+	"sort"
+)
+
+// protocol buffer encoding, enough for profile.proto
+type protoBuf []byte
+
+func (b *protoBuf) varint(x uint64) {
+	for x >= 0x80 {
+		*b = append(*b, uint8(x)|0x80)
+		x >>= 7
+	}
+	*b = append(*b, uint8(x))
+}
+
+func (b *protoBuf) uint64(field int, x uint64) {
+	b.varint(uint64(field) << 3)
+	b.varint(x)
+}
+
+func (b *protoBuf) bytes(field int, data []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(data)))
+	*b = append(*b, data...)
+}
+
+func (b *protoBuf) packed(field int, xs []uint64) {
+	var p protoBuf
+	for _, x := range xs {
+		p.varint(x)
+	}
+	b.bytes(field, p)
+}
+
+// profile.proto field numbers
+const (
+	profile_SampleType  = 1
+	profile_Sample      = 2
+	profile_Location    = 4
+	profile_Function    = 5
+	profile_StringTable = 6
+	profile_PeriodType  = 11
+	profile_Period      = 12
+
+	valueType_Type = 1
+	valueType_Unit = 2
+
+	sample_LocationID = 1
+	sample_Value      = 2
+
+	location_ID      = 1
+	location_Address = 3
+	location_Line    = 4
+
+	line_FunctionID = 1
+
+	function_ID         = 1
+	function_Name       = 2
+	function_SystemName = 3
+)
+
+type location struct {
+	pc     uint16
+	target uint16 // the subroutine pc is in
+}
+
+type pprofWriter struct {
+	out       protoBuf
+	strings   map[string]int
+	functions map[uint16]int
+	locations map[location]int
+	syms      *core6502.SymbolTable
+}
+
+func (pw *pprofWriter) str(s string) uint64 {
+	id, ok := pw.strings[s]
+	if !ok {
+		id = len(pw.strings)
+		pw.strings[s] = id
+	}
+	return uint64(id)
+}
+
+func (pw *pprofWriter) valueType(field int, typ, unit string) {
+	var vt protoBuf
+	vt.uint64(valueType_Type, pw.str(typ))
+	vt.uint64(valueType_Unit, pw.str(unit))
+	pw.out.bytes(field, vt)
+}
+
+func (pw *pprofWriter) function(target uint16) uint64 {
+	id, ok := pw.functions[target]
+	if !ok {
+		id = len(pw.functions) + 1
+		pw.functions[target] = id
+
+		var f protoBuf
+		f.uint64(function_ID, uint64(id))
+		f.uint64(function_Name, pw.str(name(pw.syms, target)))
+		f.uint64(function_SystemName, pw.str(name(nil, target)))
+		pw.out.bytes(profile_Function, f)
+	}
+	return uint64(id)
+}
+
+func (pw *pprofWriter) location(loc location) uint64 {
+	id, ok := pw.locations[loc]
+	if !ok {
+		id = len(pw.locations) + 1
+		pw.locations[loc] = id
+
+		var line protoBuf
+		line.uint64(line_FunctionID, pw.function(loc.target))
+
+		var l protoBuf
+		l.uint64(location_ID, uint64(id))
+		l.uint64(location_Address, uint64(loc.pc))
+		l.bytes(location_Line, line)
+		pw.out.bytes(profile_Location, l)
+	}
+	return uint64(id)
+}
+
+/*
+	Writes a gzipped pprof profile for go tool pprof, with instructions
+	and cycles as sample values. Each subroutine is a function, named by
+	its symbol in syms if it has one, and the call stacks are those seen
+	by the shadow call stack.
+*/
+func (p *Profiler) WritePprof(w io.Writer, syms *core6502.SymbolTable) error {
+	pw := pprofWriter{
+		strings:   map[string]int{"": 0},
+		functions: map[uint16]int{},
+		locations: map[location]int{},
+		syms:      syms,
+	}
+
+	pw.valueType(profile_SampleType, "instructions", "count")
+	pw.valueType(profile_SampleType, "cycles", "count")
+
+	// in a fixed order so the output is reproducible
+	var samples []sample
+	for s := range p.samples {
+		samples = append(samples, s)
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		if samples[i].stack != samples[j].stack {
+			return samples[i].stack < samples[j].stack
+		}
+		return samples[i].pc < samples[j].pc
+	})
+
+	for _, s := range samples {
+		// leaf first, each frame's call site is in its caller
+		var locs []uint64
+		pc := s.pc
+		for id := s.stack; id >= 0; id = p.keys[id].parent {
+			key := p.keys[id]
+			locs = append(locs, pw.location(location{pc, key.target}))
+			pc = key.site
+		}
+
+		c := p.samples[s]
+		var sp protoBuf
+		sp.packed(sample_LocationID, locs)
+		sp.packed(sample_Value, []uint64{c.Instructions, c.Cycles})
+		pw.out.bytes(profile_Sample, sp)
+	}
+
+	pw.valueType(profile_PeriodType, "cycles", "count")
+	pw.out.uint64(profile_Period, 1)
+
+	table := make([]string, len(pw.strings))
+	for s, id := range pw.strings {
+		table[id] = s
+	}
+	for _, s := range table {
+		pw.out.bytes(profile_StringTable, []byte(s))
+	}
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(pw.out); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func (p *Profiler) WritePprofFile(filename string, syms *core6502.SymbolTable) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := p.WritePprof(f, syms); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
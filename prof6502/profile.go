@@ -0,0 +1,241 @@
+package prof6502
+
+import (
+	"fmt"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	opJSR = 0x20
+	opBRK = 0x00
+)
+
+type Counts struct {
+	Instructions uint64
+	Cycles       uint64
+}
+
+func (c *Counts) add(cycles int) {
+	c.Instructions++
+	c.Cycles += uint64(cycles)
+}
+
+func (c *Counts) addCounts(o Counts) {
+	c.Instructions += o.Instructions
+	c.Cycles += o.Cycles
+}
+
+func (c Counts) since(o Counts) Counts {
+	return Counts{c.Instructions - o.Instructions, c.Cycles - o.Cycles}
+}
+
+// Totals for a subroutine. Exclusive counts its own instructions,
+// Inclusive adds those of the subroutines it calls
+type Subroutine struct {
+	Addr      uint16
+	Calls     uint64
+	Exclusive Counts
+	Inclusive Counts
+}
+
+// a call stack entry, the frames of a stack are interned so a sample can refer to its whole stack by id
+type stackKey struct {
+	parent int // -1 for the first frame
+	site   uint16
+	target uint16
+}
+
+type frame struct {
+	target uint16 // subroutine address
+	sp     int    // SP after the return address was pushed
+	stack  int    // interned call stack ending in this frame
+	outer  bool   // outermost active call of target, its inclusive counts are added
+	entry  Counts // totals when the frame was entered
+}
+
+type sample struct {
+	stack int
+	pc    uint16
+}
+
+/*
+	Attributes executed instructions and cycles to each PC, and through a
+	shadow call stack to each subroutine. JSR and BRK push a frame, and
+	frames are popped when SP rises above them, which handles RTS and RTI
+	as well as return addresses dropped or reused by stack tricks.
+	Instructions before the first call are attributed to the address
+	profiling started at.
+*/
+type Profiler struct {
+	PC    [0x10000]Counts
+	Total Counts
+
+	subs    map[uint16]*Subroutine
+	active  map[uint16]int
+	frames  []frame
+	stacks  map[stackKey]int
+	keys    []stackKey
+	samples map[sample]*Counts
+}
+
+func New() *Profiler {
+	return &Profiler{
+		subs:    map[uint16]*Subroutine{},
+		active:  map[uint16]int{},
+		stacks:  map[stackKey]int{},
+		samples: map[sample]*Counts{},
+	}
+}
+
+func (p *Profiler) intern(key stackKey) int {
+	id, ok := p.stacks[key]
+	if !ok {
+		id = len(p.keys)
+		p.stacks[key] = id
+		p.keys = append(p.keys, key)
+	}
+	return id
+}
+
+func (p *Profiler) subroutine(addr uint16) *Subroutine {
+	s, ok := p.subs[addr]
+	if !ok {
+		s = &Subroutine{Addr: addr}
+		p.subs[addr] = s
+	}
+	return s
+}
+
+func (p *Profiler) push(target, site uint16, sp int) {
+	parent := -1
+	if len(p.frames) > 0 {
+		parent = p.frames[len(p.frames)-1].stack
+	}
+
+	f := frame{target: target, sp: sp, stack: p.intern(stackKey{parent, site, target}), entry: p.Total}
+	f.outer = p.active[target] == 0
+	p.active[target]++
+	p.subroutine(target).Calls++
+	p.frames = append(p.frames, f)
+}
+
+func (p *Profiler) pop() {
+	f := p.frames[len(p.frames)-1]
+	p.frames = p.frames[:len(p.frames)-1]
+	p.active[f.target]--
+	if f.outer {
+		p.subroutine(f.target).Inclusive.addCounts(p.Total.since(f.entry))
+	}
+}
+
+// records an executed instruction, pc and opcode are from before it was executed
+func (p *Profiler) Record(ctx core6502.CPURegisters, pc uint16, opcode uint8, cycles int) {
+	if len(p.frames) == 0 {
+		p.push(pc, pc, 0x100) // above any SP, never popped
+	}
+
+	top := &p.frames[len(p.frames)-1]
+	p.PC[pc].add(cycles)
+	p.Total.add(cycles)
+	p.subroutine(top.target).Exclusive.add(cycles)
+
+	key := sample{top.stack, pc}
+	s, ok := p.samples[key]
+	if !ok {
+		s = &Counts{}
+		p.samples[key] = s
+	}
+	s.add(cycles)
+
+	sp := int(ctx.RegSP())
+	for len(p.frames) > 1 && p.frames[len(p.frames)-1].sp < sp {
+		p.pop()
+	}
+	if opcode == opJSR || opcode == opBRK {
+		p.push(ctx.RegPC(), pc, sp)
+	}
+}
+
+// the subroutines called so far, calls still in progress are included in the inclusive counts
+func (p *Profiler) Subroutines() []Subroutine {
+	live := map[uint16]Counts{}
+	for _, f := range p.frames {
+		if f.outer {
+			live[f.target] = p.Total.since(f.entry)
+		}
+	}
+
+	var subs []Subroutine
+	for addr, s := range p.subs {
+		sub := *s
+		sub.Inclusive.addCounts(live[addr])
+		subs = append(subs, sub)
+	}
+	return subs
+}
+
+// the name of a subroutine or address, its symbol or $addr
+func name(syms *core6502.SymbolTable, addr uint16) string {
+	if syms != nil {
+		if name := syms.Format(addr); name != "" {
+			return name
+		}
+	}
+	return fmt.Sprintf("$%04x", addr)
+}
+
+func percent(val, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(val) * 100 / float64(total)
+}
+
+// writes the top n addresses by cycles and the top n subroutines by inclusive cycles
+func (p *Profiler) Report(w io.Writer, n int, syms *core6502.SymbolTable) {
+	fmt.Fprintf(w, "%d instructions, %d cycles\n", p.Total.Instructions, p.Total.Cycles)
+
+	var addrs []int
+	for addr := range p.PC {
+		if p.PC[addr].Instructions > 0 {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.SliceStable(addrs, func(i, j int) bool { return p.PC[addrs[i]].Cycles > p.PC[addrs[j]].Cycles })
+	if len(addrs) > n {
+		addrs = addrs[:n]
+	}
+
+	fmt.Fprintf(w, "     Cycles      %%   Instrs  Address\n")
+	for _, addr := range addrs {
+		c := p.PC[addr]
+		label := ""
+		if syms != nil {
+			label = " " + syms.Format(uint16(addr))
+		}
+		fmt.Fprintf(w, "%11d %5.1f%% %8d  $%04x%s\n", c.Cycles, percent(c.Cycles, p.Total.Cycles),
+			c.Instructions, addr, strings.TrimRight(label, " "))
+	}
+
+	subs := p.Subroutines()
+	sort.Slice(subs, func(i, j int) bool {
+		if subs[i].Inclusive.Cycles != subs[j].Inclusive.Cycles {
+			return subs[i].Inclusive.Cycles > subs[j].Inclusive.Cycles
+		}
+		return subs[i].Addr < subs[j].Addr
+	})
+	if len(subs) > n {
+		subs = subs[:n]
+	}
+
+	fmt.Fprintf(w, "  Inclusive      %%   Exclusive      %%    Calls  Subroutine\n")
+	for _, s := range subs {
+		fmt.Fprintf(w, "%11d %5.1f%% %11d %5.1f%% %8d  %s\n",
+			s.Inclusive.Cycles, percent(s.Inclusive.Cycles, p.Total.Cycles),
+			s.Exclusive.Cycles, percent(s.Exclusive.Cycles, p.Total.Cycles),
+			s.Calls, name(syms, s.Addr))
+	}
+}
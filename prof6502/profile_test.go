@@ -0,0 +1,102 @@
+package prof6502
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/simulatedsimian/assert"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// main calls outer twice, outer calls inner
+func profileProgram(t *testing.T, instructions int) *Profiler {
+	pack := assert.Pack
+
+	var ctx core6502.BasicCPUContext
+	for addr, code := range map[uint16][]uint8{
+		0x0400: {0x20, 0x10, 0x04, 0x20, 0x10, 0x04}, // jsr outer, jsr outer
+		0x0410: {0x20, 0x20, 0x04, 0x60},             // outer: jsr inner, rts
+		0x0420: {0xea, 0x60},                         // inner: nop, rts
+	} {
+		for n, b := range code {
+			ctx.Poke(addr+uint16(n), b)
+		}
+	}
+	ctx.SetRegPC(0x0400)
+	ctx.SetRegSP(0xff)
+
+	p := New()
+	for n := 0; n < instructions; n++ {
+		pc := ctx.RegPC()
+		opcode := ctx.Peek(pc)
+		cycles, err := core6502.Execute(&ctx)
+		assert.NoError(t, pack(err))
+		p.Record(&ctx, pc, opcode, cycles)
+	}
+	return p
+}
+
+func TestProfiler(t *testing.T) {
+	p := profileProgram(t, 10)
+	assert.Equal(t, p.Total, Counts{10, 52})
+	assert.Equal(t, p.PC[0x0420], Counts{2, 4})
+	assert.Equal(t, p.PC[0x0413], Counts{2, 12})
+
+	subs := p.Subroutines()
+	sort.Slice(subs, func(i, j int) bool { return subs[i].Addr < subs[j].Addr })
+	assert.Equal(t, subs, []Subroutine{
+		{0x0400, 1, Counts{2, 12}, Counts{10, 52}},
+		{0x0410, 2, Counts{4, 24}, Counts{8, 40}},
+		{0x0420, 2, Counts{4, 16}, Counts{4, 16}},
+	})
+
+	// part way through the second call of inner
+	p = profileProgram(t, 8)
+	subs = p.Subroutines()
+	sort.Slice(subs, func(i, j int) bool { return subs[i].Addr < subs[j].Addr })
+	assert.Equal(t, subs[1], Subroutine{0x0410, 2, Counts{3, 18}, Counts{6, 28}})
+	assert.Equal(t, subs[2], Subroutine{0x0420, 2, Counts{3, 10}, Counts{3, 10}})
+}
+
+func TestReport(t *testing.T) {
+	syms := core6502.NewSymbolTable()
+	syms.Add("main", 0x0400)
+	syms.Add("outer", 0x0410)
+	syms.Add("inner", 0x0420)
+
+	var out bytes.Buffer
+	profileProgram(t, 10).Report(&out, 2, syms)
+	assert.Equal(t, strings.Split(out.String(), "\n"), []string{
+		"10 instructions, 52 cycles",
+		"     Cycles      %   Instrs  Address",
+		"         12  23.1%        2  $0410 outer",
+		"         12  23.1%        2  $0413 outer+3",
+		"  Inclusive      %   Exclusive      %    Calls  Subroutine",
+		"         52 100.0%          12  23.1%        1  main",
+		"         40  76.9%          24  46.2%        2  outer",
+		"",
+	})
+}
+
+func TestWritePprof(t *testing.T) {
+	pack := assert.Pack
+
+	syms := core6502.NewSymbolTable()
+	syms.Add("inner", 0x0420)
+
+	var out bytes.Buffer
+	assert.NoError(t, pack(profileProgram(t, 10).WritePprof(&out, syms)))
+
+	gz, err := gzip.NewReader(&out)
+	assert.NoError(t, pack(err))
+	data, err := ioutil.ReadAll(gz)
+	assert.NoError(t, pack(err))
+
+	// the string table is last, each entry is field 6, its length and the string
+	for _, s := range []string{"instructions", "cycles", "inner", "$0410"} {
+		assert.Equal(t, bytes.Contains(data, append([]byte{6<<3 | 2, uint8(len(s))}, s...)), true)
+	}
+}
@@ -0,0 +1,278 @@
+package sim6502
+
+import (
+	"github.com/simulatedsimian/emu6502/core6502"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+/*
+	cc65's sim6502 library calls the host by JSR to these addresses, just
+	below the 6502 vectors. Nothing is executed there: the call is
+	performed and an RTS simulated.
+*/
+const ParavirtBase = 0xfff4
+
+const (
+	Hook_Open  = ParavirtBase + iota // int open(const char* name, int flags, ...)
+	Hook_Close                       // int __fastcall__ close(int fd)
+	Hook_Read                        // int __fastcall__ read(int fd, void* buf, unsigned count)
+	Hook_Write                       // int __fastcall__ write(int fd, const void* buf, unsigned count)
+	Hook_Args                        // args, fills in argc and argv for main
+	Hook_Exit                        // exit with the code in A
+	hookEnd
+)
+
+// cc65 open flags
+const (
+	openRead   = 0x01
+	openWrite  = 0x02
+	openCreate = 0x10
+	openTrunc  = 0x20
+	openAppend = 0x40
+	openExcl   = 0x80
+)
+
+/*
+	The host side of the sim65 paravirtualised calls. Parameters are
+	popped from the cc65 software stack, whose pointer is at SP in zero
+	page, and results are returned in A (low byte) and X as sim65 does,
+	-1 for a failed call. Files are opened relative to Dir and cannot be
+	opened outside it; descriptors 0-2 are Stdin, Stdout and Stderr.
+*/
+type Host struct {
+	SP     uint8
+	Dir    string
+	Args   []string // argv, including the program name
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	Exited   bool // set by the exit call
+	ExitCode int
+
+	files map[int]*os.File
+}
+
+// a host for p with files in dir and the standard streams of the process
+func NewHost(p *Program, dir string, args []string) *Host {
+	return &Host{
+		SP:     p.SP,
+		Dir:    dir,
+		Args:   args,
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+		files:  map[int]*os.File{},
+	}
+}
+
+/*
+	If the PC is at one of the host calls, performs it, returns to the
+	caller and returns true. Otherwise returns false and the instruction
+	at the PC should be executed as normal.
+*/
+func (h *Host) Call(ctx core6502.CPUContext) bool {
+	pc := ctx.RegPC()
+	if pc < ParavirtBase || pc >= hookEnd {
+		return false
+	}
+
+	switch pc {
+	case Hook_Open:
+		h.open(ctx)
+	case Hook_Close:
+		h.close(ctx)
+	case Hook_Read:
+		h.read(ctx)
+	case Hook_Write:
+		h.write(ctx)
+	case Hook_Args:
+		h.args(ctx)
+	case Hook_Exit:
+		h.Exited, h.ExitCode = true, int(ctx.RegA())
+		return true
+	}
+
+	ctx.SetRegPC(core6502.Pop16(ctx) + 1)
+	return true
+}
+
+// closes any files the program left open
+func (h *Host) Close() {
+	for fd, f := range h.files {
+		f.Close()
+		delete(h.files, fd)
+	}
+}
+
+func regAX(ctx core6502.CPUContext) uint16 {
+	return core6502.MakeWord(ctx.RegX(), ctx.RegA())
+}
+
+func setAX(ctx core6502.CPUContext, val int) {
+	ctx.SetRegA(uint8(val))
+	ctx.SetRegX(uint8(val >> 8))
+}
+
+// the parameter on top of the cc65 stack, the stack is then dropped by size bytes
+func (h *Host) popParam(ctx core6502.CPUContext, size uint8) uint16 {
+	sp := ctx.PeekWord(uint16(h.SP))
+	param := ctx.PeekWord(sp)
+	ctx.PokeWord(uint16(h.SP), sp+uint16(size))
+	return param
+}
+
+func (h *Host) open(ctx core6502.CPUContext) {
+	// open is variadic, Y is the number of parameter bytes and the mode is ignored
+	if ctx.RegY() < 4 {
+		h.popParam(ctx, ctx.RegY())
+		setAX(ctx, -1)
+		return
+	}
+	h.popParam(ctx, ctx.RegY()-4)
+	flags := h.popParam(ctx, 2)
+	name := h.popParam(ctx, 2)
+
+	var s []byte
+	for b := ctx.Peek(name); b != 0; b = ctx.Peek(name) {
+		s = append(s, b)
+		name++
+	}
+
+	oflag := 0
+	switch flags & (openRead | openWrite) {
+	case openRead:
+		oflag = os.O_RDONLY
+	case openWrite:
+		oflag = os.O_WRONLY
+	case openRead | openWrite:
+		oflag = os.O_RDWR
+	}
+	for _, f := range []struct{ cc65, host int }{
+		{openCreate, os.O_CREATE}, {openTrunc, os.O_TRUNC}, {openAppend, os.O_APPEND}, {openExcl, os.O_EXCL},
+	} {
+		if int(flags)&f.cc65 != 0 {
+			oflag |= f.host
+		}
+	}
+
+	// cleaned as an absolute path so .. cannot leave Dir
+	hostName := filepath.Join(h.Dir, filepath.FromSlash(path.Clean("/"+string(s))))
+	f, err := os.OpenFile(hostName, oflag, 0666)
+	if err != nil {
+		setAX(ctx, -1)
+		return
+	}
+
+	fd := 3
+	for h.files[fd] != nil {
+		fd++
+	}
+	h.files[fd] = f
+	setAX(ctx, fd)
+}
+
+func (h *Host) close(ctx core6502.CPUContext) {
+	fd := int(regAX(ctx))
+	f, ok := h.files[fd]
+	if !ok {
+		setAX(ctx, -1)
+		return
+	}
+	delete(h.files, fd)
+	if f.Close() != nil {
+		setAX(ctx, -1)
+		return
+	}
+	setAX(ctx, 0)
+}
+
+func (h *Host) read(ctx core6502.CPUContext) {
+	count := regAX(ctx)
+	buf := h.popParam(ctx, 2)
+	fd := int(h.popParam(ctx, 2))
+
+	var r io.Reader
+	if fd == 0 {
+		r = h.Stdin
+	} else if f, ok := h.files[fd]; ok {
+		r = f
+	}
+	if r == nil {
+		setAX(ctx, -1)
+		return
+	}
+
+	data := make([]uint8, count)
+	n, err := r.Read(data)
+	if err != nil && err != io.EOF {
+		setAX(ctx, -1)
+		return
+	}
+	for i, b := range data[:n] {
+		ctx.Poke(buf+uint16(i), b)
+	}
+	setAX(ctx, n)
+}
+
+func (h *Host) write(ctx core6502.CPUContext) {
+	count := regAX(ctx)
+	buf := h.popParam(ctx, 2)
+	fd := int(h.popParam(ctx, 2))
+
+	var w io.Writer
+	switch fd {
+	case 1:
+		w = h.Stdout
+	case 2:
+		w = h.Stderr
+	default:
+		if f, ok := h.files[fd]; ok {
+			w = f
+		}
+	}
+	if w == nil {
+		setAX(ctx, -1)
+		return
+	}
+
+	data := make([]uint8, count)
+	for i := range data {
+		data[i] = ctx.Peek(buf + uint16(i))
+	}
+	n, err := w.Write(data)
+	if err != nil {
+		setAX(ctx, -1)
+		return
+	}
+	setAX(ctx, n)
+}
+
+/*
+	AX points at argv in the C startup code. The strings and the argv
+	array are put on the cc65 stack, below the current stack pointer,
+	and argc is returned.
+*/
+func (h *Host) args(ctx core6502.CPUContext) {
+	argv := regAX(ctx)
+	sp := ctx.PeekWord(uint16(h.SP))
+	array := sp - uint16(len(h.Args)+1)*2
+
+	ctx.PokeWord(argv, array)
+	sp = array
+	for n, arg := range h.Args {
+		sp -= uint16(len(arg) + 1)
+		for i := 0; i < len(arg); i++ {
+			ctx.Poke(sp+uint16(i), arg[i])
+		}
+		ctx.Poke(sp+uint16(len(arg)), 0)
+		ctx.PokeWord(array+uint16(n*2), sp)
+	}
+	ctx.PokeWord(array+uint16(len(h.Args)*2), 0)
+	ctx.PokeWord(uint16(h.SP), sp)
+
+	setAX(ctx, len(h.Args))
+}
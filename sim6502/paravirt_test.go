@@ -0,0 +1,179 @@
+package sim6502
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/simulatedsimian/assert"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testSP = 0x02 // zero page address of the cc65 stack pointer
+
+func TestReadProgram(t *testing.T) {
+	pack := assert.Pack
+
+	p, err := ReadProgram("test", []uint8("sim65\x02\x00\x02\x00\x02\x10\x02\xea"))
+	assert.NoError(t, pack(err))
+	assert.Equal(t, p.Header, Header{SP: 2, Load: 0x0200, Reset: 0x0210})
+	assert.Equal(t, p.Data, []uint8{0xea})
+
+	var ctx core6502.BasicCPUContext
+	p.Install(&ctx)
+	assert.Equal(t, ctx.RegPC(), uint16(0x0210))
+	assert.Equal(t, ctx.Peek(0x0200), uint8(0xea))
+
+	for _, test := range []struct {
+		data string
+		msg  string
+	}{
+		{"sim65\x01\x00\x02\x00\x02\x00\x02", "test: Unsupported sim65 header version 1"},
+		{"sim65\x02\x01\x02\x00\x02\x00\x02", "test: Only 6502 programs are supported, the CPU type is 1"},
+		{"sim66\x02\x00\x02\x00\x02\x00\x02", "test: Not a sim65 executable"},
+		{"sim65\x02\x00\x02\xf0\xff\x00\x02" + strings.Repeat("\x00", 5), "test: 5 bytes at $fff0 overlap the host calls at $fff4"},
+	} {
+		_, err := ReadProgram("test", []uint8(test.data))
+		assert.Equal(t, fmt.Sprint(err), test.msg)
+	}
+}
+
+func testHost(dir string, args ...string) (*Host, *core6502.BasicCPUContext) {
+	ctx := &core6502.BasicCPUContext{}
+	core6502.HardResetCPU(ctx, 0x0400)
+	ctx.PokeWord(testSP, 0xc000)
+
+	h := NewHost(&Program{Header: Header{SP: testSP}}, dir, args)
+	h.Stdout, h.Stderr = &bytes.Buffer{}, &bytes.Buffer{}
+	return h, ctx
+}
+
+// pushes a parameter onto the cc65 stack
+func pushParam(ctx core6502.CPUContext, val uint16) {
+	sp := ctx.PeekWord(testSP) - 2
+	ctx.PokeWord(sp, val)
+	ctx.PokeWord(testSP, sp)
+}
+
+func pokeString(ctx core6502.CPUContext, addr uint16, s string) {
+	for n := 0; n < len(s); n++ {
+		ctx.Poke(addr+uint16(n), s[n])
+	}
+	ctx.Poke(addr+uint16(len(s)), 0)
+}
+
+// JSR hook from $0400 with AX set to ax, returns AX
+func callHook(t *testing.T, h *Host, ctx core6502.CPUContext, hook uint16, ax uint16) uint16 {
+	core6502.Push16(ctx, 0x0402)
+	ctx.SetRegPC(hook)
+	ctx.SetRegA(uint8(ax))
+	ctx.SetRegX(uint8(ax >> 8))
+
+	assert.Equal(t, h.Call(ctx), true)
+	assert.Equal(t, ctx.RegPC(), uint16(0x0403))
+	assert.Equal(t, ctx.RegSP(), uint8(0xff))
+	return regAX(ctx)
+}
+
+func TestHostFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sim6502")
+	assert.NoError(t, assert.Pack(err))
+	defer os.RemoveAll(dir)
+
+	h, ctx := testHost(dir)
+	defer h.Close()
+
+	ctx.SetRegPC(0x0400)
+	assert.Equal(t, h.Call(ctx), false)
+
+	// write(1, "hello", 5)
+	pokeString(ctx, 0x0300, "hello")
+	pushParam(ctx, 1)
+	pushParam(ctx, 0x0300)
+	assert.Equal(t, callHook(t, h, ctx, Hook_Write, 5), uint16(5))
+	assert.Equal(t, h.Stdout.(*bytes.Buffer).String(), "hello")
+	assert.Equal(t, ctx.PeekWord(testSP), uint16(0xc000))
+
+	// fd = open("../sub/../out.txt", O_WRONLY|O_CREAT|O_TRUNC, 0666), kept inside dir
+	pokeString(ctx, 0x0310, "../sub/../out.txt")
+	pushParam(ctx, 0x0310)
+	pushParam(ctx, openWrite|openCreate|openTrunc)
+	pushParam(ctx, 0666)
+	ctx.SetRegY(6)
+	fd := callHook(t, h, ctx, Hook_Open, 0)
+	assert.Equal(t, fd, uint16(3))
+	assert.Equal(t, ctx.PeekWord(testSP), uint16(0xc000))
+
+	pushParam(ctx, fd)
+	pushParam(ctx, 0x0300)
+	assert.Equal(t, callHook(t, h, ctx, Hook_Write, 4), uint16(4))
+	assert.Equal(t, callHook(t, h, ctx, Hook_Close, fd), uint16(0))
+	assert.Equal(t, callHook(t, h, ctx, Hook_Close, fd), uint16(0xffff))
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "out.txt"))
+	assert.NoError(t, assert.Pack(err))
+	assert.Equal(t, string(data), "hell")
+
+	// open("out.txt", O_RDONLY) without a mode, then read until the end
+	pokeString(ctx, 0x0310, "out.txt")
+	pushParam(ctx, 0x0310)
+	pushParam(ctx, openRead)
+	ctx.SetRegY(4)
+	fd = callHook(t, h, ctx, Hook_Open, 0)
+	assert.Equal(t, fd, uint16(3))
+
+	pushParam(ctx, fd)
+	pushParam(ctx, 0x0320)
+	assert.Equal(t, callHook(t, h, ctx, Hook_Read, 16), uint16(4))
+	assert.Equal(t, ctx.Peek(0x0323), uint8('l'))
+	pushParam(ctx, fd)
+	pushParam(ctx, 0x0320)
+	assert.Equal(t, callHook(t, h, ctx, Hook_Read, 16), uint16(0))
+
+	// bad descriptors and missing files fail with -1
+	pushParam(ctx, 7)
+	pushParam(ctx, 0x0320)
+	assert.Equal(t, callHook(t, h, ctx, Hook_Read, 16), uint16(0xffff))
+	pokeString(ctx, 0x0310, "missing")
+	pushParam(ctx, 0x0310)
+	pushParam(ctx, openRead)
+	ctx.SetRegY(4)
+	assert.Equal(t, callHook(t, h, ctx, Hook_Open, 0), uint16(0xffff))
+	assert.Equal(t, ctx.PeekWord(testSP), uint16(0xc000))
+
+	// too few parameter bytes fails, dropping what was passed
+	pushParam(ctx, 0x0310)
+	ctx.SetRegY(2)
+	assert.Equal(t, callHook(t, h, ctx, Hook_Open, 0), uint16(0xffff))
+	assert.Equal(t, ctx.PeekWord(testSP), uint16(0xc000))
+}
+
+func TestHostArgsExit(t *testing.T) {
+	h, ctx := testHost(".", "prog", "-v")
+
+	assert.Equal(t, callHook(t, h, ctx, Hook_Args, 0x0080), uint16(2))
+	argv := ctx.PeekWord(0x0080)
+	assert.Equal(t, argv, uint16(0xc000-6))
+	assert.Equal(t, ctx.PeekWord(argv+4), uint16(0))
+	assert.Equal(t, ctx.PeekWord(testSP), uint16(0xc000-6-5-3))
+
+	for n, arg := range []string{"prog", "-v"} {
+		addr := ctx.PeekWord(argv + uint16(n*2))
+		for i := 0; i <= len(arg); i++ {
+			c := uint8(0)
+			if i < len(arg) {
+				c = arg[i]
+			}
+			assert.Equal(t, ctx.Peek(addr+uint16(i)), c)
+		}
+	}
+
+	ctx.SetRegPC(Hook_Exit)
+	ctx.SetRegA(3)
+	assert.Equal(t, h.Call(ctx), true)
+	assert.Equal(t, h.Exited, true)
+	assert.Equal(t, h.ExitCode, 3)
+}
@@ -0,0 +1,76 @@
+package sim6502
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/simulatedsimian/emu6502/core6502"
+	"io/ioutil"
+)
+
+const headerSize = 12
+
+/*
+	The header ld65 writes at the start of a sim6502 executable:
+
+	"sim65"  magic
+	$02      header version
+	CPU      0 6502, 1 65C02
+	SP       zero page address of the cc65 software stack pointer
+	load     load address, little endian
+	reset    start address, little endian
+*/
+type Header struct {
+	CPU   uint8
+	SP    uint8
+	Load  uint16
+	Reset uint16
+}
+
+// A sim6502 executable
+type Program struct {
+	Header
+	Data []uint8
+}
+
+// reads a sim6502 executable, name is used in error messages
+func ReadProgram(name string, data []uint8) (*Program, error) {
+	if len(data) < headerSize || !bytes.Equal(data[:5], []uint8("sim65")) {
+		return nil, fmt.Errorf("%s: Not a sim65 executable", name)
+	}
+	if data[5] != 2 {
+		return nil, fmt.Errorf("%s: Unsupported sim65 header version %d", name, data[5])
+	}
+	if data[6] != 0 {
+		return nil, fmt.Errorf("%s: Only 6502 programs are supported, the CPU type is %d", name, data[6])
+	}
+
+	p := &Program{
+		Header: Header{
+			CPU:   data[6],
+			SP:    data[7],
+			Load:  core6502.MakeWord(data[9], data[8]),
+			Reset: core6502.MakeWord(data[11], data[10]),
+		},
+		Data: data[headerSize:],
+	}
+	if int(p.Load)+len(p.Data) > ParavirtBase {
+		return nil, fmt.Errorf("%s: %d bytes at $%04x overlap the host calls at $%04x", name, len(p.Data), p.Load, ParavirtBase)
+	}
+	return p, nil
+}
+
+func LoadProgram(filename string) (*Program, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ReadProgram(filename, data)
+}
+
+// clears memory, loads the program and resets the CPU to its start address
+func (p *Program) Install(ctx core6502.CPUContext) {
+	core6502.HardResetCPU(ctx, p.Reset)
+	for n, b := range p.Data {
+		ctx.Poke(p.Load+uint16(n), b)
+	}
+}